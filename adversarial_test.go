@@ -0,0 +1,41 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Benchmarks of the inputs that used to take quadratic time
+//
+
+//go:build go1.7
+// +build go1.7
+
+package blackfriday
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// BenchmarkAdversarial runs each of adversarialInputs at a few sizes; the
+// time per byte should stay about the same. TestAdversarialTime checks it.
+func BenchmarkAdversarial(b *testing.B) {
+	for _, in := range adversarialInputs {
+		for _, n := range []int{1000, 10000} {
+			input := []byte(strings.Repeat(in.open, n) + strings.Repeat(in.unit, n))
+			render := in.render
+			b.Run(fmt.Sprintf("%s/%d", in.name, n), func(b *testing.B) {
+				b.SetBytes(int64(len(input)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					render(input)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,884 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Document tree
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// NodeType identifies the kind of element a Node stands for.
+type NodeType int
+
+// These are the possible node types. Apart from NODE_DOCUMENT,
+// NODE_TABLE_HEAD and NODE_TABLE_BODY, each corresponds to the Renderer
+// callback of the same name.
+const (
+	NODE_DOCUMENT NodeType = iota
+	NODE_BLOCK_CODE
+	NODE_BLOCK_QUOTE
+	NODE_BLOCK_HTML
+	NODE_HEADER
+	NODE_HRULE
+	NODE_LIST
+	NODE_LIST_ITEM
+	NODE_PARAGRAPH
+	NODE_TABLE
+	NODE_TABLE_HEAD
+	NODE_TABLE_BODY
+	NODE_TABLE_CAPTION
+	NODE_TABLE_ROW
+	NODE_TABLE_HEADER_CELL
+	NODE_TABLE_CELL
+	NODE_FOOTNOTES
+	NODE_FOOTNOTE_ITEM
+	NODE_TITLE_BLOCK
+	NODE_ADMONITION
+	NODE_CONTAINER
+	NODE_DETAILS
+	NODE_DETAILS_SUMMARY
+	NODE_BIBLIOGRAPHY
+	NODE_BLOCK_SPOILER
+	NODE_BLOCK_SHORTCODE
+	NODE_BLOCK_QUOTE_CITATION
+	NODE_ATTRIBUTION
+	NODE_LINE_BLOCK
+	NODE_LINE
+	NODE_AUTO_LINK
+	NODE_CODE_SPAN
+	NODE_DOUBLE_EMPHASIS
+	NODE_EMPHASIS
+	NODE_IMAGE
+	NODE_LINE_BREAK
+	NODE_LINK
+	NODE_RAW_HTML_TAG
+	NODE_TRIPLE_EMPHASIS
+	NODE_STRIKETHROUGH
+	NODE_HIGHLIGHT
+	NODE_INSERT
+	NODE_CRITIC_MARKUP
+	NODE_FOOTNOTE_REF
+	NODE_ABBREVIATION
+	NODE_MENTION
+	NODE_CITATION
+	NODE_MEDIA_EMBED
+	NODE_KBD
+	NODE_RUBY
+	NODE_SPOILER
+	NODE_SHORTCODE
+	NODE_ENTITY
+	NODE_TEXT
+)
+
+var nodeTypeNames = []string{
+	NODE_DOCUMENT:             "Document",
+	NODE_BLOCK_CODE:           "BlockCode",
+	NODE_BLOCK_QUOTE:          "BlockQuote",
+	NODE_BLOCK_HTML:           "BlockHtml",
+	NODE_HEADER:               "Header",
+	NODE_HRULE:                "HRule",
+	NODE_LIST:                 "List",
+	NODE_LIST_ITEM:            "ListItem",
+	NODE_PARAGRAPH:            "Paragraph",
+	NODE_TABLE:                "Table",
+	NODE_TABLE_HEAD:           "TableHead",
+	NODE_TABLE_BODY:           "TableBody",
+	NODE_TABLE_CAPTION:        "TableCaption",
+	NODE_TABLE_ROW:            "TableRow",
+	NODE_TABLE_HEADER_CELL:    "TableHeaderCell",
+	NODE_TABLE_CELL:           "TableCell",
+	NODE_FOOTNOTES:            "Footnotes",
+	NODE_FOOTNOTE_ITEM:        "FootnoteItem",
+	NODE_TITLE_BLOCK:          "TitleBlock",
+	NODE_ADMONITION:           "Admonition",
+	NODE_CONTAINER:            "Container",
+	NODE_DETAILS:              "Details",
+	NODE_DETAILS_SUMMARY:      "DetailsSummary",
+	NODE_BIBLIOGRAPHY:         "Bibliography",
+	NODE_BLOCK_SPOILER:        "BlockSpoiler",
+	NODE_BLOCK_SHORTCODE:      "BlockShortcode",
+	NODE_BLOCK_QUOTE_CITATION: "BlockQuoteCitation",
+	NODE_ATTRIBUTION:          "Attribution",
+	NODE_LINE_BLOCK:           "LineBlock",
+	NODE_LINE:                 "Line",
+	NODE_AUTO_LINK:            "AutoLink",
+	NODE_CODE_SPAN:            "CodeSpan",
+	NODE_DOUBLE_EMPHASIS:      "DoubleEmphasis",
+	NODE_EMPHASIS:             "Emphasis",
+	NODE_IMAGE:                "Image",
+	NODE_LINE_BREAK:           "LineBreak",
+	NODE_LINK:                 "Link",
+	NODE_RAW_HTML_TAG:         "RawHtmlTag",
+	NODE_TRIPLE_EMPHASIS:      "TripleEmphasis",
+	NODE_STRIKETHROUGH:        "StrikeThrough",
+	NODE_HIGHLIGHT:            "Highlight",
+	NODE_INSERT:               "Insert",
+	NODE_CRITIC_MARKUP:        "CriticMarkup",
+	NODE_FOOTNOTE_REF:         "FootnoteRef",
+	NODE_ABBREVIATION:         "Abbreviation",
+	NODE_MENTION:              "Mention",
+	NODE_CITATION:             "Citation",
+	NODE_MEDIA_EMBED:          "MediaEmbed",
+	NODE_KBD:                  "Kbd",
+	NODE_RUBY:                 "Ruby",
+	NODE_SPOILER:              "Spoiler",
+	NODE_SHORTCODE:            "Shortcode",
+	NODE_ENTITY:               "Entity",
+	NODE_TEXT:                 "Text",
+}
+
+func (t NodeType) String() string {
+	if t < 0 || int(t) >= len(nodeTypeNames) {
+		return "NodeType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return nodeTypeNames[t]
+}
+
+// Node is an element of the document tree returned by Parse. Which of the
+// fields are used depends on the node type; the rest are left empty.
+type Node struct {
+	Type     NodeType
+	Children []*Node
+
+	// Text of NODE_TEXT, NODE_ENTITY, NODE_CODE_SPAN, NODE_BLOCK_CODE,
+	// NODE_BLOCK_HTML, NODE_RAW_HTML_TAG, NODE_TITLE_BLOCK,
+	// NODE_ABBREVIATION, NODE_MENTION, NODE_KBD, NODE_SHORTCODE and
+	// NODE_BLOCK_SHORTCODE nodes, and the alt text of
+	// NODE_IMAGE and NODE_MEDIA_EMBED nodes.
+	Literal []byte
+
+	Level       int    // header level
+	ID          string // header id
+	Lang        string // language of a fenced code block, info string of a container
+	Kind        string // kind of an admonition, such as "note"
+	Destination []byte // target of links, images and autolinks
+	Title       []byte // title of links, images, abbreviations and admonitions
+	Name        []byte // reference name of footnotes and footnote references
+	NoteID      int    // number of a footnote reference
+	Columns     []int  // TABLE_ALIGNMENT_* of each column of a table
+	Span        int    // number of columns a table cell spans, if more than one
+	Start       int    // number of the first item of an ordered list
+
+	// attribute list of headers, code blocks, images and links
+	Attrs Attributes
+
+	// replacement text of a CRITIC_SUBSTITUTION, whose Children are the
+	// text it replaces
+	Replacement []*Node
+
+	// reading of a NODE_RUBY, whose Children are the text it annotates
+	Annotation []*Node
+
+	// works cited by a citation, or all the cited works in a bibliography,
+	// which has only their keys
+	Citations []CitationItem
+
+	// LIST_* flags of lists, list items and footnotes, TABLE_* flags of
+	// table rows and cells, LINK_TYPE_* of autolinks, MENTION_TYPE_* of
+	// mentions, DETAILS_* of details, CRITIC_* of CriticMarkup and MEDIA_*
+	// of media embeds.
+	Flags int
+
+	// Where the node comes from in the input given to Parse: the byte
+	// offset of its start, and of the end of it, past its last byte,
+	// leaving out the blank lines after a block. End is 0 if the position
+	// is unknown, as it is for the contents of footnotes and for nodes
+	// that Parse did not make. A node from an included document has the
+	// position of the line that includes it. Text that the parser changed,
+	// such as text with backslash escapes or running over the markers of
+	// a block quote, spans the space between the nodes around it.
+	Pos, End int
+
+	// The newlines that went between a block node from Parse and the
+	// output before it, which Parse leaves out of the text there, for
+	// Render to put back. Render guesses them for nodes made otherwise.
+	newlines  int
+	separated bool
+}
+
+// MarshalJSON implements json.Marshaler. The node type is written as its
+// name, byte slices as strings, and the position only if it is known.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	var pos, end *int
+	if n.End > 0 {
+		pos, end = &n.Pos, &n.End
+	}
+	return json.Marshal(struct {
+		Type        string         `json:"type"`
+		Literal     string         `json:"literal,omitempty"`
+		Level       int            `json:"level,omitempty"`
+		ID          string         `json:"id,omitempty"`
+		Lang        string         `json:"lang,omitempty"`
+		Kind        string         `json:"kind,omitempty"`
+		Destination string         `json:"destination,omitempty"`
+		Title       string         `json:"title,omitempty"`
+		Name        string         `json:"name,omitempty"`
+		NoteID      int            `json:"noteId,omitempty"`
+		Columns     []int          `json:"columns,omitempty"`
+		Span        int            `json:"span,omitempty"`
+		Start       int            `json:"start,omitempty"`
+		Flags       int            `json:"flags,omitempty"`
+		Attrs       Attributes     `json:"attrs,omitempty"`
+		Citations   []CitationItem `json:"citations,omitempty"`
+		Replacement []*Node        `json:"replacement,omitempty"`
+		Annotation  []*Node        `json:"annotation,omitempty"`
+		Children    []*Node        `json:"children,omitempty"`
+		Pos         *int           `json:"pos,omitempty"`
+		End         *int           `json:"end,omitempty"`
+	}{
+		Type:        n.Type.String(),
+		Literal:     string(n.Literal),
+		Level:       n.Level,
+		ID:          n.ID,
+		Lang:        n.Lang,
+		Kind:        n.Kind,
+		Destination: string(n.Destination),
+		Title:       string(n.Title),
+		Name:        string(n.Name),
+		NoteID:      n.NoteID,
+		Columns:     n.Columns,
+		Span:        n.Span,
+		Start:       n.Start,
+		Flags:       n.Flags,
+		Attrs:       n.Attrs,
+		Citations:   n.Citations,
+		Replacement: n.Replacement,
+		Annotation:  n.Annotation,
+		Children:    n.Children,
+		Pos:         pos,
+		End:         end,
+	})
+}
+
+// Parse parses a block of markdown-encoded text into a document tree, with
+// the extensions and other settings given in opts. The root of the tree is
+// a NODE_DOCUMENT node.
+//
+// It panics if the parser fails. Processor.Parse returns an error instead.
+func Parse(input []byte, opts Options) *Node {
+	doc, err := parse(input, opts)
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+func parse(input []byte, opts Options) (*Node, error) {
+	r := new(astRecorder)
+	p := newParser(r, opts)
+	out, err := p.render(input)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Node{Type: NODE_DOCUMENT, Children: r.children(out), End: len(input)}
+	p.placeTree(doc.Children)
+	return doc, nil
+}
+
+// astRecorder is a Renderer that builds nodes instead of rendering. In
+// place of the rendered output of a node it writes a token referring to
+// it, while text is written as is so that the parser can still inspect
+// and trim its buffers. The callback of the enclosing node turns the
+// tokens back into nodes.
+//
+// A token is a NUL byte, the index of the node in decimal, and another
+// NUL byte. NUL bytes in the text itself are doubled.
+type astRecorder struct {
+	nodes  []*Node
+	placed []bool // whether the parser has placed each node
+}
+
+func (r *astRecorder) add(out *bytes.Buffer, n *Node) {
+	out.WriteByte(0)
+	out.WriteString(strconv.Itoa(len(r.nodes)))
+	out.WriteByte(0)
+	r.nodes = append(r.nodes, n)
+	r.placed = append(r.placed, false)
+}
+
+// children decodes the output written for the children of a node
+func (r *astRecorder) children(data []byte) []*Node {
+	var nodes []*Node
+	var text []byte
+	for i := 0; i < len(data); i++ {
+		if data[i] != 0 {
+			text = append(text, data[i])
+			continue
+		}
+		if i+1 < len(data) && data[i+1] == 0 {
+			text = append(text, 0)
+			i++
+			continue
+		}
+		end := bytes.IndexByte(data[i+1:], 0)
+		if end < 0 {
+			text = append(text, data[i])
+			continue
+		}
+		id, err := strconv.Atoi(string(data[i+1 : i+1+end]))
+		if err != nil || id >= len(r.nodes) {
+			text = append(text, data[i])
+			continue
+		}
+		n := r.nodes[id]
+		if isBlockNode(n.Type) {
+			// newlines only separate the text from the block
+			trimmed := bytes.TrimRight(text, "\n")
+			n.newlines, n.separated = len(text)-len(trimmed), true
+			text = trimmed
+		}
+		if len(text) > 0 {
+			nodes = append(nodes, &Node{Type: NODE_TEXT, Literal: text})
+			text = nil
+		}
+		nodes = append(nodes, n)
+		i += end + 1
+	}
+	if len(text) > 0 {
+		nodes = append(nodes, &Node{Type: NODE_TEXT, Literal: text})
+	}
+	return nodes
+}
+
+// capture runs a callback that renders the children of a node, and takes
+// them back out of the output
+func (r *astRecorder) capture(out *bytes.Buffer, text func() bool) []*Node {
+	mark := out.Len()
+	text()
+	children := r.children(out.Bytes()[mark:])
+	out.Truncate(mark)
+	return children
+}
+
+func isBlockNode(t NodeType) bool {
+	return t < NODE_AUTO_LINK
+}
+
+func copyBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func copyAttributes(a Attributes) Attributes {
+	if len(a) == 0 {
+		return nil
+	}
+	return append(Attributes(nil), a...)
+}
+
+func (r *astRecorder) GetFlags() int {
+	return 0
+}
+
+// block-level callbacks
+
+func (r *astRecorder) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
+	r.add(out, &Node{Type: NODE_BLOCK_CODE, Literal: copyBytes(text), Lang: lang, Attrs: copyAttributes(attrs)})
+}
+
+func (r *astRecorder) BlockQuote(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_BLOCK_QUOTE, Children: r.children(text)})
+}
+
+// The citation of a cited quote is its last child.
+func (r *astRecorder) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
+	children := append(r.children(text), &Node{Type: NODE_ATTRIBUTION, Children: r.children(citation)})
+	r.add(out, &Node{Type: NODE_BLOCK_QUOTE_CITATION, Children: children})
+}
+
+func (r *astRecorder) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
+	r.add(out, &Node{Type: NODE_ADMONITION, Children: r.children(text), Kind: kind, Title: copyBytes(title)})
+}
+
+func (r *astRecorder) Container(out *bytes.Buffer, info string, text []byte) {
+	r.add(out, &Node{Type: NODE_CONTAINER, Children: r.children(text), Lang: info})
+}
+
+// The summary of details is their first child.
+func (r *astRecorder) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	var children []*Node
+	if len(summary) > 0 {
+		children = append(children, &Node{Type: NODE_DETAILS_SUMMARY, Children: r.children(summary)})
+	}
+	r.add(out, &Node{Type: NODE_DETAILS, Children: append(children, r.children(text)...), Flags: flags})
+}
+
+func (r *astRecorder) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_BLOCK_SPOILER, Children: r.children(text)})
+}
+
+func (r *astRecorder) BlockShortcode(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_BLOCK_SHORTCODE, Literal: copyBytes(text)})
+}
+
+func (r *astRecorder) BlockHtml(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_BLOCK_HTML, Literal: copyBytes(text)})
+}
+
+func (r *astRecorder) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	r.add(out, &Node{Type: NODE_HEADER, Children: r.capture(out, text), Level: level, ID: id, Attrs: copyAttributes(attrs)})
+}
+
+func (r *astRecorder) HRule(out *bytes.Buffer) {
+	r.add(out, &Node{Type: NODE_HRULE})
+}
+
+func (r *astRecorder) List(out *bytes.Buffer, text func() bool, flags int, start int) {
+	r.add(out, &Node{Type: NODE_LIST, Children: r.capture(out, text), Flags: flags, Start: start})
+}
+
+func (r *astRecorder) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	r.add(out, &Node{Type: NODE_LIST_ITEM, Children: r.children(text), Flags: flags})
+}
+
+func (r *astRecorder) Paragraph(out *bytes.Buffer, text func() bool) {
+	r.add(out, &Node{Type: NODE_PARAGRAPH, Children: r.capture(out, text)})
+}
+
+func (r *astRecorder) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	children := []*Node{
+		{Type: NODE_TABLE_HEAD, Children: r.children(header)},
+		{Type: NODE_TABLE_BODY, Children: r.children(body)},
+	}
+	if len(caption) > 0 {
+		children = append(children, &Node{Type: NODE_TABLE_CAPTION, Children: r.children(caption)})
+	}
+	r.add(out, &Node{
+		Type:     NODE_TABLE,
+		Children: children,
+		Columns:  append([]int(nil), columnData...),
+	})
+}
+
+func (r *astRecorder) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	r.add(out, &Node{Type: NODE_TABLE_ROW, Children: r.children(text), Flags: flags})
+}
+
+func (r *astRecorder) TableHeaderCell(out *bytes.Buffer, text []byte, flags int, span int) {
+	r.add(out, &Node{Type: NODE_TABLE_HEADER_CELL, Children: r.children(text), Flags: flags, Span: cellSpan(span)})
+}
+
+func (r *astRecorder) TableCell(out *bytes.Buffer, text []byte, flags int, span int) {
+	r.add(out, &Node{Type: NODE_TABLE_CELL, Children: r.children(text), Flags: flags, Span: cellSpan(span)})
+}
+
+// cellSpan leaves the span of ordinary cells out of the tree.
+func cellSpan(span int) int {
+	if span > 1 {
+		return span
+	}
+	return 0
+}
+
+// nodeSpan undoes cellSpan.
+func nodeSpan(n *Node) int {
+	if n.Span > 1 {
+		return n.Span
+	}
+	return 1
+}
+
+func (r *astRecorder) Footnotes(out *bytes.Buffer, text func() bool) {
+	r.add(out, &Node{Type: NODE_FOOTNOTES, Children: r.capture(out, text)})
+}
+
+func (r *astRecorder) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	r.add(out, &Node{Type: NODE_FOOTNOTE_ITEM, Children: r.children(text), Name: copyBytes(name), Flags: flags})
+}
+
+func (r *astRecorder) TitleBlock(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_TITLE_BLOCK, Literal: copyBytes(text)})
+}
+
+// Each line of a line block is a child of its own.
+func (r *astRecorder) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	children := make([]*Node, len(lines))
+	for i, line := range lines {
+		children[i] = &Node{Type: NODE_LINE, Children: r.children(line)}
+	}
+	r.add(out, &Node{Type: NODE_LINE_BLOCK, Children: children})
+}
+
+func (r *astRecorder) Bibliography(out *bytes.Buffer, keys []string) {
+	items := make([]CitationItem, len(keys))
+	for i, key := range keys {
+		items[i].Key = key
+	}
+	r.add(out, &Node{Type: NODE_BIBLIOGRAPHY, Citations: items})
+}
+
+// Span-level callbacks
+
+func (r *astRecorder) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	r.add(out, &Node{Type: NODE_AUTO_LINK, Destination: copyBytes(link), Flags: kind})
+}
+
+func (r *astRecorder) CodeSpan(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_CODE_SPAN, Literal: copyBytes(text)})
+}
+
+func (r *astRecorder) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_DOUBLE_EMPHASIS, Children: r.children(text)})
+}
+
+func (r *astRecorder) Emphasis(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_EMPHASIS, Children: r.children(text)})
+}
+
+func (r *astRecorder) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
+	r.add(out, &Node{Type: NODE_IMAGE, Destination: copyBytes(link), Title: copyBytes(title), Literal: copyBytes(alt), Attrs: copyAttributes(attrs)})
+}
+
+func (r *astRecorder) LineBreak(out *bytes.Buffer) {
+	r.add(out, &Node{Type: NODE_LINE_BREAK})
+}
+
+func (r *astRecorder) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
+	r.add(out, &Node{
+		Type:        NODE_LINK,
+		Children:    r.children(content),
+		Destination: copyBytes(link),
+		Title:       copyBytes(title),
+		Attrs:       copyAttributes(attrs),
+	})
+}
+
+func (r *astRecorder) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	r.add(out, &Node{Type: NODE_RAW_HTML_TAG, Literal: copyBytes(tag)})
+}
+
+func (r *astRecorder) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_TRIPLE_EMPHASIS, Children: r.children(text)})
+}
+
+func (r *astRecorder) StrikeThrough(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_STRIKETHROUGH, Children: r.children(text)})
+}
+
+func (r *astRecorder) Highlight(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_HIGHLIGHT, Children: r.children(text)})
+}
+
+func (r *astRecorder) Insert(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_INSERT, Children: r.children(text)})
+}
+
+func (r *astRecorder) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	r.add(out, &Node{Type: NODE_CRITIC_MARKUP, Children: r.children(text), Replacement: r.children(replacement), Flags: kind})
+}
+
+func (r *astRecorder) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	r.add(out, &Node{Type: NODE_FOOTNOTE_REF, Name: copyBytes(ref), NoteID: id})
+}
+
+func (r *astRecorder) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	r.add(out, &Node{Type: NODE_ABBREVIATION, Literal: copyBytes(abbr), Title: copyBytes(title)})
+}
+
+func (r *astRecorder) Mention(out *bytes.Buffer, kind int, token []byte) {
+	r.add(out, &Node{Type: NODE_MENTION, Literal: copyBytes(token), Flags: kind})
+}
+
+func (r *astRecorder) Citation(out *bytes.Buffer, items []CitationItem) {
+	r.add(out, &Node{Type: NODE_CITATION, Citations: append([]CitationItem(nil), items...)})
+}
+
+func (r *astRecorder) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	r.add(out, &Node{Type: NODE_MEDIA_EMBED, Destination: copyBytes(link), Title: copyBytes(title), Literal: copyBytes(alt), Flags: kind})
+}
+
+func (r *astRecorder) Kbd(out *bytes.Buffer, key []byte) {
+	r.add(out, &Node{Type: NODE_KBD, Literal: copyBytes(key)})
+}
+
+func (r *astRecorder) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	r.add(out, &Node{Type: NODE_RUBY, Children: r.children(text), Annotation: r.children(annotation)})
+}
+
+func (r *astRecorder) Spoiler(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_SPOILER, Children: r.children(text)})
+}
+
+func (r *astRecorder) Shortcode(out *bytes.Buffer, text []byte) {
+	r.add(out, &Node{Type: NODE_SHORTCODE, Literal: copyBytes(text)})
+}
+
+// Low-level callbacks
+
+func (r *astRecorder) Entity(out *bytes.Buffer, entity []byte) {
+	r.add(out, &Node{Type: NODE_ENTITY, Literal: copyBytes(entity)})
+}
+
+func (r *astRecorder) NormalText(out *bytes.Buffer, text []byte) {
+	for {
+		i := bytes.IndexByte(text, 0)
+		if i < 0 {
+			out.Write(text)
+			return
+		}
+		out.Write(text[:i+1])
+		out.WriteByte(0)
+		text = text[i+1:]
+	}
+}
+
+// Header and footer
+
+func (r *astRecorder) DocumentHeader(out *bytes.Buffer) {
+}
+
+func (r *astRecorder) DocumentFooter(out *bytes.Buffer) {
+}
+
+// WalkStatus tells Walk how to go on after visiting a node.
+type WalkStatus int
+
+// These are the statuses a NodeVisitor can return.
+const (
+	WALK_GO_TO_NEXT    WalkStatus = iota // visit the next node
+	WALK_SKIP_CHILDREN                   // don't visit the children of the node just entered
+	WALK_TERMINATE                       // stop walking the tree
+)
+
+// NodeVisitor is called by Walk when it enters a node, before its children
+// are visited, and again when it leaves the node. entering tells which of
+// the two visits it is.
+type NodeVisitor func(node *Node, entering bool) WalkStatus
+
+// Walk visits node and all of its descendants in document order.
+//
+// The children of a node are visited after the visitor has entered it, so
+// the visitor can add, remove or replace them then, for example to drop
+// nodes from the tree.
+func Walk(node *Node, visitor NodeVisitor) {
+	walk(node, visitor)
+}
+
+// walk returns false once the walk is to be terminated
+func walk(node *Node, visitor NodeVisitor) bool {
+	switch visitor(node, true) {
+	case WALK_TERMINATE:
+		return false
+	case WALK_GO_TO_NEXT:
+		for _, child := range node.Children {
+			if !walk(child, visitor) {
+				return false
+			}
+		}
+	}
+	return visitor(node, false) != WALK_TERMINATE
+}
+
+// Render renders a document tree returned by Parse with the given
+// renderer, calling its callbacks the way the parser would have. The
+// tree can be changed before it is rendered, and rendered more than once.
+// Like the parser, it renders each document with a renderer of its own,
+// from ForDocument(renderer).
+func Render(doc *Node, renderer Renderer) []byte {
+	renderer = ForDocument(renderer)
+	var output bytes.Buffer
+	renderer.DocumentHeader(&output)
+	renderNodes(&output, doc.Children, renderer)
+	renderer.DocumentFooter(&output)
+	return output.Bytes()
+}
+
+// renderNodes renders a list of sibling nodes to out
+func renderNodes(out *bytes.Buffer, nodes []*Node, renderer Renderer) {
+	for i, n := range nodes {
+		// put back the newlines that Parse trims before a block, or one
+		// between text and a block that it did not make
+		switch {
+		case n.separated:
+			for k := 0; k < n.newlines; k++ {
+				out.WriteByte('\n')
+			}
+		case isBlockNode(n.Type) && i > 0 && !isBlockNode(nodes[i-1].Type):
+			out.WriteByte('\n')
+		}
+		renderNode(out, n, renderer)
+	}
+}
+
+// renderChildren renders the children of n into a buffer of their own
+func renderChildren(n *Node, renderer Renderer) []byte {
+	var buf bytes.Buffer
+	renderNodes(&buf, n.Children, renderer)
+	return buf.Bytes()
+}
+
+// renderNode renders n and its children to out
+func renderNode(out *bytes.Buffer, n *Node, renderer Renderer) {
+	// the callbacks given to the renderer instead of the rendered children
+	work := func() bool {
+		renderNodes(out, n.Children, renderer)
+		return true
+	}
+
+	switch n.Type {
+	case NODE_DOCUMENT:
+		work()
+
+	// block-level nodes
+	case NODE_BLOCK_CODE:
+		renderer.BlockCode(out, n.Literal, n.Lang, n.Attrs)
+	case NODE_BLOCK_QUOTE:
+		renderer.BlockQuote(out, renderChildren(n, renderer))
+	case NODE_BLOCK_QUOTE_CITATION:
+		var citation []byte
+		children := n.Children
+		if last := len(children) - 1; last >= 0 && children[last].Type == NODE_ATTRIBUTION {
+			citation = renderChildren(children[last], renderer)
+			children = children[:last]
+		}
+		var text bytes.Buffer
+		renderNodes(&text, children, renderer)
+		renderer.BlockQuoteCitation(out, text.Bytes(), citation)
+	case NODE_ATTRIBUTION:
+		work()
+	case NODE_ADMONITION:
+		renderer.Admonition(out, n.Kind, n.Title, renderChildren(n, renderer))
+	case NODE_CONTAINER:
+		renderer.Container(out, n.Lang, renderChildren(n, renderer))
+	case NODE_DETAILS:
+		var summary []byte
+		children := n.Children
+		if len(children) > 0 && children[0].Type == NODE_DETAILS_SUMMARY {
+			summary = renderChildren(children[0], renderer)
+			children = children[1:]
+		}
+		var text bytes.Buffer
+		renderNodes(&text, children, renderer)
+		renderer.Details(out, summary, text.Bytes(), n.Flags)
+	case NODE_DETAILS_SUMMARY:
+		work()
+	case NODE_BLOCK_SPOILER:
+		renderer.BlockSpoiler(out, renderChildren(n, renderer))
+	case NODE_BLOCK_SHORTCODE:
+		renderer.BlockShortcode(out, n.Literal)
+	case NODE_BLOCK_HTML:
+		renderer.BlockHtml(out, n.Literal)
+	case NODE_HEADER:
+		renderer.Header(out, work, n.Level, n.ID, n.Attrs)
+	case NODE_HRULE:
+		renderer.HRule(out)
+	case NODE_LIST:
+		renderer.List(out, work, n.Flags, n.Start)
+	case NODE_LIST_ITEM:
+		// like the parser, strip trailing newlines
+		renderer.ListItem(out, bytes.TrimRight(renderChildren(n, renderer), "\n"), n.Flags)
+	case NODE_PARAGRAPH:
+		renderer.Paragraph(out, work)
+	case NODE_TABLE:
+		var header, body, caption []byte
+		for _, child := range n.Children {
+			switch child.Type {
+			case NODE_TABLE_HEAD:
+				header = renderChildren(child, renderer)
+			case NODE_TABLE_BODY:
+				body = renderChildren(child, renderer)
+			case NODE_TABLE_CAPTION:
+				caption = renderChildren(child, renderer)
+			}
+		}
+		renderer.Table(out, header, body, n.Columns, caption)
+	case NODE_TABLE_HEAD, NODE_TABLE_BODY, NODE_TABLE_CAPTION:
+		work()
+	case NODE_TABLE_ROW:
+		renderer.TableRow(out, renderChildren(n, renderer), n.Flags)
+	case NODE_TABLE_HEADER_CELL:
+		renderer.TableHeaderCell(out, renderChildren(n, renderer), n.Flags, nodeSpan(n))
+	case NODE_TABLE_CELL:
+		renderer.TableCell(out, renderChildren(n, renderer), n.Flags, nodeSpan(n))
+	case NODE_FOOTNOTES:
+		renderer.Footnotes(out, work)
+	case NODE_FOOTNOTE_ITEM:
+		renderer.FootnoteItem(out, n.Name, renderChildren(n, renderer), n.Flags)
+	case NODE_TITLE_BLOCK:
+		renderer.TitleBlock(out, n.Literal)
+	case NODE_BIBLIOGRAPHY:
+		keys := make([]string, len(n.Citations))
+		for i, item := range n.Citations {
+			keys[i] = item.Key
+		}
+		renderer.Bibliography(out, keys)
+	case NODE_LINE_BLOCK:
+		lines := make([][]byte, len(n.Children))
+		for i, line := range n.Children {
+			lines[i] = renderChildren(line, renderer)
+		}
+		renderer.LineBlock(out, lines)
+	case NODE_LINE:
+		work()
+
+	// span-level nodes
+	case NODE_AUTO_LINK:
+		renderer.AutoLink(out, n.Destination, n.Flags)
+	case NODE_CODE_SPAN:
+		renderer.CodeSpan(out, n.Literal)
+	case NODE_DOUBLE_EMPHASIS:
+		renderer.DoubleEmphasis(out, renderChildren(n, renderer))
+	case NODE_EMPHASIS:
+		renderer.Emphasis(out, renderChildren(n, renderer))
+	case NODE_IMAGE:
+		renderer.Image(out, n.Destination, n.Title, n.Literal, n.Attrs)
+	case NODE_LINE_BREAK:
+		renderer.LineBreak(out)
+	case NODE_LINK:
+		renderer.Link(out, n.Destination, n.Title, renderChildren(n, renderer), n.Attrs)
+	case NODE_RAW_HTML_TAG:
+		renderer.RawHtmlTag(out, n.Literal)
+	case NODE_TRIPLE_EMPHASIS:
+		renderer.TripleEmphasis(out, renderChildren(n, renderer))
+	case NODE_STRIKETHROUGH:
+		renderer.StrikeThrough(out, renderChildren(n, renderer))
+	case NODE_HIGHLIGHT:
+		renderer.Highlight(out, renderChildren(n, renderer))
+	case NODE_INSERT:
+		renderer.Insert(out, renderChildren(n, renderer))
+	case NODE_CRITIC_MARKUP:
+		var replacement bytes.Buffer
+		renderNodes(&replacement, n.Replacement, renderer)
+		renderer.CriticMarkup(out, n.Flags, renderChildren(n, renderer), replacement.Bytes())
+	case NODE_FOOTNOTE_REF:
+		renderer.FootnoteRef(out, n.Name, n.NoteID)
+	case NODE_ABBREVIATION:
+		renderer.Abbreviation(out, n.Literal, n.Title)
+	case NODE_MENTION:
+		renderer.Mention(out, n.Flags, n.Literal)
+	case NODE_CITATION:
+		renderer.Citation(out, n.Citations)
+	case NODE_MEDIA_EMBED:
+		renderer.MediaEmbed(out, n.Flags, n.Destination, n.Title, n.Literal)
+	case NODE_KBD:
+		renderer.Kbd(out, n.Literal)
+	case NODE_RUBY:
+		var annotation bytes.Buffer
+		renderNodes(&annotation, n.Annotation, renderer)
+		renderer.Ruby(out, renderChildren(n, renderer), annotation.Bytes())
+	case NODE_SPOILER:
+		renderer.Spoiler(out, renderChildren(n, renderer))
+	case NODE_SHORTCODE:
+		renderer.Shortcode(out, n.Literal)
+	case NODE_ENTITY:
+		renderer.Entity(out, n.Literal)
+	case NODE_TEXT:
+		renderer.NormalText(out, n.Literal)
+	}
+}
@@ -0,0 +1,634 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for the document tree
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runParseJSON encodes the tree of input without the positions of its
+// nodes, which TestParsePositions covers.
+func runParseJSON(input string, extensions Extensions) string {
+	doc := Parse([]byte(input), Options{Extensions: extensions})
+	clearPositions(doc)
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+func clearPositions(n *Node) {
+	n.Pos, n.End = 0, 0
+	for _, list := range [][]*Node{n.Children, n.Replacement, n.Annotation} {
+		for _, child := range list {
+			clearPositions(child)
+		}
+	}
+}
+
+// checkRoundTrip checks that rendering the tree of input gives the same
+// output as Markdown does, with renderers from newRenderer.
+func checkRoundTrip(t *testing.T, input string, opts Options, newRenderer func() Renderer) {
+	expected := string(MarkdownOptions([]byte(input), newRenderer(), opts))
+	doc, err := parse([]byte(input), opts)
+	if err != nil {
+		t.Errorf("\nInput   [%#v]\nParse: %v", input, err)
+		return
+	}
+	if actual := string(Render(doc, newRenderer())); actual != expected {
+		t.Errorf("\nInput   [%#v]\nMarkdown[%#v]\nRender  [%#v]", input, expected, actual)
+	}
+}
+
+func doTestsParse(t *testing.T, tests []string, extensions Extensions) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runParseJSON(input, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%s]\nActual  [%s]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	var tests = []string{
+		"",
+		`{"type":"Document"}`,
+
+		"Hello *world*\n",
+		`{"type":"Document","children":[{"type":"Paragraph","children":[` +
+			`{"type":"Text","literal":"Hello "},` +
+			`{"type":"Emphasis","children":[{"type":"Text","literal":"world"}]}]}]}`,
+
+		"# Title {#top}\n\n> quoted\n",
+		`{"type":"Document","children":[` +
+			`{"type":"Header","level":1,"id":"top","children":[{"type":"Text","literal":"Title"}]},` +
+			`{"type":"BlockQuote","children":[{"type":"Paragraph","children":[{"type":"Text","literal":"quoted"}]}]}]}`,
+
+		"- a\n- b\n",
+		`{"type":"Document","children":[{"type":"List","flags":16,"children":[` +
+			`{"type":"ListItem","flags":16,"children":[{"type":"Text","literal":"a"}]},` +
+			`{"type":"ListItem","flags":32,"children":[{"type":"Text","literal":"b"}]}]}]}`,
+
+		"```go\nx := 1\n```\n",
+		`{"type":"Document","children":[{"type":"BlockCode","literal":"x := 1\n","lang":"go"}]}`,
+
+		// the parser trims the '!' before images and the scheme before
+		// autolinks from its output
+		"see ![alt](/a.png \"T\") at http://example.com/\n",
+		`{"type":"Document","children":[{"type":"Paragraph","children":[` +
+			`{"type":"Text","literal":"see "},` +
+			`{"type":"Image","literal":"alt","destination":"/a.png","title":"T"},` +
+			`{"type":"Text","literal":" at "},` +
+			`{"type":"AutoLink","destination":"http://example.com/","flags":1}]}]}`,
+
+		"a  \nb &amp; [c](/d)\n",
+		`{"type":"Document","children":[{"type":"Paragraph","children":[` +
+			`{"type":"Text","literal":"a"},{"type":"LineBreak"},{"type":"Text","literal":"b "},` +
+			`{"type":"Entity","literal":"\u0026amp;"},{"type":"Text","literal":" "},` +
+			`{"type":"Link","destination":"/d","children":[{"type":"Text","literal":"c"}]}]}]}`,
+
+		"nul \x00 bytes\n",
+		`{"type":"Document","children":[{"type":"Paragraph","children":[` +
+			`{"type":"Text","literal":"nul \u0000 bytes"}]}]}`,
+
+		"| a |\n|---|\n| 1 |\n",
+		`{"type":"Document","children":[{"type":"Table","columns":[0],"children":[` +
+			`{"type":"TableHead","children":[{"type":"TableRow","flags":1,"children":[` +
+			`{"type":"TableHeaderCell","flags":4,"children":[{"type":"Text","literal":"a"}]}]}]},` +
+			`{"type":"TableBody","children":[{"type":"TableRow","flags":2,"children":[` +
+			`{"type":"TableCell","children":[{"type":"Text","literal":"1"}]}]}]}]}]}`,
+	}
+	doTestsParse(t, tests, commonExtensions)
+}
+
+func TestParseFootnotes(t *testing.T) {
+	var tests = []string{
+		"Text[^1].\n\n[^1]: The note.\n",
+		`{"type":"Document","children":[{"type":"Paragraph","children":[` +
+			`{"type":"Text","literal":"Text"},{"type":"FootnoteRef","name":"1","noteId":1},` +
+			`{"type":"Text","literal":"."}]},` +
+			`{"type":"Footnotes","children":[{"type":"FootnoteItem","name":"1","flags":16,` +
+			`"children":[{"type":"Text","literal":"The note.\n"}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_FOOTNOTES)
+}
+
+func TestParseLists(t *testing.T) {
+	var tests = []string{
+		"3) a\n\n4) b\n",
+		`{"type":"Document","children":[{"type":"List","start":3,"flags":785,"children":[` +
+			`{"type":"ListItem","flags":793,"children":[{"type":"Paragraph","children":[{"type":"Text","literal":"a"}]}]},` +
+			`{"type":"ListItem","flags":809,"children":[{"type":"Paragraph","children":[{"type":"Text","literal":"b"}]}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_COMMONMARK)
+}
+
+func TestParseRuby(t *testing.T) {
+	var tests = []string{
+		"{漢字|かんじ}\n",
+		`{"type":"Document","children":[{"type":"Paragraph","children":[{"type":"Ruby",` +
+			`"annotation":[{"type":"Text","literal":"かんじ"}],"children":[{"type":"Text","literal":"漢字"}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_RUBY)
+}
+
+func TestParseAdmonition(t *testing.T) {
+	var tests = []string{
+		"> [!NOTE] Heads up\n> Text.\n",
+		`{"type":"Document","children":[{"type":"Admonition","kind":"note","title":"Heads up",` +
+			`"children":[{"type":"Paragraph","children":[{"type":"Text","literal":"Text."}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_ADMONITIONS)
+}
+
+func TestParseQuoteCitation(t *testing.T) {
+	var tests = []string{
+		"> Text.\n> -- *Author*\n",
+		`{"type":"Document","children":[{"type":"BlockQuoteCitation","children":[` +
+			`{"type":"Paragraph","children":[{"type":"Text","literal":"Text."}]},` +
+			`{"type":"Attribution","children":[{"type":"Emphasis","children":[{"type":"Text","literal":"Author"}]}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_QUOTE_CITATIONS)
+
+	input := []byte(tests[0])
+	for _, renderer := range []Renderer{HtmlRenderer(0, "", ""), DocBookRenderer(0), MarkdownRenderer(0)} {
+		expected := string(Markdown(input, renderer, EXTENSION_QUOTE_CITATIONS))
+		actual := string(Render(Parse(input, Options{Extensions: EXTENSION_QUOTE_CITATIONS}), renderer))
+		if actual != expected {
+			t.Errorf("%T output differs\nExpected[%s]\nActual  [%s]", renderer, expected, actual)
+		}
+	}
+}
+
+func TestParseLineBlock(t *testing.T) {
+	var tests = []string{
+		"| a\n|\n|   *b*\n",
+		`{"type":"Document","children":[{"type":"LineBlock","children":[` +
+			`{"type":"Line","children":[{"type":"Text","literal":"a"}]},` +
+			`{"type":"Line"},` +
+			"{\"type\":\"Line\",\"children\":[{\"type\":\"Text\",\"literal\":\"\u00a0\u00a0\"}," +
+			`{"type":"Emphasis","children":[{"type":"Text","literal":"b"}]}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_LINE_BLOCKS)
+
+	input := []byte(tests[0])
+	for _, renderer := range []Renderer{HtmlRenderer(0, "", ""), LatexRenderer(0), MarkdownRenderer(0)} {
+		expected := string(Markdown(input, renderer, EXTENSION_LINE_BLOCKS))
+		actual := string(Render(Parse(input, Options{Extensions: EXTENSION_LINE_BLOCKS}), renderer))
+		if actual != expected {
+			t.Errorf("%T output differs\nExpected[%s]\nActual  [%s]", renderer, expected, actual)
+		}
+	}
+}
+
+func TestParseContainer(t *testing.T) {
+	var tests = []string{
+		"::: aside\nText.\n:::\n",
+		`{"type":"Document","children":[{"type":"Container","lang":"aside",` +
+			`"children":[{"type":"Paragraph","children":[{"type":"Text","literal":"Text."}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_FENCED_DIVS)
+}
+
+func TestParseAttributes(t *testing.T) {
+	var tests = []string{
+		"# Title {#top .main}\n\n![a](a.png){width=10}\n",
+		`{"type":"Document","children":[{"type":"Header","level":1,"id":"top",` +
+			`"attrs":[{"key":"class","value":"main"}],"children":[{"type":"Text","literal":"Title"}]},` +
+			`{"type":"Paragraph","children":[{"type":"Image","literal":"a","destination":"a.png",` +
+			`"attrs":[{"key":"width","value":"10"}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_ATTRIBUTES)
+}
+
+func TestParseCodeMetadata(t *testing.T) {
+	var tests = []string{
+		"```go title=main.go linenos\nx\n```\n",
+		`{"type":"Document","children":[{"type":"BlockCode","literal":"x\n","lang":"go",` +
+			`"attrs":[{"key":"title","value":"main.go"},{"key":"linenos","value":""}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_FENCED_CODE|EXTENSION_CODE_METADATA)
+}
+
+func TestParseTableExtras(t *testing.T) {
+	var tests = []string{
+		"| a ||\n|---|---|\n| 1 | 2 |\nTable: Cap\n",
+		`{"type":"Document","children":[{"type":"Table","columns":[0,0],"children":[` +
+			`{"type":"TableHead","children":[{"type":"TableRow","flags":1,"children":[` +
+			`{"type":"TableHeaderCell","span":2,"flags":4,"children":[{"type":"Text","literal":"a"}]}]}]},` +
+			`{"type":"TableBody","children":[{"type":"TableRow","flags":2,"children":[` +
+			`{"type":"TableCell","children":[{"type":"Text","literal":"1"}]},` +
+			`{"type":"TableCell","children":[{"type":"Text","literal":"2"}]}]}]},` +
+			`{"type":"TableCaption","children":[{"type":"Text","literal":"Cap"}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_TABLES|EXTENSION_TABLE_EXTRAS)
+}
+
+func TestParseCitations(t *testing.T) {
+	var tests = []string{
+		"[@doe, p. 3; @roe] and [@doe]\n",
+		`{"type":"Document","children":[{"type":"Paragraph","children":[` +
+			`{"type":"Citation","citations":[{"key":"doe","locator":"p. 3"},{"key":"roe"}]},` +
+			`{"type":"Text","literal":" and "},{"type":"Citation","citations":[{"key":"doe"}]}]},` +
+			`{"type":"Bibliography","citations":[{"key":"doe"},{"key":"roe"}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_CITATIONS)
+}
+
+func TestParseCriticMarkup(t *testing.T) {
+	var tests = []string{
+		"{~~a~>*b*~~}\n",
+		`{"type":"Document","children":[{"type":"Paragraph","children":[` +
+			`{"type":"CriticMarkup","flags":2,"replacement":[{"type":"Emphasis",` +
+			`"children":[{"type":"Text","literal":"b"}]}],"children":[{"type":"Text","literal":"a"}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_CRITIC_MARKUP)
+}
+
+func TestParseDetails(t *testing.T) {
+	var tests = []string{
+		"<details open>\n<summary>*S*</summary>\n\nText.\n</details>\n",
+		`{"type":"Document","children":[{"type":"Details","flags":1,"children":[` +
+			`{"type":"DetailsSummary","children":[{"type":"Emphasis","children":[{"type":"Text","literal":"S"}]}]},` +
+			`{"type":"Paragraph","children":[{"type":"Text","literal":"Text."}]}]}]}`,
+	}
+	doTestsParse(t, tests, EXTENSION_DETAILS)
+}
+
+// runParsePositions lists the nodes of the tree of input with the text
+// they come from, or ? if it is unknown.
+func runParsePositions(input string, opts Options) string {
+	var out bytes.Buffer
+	var list func(n *Node)
+	list = func(n *Node) {
+		if n.End > 0 {
+			fmt.Fprintf(&out, "%s %q\n", n.Type, input[n.Pos:n.End])
+		} else {
+			fmt.Fprintf(&out, "%s ?\n", n.Type)
+		}
+		for _, children := range [][]*Node{n.Children, n.Replacement, n.Annotation} {
+			for _, child := range children {
+				list(child)
+			}
+		}
+	}
+	for _, n := range Parse([]byte(input), opts).Children {
+		list(n)
+	}
+	return out.String()
+}
+
+func TestParsePositions(t *testing.T) {
+	var tests = []struct {
+		input    string
+		opts     Options
+		expected string
+	}{
+		{"# Title *x*\n\nSome `code` and [a link](/u).\n", Options{},
+			"Header \"# Title *x*\"\nText \"Title \"\nEmphasis \"*x*\"\nText \"x\"\n" +
+				"Paragraph \"Some `code` and [a link](/u).\"\nText \"Some \"\nCodeSpan \"`code`\"\n" +
+				"Text \" and \"\nLink \"[a link](/u)\"\nText \"a link\"\nText \".\"\n"},
+
+		// the contents of quotes and lists are copied without their
+		// markers, and placed where they come from
+		{"> a *b*\n\n- one\n- two\n  - three\n", Options{},
+			"BlockQuote \"> a *b*\"\nParagraph \"a *b*\"\nText \"a \"\nEmphasis \"*b*\"\nText \"b\"\n" +
+				"List \"- one\\n- two\\n  - three\"\nListItem \"- one\"\nText \"one\"\n" +
+				"ListItem \"- two\\n  - three\"\nText \"two\"\nList \"- three\"\nListItem \"- three\"\nText \"three\"\n"},
+
+		// the parser starts an autolink at its trigger and goes back
+		{"see http://a.com/ ok\n", Options{Extensions: EXTENSION_AUTOLINK},
+			"Paragraph \"see http://a.com/ ok\"\nText \"see \"\nAutoLink \"http://a.com/\"\nText \" ok\"\n"},
+
+		// tabs, line endings and references are undone
+		{"[r]: /u\r\n\r\n\tcode\r\n\r\nx\t[y][r]\r\n", Options{},
+			"BlockCode \"\\tcode\"\nParagraph \"x\\t[y][r]\"\nText \"x\\t\"\nLink \"[y][r]\"\nText \"y\"\n"},
+
+		{"| a | *b* |\n|---|---|\n| c |\n", Options{Extensions: EXTENSION_TABLES},
+			"Table \"| a | *b* |\\n|---|---|\\n| c |\"\nTableHead \"| a | *b* |\"\nTableRow \"| a | *b* |\"\n" +
+				"TableHeaderCell \"a\"\nText \"a\"\nTableHeaderCell \"*b*\"\nEmphasis \"*b*\"\nText \"b\"\n" +
+				"TableBody \"| c |\"\nTableRow \"| c |\"\nTableCell \"c\"\nText \"c\"\nTableCell ?\n"},
+
+		{"{~~a~>*b*~~}\n", Options{Extensions: EXTENSION_CRITIC_MARKUP},
+			"Paragraph \"{~~a~>*b*~~}\"\nCriticMarkup \"{~~a~>*b*~~}\"\nText \"a\"\nEmphasis \"*b*\"\nText \"b\"\n"},
+
+		// a byte order mark is left out before parsing, but not of the
+		// positions
+		{"\xef\xbb\xbfa[^1]\n\n[^1]: b\n", Options{Extensions: EXTENSION_FOOTNOTES, InvalidUTF8: INVALID_UTF8_REPLACE},
+			"Paragraph \"a[^1]\"\nText \"a\"\nFootnoteRef \"[^1]\"\nFootnotes ?\nFootnoteItem ?\nText ?\n"},
+	}
+	for _, test := range tests {
+		if actual := runParsePositions(test.input, test.opts); actual != test.expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%s]\nActual  [%s]", test.input, test.expected, actual)
+		}
+	}
+
+	doc := Parse([]byte("*a*\n"), Options{})
+	out, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"Document","children":[{"type":"Paragraph","children":[{"type":"Emphasis",` +
+		`"children":[{"type":"Text","literal":"a","pos":1,"end":2}],"pos":0,"end":3}],"pos":0,"end":3}],"pos":0,"end":4}`
+	if string(out) != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", expected, out)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+
+		// no token may be left behind in the text, and each node must be
+		// placed inside the one it is in
+		var check func(n, parent *Node)
+		check = func(n, parent *Node) {
+			if n.Type == NODE_TEXT && strings.IndexByte(string(n.Literal), 0) >= 0 {
+				t.Errorf("%s: stray token in text %q", filename, n.Literal)
+			}
+			if n.End > 0 && (n.Pos >= n.End || n.End > len(input)) {
+				t.Errorf("%s: %s placed at [%d:%d]", filename, n.Type, n.Pos, n.End)
+			}
+			if n.End > 0 && parent != nil && parent.End > 0 && (n.Pos < parent.Pos || n.End > parent.End) {
+				t.Errorf("%s: %s placed at [%d:%d] in %s at [%d:%d]",
+					filename, n.Type, n.Pos, n.End, parent.Type, parent.Pos, parent.End)
+			}
+			for _, child := range n.Children {
+				check(child, n)
+			}
+		}
+		check(Parse(input, Options{Extensions: commonExtensions}), nil)
+	}
+}
+
+// Rendering the tree must give the same output as rendering while parsing.
+func TestRenderReference(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	extensions := commonExtensions | EXTENSION_FOOTNOTES
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+		for _, renderer := range []Renderer{
+			HtmlRenderer(commonHtmlFlags, "", ""),
+			LatexRenderer(0),
+			MarkdownRenderer(0),
+		} {
+			expected := string(Markdown(input, renderer, extensions))
+			actual := string(Render(Parse(input, Options{Extensions: extensions}), renderer))
+			if actual != expected {
+				t.Errorf("%s: %T output differs\nExpected[%s]\nActual  [%s]",
+					filename, renderer, expected, actual)
+			}
+		}
+	}
+}
+
+func TestRenderNestedLists(t *testing.T) {
+	var tests = []string{
+		"- a\n  b\n  - c\n    d\n- e\n",
+		"1. one\n2. two\n   * x\n   * y\n\n   more\n3. three\n",
+		"- a\n\n  b\n  - c\n",
+		"* a\n  > q\n* b\n    code\n",
+	}
+	for _, input := range tests {
+		for _, extensions := range []Extensions{
+			0,
+			EXTENSION_JOIN_LINES,
+			EXTENSION_HARD_LINE_BREAK,
+			EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK,
+		} {
+			checkRoundTrip(t, input, Options{Extensions: extensions},
+				func() Renderer { return HtmlRenderer(0, "", "") })
+		}
+	}
+}
+
+func TestRenderTwice(t *testing.T) {
+	input := []byte("# One\n\nA note[^1].\n\n# Two\n\n[^1]: The note.\n")
+	opts := Options{Extensions: EXTENSION_FOOTNOTES | EXTENSION_AUTO_HEADER_IDS}
+	renderer := HtmlRenderer(HTML_TOC, "", "")
+	expected := string(MarkdownOptions(input, renderer, opts))
+	doc := Parse(input, opts)
+	for i := 0; i < 2; i++ {
+		if actual := string(Render(doc, renderer)); actual != expected {
+			t.Errorf("render %d:\nExpected[%#v]\nActual  [%#v]", i, expected, actual)
+		}
+	}
+}
+
+func TestRenderModified(t *testing.T) {
+	doc := Parse([]byte("See [the docs](/docs).\n"), Options{})
+	link := doc.Children[0].Children[1]
+	if link.Type != NODE_LINK {
+		t.Fatalf("expected a link, got %v", link.Type)
+	}
+	link.Destination = []byte("https://example.com/docs")
+
+	expected := "<p>See <a href=\"https://example.com/docs\">the docs</a>.</p>\n"
+	if actual := string(Render(doc, HtmlRenderer(0, "", ""))); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	doc := Parse([]byte("# A [b](/b)\n\n[c](/c) *[d](/d)*\n\n[e](/e)\n"), Options{})
+
+	var visits []string
+	Walk(doc, func(node *Node, entering bool) WalkStatus {
+		if node.Type == NODE_LINK {
+			visits = append(visits, fmt.Sprintf("%s:%v", node.Destination, entering))
+		}
+		return WALK_GO_TO_NEXT
+	})
+	if actual := strings.Join(visits, " "); actual != "/b:true /b:false /c:true /c:false /d:true /d:false /e:true /e:false" {
+		t.Errorf("unexpected visits %q", actual)
+	}
+
+	// skip headers and stop after the link in emphasis
+	visits = nil
+	Walk(doc, func(node *Node, entering bool) WalkStatus {
+		switch {
+		case node.Type == NODE_HEADER && entering:
+			return WALK_SKIP_CHILDREN
+		case node.Type == NODE_LINK && entering:
+			visits = append(visits, string(node.Destination))
+		case node.Type == NODE_EMPHASIS && !entering:
+			return WALK_TERMINATE
+		}
+		return WALK_GO_TO_NEXT
+	})
+	if actual := strings.Join(visits, " "); actual != "/c /d" {
+		t.Errorf("unexpected visits %q", actual)
+	}
+
+	// drop the emphasis and rewrite the remaining links
+	Walk(doc, func(node *Node, entering bool) WalkStatus {
+		if !entering {
+			return WALK_GO_TO_NEXT
+		}
+		var kept []*Node
+		for _, child := range node.Children {
+			if child.Type != NODE_EMPHASIS {
+				kept = append(kept, child)
+			}
+		}
+		node.Children = kept
+		if node.Type == NODE_LINK {
+			node.Destination = append([]byte("/docs"), node.Destination...)
+		}
+		return WALK_GO_TO_NEXT
+	})
+	expected := "<h1>A <a href=\"/docs/b\">b</a></h1>\n\n" +
+		"<p><a href=\"/docs/c\">c</a> </p>\n\n" +
+		"<p><a href=\"/docs/e\">e</a></p>\n"
+	if actual := string(Render(doc, HtmlRenderer(0, "", ""))); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	input := "# Title\n\n> Quote\n> *a*\n\nText[^1]\n\n[^1]: Note\n"
+	opts := Options{Extensions: EXTENSION_FOOTNOTES}
+
+	var events []string
+	err := ParseEvents([]byte(input), opts, func(event Event) error {
+		kind := "enter"
+		if event.Kind == EVENT_EXIT {
+			kind = "exit"
+		}
+		e := kind + " " + event.Node.Type.String()
+		if event.Line > 0 {
+			e += fmt.Sprintf("@%d", event.Line)
+		}
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "enter Header@1,enter Text@1,exit Text@1,exit Header@1," +
+		"enter BlockQuote@3,enter Paragraph@3,enter Text@3,exit Text@3," +
+		"enter Emphasis@4,enter Text@4,exit Text@4,exit Emphasis@4,exit Paragraph@3,exit BlockQuote@3," +
+		"enter Paragraph@6,enter Text@6,exit Text@6,enter FootnoteRef@6,exit FootnoteRef@6,exit Paragraph@6," +
+		"enter Footnotes,enter FootnoteItem,enter Text,exit Text,exit FootnoteItem,exit Footnotes"
+	if actual := strings.Join(events, ","); actual != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", expected, actual)
+	}
+
+	// the events place the nodes as Parse does
+	input2 := "[r]: /u\r\n# A\t*b*\r\n\r\n- c [d][r]\r\n  e\t`f`\r\n"
+	var placed []string
+	err = ParseEvents([]byte(input2), opts, func(event Event) error {
+		if event.Kind == EVENT_ENTER {
+			placed = append(placed, fmt.Sprintf("%s[%d:%d]", event.Node.Type, event.Node.Pos, event.Node.End))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed []string
+	Walk(Parse([]byte(input2), opts), func(node *Node, entering bool) WalkStatus {
+		if entering && node.Type != NODE_DOCUMENT {
+			parsed = append(parsed, fmt.Sprintf("%s[%d:%d]", node.Type, node.Pos, node.End))
+		}
+		return WALK_GO_TO_NEXT
+	})
+	if actual, expected := strings.Join(placed, ","), strings.Join(parsed, ","); actual != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", expected, actual)
+	}
+
+	// stop at the first header
+	stop := errors.New("stop")
+	var title string
+	err = ParseEvents([]byte(input), opts, func(event Event) error {
+		if event.Node.Type == NODE_TEXT {
+			title = string(event.Node.Literal)
+		}
+		if event.Node.Type == NODE_HEADER && event.Kind == EVENT_EXIT {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || title != "Title" {
+		t.Errorf("unexpected title %q, %v", title, err)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	input := "# Title\n\nFirst para!\n\nSecond para!\n"
+	parsed := 0
+	opts := Options{InlineParsers: map[byte]InlineParserFunc{
+		'!': func(r Renderer, out *bytes.Buffer, data []byte, offset int) int {
+			parsed++
+			return 0
+		},
+	}}
+
+	// an excerpt: the first header and paragraph
+	it := NewIterator([]byte(input), opts)
+	var header, para *Node
+	for para == nil {
+		event, ok := it.Next()
+		if !ok {
+			t.Fatalf("no paragraph, %v", it.Err())
+		}
+		switch {
+		case event.Kind != EVENT_ENTER:
+		case event.Node.Type == NODE_HEADER && header == nil:
+			header = event.Node
+		case event.Node.Type == NODE_PARAGRAPH:
+			para = event.Node
+		}
+	}
+	if header == nil || string(header.Children[0].Literal) != "Title" || string(para.Children[0].Literal) != "First para!" {
+		t.Errorf("unexpected excerpt %v, %v", header, para)
+	}
+	if parsed != 1 {
+		t.Errorf("parsed %d paragraphs, expected only the first", parsed)
+	}
+
+	count := 0
+	for _, ok := it.Next(); ok; _, ok = it.Next() {
+		count++
+	}
+	if count != 7 || parsed != 2 || it.Err() != nil {
+		t.Errorf("unexpected %d more events after %d paragraphs, %v", count, parsed, it.Err())
+	}
+}
@@ -0,0 +1,290 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Attribute lists with EXTENSION_ATTRIBUTES
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Attribute is one entry of an attribute list.
+type Attribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Attributes is the attribute list given in braces after a header, the
+// fence of a code block, an image or a link with EXTENSION_ATTRIBUTES:
+//
+//	![logo](logo.png){width=300 .rounded}
+//
+// The list keeps the order it was written in. An #id is stored under the
+// key "id" and each .class under the key "class", so a key can appear more
+// than once.
+type Attributes []Attribute
+
+// Get returns the value of the first attribute with the given key, or ""
+// if there is none.
+func (a Attributes) Get(key string) string {
+	for _, attr := range a {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Classes returns the values of all the class attributes.
+func (a Attributes) Classes() []string {
+	var classes []string
+	for _, attr := range a {
+		if attr.Key == "class" {
+			classes = append(classes, attr.Value)
+		}
+	}
+	return classes
+}
+
+// String returns the list in the attribute list syntax.
+func (a Attributes) String() string {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, attr := range a {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		switch {
+		case attr.Key == "id":
+			buf.WriteString("#" + attr.Value)
+		case attr.Key == "class":
+			buf.WriteString("." + attr.Value)
+		case strings.Contains(attr.Value, "\""):
+			buf.WriteString(attr.Key + "='" + attr.Value + "'")
+		case attr.Value == "" || strings.ContainsAny(attr.Value, " \t'{}"):
+			buf.WriteString(attr.Key + "=\"" + attr.Value + "\"")
+		default:
+			buf.WriteString(attr.Key + "=" + attr.Value)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+// hasClass reports whether the class is one of the list's classes.
+func (a Attributes) hasClass(class string) bool {
+	for _, attr := range a {
+		if attr.Key == "class" && attr.Value == class {
+			return true
+		}
+	}
+	return false
+}
+
+// has reports whether there is an attribute with the given key.
+func (a Attributes) has(key string) bool {
+	for _, attr := range a {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// without returns the list without the attributes with the given key.
+func (a Attributes) without(key string) Attributes {
+	var rest Attributes
+	for _, attr := range a {
+		if attr.Key != key {
+			rest = append(rest, attr)
+		}
+	}
+	return rest
+}
+
+// attributeList parses an attribute list at the beginning of data, which
+// starts with '{'. It returns the attributes and the index just past the
+// closing '}', or 0 if there isn't a valid list.
+func attributeList(data []byte) (Attributes, int) {
+	if len(data) == 0 || data[0] != '{' {
+		return nil, 0
+	}
+
+	var attrs Attributes
+	i := 1
+	for {
+		for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
+			i++
+		}
+		if i >= len(data) || data[i] == '\n' {
+			return nil, 0
+		}
+		if data[i] == '}' {
+			break
+		}
+
+		switch data[i] {
+		case '#', '.':
+			start := i + 1
+			i = start
+			for i < len(data) && !isspace(data[i]) && data[i] != '}' && data[i] != '"' && data[i] != '\'' {
+				i++
+			}
+			if i == start {
+				return nil, 0
+			}
+			key := "id"
+			if data[start-1] == '.' {
+				key = "class"
+			}
+			attrs = append(attrs, Attribute{key, string(data[start:i])})
+
+		default:
+			if !isletter(data[i]) {
+				return nil, 0
+			}
+			start := i
+			for i < len(data) && (isalnum(data[i]) || data[i] == '-' || data[i] == '_' || data[i] == ':') {
+				i++
+			}
+			if i >= len(data) || data[i] != '=' {
+				return nil, 0
+			}
+			key := string(data[start:i])
+			i++
+
+			var value []byte
+			if i < len(data) && (data[i] == '"' || data[i] == '\'') {
+				end := bytes.IndexByte(data[i+1:], data[i])
+				if end < 0 {
+					return nil, 0
+				}
+				value = data[i+1 : i+1+end]
+				if bytes.IndexByte(value, '\n') >= 0 {
+					return nil, 0
+				}
+				i += end + 2
+			} else {
+				start = i
+				for i < len(data) && !isspace(data[i]) && data[i] != '}' {
+					i++
+				}
+				value = data[start:i]
+			}
+			attrs = append(attrs, Attribute{key, string(value)})
+		}
+
+		// entries are separated by spaces
+		if i < len(data) && data[i] != '}' && data[i] != ' ' && data[i] != '\t' {
+			return nil, 0
+		}
+	}
+
+	if len(attrs) == 0 {
+		return nil, 0
+	}
+	return attrs, i + 1
+}
+
+// trailingAttributes checks whether the text data[beg:end] ends with an
+// attribute list. If so, it returns the end of the text preceding the list
+// and the attributes; otherwise end is returned unchanged.
+func trailingAttributes(data []byte, beg, end int) (int, Attributes) {
+	e := end
+	for e > beg && data[e-1] == ' ' {
+		e--
+	}
+	if e == beg || data[e-1] != '}' {
+		return end, nil
+	}
+	start := bytes.LastIndexByte(data[beg:e], '{')
+	if start < 0 {
+		return end, nil
+	}
+	start += beg
+	attrs, n := attributeList(data[start:e])
+	if start+n != e {
+		return end, nil
+	}
+	for start > beg && data[start-1] == ' ' {
+		start--
+	}
+	return start, attrs
+}
+
+// codeMetadata parses the rest of the info string of a fenced code block
+// after the language, as in
+//
+//	```go title="main.go" hl_lines="2-4" linenos
+//
+// Each word is a key, optionally followed by '=' and a value, which can be
+// quoted. Keys without a value get an empty one.
+func codeMetadata(info string) Attributes {
+	var attrs Attributes
+	data := []byte(info)
+	i := 0
+	for i < len(data) {
+		if isspace(data[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(data) && !isspace(data[i]) && data[i] != '=' {
+			i++
+		}
+		key := string(data[start:i])
+		if key == "" {
+			i++
+			continue
+		}
+		if i >= len(data) || data[i] != '=' {
+			attrs = append(attrs, Attribute{key, ""})
+			continue
+		}
+		i++
+
+		var value []byte
+		if i < len(data) && (data[i] == '"' || data[i] == '\'') {
+			if end := bytes.IndexByte(data[i+1:], data[i]); end >= 0 {
+				value = data[i+1 : i+1+end]
+				i += end + 2
+			}
+		}
+		if value == nil {
+			start = i
+			for i < len(data) && !isspace(data[i]) {
+				i++
+			}
+			value = data[start:i]
+		}
+		attrs = append(attrs, Attribute{key, string(value)})
+	}
+	return attrs
+}
+
+// fenceAttributes parses the info string of a fenced code block as an
+// attribute list. The first class is taken as the language.
+func fenceAttributes(syntax string) (string, Attributes) {
+	attrs, n := attributeList([]byte("{" + syntax + "}"))
+	if n == 0 {
+		return syntax, nil
+	}
+	for i, attr := range attrs {
+		if attr.Key == "class" {
+			return attr.Value, append(attrs[:i:i], attrs[i+1:]...)
+		}
+	}
+	return "", attrs
+}
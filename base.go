@@ -0,0 +1,298 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Default implementations of the Renderer callbacks
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html"
+)
+
+// BaseRenderer implements the Renderer interface by writing out the plain
+// text of the document, without any markup.
+//
+// It is meant to be embedded in renderers that only care about some of the
+// callbacks:
+//
+//	type linkLister struct {
+//	    blackfriday.BaseRenderer
+//	    links []string
+//	}
+//
+//	func (r *linkLister) Link(out *bytes.Buffer, link, title, content []byte, attrs blackfriday.Attributes) {
+//	    r.links = append(r.links, string(link))
+//	    r.BaseRenderer.Link(out, link, title, content, attrs)
+//	}
+//
+// The parser calls the callbacks on the outer type, so the methods it
+// defines take the place of those of BaseRenderer. An existing renderer
+// can be wrapped the same way by embedding the Renderer it returns.
+type BaseRenderer struct{}
+
+func (r BaseRenderer) GetFlags() int {
+	return 0
+}
+
+// block-level callbacks
+
+func (r BaseRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) BlockQuote(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
+	out.Write(text)
+	out.WriteString("\u2014 ")
+	out.Write(citation)
+	out.WriteString("\n")
+}
+
+func (r BaseRenderer) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
+	out.Write(admonitionTitle(kind, title))
+	out.WriteString("\n")
+	out.Write(text)
+}
+
+func (r BaseRenderer) Container(out *bytes.Buffer, info string, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	if len(summary) > 0 {
+		out.Write(summary)
+		out.WriteString("\n")
+	}
+	out.Write(text)
+}
+
+func (r BaseRenderer) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) BlockShortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteString("\n")
+}
+
+func (r BaseRenderer) BlockHtml(out *bytes.Buffer, text []byte) {
+}
+
+func (r BaseRenderer) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n")
+}
+
+func (r BaseRenderer) HRule(out *bytes.Buffer) {
+}
+
+func (r BaseRenderer) List(out *bytes.Buffer, text func() bool, flags int, start int) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+	}
+}
+
+func (r BaseRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	out.Write(text)
+	out.WriteString("\n")
+}
+
+func (r BaseRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n")
+}
+
+func (r BaseRenderer) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	out.Write(header)
+	out.Write(body)
+	if len(caption) > 0 {
+		out.Write(caption)
+		out.WriteString("\n")
+	}
+}
+
+func (r BaseRenderer) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	out.Write(text)
+	out.WriteString("\n")
+}
+
+func (r BaseRenderer) TableHeaderCell(out *bytes.Buffer, text []byte, align int, span int) {
+	r.TableCell(out, text, align, span)
+}
+
+func (r BaseRenderer) TableCell(out *bytes.Buffer, text []byte, align int, span int) {
+	if out.Len() > 0 {
+		out.WriteString("\t")
+	}
+	out.Write(text)
+	for i := 1; i < span; i++ {
+		out.WriteString("\t")
+	}
+}
+
+func (r BaseRenderer) Footnotes(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+	}
+}
+
+func (r BaseRenderer) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) TitleBlock(out *bytes.Buffer, text []byte) {
+}
+
+func (r BaseRenderer) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	for _, line := range lines {
+		out.Write(line)
+		out.WriteString("\n")
+	}
+}
+
+func (r BaseRenderer) Bibliography(out *bytes.Buffer, keys []string) {
+}
+
+// span-level callbacks
+
+func (r BaseRenderer) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.Write(link)
+}
+
+func (r BaseRenderer) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) Emphasis(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
+	out.Write(alt)
+}
+
+func (r BaseRenderer) LineBreak(out *bytes.Buffer) {
+	out.WriteString("\n")
+}
+
+func (r BaseRenderer) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
+	out.Write(content)
+}
+
+func (r BaseRenderer) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+}
+
+func (r BaseRenderer) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) Highlight(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) Insert(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+// The text is written as it is with the changes accepted.
+func (r BaseRenderer) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	switch kind {
+	case CRITIC_ADDITION, CRITIC_HIGHLIGHT:
+		out.Write(text)
+	case CRITIC_SUBSTITUTION:
+		out.Write(replacement)
+	}
+}
+
+func (r BaseRenderer) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+}
+
+func (r BaseRenderer) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	out.Write(abbr)
+}
+
+func (r BaseRenderer) Mention(out *bytes.Buffer, kind int, token []byte) {
+	if kind == MENTION_TYPE_ISSUE {
+		out.WriteByte('#')
+	} else {
+		out.WriteByte('@')
+	}
+	out.Write(token)
+}
+
+func (r BaseRenderer) Citation(out *bytes.Buffer, items []CitationItem) {
+	out.Write(citationText(items))
+}
+
+func (r BaseRenderer) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	r.Image(out, link, title, alt, nil)
+}
+
+func (r BaseRenderer) Kbd(out *bytes.Buffer, key []byte) {
+	out.Write(key)
+}
+
+func (r BaseRenderer) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	out.Write(text)
+	out.WriteString("(")
+	out.Write(annotation)
+	out.WriteString(")")
+}
+
+func (r BaseRenderer) Spoiler(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r BaseRenderer) Shortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+// low-level callbacks
+
+func (r BaseRenderer) Entity(out *bytes.Buffer, entity []byte) {
+	out.WriteString(html.UnescapeString(string(entity)))
+}
+
+func (r BaseRenderer) NormalText(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+// header and footer
+
+func (r BaseRenderer) DocumentHeader(out *bytes.Buffer) {
+}
+
+func (r BaseRenderer) DocumentFooter(out *bytes.Buffer) {
+}
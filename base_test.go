@@ -0,0 +1,144 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for the default renderer callbacks
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBaseRenderer(t *testing.T) {
+	var tests = []string{
+		"# Title\n\nSome *emphasized* [link](/a) &amp; `code`.\n",
+		"Title\nSome emphasized link & code.\n",
+
+		"- one\n- two\n\n<div>raw</div>\n",
+		"one\ntwo\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := string(Markdown([]byte(tests[i]), BaseRenderer{}, 0))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+type linkLister struct {
+	BaseRenderer
+	links []string
+}
+
+func (r *linkLister) Link(out *bytes.Buffer, link, title, content []byte, attrs Attributes) {
+	r.links = append(r.links, string(link))
+	r.BaseRenderer.Link(out, link, title, content, attrs)
+}
+
+type upperHeaders struct {
+	Renderer
+}
+
+func (r upperHeaders) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	r.Renderer.Header(out, text, level+1, id, attrs)
+}
+
+func TestBaseRendererEmbedding(t *testing.T) {
+	r := &linkLister{}
+	out := string(Markdown([]byte("[a](/1) and [b](/2)\n"), r, 0))
+	if out != "a and b\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if len(r.links) != 2 || r.links[0] != "/1" || r.links[1] != "/2" {
+		t.Errorf("unexpected links %q", r.links)
+	}
+
+	// wrapping an existing renderer
+	out = string(Markdown([]byte("# Title\n"), upperHeaders{HtmlRenderer(0, "", "")}, 0))
+	if out != "<h2>Title</h2>\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+type bibliography struct {
+	Renderer
+	keys []string
+}
+
+func (r *bibliography) Bibliography(out *bytes.Buffer, keys []string) {
+	r.keys = keys
+	out.WriteString("<ol class=\"references\">")
+	for _, key := range keys {
+		out.WriteString("<li>" + key + "</li>")
+	}
+	out.WriteString("</ol>\n")
+}
+
+func TestBibliography(t *testing.T) {
+	r := &bibliography{Renderer: HtmlRenderer(0, "", "")}
+	input := "[@b; @a] and [@b, p. 3][^1]\n\n[^1]: Also [@c].\n"
+	out := string(Markdown([]byte(input), r, EXTENSION_CITATIONS|EXTENSION_FOOTNOTES))
+	if fmt.Sprint(r.keys) != "[b a c]" {
+		t.Errorf("unexpected keys %q", r.keys)
+	}
+	if !strings.HasSuffix(out, "</div>\n<ol class=\"references\"><li>b</li><li>a</li><li>c</li></ol>\n") {
+		t.Errorf("bibliography not after the footnotes:\n%s", out)
+	}
+
+	// no citations, no bibliography
+	r = &bibliography{Renderer: HtmlRenderer(0, "", "")}
+	Markdown([]byte("[@b](/x)\n"), r, 0)
+	if r.keys != nil {
+		t.Errorf("unexpected keys %q", r.keys)
+	}
+}
+
+type tableFlags struct {
+	BaseRenderer
+	rows, cells []int
+}
+
+func (r *tableFlags) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	r.rows = append(r.rows, flags)
+	r.BaseRenderer.TableRow(out, text, flags)
+}
+
+func (r *tableFlags) TableHeaderCell(out *bytes.Buffer, text []byte, flags int, span int) {
+	r.TableCell(out, text, flags, span)
+}
+
+func (r *tableFlags) TableCell(out *bytes.Buffer, text []byte, flags int, span int) {
+	r.cells = append(r.cells, flags)
+	r.BaseRenderer.TableCell(out, text, flags, span)
+}
+
+func TestTableFlags(t *testing.T) {
+	r := &tableFlags{}
+	Markdown([]byte("a | b\n:--|--:\n1 | 2\n3 | 4\n5 | 6\n"), r, EXTENSION_TABLES)
+
+	rows := []int{TABLE_ROW_HEADER, TABLE_ROW_ODD, TABLE_ROW_EVEN, TABLE_ROW_ODD}
+	if fmt.Sprint(r.rows) != fmt.Sprint(rows) {
+		t.Errorf("unexpected row flags %v, want %v", r.rows, rows)
+	}
+	cells := []int{
+		TABLE_ALIGNMENT_LEFT | TABLE_CELL_HEADER, TABLE_ALIGNMENT_RIGHT | TABLE_CELL_HEADER,
+		TABLE_ALIGNMENT_LEFT, TABLE_ALIGNMENT_RIGHT,
+		TABLE_ALIGNMENT_LEFT, TABLE_ALIGNMENT_RIGHT,
+		TABLE_ALIGNMENT_LEFT, TABLE_ALIGNMENT_RIGHT,
+	}
+	if fmt.Sprint(r.cells) != fmt.Sprint(cells) {
+		t.Errorf("unexpected cell flags %v, want %v", r.cells, cells)
+	}
+}
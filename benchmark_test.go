@@ -0,0 +1,155 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Benchmarks over representative documents, and allocation limits
+//
+
+package blackfriday
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// raceEnabled is set when the tests are built with the race detector.
+var raceEnabled bool
+
+// corpusSize is the length the documents of the benchmarks are grown to.
+const corpusSize = 256 << 10
+
+// corpus repeats a section, with {n} replaced by the number of each copy,
+// until it is about corpusSize bytes long
+func corpus(section string) []byte {
+	var doc []byte
+	for i := 1; len(doc) < corpusSize; i++ {
+		doc = append(doc, strings.Replace(section, "{n}", strconv.Itoa(i), -1)...)
+	}
+	return doc
+}
+
+// benchmarkCorpora are documents of the kinds Blackfriday is used for. The
+// README comes from the repository; the others are made up.
+var benchmarkCorpora = []struct {
+	name string
+	doc  func(tb testing.TB) []byte
+
+	// the allocations per kilobyte that TestCorpusAllocations allows, a
+	// quarter or so above what rendering the document takes
+	allocsPerKB float64
+}{
+	{"Readme", func(tb testing.TB) []byte {
+		readme, err := ioutil.ReadFile("README.md")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return corpus(string(readme) + "\n")
+	}, 60},
+	{"Prose", func(tb testing.TB) []byte {
+		return corpus("## Chapter {n}\n\n" +
+			"It was the best of times, it was the worst of times -- it was the age of " +
+			"wisdom, it was the age of *foolishness*, it was the epoch of belief, it was " +
+			"the epoch of incredulity. \"We had everything before us,\" she said; " +
+			"we had nothing before us... It's 1/2 past {n}.\n\n" +
+			"> There were a king with a large jaw and a queen with a plain face, on the " +
+			"> throne of England; see [the notes](http://example.com/notes/{n}).\n\n" +
+			"In both countries it was clearer than crystal to the lords of the State " +
+			"preserves of loaves and fishes, that things in general were settled for " +
+			"ever.  \nIt was the year of Our Lord one thousand seven hundred and " +
+			"seventy-five, and **spiritual revelations** were conceded to England.\n\n")
+	}, 48},
+	{"Tables", func(tb testing.TB) []byte {
+		return corpus("Table {n}\n\n" +
+			"| Name | Type | Default | Description |\n" +
+			"|:-----|:----:|--------:|-------------|\n" +
+			"| `width` | int | {n} | The width, in *pixels* |\n" +
+			"| `height` | int | 0 | The height, see [below](#height) |\n" +
+			"| `title` | string | \"\" | A title with a \\| pipe |\n" +
+			"| `hidden` | bool | false | **Deprecated** |\n\n")
+	}, 400},
+	{"Code", func(tb testing.TB) []byte {
+		return corpus("Call `Render({n})` or `New()`:\n\n" +
+			"```go\n" +
+			"func example{n}(input []byte) ([]byte, error) {\n" +
+			"\tmd := blackfriday.New(blackfriday.WithExtensions(blackfriday.EXTENSION_TABLES))\n" +
+			"\treturn md.Render(input, blackfriday.HtmlRenderer(0, \"\", \"\"))\n" +
+			"}\n" +
+			"```\n\n" +
+			"    $ go get github.com/russross/blackfriday\n" +
+			"    $ blackfriday-tool -page input.md > output.html\n\n")
+	}, 58},
+	{"Inline", func(tb testing.TB) []byte {
+		// delimiters that mostly don't match
+		return corpus("Some [brackets [and *stars _and `ticks <tags " +
+			"and [links](http://example.com/{n} \"title\" and **strong* __under_ " +
+			"and ![images][ref and <!-- comments and \\*escapes\\* and " +
+			"&entities; & a_b_c and *[a]* and `code`` and [x]: y\n\n")
+	}, 140},
+}
+
+// benchmarkCorpus renders the document of benchmarkCorpora with the given
+// name with MarkdownCommon. The benchmarks of the documents run with:
+//
+//	go test -run NONE -bench Corpus -benchmem
+func benchmarkCorpus(b *testing.B, name string) {
+	for _, c := range benchmarkCorpora {
+		if c.name != name {
+			continue
+		}
+		input := c.doc(b)
+		b.SetBytes(int64(len(input)))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			MarkdownCommon(input)
+		}
+	}
+}
+
+func BenchmarkCorpusReadme(b *testing.B) {
+	benchmarkCorpus(b, "Readme")
+}
+
+func BenchmarkCorpusProse(b *testing.B) {
+	benchmarkCorpus(b, "Prose")
+}
+
+func BenchmarkCorpusTables(b *testing.B) {
+	benchmarkCorpus(b, "Tables")
+}
+
+func BenchmarkCorpusCode(b *testing.B) {
+	benchmarkCorpus(b, "Code")
+}
+
+func BenchmarkCorpusInline(b *testing.B) {
+	benchmarkCorpus(b, "Inline")
+}
+
+// TestCorpusAllocations keeps changes to the parser from allocating much
+// more than it does for each of benchmarkCorpora.
+func TestCorpusAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector makes pooled buffers allocate again")
+	}
+	if !pooledBuffers {
+		t.Skip("scratch buffers are only pooled from Go 1.3 on")
+	}
+	for _, c := range benchmarkCorpora {
+		input := c.doc(t)
+		allocs := testing.AllocsPerRun(3, func() {
+			MarkdownCommon(input)
+		})
+		if perKB := allocs / float64(len(input)>>10); perKB > c.allocsPerKB {
+			t.Errorf("%s: %.1f allocations per kilobyte, want at most %.0f", c.name, perKB, c.allocsPerKB)
+		}
+	}
+}
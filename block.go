@@ -15,6 +15,9 @@ package blackfriday
 
 import (
 	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -28,164 +31,237 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 
 	// this is called recursively: enforce a maximum depth
 	if p.nesting >= p.maxNesting {
+		p.diagnose(DIAGNOSTIC_ERROR, data, "blocks nested more than %d deep left out", p.maxNesting)
 		return
 	}
 	p.nesting++
 
 	// parse out one block-level construct at a time
-	for len(data) > 0 {
-		// prefixed header:
-		//
-		// # Header 1
-		// ## Header 2
-		// ...
-		// ###### Header 6
-		if p.isPrefixHeader(data) {
-			data = data[p.prefixHeader(out, data):]
-			continue
-		}
+	for len(data) > 0 && !p.cancelled() {
+		data = data[p.nextBlock(out, data):]
+	}
 
-		// block of preformatted HTML:
-		//
-		// <div>
-		//     ...
-		// </div>
-		if data[0] == '<' {
-			if i := p.html(out, data, true); i > 0 {
-				data = data[i:]
-				continue
-			}
-		}
+	p.nesting--
+}
 
-		// title block
-		//
-		// % stuff
-		// % more stuff
-		// % even more stuff
-		if p.flags&EXTENSION_TITLEBLOCK != 0 {
-			if data[0] == '%' {
-				if i := p.titleBlock(out, data, true); i > 0 {
-					data = data[i:]
-					continue
-				}
+// cancelled reports whether the document is no longer wanted, in which case
+// the remaining blocks are left out.
+func (p *parser) cancelled() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// nextBlock parses the block-level construct at the start of data, and
+// returns its length.
+func (p *parser) nextBlock(out *bytes.Buffer, data []byte) int {
+	mark := p.mark()
+	size := p.blockAt(out, data)
+	p.place(mark, data, size)
+	return size
+}
+
+// blockAt is nextBlock, without placing the nodes of the block.
+func (p *parser) blockAt(out *bytes.Buffer, data []byte) int {
+	// prefixed header:
+	//
+	// # Header 1
+	// ## Header 2
+	// ...
+	// ###### Header 6
+	if p.isPrefixHeader(data) {
+		return p.prefixHeader(out, data)
+	}
+
+	// block of preformatted HTML:
+	//
+	// <div>
+	//     ...
+	// </div>
+	if data[0] == '<' || data[0] == ' ' && p.flags&EXTENSION_COMMONMARK != 0 && p.htmlBlockKind(data) > 0 {
+		if p.flags&EXTENSION_DETAILS != 0 && data[0] == '<' {
+			if i := p.details(out, data); i > 0 {
+				return i
 			}
 		}
-
-		// blank lines.  note: returns the # of bytes to skip
-		if i := p.isEmpty(data); i > 0 {
-			data = data[i:]
-			continue
+		if i := p.html(out, data, true); i > 0 {
+			return i
 		}
+	}
 
-		// indented code block:
-		//
-		//     func max(a, b int) int {
-		//         if a > b {
-		//             return a
-		//         }
-		//         return b
-		//      }
-		if p.codePrefix(data) > 0 {
-			data = data[p.code(out, data):]
-			continue
+	// shortcode on a line of its own:
+	//
+	// {{< figure src="a.png" >}}
+	if data[0] == '{' && p.flags&EXTENSION_SHORTCODES != 0 {
+		if i := p.shortcodeBlock(out, data); i > 0 {
+			return i
 		}
+	}
 
-		// fenced code block:
-		//
-		// ``` go
-		// func fact(n int) int {
-		//     if n <= 1 {
-		//         return n
-		//     }
-		//     return n * fact(n-1)
-		// }
-		// ```
-		if p.flags&EXTENSION_FENCED_CODE != 0 {
-			if i := p.fencedCodeBlock(out, data, true); i > 0 {
-				data = data[i:]
-				continue
+	// title block
+	//
+	// % stuff
+	// % more stuff
+	// % even more stuff
+	if p.flags&EXTENSION_TITLEBLOCK != 0 {
+		if data[0] == '%' {
+			if i := p.titleBlock(out, data, true); i > 0 {
+				return i
 			}
 		}
+	}
 
-		// horizontal rule:
-		//
-		// ------
-		// or
-		// ******
-		// or
-		// ______
-		if p.isHRule(data) {
-			p.r.HRule(out)
-			var i int
-			for i = 0; data[i] != '\n'; i++ {
-			}
-			data = data[i:]
-			continue
+	// blank lines.  note: returns the # of bytes to skip
+	if i := p.isEmpty(data); i > 0 {
+		return i
+	}
+
+	// indented code block:
+	//
+	//     func max(a, b int) int {
+	//         if a > b {
+	//             return a
+	//         }
+	//         return b
+	//      }
+	if p.codePrefix(data) > 0 {
+		return p.code(out, data)
+	}
+
+	// fenced code block:
+	//
+	// ``` go
+	// func fact(n int) int {
+	//     if n <= 1 {
+	//         return n
+	//     }
+	//     return n * fact(n-1)
+	// }
+	// ```
+	if p.flags&EXTENSION_FENCED_CODE != 0 {
+		if i := p.fencedCodeBlock(out, data, true); i > 0 {
+			return i
 		}
+	}
 
-		// block quote:
-		//
-		// > A big quote I found somewhere
-		// > on the web
-		if p.quotePrefix(data) > 0 {
-			data = data[p.quote(out, data):]
-			continue
+	// fenced div:
+	//
+	// ::: warning
+	// Contents, which can have *any* blocks.
+	// :::
+	if p.flags&EXTENSION_FENCED_DIVS != 0 {
+		if i := p.fencedDiv(out, data); i > 0 {
+			return i
 		}
+	}
 
-		// table:
-		//
-		// Name  | Age | Phone
-		// ------|-----|---------
-		// Bob   | 31  | 555-1234
-		// Alice | 27  | 555-4321
-		if p.flags&EXTENSION_TABLES != 0 {
-			if i := p.table(out, data); i > 0 {
-				data = data[i:]
-				continue
-			}
+	// horizontal rule:
+	//
+	// ------
+	// or
+	// ******
+	// or
+	// ______
+	if p.isHRule(data) {
+		p.r.HRule(out)
+		var i int
+		for i = 0; data[i] != '\n'; i++ {
 		}
+		return i
+	}
 
-		// an itemized/unordered list:
-		//
-		// * Item 1
-		// * Item 2
-		//
-		// also works with + or -
-		if p.uliPrefix(data) > 0 {
-			data = data[p.list(out, data, 0):]
-			continue
+	// spoiler:
+	//
+	// >! The butler
+	// >! did it.
+	if p.flags&EXTENSION_SPOILERS != 0 {
+		if i := p.spoiler(out, data); i > 0 {
+			return i
+		}
+	}
+
+	// block quote:
+	//
+	// > A big quote I found somewhere
+	// > on the web
+	if p.quotePrefix(data) > 0 {
+		return p.quote(out, data)
+	}
+
+	// table:
+	//
+	// Name  | Age | Phone
+	// ------|-----|---------
+	// Bob   | 31  | 555-1234
+	// Alice | 27  | 555-4321
+	if p.flags&EXTENSION_TABLES != 0 {
+		if i := p.table(out, data); i > 0 {
+			return i
 		}
+	}
 
-		// a numbered/ordered list:
-		//
-		// 1. Item 1
-		// 2. Item 2
-		if p.oliPrefix(data) > 0 {
-			data = data[p.list(out, data, LIST_TYPE_ORDERED):]
-			continue
+	// grid table:
+	//
+	// +-------+-----------+
+	// | Fruit | Notes     |
+	// +=======+===========+
+	// | Apple | - crunchy |
+	// |       | - red     |
+	// +-------+-----------+
+	if p.flags&EXTENSION_GRID_TABLES != 0 && data[0] == '+' {
+		if i := p.gridTable(out, data); i > 0 {
+			return i
 		}
+	}
 
-		// definition lists:
-		//
-		// Term 1
-		// :   Definition a
-		// :   Definition b
-		//
-		// Term 2
-		// :   Definition c
-		if p.flags&EXTENSION_DEFINITION_LISTS != 0 {
-			if p.dliPrefix(data) > 0 {
-				data = data[p.list(out, data, LIST_TYPE_DEFINITION):]
-				continue
-			}
+	// line block:
+	//
+	// | The limerick packs laughs anatomical
+	// |     Into space that is quite economical.
+	if p.flags&EXTENSION_LINE_BLOCKS != 0 && data[0] == '|' {
+		if i := p.lineBlock(out, data); i > 0 {
+			return i
 		}
+	}
 
-		// anything else must look like a normal paragraph
-		// note: this finds underlined headers, too
-		data = data[p.paragraph(out, data):]
+	// an itemized/unordered list:
+	//
+	// * Item 1
+	// * Item 2
+	//
+	// also works with + or -
+	if p.uliPrefix(data) > 0 {
+		return p.list(out, data, 0)
 	}
 
-	p.nesting--
+	// a numbered/ordered list:
+	//
+	// 1. Item 1
+	// 2. Item 2
+	if p.oliPrefix(data) > 0 {
+		return p.list(out, data, LIST_TYPE_ORDERED)
+	}
+
+	// definition lists:
+	//
+	// Term 1
+	// :   Definition a
+	// :   Definition b
+	//
+	// Term 2
+	// :   Definition c
+	if p.flags&EXTENSION_DEFINITION_LISTS != 0 {
+		if p.dliPrefix(data) > 0 {
+			return p.list(out, data, LIST_TYPE_DEFINITION)
+		}
+	}
+
+	// anything else must look like a normal paragraph
+	// note: this finds underlined headers, too
+	return p.paragraph(out, data)
 }
 
 func (p *parser) isPrefixHeader(data []byte) bool {
@@ -193,12 +269,12 @@ func (p *parser) isPrefixHeader(data []byte) bool {
 		return false
 	}
 
-	if p.flags&EXTENSION_SPACE_HEADERS != 0 {
+	if p.flags&EXTENSION_SPACE_HEADERS != 0 || p.flags&EXTENSION_COMMONMARK != 0 {
 		level := 0
 		for level < 6 && data[level] == '#' {
 			level++
 		}
-		if data[level] != ' ' {
+		if data[level] != ' ' && (p.flags&EXTENSION_COMMONMARK == 0 || data[level] != '\n') {
 			return false
 		}
 	}
@@ -214,7 +290,12 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 	end := skipUntilChar(data, i, '\n')
 	skip := end
 	id := ""
-	if p.flags&EXTENSION_HEADER_IDS != 0 {
+	var attrs Attributes
+	if p.flags&EXTENSION_ATTRIBUTES != 0 {
+		end, attrs = trailingAttributes(data, i, end)
+		id, attrs = attrs.Get("id"), attrs.without("id")
+	}
+	if id == "" && p.flags&EXTENSION_HEADER_IDS != 0 {
 		j, k := 0, 0
 		// find start/end of header id
 		for j = i; j < end-1 && (data[j] != '{' || data[j+1] != '#'); j++ {
@@ -240,20 +321,59 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 	for end > 0 && data[end-1] == ' ' {
 		end--
 	}
-	if end > i {
-		if id == "" && p.flags&EXTENSION_AUTO_HEADER_IDS != 0 {
+	// CommonMark keeps empty headers
+	if end < i && p.flags&EXTENSION_COMMONMARK != 0 {
+		end = i
+	}
+	if end > i || p.flags&EXTENSION_COMMONMARK != 0 {
+		if id == "" && p.flags&(EXTENSION_AUTO_HEADER_IDS|EXTENSION_CROSS_REFERENCES) != 0 {
 			id = SanitizedAnchorName(string(data[i:end]))
 		}
+		if id != "" {
+			id = uniqueID(p.headerIDs, id)
+			p.indexHeader(id, data[i:end])
+		}
 		work := func() bool {
 			p.inline(out, data[i:end])
 			return true
 		}
-		p.r.Header(out, work, level, id)
+		marker := out.Len()
+		p.r.Header(out, work, level, id, attrs)
+		p.outlineHeader(data, level, id, out.Bytes()[marker:])
 	}
 	return skip
 }
 
+// trailingHeaderID checks whether the header text data[beg:end] ends with a
+// {#id} attribute. If so, it returns the end of the text preceding the
+// attribute and the id; otherwise end is returned unchanged with an empty id.
+func trailingHeaderID(data []byte, beg, end int) (int, string) {
+	if end-beg < 4 || data[end-1] != '}' {
+		return end, ""
+	}
+	j := bytes.LastIndex(data[beg:end], []byte("{#"))
+	if j < 0 {
+		return end, ""
+	}
+	j += beg
+	if j+2 >= end-1 {
+		return end, ""
+	}
+	id := string(data[j+2 : end-1])
+	for j > beg && data[j-1] == ' ' {
+		j--
+	}
+	return j, id
+}
+
 func (p *parser) isUnderlinedHeader(data []byte) int {
+	// CommonMark lets the underline be indented up to three spaces
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		for i := 0; i < 3 && data[0] == ' '; i++ {
+			data = data[1:]
+		}
+	}
+
 	// test of level 1 header
 	if data[0] == '=' {
 		i := skipChar(data, 1, '=')
@@ -298,9 +418,139 @@ func (p *parser) titleBlock(out *bytes.Buffer, data []byte, doRender bool) int {
 	return len(data)
 }
 
+// commonmarkBlockTags are the tags that open an HTML block of the sixth kind
+// in CommonMark, which runs up to the next blank line.
+var commonmarkBlockTags = map[string]struct{}{
+	"address": {}, "article": {}, "aside": {}, "base": {}, "basefont": {},
+	"blockquote": {}, "body": {}, "caption": {}, "center": {}, "col": {},
+	"colgroup": {}, "dd": {}, "details": {}, "dialog": {}, "dir": {},
+	"div": {}, "dl": {}, "dt": {}, "fieldset": {}, "figcaption": {},
+	"figure": {}, "footer": {}, "form": {}, "frame": {}, "frameset": {},
+	"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {}, "head": {},
+	"header": {}, "hr": {}, "html": {}, "iframe": {}, "legend": {}, "li": {},
+	"link": {}, "main": {}, "menu": {}, "menuitem": {}, "nav": {},
+	"noframes": {}, "ol": {}, "optgroup": {}, "option": {}, "p": {},
+	"param": {}, "search": {}, "section": {}, "summary": {}, "table": {},
+	"tbody": {}, "td": {}, "tfoot": {}, "th": {}, "thead": {}, "title": {},
+	"tr": {}, "track": {}, "ul": {},
+}
+
+var (
+	htmlRawOpenRe  = regexp.MustCompile(`(?i)^<(?:pre|script|style|textarea)(?:[ \t>]|$)`)
+	htmlRawCloseRe = regexp.MustCompile(`(?i)</(?:pre|script|style|textarea)>`)
+	htmlTagLineRe  = regexp.MustCompile(`^(?:<[A-Za-z][A-Za-z0-9-]*` +
+		`(?:[ \t]+[A-Za-z_:][A-Za-z0-9_.:-]*(?:[ \t]*=[ \t]*(?:[^ \t"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*` +
+		`[ \t]*/?>|</[A-Za-z][A-Za-z0-9-]*[ \t]*>)[ \t]*$`)
+)
+
+// htmlBlockEnds holds the end markers of the CommonMark HTML blocks of the
+// second to the fifth kind.
+var htmlBlockEnds = [...][]byte{2: []byte("-->"), 3: []byte("?>"), 4: []byte(">"), 5: []byte("]]>")}
+
+// htmlBlockKind returns which of the seven kinds of CommonMark HTML block
+// the line opens, or 0 if it opens none.
+func (p *parser) htmlBlockKind(data []byte) int {
+	i := 0
+	for i < 3 && i < len(data) && data[i] == ' ' {
+		i++
+	}
+	line := data[i:skipUntilChar(data, i, '\n')]
+	if len(line) < 2 || line[0] != '<' {
+		return 0
+	}
+	switch {
+	case htmlRawOpenRe.Match(line):
+		return 1
+	case bytes.HasPrefix(line, []byte("<!--")):
+		return 2
+	case line[1] == '?':
+		return 3
+	case bytes.HasPrefix(line, []byte("<![CDATA[")):
+		return 5
+	case line[1] == '!' && len(line) > 2 && isletter(line[2]):
+		return 4
+	}
+
+	// the sixth kind opens with a block tag, the seventh with any other
+	// complete tag alone on its line
+	j := 1
+	if line[1] == '/' {
+		j++
+	}
+	k := j
+	for k < len(line) && isalnum(line[k]) {
+		k++
+	}
+	if k > j && (k == len(line) || line[k] == ' ' || line[k] == '\t' || line[k] == '>' ||
+		line[k] == '/' && k+1 < len(line) && line[k+1] == '>') {
+		name := strings.ToLower(string(line[j:k]))
+		if _, ok := commonmarkBlockTags[name]; ok {
+			return 6
+		}
+		if _, ok := p.blockTags[name]; ok {
+			if _, std := blockTags[name]; !std {
+				return 6
+			}
+		}
+	}
+	if htmlTagLineRe.Match(line) && !htmlRawOpenRe.Match(line) {
+		return 7
+	}
+	return 0
+}
+
+// commonmarkHTML parses an HTML block by the rules of CommonMark: the first
+// five kinds run up to the line holding their end marker, or the end of the
+// document, and the last two up to a blank line.
+func (p *parser) commonmarkHTML(out *bytes.Buffer, data []byte, doRender bool) int {
+	kind := p.htmlBlockKind(data)
+	if kind == 0 {
+		return 0
+	}
+	end := 0
+	for end < len(data) {
+		eol := lineEnd(data, end)
+		line := data[end:eol]
+		if kind >= 6 && end > 0 && p.isEmpty(line) > 0 {
+			break
+		}
+		end = eol
+		if kind == 1 && htmlRawCloseRe.Match(line) ||
+			kind > 1 && kind < 6 && bytes.Contains(line, htmlBlockEnds[kind]) {
+			break
+		}
+	}
+	if end == len(data) && kind < 6 {
+		p.lookedAhead(data, len(data))
+	}
+	if kind == 2 && p.isSourceComment(bytes.TrimSpace(data[:end])) {
+		doRender = false
+	}
+	if doRender {
+		// trim trailing newlines
+		size := end
+		for size > 0 && data[size-1] == '\n' {
+			size--
+		}
+		p.r.BlockHtml(out, data[:size])
+	}
+	return end
+}
+
 func (p *parser) html(out *bytes.Buffer, data []byte, doRender bool) int {
 	var i, j int
 
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		if p.flags&EXTENSION_MARKDOWN_ATTRIBUTE != 0 && data[0] == '<' {
+			if curtag, ok := p.htmlFindTag(data[1:]); ok {
+				if size := p.htmlMarkdownBlock(out, data, curtag, doRender); size > 0 {
+					return size
+				}
+			}
+		}
+		return p.commonmarkHTML(out, data, doRender)
+	}
+
 	// identify the opening tag
 	if data[0] != '<' {
 		return 0
@@ -328,6 +578,12 @@ func (p *parser) html(out *bytes.Buffer, data []byte, doRender bool) int {
 		return 0
 	}
 
+	if p.flags&EXTENSION_MARKDOWN_ATTRIBUTE != 0 {
+		if size := p.htmlMarkdownBlock(out, data, curtag, doRender); size > 0 {
+			return size
+		}
+	}
+
 	// look for an unindented matching closing tag
 	// followed by a blank line
 	found := false
@@ -385,6 +641,7 @@ func (p *parser) html(out *bytes.Buffer, data []byte, doRender bool) int {
 	}
 
 	if !found {
+		p.lookedAhead(data, len(data))
 		return 0
 	}
 
@@ -401,6 +658,58 @@ func (p *parser) html(out *bytes.Buffer, data []byte, doRender bool) int {
 	return i
 }
 
+var markdownAttributeRe = regexp.MustCompile(`\smarkdown=(?:"1"|'1'|1)`)
+
+// htmlMarkdownBlock handles an HTML block whose opening tag has the
+// markdown="1" attribute of Markdown Extra. The tags are passed to the
+// renderer as HTML, without the attribute, and the contents in between
+// are parsed as blocks, or as spans for paragraphs and headers.
+func (p *parser) htmlMarkdownBlock(out *bytes.Buffer, data []byte, tag string, doRender bool) int {
+	openEnd := bytes.IndexByte(data, '>') + 1
+	if openEnd == 0 || !markdownAttributeRe.Match(data[:openEnd]) {
+		return 0
+	}
+
+	// find the matching closing tag, counting the nested ones
+	opening, closing := []byte("<"+tag), []byte("</"+tag+">")
+	depth, closeStart := 1, -1
+	for i := openEnd; i < len(data) && closeStart < 0; i++ {
+		switch {
+		case data[i] != '<':
+		case bytes.HasPrefix(data[i:], closing):
+			if depth--; depth == 0 {
+				closeStart = i
+			}
+		case bytes.HasPrefix(data[i:], opening) && i+len(opening) < len(data) &&
+			(isspace(data[i+len(opening)]) || data[i+len(opening)] == '>'):
+			depth++
+		}
+	}
+	if closeStart < 0 {
+		p.lookedAhead(data, len(data))
+		return 0
+	}
+	end := closeStart + len(closing)
+	skip := p.isEmpty(data[end:])
+	if skip == 0 && end < len(data) {
+		return 0
+	}
+
+	if doRender {
+		p.r.BlockHtml(out, markdownAttributeRe.ReplaceAll(data[:openEnd], nil))
+		contents := bytes.TrimSpace(data[openEnd:closeStart])
+		switch {
+		case len(contents) == 0:
+		case tag == "p" || len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6':
+			p.inline(out, contents)
+		default:
+			p.block(out, append(contents, '\n'))
+		}
+		p.r.BlockHtml(out, closing)
+	}
+	return end + skip
+}
+
 func (p *parser) renderHTMLBlock(out *bytes.Buffer, data []byte, start int, doRender bool) int {
 	// html block needs to end with a blank line
 	if i := p.isEmpty(data[start:]); i > 0 {
@@ -421,6 +730,12 @@ func (p *parser) renderHTMLBlock(out *bytes.Buffer, data []byte, start int, doRe
 // HTML comment, lax form
 func (p *parser) htmlComment(out *bytes.Buffer, data []byte, doRender bool) int {
 	i := p.inlineHTMLComment(out, data)
+	if i == 0 {
+		p.lookedAhead(data, len(data))
+	}
+	if i > 0 && p.isSourceComment(data[:i]) {
+		return p.renderHTMLBlock(out, data, i, false)
+	}
 	return p.renderHTMLBlock(out, data, i, doRender)
 }
 
@@ -442,6 +757,7 @@ func (p *parser) htmlCDATA(out *bytes.Buffer, data []byte, doRender bool) int {
 	i++
 	// no end-of-comment marker
 	if i >= len(data) {
+		p.lookedAhead(data, len(data))
 		return 0
 	}
 	return p.renderHTMLBlock(out, data, i, doRender)
@@ -470,12 +786,13 @@ func (p *parser) htmlHr(out *bytes.Buffer, data []byte, doRender bool) int {
 }
 
 func (p *parser) htmlFindTag(data []byte) (string, bool) {
+	// custom elements have a dash in their name
 	i := 0
-	for isalnum(data[i]) {
+	for isalnum(data[i]) || data[i] == '-' {
 		i++
 	}
 	key := string(data[:i])
-	if _, ok := blockTags[key]; ok {
+	if _, ok := p.blockTags[key]; ok {
 		return key, true
 	}
 	return "", false
@@ -561,8 +878,10 @@ func (*parser) isHRule(data []byte) bool {
 // isFenceLine checks if there's a fence line (e.g., ``` or ``` go) at the beginning of data,
 // and returns the end index if so, or 0 otherwise. It also returns the marker found.
 // If syntax is not nil, it gets set to the syntax specified in the fence line.
+// If info is not nil as well, it gets set to the rest of the line after a syntax
+// not in braces, which otherwise isn't allowed.
 // A final newline is mandatory to recognize the fence line, unless newlineOptional is true.
-func isFenceLine(data []byte, syntax *string, oldmarker string, newlineOptional bool) (end int, marker string) {
+func isFenceLine(data []byte, syntax, info *string, oldmarker string, newlineOptional bool) (end int, marker string) {
 	i, size := 0, 0
 
 	// skip up to three spaces
@@ -592,8 +911,9 @@ func isFenceLine(data []byte, syntax *string, oldmarker string, newlineOptional
 	}
 	marker = string(data[i-size : i])
 
-	// if this is the end marker, it must match the beginning marker
-	if oldmarker != "" && marker != oldmarker {
+	// if this is the end marker, it must be made of the same char as the
+	// beginning marker and be at least as long
+	if oldmarker != "" && (c != oldmarker[0] || size < len(oldmarker)) {
 		return 0, ""
 	}
 
@@ -642,6 +962,11 @@ func isFenceLine(data []byte, syntax *string, oldmarker string, newlineOptional
 				syn++
 				i++
 			}
+			if info != nil {
+				infoStart := skipChar(data, i, ' ')
+				i = skipUntilChar(data, infoStart, '\n')
+				*info = strings.TrimSpace(string(data[infoStart:i]))
+			}
 		}
 
 		*syntax = string(data[syntaxStart : syntaxStart+syn])
@@ -662,20 +987,39 @@ func isFenceLine(data []byte, syntax *string, oldmarker string, newlineOptional
 // or 0 otherwise. It writes to out if doRender is true, otherwise it has no side effects.
 // If doRender is true, a final newline is mandatory to recognize the fenced code block.
 func (p *parser) fencedCodeBlock(out *bytes.Buffer, data []byte, doRender bool) int {
-	var syntax string
-	beg, marker := isFenceLine(data, &syntax, "", false)
+	var syntax, info string
+	var infop *string
+	if p.flags&EXTENSION_CODE_METADATA != 0 {
+		infop = &info
+	}
+	beg, marker := isFenceLine(data, &syntax, infop, "", false)
 	if beg == 0 || beg >= len(data) {
 		return 0
 	}
 
-	var work bytes.Buffer
+	// in CommonMark, backticks after a fence of backticks make it a code
+	// span instead
+	if p.flags&EXTENSION_COMMONMARK != 0 && marker[0] == '`' &&
+		bytes.IndexByte(data[bytes.IndexByte(data, '`')+len(marker):beg], '`') >= 0 {
+		return 0
+	}
+
+	// the lines of a fence indented by up to three spaces lose as many
+	// spaces as it has
+	indent := 0
+	for data[indent] == ' ' {
+		indent++
+	}
+
+	work := getBuffer()
+	defer putBuffer(work)
 
 	for {
 		// safe to assume beg < len(data)
 
 		// check for the end of the code block
 		newlineOptional := !doRender
-		fenceEnd, _ := isFenceLine(data[beg:], nil, marker, newlineOptional)
+		fenceEnd, _ := isFenceLine(data[beg:], nil, nil, marker, newlineOptional)
 		if fenceEnd != 0 {
 			beg += fenceEnd
 			break
@@ -686,33 +1030,117 @@ func (p *parser) fencedCodeBlock(out *bytes.Buffer, data []byte, doRender bool)
 
 		// did we reach the end of the buffer without a closing marker?
 		if end >= len(data) {
+			if doRender {
+				p.diagnose(DIAGNOSTIC_WARNING, data, "fenced code block without a closing fence")
+			}
+			p.lookedAhead(data, len(data))
 			return 0
 		}
 
 		// verbatim copy to the working buffer
 		if doRender {
-			work.Write(data[beg:end])
+			line := data[beg:end]
+			for i := 0; i < indent && len(line) > 0 && line[0] == ' '; i++ {
+				line = line[1:]
+			}
+			work.Write(line)
 		}
 		beg = end
 	}
 
 	if doRender {
-		p.r.BlockCode(out, work.Bytes(), syntax)
+		var attrs Attributes
+		if p.flags&(EXTENSION_ATTRIBUTES|EXTENSION_CODE_METADATA) != 0 {
+			syntax, attrs = fenceAttributes(syntax)
+		}
+		if info != "" {
+			attrs = append(attrs, codeMetadata(info)...)
+		}
+		p.r.BlockCode(out, work.Bytes(), syntax, attrs)
 	}
 
 	return beg
 }
 
+// isDivFence checks for a line of at least three colons, optionally
+// followed by an info string, and returns the length of the line and the
+// info string without any trailing colons.
+func isDivFence(data []byte) (end int, info string) {
+	i := 0
+	for i < 3 && i < len(data) && data[i] == ' ' {
+		i++
+	}
+	start := i
+	for i < len(data) && data[i] == ':' {
+		i++
+	}
+	if i-start < 3 {
+		return 0, ""
+	}
+
+	end = skipUntilChar(data, i, '\n')
+	info = strings.TrimSpace(strings.TrimRight(string(bytes.TrimSpace(data[i:end])), ":"))
+	if end < len(data) {
+		end++
+	}
+	return end, info
+}
+
+// A fenced div is opened by a fence with an info string and closed by a
+// bare fence. Divs can be nested, so the fences are counted to find the
+// one that closes it.
+func (p *parser) fencedDiv(out *bytes.Buffer, data []byte) int {
+	beg, info := isDivFence(data)
+	if beg == 0 || info == "" {
+		return 0
+	}
+
+	depth := 1
+	for i := beg; i < len(data); {
+		// fences inside fenced code don't count
+		if p.flags&EXTENSION_FENCED_CODE != 0 {
+			if n := p.fencedCodeBlock(out, data[i:], false); n > 0 {
+				i += n
+				continue
+			}
+		}
+
+		end, lineInfo := isDivFence(data[i:])
+		if end == 0 {
+			i = skipUntilChar(data, i, '\n') + 1
+			continue
+		}
+		if lineInfo != "" {
+			depth++
+		} else if depth--; depth == 0 {
+			work := getBuffer()
+			defer putBuffer(work)
+			if i > beg {
+				p.block(work, data[beg:i])
+			}
+			p.r.Container(out, info, work.Bytes())
+			return i + end
+		}
+		i += end
+	}
+
+	p.diagnose(DIAGNOSTIC_WARNING, data, "fenced div without a closing fence")
+	p.lookedAhead(data, len(data))
+	return 0
+}
+
 func (p *parser) table(out *bytes.Buffer, data []byte) int {
-	var header bytes.Buffer
-	i, columns := p.tableHeader(&header, data)
+	header := getBuffer()
+	defer putBuffer(header)
+	i, columns := p.tableHeader(header, data)
 	if i == 0 {
 		return 0
 	}
 
-	var body bytes.Buffer
+	body := getBuffer()
+	defer putBuffer(body)
 
-	for i < len(data) {
+	for rows := 1; i < len(data); rows++ {
 		pipes, rowStart := 0, i
 		for ; data[i] != '\n'; i++ {
 			if data[i] == '|' {
@@ -727,62 +1155,263 @@ func (p *parser) table(out *bytes.Buffer, data []byte) int {
 
 		// include the newline in data sent to tableRow
 		i++
-		p.tableRow(&body, data[rowStart:i], columns, false)
+		rowFlags := TABLE_ROW_ODD
+		if rows%2 == 0 {
+			rowFlags = TABLE_ROW_EVEN
+		}
+		p.tableRow(body, data[rowStart:i], columns, rowFlags)
+	}
+
+	caption := getBuffer()
+	defer putBuffer(caption)
+	if p.flags&EXTENSION_TABLE_EXTRAS != 0 {
+		i += p.tableCaption(caption, data[i:])
 	}
 
-	p.r.Table(out, header.Bytes(), body.Bytes(), columns)
+	p.r.Table(out, header.Bytes(), body.Bytes(), columns, caption.Bytes())
 
 	return i
 }
 
-// check if the specified position is preceded by an odd number of backslashes
-func isBackslashEscaped(data []byte, i int) bool {
-	backslashes := 0
-	for i-backslashes-1 >= 0 && data[i-backslashes-1] == '\\' {
-		backslashes++
+// tableCaption parses a "Table: caption" line after a table, which may be
+// separated from it by a blank line, and returns its length.
+func (p *parser) tableCaption(out *bytes.Buffer, data []byte) int {
+	i := p.isEmpty(data)
+	if !bytes.HasPrefix(data[i:], []byte("Table:")) {
+		return 0
 	}
-	return backslashes&1 == 1
+	start := skipChar(data, i+len("Table:"), ' ')
+	end := skipUntilChar(data, start, '\n')
+	caption := bytes.TrimRight(data[start:end], " ")
+	if len(caption) == 0 {
+		return 0
+	}
+	p.inline(out, caption)
+	if end < len(data) {
+		end++
+	}
+	return end
 }
 
-func (p *parser) tableHeader(out *bytes.Buffer, data []byte) (size int, columns []int) {
-	i := 0
-	colCount := 1
-	for i = 0; data[i] != '\n'; i++ {
-		if data[i] == '|' && !isBackslashEscaped(data, i) {
-			colCount++
-		}
+// gridTable parses a Pandoc grid table. Its rows are separated by border
+// lines, and the border under the header rows is drawn with '=' instead of
+// '-'. Colons in that border, or in the top one of a table without a
+// header, set the alignment of the columns.
+func (p *parser) gridTable(out *bytes.Buffer, data []byte) int {
+	end := skipUntilChar(data, 0, '\n')
+	bounds, columns := gridBorder(data[:end])
+	if bounds == nil {
+		return 0
 	}
 
-	// doesn't look like a table header
-	if colCount == 1 {
-		return
-	}
+	var rows [][][]byte
+	var lines [][]rune
+	headerRows := 0
+	i := end + 1
+	for i < len(data) && (data[i] == '|' || data[i] == '+') {
+		end = skipUntilChar(data, i, '\n')
+		line := data[i:end]
+		i = end + 1
 
-	// include the newline in the data sent to tableRow
-	header := data[:i+1]
+		if line[0] == '|' {
+			lines = append(lines, []rune(string(line)))
+			continue
+		}
 
-	// column count ignores pipes at beginning or end of line
-	if data[0] == '|' {
-		colCount--
+		b, aligns := gridBorder(line)
+		if len(b) != len(bounds) || len(lines) == 0 {
+			return 0
+		}
+		for k := range b {
+			if b[k] != bounds[k] {
+				return 0
+			}
+		}
+		rows = append(rows, gridCells(lines, bounds))
+		lines = nil
+
+		if bytes.IndexByte(line, '=') >= 0 {
+			if headerRows > 0 {
+				return 0
+			}
+			headerRows = len(rows)
+			columns = aligns
+		}
 	}
-	if i > 2 && data[i-1] == '|' && !isBackslashEscaped(data, i-1) {
-		colCount--
+
+	// the last row has to be closed by a border
+	if len(rows) == 0 || len(lines) > 0 {
+		return 0
+	}
+	if i > len(data) {
+		i = len(data)
 	}
 
-	columns = make([]int, colCount)
+	var header, body bytes.Buffer
+	for r, row := range rows {
+		var rowWork bytes.Buffer
+		for col, text := range row {
+			var cellWork bytes.Buffer
+			p.gridCell(&cellWork, text)
+			if r < headerRows {
+				p.r.TableHeaderCell(&rowWork, cellWork.Bytes(), columns[col]|TABLE_CELL_HEADER, 1)
+			} else {
+				p.r.TableCell(&rowWork, cellWork.Bytes(), columns[col], 1)
+			}
+		}
 
-	// move on to the header underline
-	i++
-	if i >= len(data) {
-		return
+		switch {
+		case r < headerRows:
+			p.r.TableRow(&header, rowWork.Bytes(), TABLE_ROW_HEADER)
+		case (r-headerRows)%2 == 0:
+			p.r.TableRow(&body, rowWork.Bytes(), TABLE_ROW_ODD)
+		default:
+			p.r.TableRow(&body, rowWork.Bytes(), TABLE_ROW_EVEN)
+		}
 	}
 
-	if data[i] == '|' && !isBackslashEscaped(data, i) {
-		i++
-	}
-	i = skipChar(data, i, ' ')
+	p.r.Table(out, header.Bytes(), body.Bytes(), columns, nil)
+	return i
+}
 
-	// each column header is of form: / *:?-+:? *|/ with # dashes + # colons >= 3
+// gridBorder checks for a border line of a grid table, such as
+// +:---+---:+, and returns the positions of its '+' characters and the
+// alignment of each column. The bounds are nil if it isn't a border.
+func gridBorder(line []byte) (bounds []int, columns []int) {
+	line = bytes.TrimRight(line, " ")
+	if len(line) < 3 || line[0] != '+' || line[len(line)-1] != '+' {
+		return nil, nil
+	}
+
+	bounds = []int{0}
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '-', '=', ':':
+		case '+':
+			start := bounds[len(bounds)-1] + 1
+			if i == start {
+				return nil, nil
+			}
+			align := 0
+			if line[start] == ':' {
+				align |= TABLE_ALIGNMENT_LEFT
+			}
+			if i-1 > start && line[i-1] == ':' {
+				align |= TABLE_ALIGNMENT_RIGHT
+			}
+			bounds = append(bounds, i)
+			columns = append(columns, align)
+		default:
+			return nil, nil
+		}
+	}
+	return bounds, columns
+}
+
+// gridCells cuts the lines of a grid table row into the text of its cells,
+// with the indentation they share removed.
+func gridCells(lines [][]rune, bounds []int) [][]byte {
+	cells := make([][]byte, len(bounds)-1)
+	for col := range cells {
+		var text []string
+		indent := -1
+		for _, line := range lines {
+			start, end := bounds[col]+1, bounds[col+1]
+			if end > len(line) {
+				end = len(line)
+			}
+			if start > end {
+				start = end
+			}
+			s := strings.TrimRight(string(line[start:end]), " ")
+			if s != "" {
+				n := len(s) - len(strings.TrimLeft(s, " "))
+				if indent < 0 || n < indent {
+					indent = n
+				}
+			}
+			text = append(text, s)
+		}
+
+		var cell bytes.Buffer
+		for _, s := range text {
+			if s == "" && cell.Len() == 0 {
+				continue
+			}
+			if s != "" {
+				cell.WriteString(s[indent:])
+			}
+			cell.WriteByte('\n')
+		}
+		cells[col] = append(bytes.TrimRight(cell.Bytes(), "\n"), '\n')
+		if len(cells[col]) == 1 {
+			cells[col] = nil
+		}
+	}
+	return cells
+}
+
+// gridCell parses the text of a grid table cell. As in list items, the
+// text is only taken as blocks if it has a blank line or starts a list.
+func (p *parser) gridCell(out *bytes.Buffer, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	if bytes.Contains(text, []byte("\n\n")) || p.uliPrefix(text) > 0 || p.oliPrefix(text) > 0 {
+		p.block(out, text)
+		return
+	}
+	p.inline(out, text[:len(text)-1])
+}
+
+// check if the specified position is preceded by an odd number of backslashes
+func isBackslashEscaped(data []byte, i int) bool {
+	backslashes := 0
+	for i-backslashes-1 >= 0 && data[i-backslashes-1] == '\\' {
+		backslashes++
+	}
+	return backslashes&1 == 1
+}
+
+func (p *parser) tableHeader(out *bytes.Buffer, data []byte) (size int, columns []int) {
+	i := 0
+	colCount := 1
+	for i = 0; data[i] != '\n'; i++ {
+		if data[i] == '|' && !isBackslashEscaped(data, i) {
+			colCount++
+		}
+	}
+
+	// doesn't look like a table header
+	if colCount == 1 {
+		return
+	}
+
+	// include the newline in the data sent to tableRow
+	header := data[:i+1]
+
+	// column count ignores pipes at beginning or end of line
+	if data[0] == '|' {
+		colCount--
+	}
+	if i > 2 && data[i-1] == '|' && !isBackslashEscaped(data, i-1) {
+		colCount--
+	}
+
+	columns = make([]int, colCount)
+
+	// move on to the header underline
+	i++
+	if i >= len(data) {
+		return
+	}
+
+	if data[i] == '|' && !isBackslashEscaped(data, i) {
+		i++
+	}
+	i = skipChar(data, i, ' ')
+
+	// each column header is of form: / *:?-+:? *|/ with # dashes + # colons >= 3
 	// and trailing | optional on last column
 	col := 0
 	for data[i] != '\n' {
@@ -842,20 +1471,24 @@ func (p *parser) tableHeader(out *bytes.Buffer, data []byte) (size int, columns
 		return
 	}
 
-	p.tableRow(out, header, columns, true)
+	p.tableRow(out, header, columns, TABLE_ROW_HEADER)
 	size = i + 1
 	return
 }
 
-func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, header bool) {
+func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, flags int) {
+	header := flags&TABLE_ROW_HEADER != 0
 	i, col := 0, 0
 	var rowWork bytes.Buffer
+	row := p.mark()
 
 	if data[i] == '|' && !isBackslashEscaped(data, i) {
 		i++
 	}
 
-	for col = 0; col < len(columns) && i < len(data); col++ {
+	span := 1
+	for col = 0; col < len(columns) && i < len(data); col += span {
+		span = 1
 		for data[i] == ' ' {
 			i++
 		}
@@ -871,32 +1504,47 @@ func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, header
 		// skip the end-of-cell marker, possibly taking us past end of buffer
 		i++
 
+		// more markers right after it make the cell span more columns
+		if p.flags&EXTENSION_TABLE_EXTRAS != 0 {
+			for i < len(data) && data[i] == '|' && col+span < len(columns) {
+				span++
+				i++
+			}
+		}
+
 		for cellEnd > cellStart && data[cellEnd-1] == ' ' {
 			cellEnd--
 		}
 
 		var cellWork bytes.Buffer
+		cell := p.mark()
 		p.inline(&cellWork, data[cellStart:cellEnd])
 
 		if header {
-			p.r.TableHeaderCell(&rowWork, cellWork.Bytes(), columns[col])
+			p.r.TableHeaderCell(&rowWork, cellWork.Bytes(), columns[col]|TABLE_CELL_HEADER, span)
 		} else {
-			p.r.TableCell(&rowWork, cellWork.Bytes(), columns[col])
+			p.r.TableCell(&rowWork, cellWork.Bytes(), columns[col], span)
 		}
+		p.place(cell, data[cellStart:], cellEnd-cellStart)
 	}
 
-	// pad it out with empty columns to get the right number
+	// pad it out with empty columns to get the right number, which are
+	// nowhere in the document
+	pad := p.mark()
 	for ; col < len(columns); col++ {
 		if header {
-			p.r.TableHeaderCell(&rowWork, nil, columns[col])
+			p.r.TableHeaderCell(&rowWork, nil, columns[col]|TABLE_CELL_HEADER, 1)
 		} else {
-			p.r.TableCell(&rowWork, nil, columns[col])
+			p.r.TableCell(&rowWork, nil, columns[col], 1)
 		}
 	}
 
+	p.place(pad, nil, 0)
+
 	// silently ignore rows with too many cells
 
-	p.r.TableRow(out, rowWork.Bytes())
+	p.r.TableRow(out, rowWork.Bytes(), flags)
+	p.place(row, data, skipUntilChar(data, 0, '\n'))
 }
 
 // returns blockquote prefix length
@@ -928,10 +1576,41 @@ func (p *parser) terminateBlockquote(data []byte, beg, end int) bool {
 
 // parse a blockquote fragment
 func (p *parser) quote(out *bytes.Buffer, data []byte) int {
-	var raw bytes.Buffer
+	raw := getBuffer()
+	defer putBuffer(raw)
+	var src sourceCopy
 	beg, end := 0, 0
+	paragraph, fence := false, ""
 	for beg < len(data) {
 		end = beg
+		// in CommonMark, the lines of a block quote have its prefix, but
+		// for those that lazily continue a paragraph
+		if p.flags&EXTENSION_COMMONMARK != 0 {
+			end = skipUntilChar(data, beg, '\n') + 1
+			pre := p.quotePrefix(data[beg:])
+			if pre == 0 && (!paragraph || !p.continuesParagraph(data[beg:end])) {
+				end = beg
+				break
+			}
+			line := data[beg+pre : end]
+			switch {
+			case pre == 0:
+			case fence != "":
+				if n, _ := isFenceLine(line, nil, nil, fence, false); n > 0 {
+					fence = ""
+				}
+				paragraph = false
+			default:
+				if n, marker := isFenceLine(line, nil, nil, "", false); n > 0 && p.flags&EXTENSION_FENCED_CODE != 0 {
+					fence = marker
+				}
+				paragraph = fence == "" && (paragraph && p.codePrefix(line) > 0 || p.paragraphLine(line))
+			}
+			p.copySource(&src, raw, line)
+			beg = end
+			continue
+		}
+
 		// Step over whole lines, collecting them. While doing that, check for
 		// fenced code and if one's found, incorporate it altogether,
 		// irregardless of any contents inside it
@@ -955,26 +1634,122 @@ func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 		}
 
 		// this line is part of the blockquote
-		raw.Write(data[beg:end])
+		p.copySource(&src, raw, data[beg:end])
 		beg = end
 	}
+	defer p.leaveCopy(p.enterCopy(&src, raw.Bytes()))
 
-	var cooked bytes.Buffer
-	p.block(&cooked, raw.Bytes())
+	if p.flags&EXTENSION_ADMONITIONS != 0 {
+		if kind, title, body := admonitionHeader(raw.Bytes()); kind != "" {
+			cooked := getBuffer()
+			defer putBuffer(cooked)
+			if len(body) > 0 {
+				p.block(cooked, body)
+			}
+			p.r.Admonition(out, kind, title, cooked.Bytes())
+			return end
+		}
+	}
+
+	cooked := getBuffer()
+	defer putBuffer(cooked)
+	if p.flags&EXTENSION_QUOTE_CITATIONS != 0 {
+		if body, citation := quoteCitation(raw.Bytes()); citation != nil {
+			p.block(cooked, body)
+			source := getBuffer()
+			defer putBuffer(source)
+			p.inline(source, citation)
+			p.r.BlockQuoteCitation(out, cooked.Bytes(), source.Bytes())
+			return end
+		}
+	}
+	p.block(cooked, raw.Bytes())
 	p.r.BlockQuote(out, cooked.Bytes())
 	return end
 }
 
+// quoteCitation looks for the line that credits a blockquote at its end:
+//
+//	> Simplicity is prerequisite for reliability.
+//	> -- Edsger W. Dijkstra
+//
+// The line starts with two hyphens or an em dash and a space. It returns
+// the blockquote before the line and the citation after the dash, or a nil
+// citation if data doesn't end with such a line after some other text.
+func quoteCitation(data []byte) (body, citation []byte) {
+	end := len(data)
+	for end > 0 && (data[end-1] == '\n' || data[end-1] == ' ') {
+		end--
+	}
+	beg := bytes.LastIndexByte(data[:end], '\n') + 1
+	line := data[beg:end]
+	switch {
+	case bytes.HasPrefix(line, []byte("-- ")):
+		line = line[3:]
+	case bytes.HasPrefix(line, []byte("\u2014 ")):
+		line = line[len("\u2014 "):]
+	default:
+		return nil, nil
+	}
+	line = bytes.TrimLeft(line, " ")
+	if len(line) == 0 || len(bytes.TrimSpace(data[:beg])) == 0 {
+		return nil, nil
+	}
+	return data[:beg], line
+}
+
+// admonitionHeader looks for the [!KIND] line, optionally followed by a
+// title, that turns a blockquote into an admonition:
+//
+//	> [!WARNING] Mind the gap
+//	> Text of the warning.
+//
+// It returns the kind in lower case, the title and the rest of the
+// blockquote, or an empty kind if data doesn't start with such a line.
+func admonitionHeader(data []byte) (kind string, title, body []byte) {
+	if !bytes.HasPrefix(data, []byte("[!")) {
+		return "", nil, nil
+	}
+	i := 2
+	for i < len(data) && (isalnum(data[i]) || data[i] == '-') {
+		i++
+	}
+	if i == 2 || i >= len(data) || data[i] != ']' {
+		return "", nil, nil
+	}
+	kind = strings.ToLower(string(data[2:i]))
+
+	end := i + 1
+	for end < len(data) && data[end] != '\n' {
+		end++
+	}
+	title = bytes.TrimSpace(data[i+1 : end])
+	if end < len(data) {
+		end++
+	}
+	return kind, title, data[end:]
+}
+
+// admonitionTitle returns the title of an admonition, which defaults to
+// its kind with a capital letter.
+func admonitionTitle(kind string, title []byte) []byte {
+	if len(title) > 0 || kind == "" {
+		return title
+	}
+	return []byte(strings.ToUpper(kind[:1]) + kind[1:])
+}
+
 // returns prefix length for block code
 func (p *parser) codePrefix(data []byte) int {
-	if data[0] == ' ' && data[1] == ' ' && data[2] == ' ' && data[3] == ' ' {
+	if len(data) >= 4 && data[0] == ' ' && data[1] == ' ' && data[2] == ' ' && data[3] == ' ' {
 		return 4
 	}
 	return 0
 }
 
 func (p *parser) code(out *bytes.Buffer, data []byte) int {
-	var work bytes.Buffer
+	work := getBuffer()
+	defer putBuffer(work)
 
 	i := 0
 	for i < len(data) {
@@ -1013,7 +1788,7 @@ func (p *parser) code(out *bytes.Buffer, data []byte) int {
 
 	work.WriteByte('\n')
 
-	p.r.BlockCode(out, work.Bytes(), "")
+	p.r.BlockCode(out, work.Bytes(), "", nil)
 
 	return i
 }
@@ -1028,8 +1803,15 @@ func (p *parser) uliPrefix(data []byte) int {
 	}
 
 	// need a *, +, or - followed by a space
-	if (data[i] != '*' && data[i] != '+' && data[i] != '-') ||
-		data[i+1] != ' ' {
+	if data[i] != '*' && data[i] != '+' && data[i] != '-' {
+		return 0
+	}
+	// or, in CommonMark, by the end of the line, for an item that starts
+	// with a blank line
+	if data[i+1] == '\n' && p.flags&EXTENSION_COMMONMARK != 0 {
+		return i + 1
+	}
+	if data[i+1] != ' ' {
 		return 0
 	}
 	return i + 2
@@ -1050,13 +1832,170 @@ func (p *parser) oliPrefix(data []byte) int {
 		i++
 	}
 
-	// we need >= 1 digits followed by a dot and a space
-	if start == i || data[i] != '.' || data[i+1] != ' ' {
+	// or take a letter or a roman numeral
+	if start == i && p.flags&EXTENSION_FANCY_LISTS != 0 {
+		i += letterMarker(data[i:])
+	}
+
+	// or an example marker, which has its parentheses already
+	if start == i && p.flags&EXTENSION_EXAMPLE_LISTS != 0 {
+		if _, n := exampleMarker(data[i:]); n > 0 && data[i+n] == ' ' {
+			return i + n + 1
+		}
+	}
+
+	// we need >= 1 digits followed by a dot and a space; CommonMark and
+	// fancy lists also allow a parenthesis instead of the dot
+	if start == i || (data[i] != '.' && (data[i] != ')' || p.flags&(EXTENSION_COMMONMARK|EXTENSION_FANCY_LISTS) == 0)) {
+		return 0
+	}
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		// CommonMark takes up to 9 digits, and an empty first line
+		if i-start > 9 {
+			return 0
+		}
+		if data[i+1] == '\n' {
+			return i + 1
+		}
+	}
+	if data[i+1] != ' ' {
+		return 0
+	}
+
+	// a capital letter and a dot need two spaces, or initials such as
+	// "B. Russell" would start lists
+	if i-start == 1 && isupper(data[start]) && data[i] == '.' && data[i+2] != ' ' {
 		return 0
 	}
 	return i + 2
 }
 
+// returns the length of a letter or roman numeral list marker, or 0 if data
+// doesn't start with one
+func letterMarker(data []byte) int {
+	if !isletter(data[0]) {
+		return 0
+	}
+	if n := romanLength(data); n > 1 && strings.EqualFold(romanNumeral(romanValue(data[:n])), string(data[:n])) {
+		return n
+	}
+	if isletter(data[1]) {
+		return 0
+	}
+	return 1
+}
+
+// returns the number of an ordered list item and the LIST_DELIMITER_PAREN
+// and LIST_STYLE_* flags of its marker, which must have been checked with
+// oliPrefix
+func (p *parser) oliMarker(data []byte) (int, int) {
+	i := 0
+	for data[i] == ' ' {
+		i++
+	}
+	start := i
+	for data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+
+	// examples take the next number in the document
+	if _, n := exampleMarker(data[i:]); n > 0 {
+		return p.exampleCount + 1, LIST_STYLE_EXAMPLE
+	}
+
+	flags := 0
+	number := 1
+	if start == i {
+		i += letterMarker(data[i:])
+		marker := data[start:i]
+		upper := isupper(marker[0])
+		// i, v and x on their own are taken for roman numerals
+		if romanLength(marker) == len(marker) && (len(marker) > 1 || bytes.IndexByte([]byte("ivxIVX"), marker[0]) >= 0) {
+			number = romanValue(marker)
+			flags = LIST_STYLE_LOWER_ROMAN
+			if upper {
+				flags = LIST_STYLE_UPPER_ROMAN
+			}
+		} else {
+			number = int(marker[0]|0x20-'a') + 1
+			flags = LIST_STYLE_LOWER_ALPHA
+			if upper {
+				flags = LIST_STYLE_UPPER_ALPHA
+			}
+		}
+	} else if n, err := strconv.Atoi(string(data[start:i])); err == nil {
+		number = n
+	}
+
+	if data[i] == ')' {
+		flags |= LIST_DELIMITER_PAREN
+	}
+	return number, flags
+}
+
+var romanDigits = map[byte]int{'i': 1, 'v': 5, 'x': 10, 'l': 50, 'c': 100, 'd': 500, 'm': 1000}
+
+// returns the length of the roman numeral data starts with, all of whose
+// letters are of the same case
+func romanLength(data []byte) int {
+	i := 0
+	for i < len(data) && isletter(data[i]) && isupper(data[i]) == isupper(data[0]) {
+		if romanDigits[data[i]|0x20] == 0 {
+			return 0
+		}
+		i++
+	}
+	return i
+}
+
+// returns the value of a roman numeral
+func romanValue(numeral []byte) int {
+	value := 0
+	for i, c := range numeral {
+		digit := romanDigits[c|0x20]
+		if i+1 < len(numeral) && romanDigits[numeral[i+1]|0x20] > digit {
+			value -= digit
+		} else {
+			value += digit
+		}
+	}
+	return value
+}
+
+// returns the marker of the nth item of an ordered list with the given
+// LIST_STYLE_* flags, without its delimiter
+func listMarker(n int, flags int) string {
+	switch {
+	case flags&(LIST_STYLE_LOWER_ALPHA|LIST_STYLE_UPPER_ALPHA) != 0 && n >= 1 && n <= 26:
+		marker := string(rune('a' + n - 1))
+		if flags&LIST_STYLE_UPPER_ALPHA != 0 {
+			marker = strings.ToUpper(marker)
+		}
+		return marker
+	case flags&(LIST_STYLE_LOWER_ROMAN|LIST_STYLE_UPPER_ROMAN) != 0 && n >= 1 && n < 4000:
+		marker := romanNumeral(n)
+		if flags&LIST_STYLE_LOWER_ROMAN != 0 {
+			marker = strings.ToLower(marker)
+		}
+		return marker
+	}
+	return strconv.Itoa(n)
+}
+
+// returns n in roman numerals
+func romanNumeral(n int) string {
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	numerals := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var out bytes.Buffer
+	for i, value := range values {
+		for n >= value {
+			out.WriteString(numerals[i])
+			n -= value
+		}
+	}
+	return out.String()
+}
+
 // returns definition list item prefix
 func (p *parser) dliPrefix(data []byte) int {
 	i := 0
@@ -1074,27 +2013,75 @@ func (p *parser) dliPrefix(data []byte) int {
 // parse ordered or unordered list block
 func (p *parser) list(out *bytes.Buffer, data []byte, flags int) int {
 	i := 0
+	start := 0
+	if flags&LIST_TYPE_ORDERED != 0 {
+		var marker int
+		start, marker = p.oliMarker(data)
+		flags |= marker
+	}
 	flags |= LIST_ITEM_BEGINNING_OF_LIST
-	work := func() bool {
-		for i < len(data) {
-			skip := p.listItem(out, data[i:], &flags)
-			i += skip
+	listFlags := flags
 
-			if skip == 0 || flags&LIST_ITEM_END_OF_LIST != 0 {
-				break
+	// the items are gathered before any is rendered, to tell the renderer
+	// whether the list is loose
+	var items []listItemData
+	for i < len(data) {
+		item, skip := p.listItem(data[i:], &flags)
+		if skip == 0 {
+			break
+		}
+		if flags&LIST_STYLE_EXAMPLE != 0 {
+			p.numberExample(data[i:])
+		}
+		i += skip
+		items = append(items, item)
+		if flags&LIST_ITEM_END_OF_LIST != 0 {
+			break
+		}
+		flags &= ^LIST_ITEM_BEGINNING_OF_LIST
+	}
+
+	loose := 0
+	for _, item := range items {
+		if item.flags&LIST_ITEM_CONTAINS_BLOCK != 0 && item.flags&LIST_TYPE_TERM == 0 {
+			loose = LIST_LOOSE
+		}
+	}
+
+	work := func() bool {
+		for _, item := range items {
+			item.flags |= loose
+			// in CommonMark, all the items of a loose list are
+			// paragraphs, not only those next to blank lines
+			if loose != 0 && p.flags&EXTENSION_COMMONMARK != 0 && item.flags&LIST_TYPE_TERM == 0 {
+				item.flags |= LIST_ITEM_CONTAINS_BLOCK
 			}
-			flags &= ^LIST_ITEM_BEGINNING_OF_LIST
+			p.renderListItem(out, item)
 		}
 		return true
 	}
 
-	p.r.List(out, work, flags)
+	p.r.List(out, work, listFlags|loose, start)
 	return i
 }
 
+// listItemData is a list item gathered by listItem, to be rendered by
+// renderListItem.
+type listItemData struct {
+	raw     []byte     // the lines of the item without their prefix
+	sublist int        // where a nested list starts in raw, if there is one
+	flags   int        // LIST_* flags of the item
+	source  []byte     // the lines of the item as they are in data
+	copy    sourceCopy // where the lines of raw come from
+}
+
 // Parse a single list item.
 // Assumes initial prefix is already removed if this is a sublist.
-func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
+func (p *parser) listItem(data []byte, flags *int) (listItemData, int) {
+	if p.flags&EXTENSION_COMMONMARK != 0 && *flags&LIST_TYPE_DEFINITION == 0 {
+		return p.commonmarkListItem(data, flags)
+	}
+
 	// keep track of the indentation of the first line
 	itemIndent := 0
 	for itemIndent < 3 && data[itemIndent] == ' ' {
@@ -1117,7 +2104,7 @@ func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
 		if *flags&LIST_TYPE_DEFINITION != 0 {
 			*flags |= LIST_TYPE_TERM
 		} else {
-			return 0
+			return listItemData{}, 0
 		}
 	}
 
@@ -1126,6 +2113,18 @@ func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
 		i++
 	}
 
+	// task list item: [ ] or [x] right after the list marker
+	taskFlags := 0
+	if p.flags&EXTENSION_TASK_LISTS != 0 && *flags&LIST_TYPE_DEFINITION == 0 {
+		if n := isTaskMarker(data[i:]); n > 0 {
+			taskFlags = LIST_ITEM_TASK
+			if data[i+1] != ' ' {
+				taskFlags |= LIST_ITEM_CHECKED
+			}
+			i += n
+		}
+	}
+
 	// find the end of the line
 	line := i
 	for i > 0 && data[i-1] != '\n' {
@@ -1134,9 +2133,10 @@ func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
 
 	// get working buffer
 	var raw bytes.Buffer
+	var src sourceCopy
 
 	// put the first line into the working buffer
-	raw.Write(data[line:i])
+	p.copySource(&src, &raw, data[line:i])
 	line = i
 
 	// process the following lines
@@ -1156,7 +2156,7 @@ gatherlines:
 		// and move on to the next line
 		if p.isEmpty(data[line:i]) > 0 {
 			containsBlankLine = true
-			raw.Write(data[line:i])
+			p.copySource(&src, &raw, data[line:i])
 			line = i
 			continue
 		}
@@ -1188,6 +2188,23 @@ gatherlines:
 				*flags |= LIST_ITEM_CONTAINS_BLOCK
 			}
 
+			// CommonMark starts a new list when the bullet character changes
+			if p.flags&EXTENSION_COMMONMARK != 0 && indent <= itemIndent &&
+				*flags&(LIST_TYPE_ORDERED|LIST_TYPE_DEFINITION) == 0 &&
+				p.uliPrefix(chunk) > 0 && chunk[0] != data[itemIndent] {
+
+				*flags |= LIST_ITEM_END_OF_LIST
+				break gatherlines
+			}
+
+			// and when the delimiter of an ordered list changes
+			if indent <= itemIndent && *flags&LIST_TYPE_ORDERED != 0 && p.oliPrefix(chunk) > 0 {
+				if _, marker := p.oliMarker(chunk); marker&LIST_DELIMITER_PAREN != *flags&LIST_DELIMITER_PAREN {
+					*flags |= LIST_ITEM_END_OF_LIST
+					break gatherlines
+				}
+			}
+
 			// to be a nested list, it must be indented more
 			// if not, it is the next item in the same list
 			if indent <= itemIndent {
@@ -1238,7 +2255,7 @@ gatherlines:
 		containsBlankLine = false
 
 		// add the line into the working buffer without prefix
-		raw.Write(data[line+indent : i])
+		p.copySource(&src, &raw, data[line+indent:i])
 
 		line = i
 	}
@@ -1249,25 +2266,290 @@ gatherlines:
 		*flags |= LIST_ITEM_END_OF_LIST
 	}
 
-	rawBytes := raw.Bytes()
+	return listItemData{raw: raw.Bytes(), sublist: sublist, flags: *flags | taskFlags, source: data[:line], copy: src}, line
+}
+
+// commonmarkListItem parses a list item by the rules of CommonMark: its
+// lines are those indented at least as far as the text after its marker,
+// and those that lazily continue a paragraph of it. It is loose if a blank
+// line separates two of its blocks, or the item from the next one.
+func (p *parser) commonmarkListItem(data []byte, flags *int) (listItemData, int) {
+	itemIndent := 0
+	for itemIndent < 3 && data[itemIndent] == ' ' {
+		itemIndent++
+	}
+	i := p.uliPrefix(data)
+	if i == 0 {
+		i = p.oliPrefix(data)
+	}
+	if i == 0 {
+		return listItemData{}, 0
+	}
+
+	// the text starts after the marker and up to four spaces; with more,
+	// it starts with indented code one space after the marker
+	marker := i
+	if data[i-1] == ' ' {
+		marker--
+	}
+	content := skipChar(data, marker, ' ')
+	startsBlank := data[content] == '\n'
+	if startsBlank || content-marker > 4 {
+		content = marker + 1
+	}
+
+	taskFlags := 0
+	if p.flags&EXTENSION_TASK_LISTS != 0 && !startsBlank {
+		if n := isTaskMarker(data[content:]); n > 0 {
+			taskFlags = LIST_ITEM_TASK
+			if data[content+1] != ' ' {
+				taskFlags |= LIST_ITEM_CHECKED
+			}
+			i = content + n
+		}
+	}
+	if i < content {
+		i = content
+	}
+
+	var raw bytes.Buffer
+	var src sourceCopy
+	line := skipUntilChar(data, marker, '\n') + 1
+	if !startsBlank {
+		p.copySource(&src, &raw, data[i:line])
+	}
+
+	var (
+		blank     bool   // the last line was blank
+		paragraph bool   // the last line was of a paragraph
+		fence     string // the marker of the fenced code the item is in
+		nested    int    // how far the text of a list in the item is indented
+		loose     bool
+		done      bool // the item is over, but for blank lines
+	)
+	if p.flags&EXTENSION_FENCED_CODE != 0 && !startsBlank {
+		if n, marker := isFenceLine(data[i:line], nil, nil, "", false); n > 0 {
+			fence = marker
+		}
+	}
+	paragraph = !startsBlank && fence == "" && p.paragraphLine(data[i:line])
+	for line < len(data) {
+		end := skipUntilChar(data, line, '\n') + 1
+		if p.isEmpty(data[line:end]) > 0 {
+			// an item can start with one blank line, but not two
+			if startsBlank && raw.Len() == 0 {
+				done = true
+			}
+			blank, paragraph = true, false
+			if !done {
+				p.copySource(&src, &raw, data[line:end])
+			}
+			line = end
+			continue
+		}
+
+		indent := 0
+		for line+indent < end && data[line+indent] == ' ' {
+			indent++
+		}
+		if indent < content || done {
+			chunk := data[line+indent : end]
+
+			// a paragraph goes on over lines that start no other block
+			if !blank && paragraph && p.continuesParagraph(data[line:end]) {
+				p.copySource(&src, &raw, data[line:end])
+				line = end
+				continue
+			}
+
+			// the next item of the same list, or the end of the list
+			if indent > 3 || p.uliPrefix(chunk) == 0 && p.oliPrefix(chunk) == 0 || p.isHRule(chunk) ||
+				!sameListMarker(data[itemIndent:], chunk, *flags) {
+				*flags |= LIST_ITEM_END_OF_LIST
+			} else if blank {
+				loose = true
+			}
+			break
+		}
+
+		// a line of the item
+		chunk := data[line+content : end]
+		rel := indent - content
+		inFence := fence != ""
+		switch {
+		case fence != "":
+			if n, _ := isFenceLine(chunk, nil, nil, fence, false); n > 0 {
+				fence = ""
+			}
+		case blank && nested > 0 && (rel >= nested || rel < 4 && p.listMarker(chunk) > 0):
+			// still in a list of the item
+		case blank:
+			loose = true
+		}
+		if rel < 4 && !inFence {
+			if n, marker := isFenceLine(chunk, nil, nil, "", false); n > 0 && p.flags&EXTENSION_FENCED_CODE != 0 {
+				fence = marker
+			} else if n := p.listMarker(chunk); n > 0 {
+				nested = n
+			} else if blank && rel < nested {
+				nested = 0
+			}
+		}
+		switch {
+		case inFence || fence != "":
+			paragraph = false
+		case rel >= 4:
+			// continuing a paragraph, or indented code
+		default:
+			paragraph = p.paragraphLine(chunk)
+		}
+		blank = false
+		p.copySource(&src, &raw, chunk)
+		line = end
+	}
+
+	if line >= len(data) {
+		*flags |= LIST_ITEM_END_OF_LIST
+	}
+	if loose {
+		*flags |= LIST_ITEM_CONTAINS_BLOCK
+	}
+	item := listItemData{raw: raw.Bytes(), flags: *flags | taskFlags, source: data[:line], copy: src}
+	*flags &^= LIST_ITEM_CONTAINS_BLOCK
+	return item, line
+}
+
+// listMarker returns how far the text of the list item data starts with
+// is indented, or 0 if data doesn't start with one
+func (p *parser) listMarker(data []byte) int {
+	i := p.uliPrefix(data)
+	if i == 0 {
+		i = p.oliPrefix(data)
+	}
+	if i == 0 || p.isHRule(data) {
+		return 0
+	}
+	marker := i
+	if data[i-1] == ' ' {
+		marker--
+	}
+	if content := skipChar(data, marker, ' '); data[content] != '\n' && content-marker <= 4 {
+		return content
+	}
+	return marker + 1
+}
+
+// sameListMarker reports whether the list item next is of the same list
+// as the item item, which has the LIST_* flags
+func sameListMarker(item, next []byte, flags int) bool {
+	if flags&LIST_TYPE_ORDERED == 0 {
+		return next[0] == item[0]
+	}
+	i := 0
+	for i < len(next) && isdigit(next[i]) {
+		i++
+	}
+	if i == 0 {
+		return false
+	}
+	return next[i] == ')' == (flags&LIST_DELIMITER_PAREN != 0)
+}
+
+// paragraphLine reports whether the line data is one of a paragraph, once
+// it is inside the block quotes and list items it starts, which the lines
+// after it can lazily continue in CommonMark
+func (p *parser) paragraphLine(data []byte) bool {
+	for len(data) > 0 {
+		if i := p.quotePrefix(data); i > 0 {
+			data = data[i:]
+		} else if i := p.listMarker(data); i > 0 {
+			if data[i-1] == '\n' {
+				return false // an empty list item
+			}
+			data = data[i:]
+		} else {
+			break
+		}
+	}
+	return len(data) > 0 && p.isEmpty(data) == 0 && p.codePrefix(data) == 0 && !p.startsBlock(data)
+}
+
+// continuesParagraph reports whether the line data can lazily continue a
+// paragraph, in CommonMark, for starting no other block or list item
+func (p *parser) continuesParagraph(data []byte) bool {
+	if p.isEmpty(data) > 0 {
+		return false
+	}
+	return p.codePrefix(data) > 0 || !p.startsBlock(data) && p.uliPrefix(data) == 0 && p.oliPrefix(data) == 0
+}
+
+// startsBlock reports whether the line data starts a block that ends a
+// paragraph before it, in CommonMark
+func (p *parser) startsBlock(data []byte) bool {
+	if p.isPrefixHeader(data) || p.isHRule(data) || p.quotePrefix(data) > 0 {
+		return true
+	}
+	if p.flags&EXTENSION_FENCED_CODE != 0 {
+		if n, _ := isFenceLine(data, nil, nil, "", false); n > 0 {
+			return true
+		}
+	}
+	if kind := p.htmlBlockKind(data); kind > 0 && kind < 7 {
+		return true
+	}
+	return p.interruptingListItem(data)
+}
+
+// interruptingListItem reports whether the line data starts a list item
+// that can end a paragraph before it, in CommonMark: one with some text,
+// and numbered 1 if it is ordered.
+func (p *parser) interruptingListItem(data []byte) bool {
+	i := p.uliPrefix(data)
+	if i == 0 {
+		if i = p.oliPrefix(data); i == 0 {
+			return false
+		}
+		if number, _ := p.oliMarker(data); number != 1 {
+			return false
+		}
+	}
+	return p.isEmpty(data[i:]) == 0
+}
+
+// renderListItem renders a list item gathered by listItem.
+func (p *parser) renderListItem(out *bytes.Buffer, item listItemData) {
+	rawBytes, sublist := item.raw, item.sublist
+	mark := p.mark()
+	defer p.leaveCopy(p.enterCopy(&item.copy, rawBytes))
 
 	// render the contents of the list item
-	var cooked bytes.Buffer
-	if *flags&LIST_ITEM_CONTAINS_BLOCK != 0 && *flags&LIST_TYPE_TERM == 0 {
+	cooked := getBuffer()
+	defer putBuffer(cooked)
+	if p.flags&EXTENSION_COMMONMARK != 0 && item.flags&LIST_TYPE_DEFINITION == 0 {
+		// the paragraphs of a tight item are rendered as their text
+		if len(rawBytes) > 0 {
+			tight := p.tight
+			if item.flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+				p.tight = p.nesting + 1
+			}
+			p.block(cooked, rawBytes)
+			p.tight = tight
+		}
+	} else if item.flags&LIST_ITEM_CONTAINS_BLOCK != 0 && item.flags&LIST_TYPE_TERM == 0 {
 		// intermediate render of block item, except for definition term
 		if sublist > 0 {
-			p.block(&cooked, rawBytes[:sublist])
-			p.block(&cooked, rawBytes[sublist:])
+			p.block(cooked, rawBytes[:sublist])
+			p.block(cooked, rawBytes[sublist:])
 		} else {
-			p.block(&cooked, rawBytes)
+			p.block(cooked, rawBytes)
 		}
 	} else {
 		// intermediate render of inline item
 		if sublist > 0 {
-			p.inline(&cooked, rawBytes[:sublist])
-			p.block(&cooked, rawBytes[sublist:])
+			p.inline(cooked, rawBytes[:sublist])
+			p.block(cooked, rawBytes[sublist:])
 		} else {
-			p.inline(&cooked, rawBytes)
+			p.inline(cooked, rawBytes)
 		}
 	}
 
@@ -1279,9 +2561,24 @@ gatherlines:
 	for parsedEnd > 0 && cookedBytes[parsedEnd-1] == '\n' {
 		parsedEnd--
 	}
-	p.r.ListItem(out, cookedBytes[:parsedEnd], *flags)
+	p.r.ListItem(out, cookedBytes[:parsedEnd], item.flags)
+	p.place(mark, item.source, len(item.source))
+}
 
-	return line
+// returns the length of a task list marker ("[ ] ", "[x] " or "[X] ")
+// including the spaces that follow it, or 0 if there is none
+func isTaskMarker(data []byte) int {
+	if len(data) < 4 || data[0] != '[' || data[2] != ']' || data[3] != ' ' {
+		return 0
+	}
+	if data[1] != ' ' && data[1] != 'x' && data[1] != 'X' {
+		return 0
+	}
+	i := 4
+	for i < len(data) && data[i] == ' ' {
+		i++
+	}
+	return i
 }
 
 // render a single paragraph that has already been parsed out
@@ -1304,10 +2601,20 @@ func (p *parser) renderParagraph(out *bytes.Buffer, data []byte) {
 		end--
 	}
 
+	if p.tight != 0 && p.tight == p.nesting {
+		p.inline(out, data[beg:end])
+		return
+	}
 	work := func() bool {
 		p.inline(out, data[beg:end])
 		return true
 	}
+	if p.flags&EXTENSION_MEDIA_EMBED != 0 && mediaLine(data[beg:end]) {
+		work = func() bool {
+			p.r.MediaEmbed(out, MEDIA_EMBED, data[beg:end], nil, nil)
+			return true
+		}
+	}
 	p.r.Paragraph(out, work)
 }
 
@@ -1340,6 +2647,12 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 		// an underline under some text marks a header, so our paragraph ended on prev line
 		if i > 0 {
 			if level := p.isUnderlinedHeader(current); level > 0 {
+				// CommonMark makes the whole paragraph the header text,
+				// otherwise only the last line is used
+				if p.flags&EXTENSION_COMMONMARK != 0 {
+					prev = 0
+				}
+
 				// render the paragraph
 				p.renderParagraph(out, data[:prev])
 
@@ -1352,6 +2665,16 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 					eol--
 				}
 
+				id := ""
+				var attrs Attributes
+				if p.flags&EXTENSION_ATTRIBUTES != 0 {
+					eol, attrs = trailingAttributes(data, prev, eol)
+					id, attrs = attrs.Get("id"), attrs.without("id")
+				}
+				if id == "" && p.flags&EXTENSION_HEADER_IDS != 0 {
+					eol, id = trailingHeaderID(data, prev, eol)
+				}
+
 				// render the header
 				// this ugly double closure avoids forcing variables onto the heap
 				work := func(o *bytes.Buffer, pp *parser, d []byte) func() bool {
@@ -1361,12 +2684,17 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 					}
 				}(out, p, data[prev:eol])
 
-				id := ""
-				if p.flags&EXTENSION_AUTO_HEADER_IDS != 0 {
+				if id == "" && p.flags&(EXTENSION_AUTO_HEADER_IDS|EXTENSION_CROSS_REFERENCES) != 0 {
 					id = SanitizedAnchorName(string(data[prev:eol]))
 				}
+				if id != "" {
+					id = uniqueID(p.headerIDs, id)
+					p.indexHeader(id, data[prev:eol])
+				}
 
-				p.r.Header(out, work, level, id)
+				marker := out.Len()
+				p.r.Header(out, work, level, id, attrs)
+				p.outlineHeader(data[prev:], level, id, out.Bytes()[marker:])
 
 				// find the end of the underline
 				for data[i] != '\n' {
@@ -1376,8 +2704,14 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 			}
 		}
 
-		// if the next line starts a block of HTML, then the paragraph ends here
-		if p.flags&EXTENSION_LAX_HTML_BLOCKS != 0 {
+		// if the next line starts a block of HTML, then the paragraph ends
+		// here; in CommonMark any kind but the seventh does that
+		if p.flags&EXTENSION_COMMONMARK != 0 {
+			if kind := p.htmlBlockKind(current); kind > 0 && kind < 7 {
+				p.renderParagraph(out, data[:i])
+				return i
+			}
+		} else if p.flags&EXTENSION_LAX_HTML_BLOCKS != 0 {
 			if data[i] == '<' && p.html(out, current, false) > 0 {
 				// rewind to before the HTML block
 				p.renderParagraph(out, data[:i])
@@ -1385,6 +2719,12 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 			}
 		}
 
+		// so does a <details> element
+		if p.flags&EXTENSION_DETAILS != 0 && i > 0 && detailsOpenRe.Match(current) {
+			p.renderParagraph(out, data[:i])
+			return i
+		}
+
 		// if there's a prefixed header or a horizontal rule after this, paragraph is over
 		if p.isPrefixHeader(current) || p.isHRule(current) {
 			p.renderParagraph(out, data[:i])
@@ -1406,6 +2746,13 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 			}
 		}
 
+		// in CommonMark, so is a block quote, or a list item with text,
+		// unless it's numbered other than 1
+		if p.flags&EXTENSION_COMMONMARK != 0 && (p.quotePrefix(current) > 0 || p.interruptingListItem(current)) {
+			p.renderParagraph(out, data[:i])
+			return i
+		}
+
 		// if there's a list after this, paragraph is over
 		if p.flags&EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK != 0 {
 			if p.uliPrefix(current) != 0 ||
@@ -14,16 +14,20 @@
 package blackfriday
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"strings"
 	"testing"
 )
 
-func runMarkdownBlockWithRenderer(input string, extensions int, renderer Renderer) string {
+func runMarkdownBlockWithRenderer(input string, extensions Extensions, renderer Renderer) string {
 	return string(Markdown([]byte(input), renderer, extensions))
 }
 
-func runMarkdownBlock(input string, extensions int) string {
-	htmlFlags := 0
+func runMarkdownBlock(input string, extensions Extensions) string {
+	var htmlFlags HtmlFlags
 	htmlFlags |= HTML_USE_XHTML
 
 	renderer := HtmlRenderer(htmlFlags, "", "")
@@ -31,9 +35,9 @@ func runMarkdownBlock(input string, extensions int) string {
 	return runMarkdownBlockWithRenderer(input, extensions, renderer)
 }
 
-func runnerWithRendererParameters(parameters HtmlRendererParameters) func(string, int) string {
-	return func(input string, extensions int) string {
-		htmlFlags := 0
+func runnerWithRendererParameters(parameters HtmlRendererParameters) func(string, Extensions) string {
+	return func(input string, extensions Extensions) string {
+		var htmlFlags HtmlFlags
 		htmlFlags |= HTML_USE_XHTML
 
 		renderer := HtmlRendererWithParameters(htmlFlags, "", "", parameters)
@@ -42,11 +46,16 @@ func runnerWithRendererParameters(parameters HtmlRendererParameters) func(string
 	}
 }
 
-func doTestsBlock(t *testing.T, tests []string, extensions int) {
+func doTestsBlock(t *testing.T, tests []string, extensions Extensions) {
 	doTestsBlockWithRunner(t, tests, extensions, runMarkdownBlock)
+	for i := 0; i < len(tests); i += 2 {
+		checkRoundTrip(t, tests[i], Options{Extensions: extensions}, func() Renderer {
+			return HtmlRenderer(HTML_USE_XHTML, "", "")
+		})
+	}
 }
 
-func doTestsBlockWithRunner(t *testing.T, tests []string, extensions int, runner func(string, int) string) {
+func doTestsBlockWithRunner(t *testing.T, tests []string, extensions Extensions, runner func(string, Extensions) string) {
 	// catch and report panics
 	var candidate string
 	defer func() {
@@ -530,6 +539,35 @@ func TestUnderlineHeadersAutoIDs(t *testing.T) {
 	doTestsBlock(t, tests, EXTENSION_AUTO_HEADER_IDS)
 }
 
+func TestUnderlineHeadersIdExtension(t *testing.T) {
+	var tests = []string{
+		"Header 1 {#someid}\n========\n",
+		"<h1 id=\"someid\">Header 1</h1>\n",
+
+		"Header 2 {#someid}\n--------\n",
+		"<h2 id=\"someid\">Header 2</h2>\n",
+
+		"Header {#someid}   \n===\nParagraph\n",
+		"<h1 id=\"someid\">Header</h1>\n\n<p>Paragraph</p>\n",
+
+		"Header {#}\n===\n",
+		"<h1>Header {#}</h1>\n",
+
+		"Header {#someid} trailing\n===\n",
+		"<h1>Header {#someid} trailing</h1>\n",
+
+		"Header {#someid}\n===\n\nHeader {#someid}\n===\n",
+		"<h1 id=\"someid\">Header</h1>\n\n<h1 id=\"someid-1\">Header</h1>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_HEADER_IDS)
+
+	// an explicit id overrides the generated one
+	doTestsBlock(t, []string{
+		"Header one {#someid}\n===\n\nHeader two\n---\n",
+		"<h1 id=\"someid\">Header one</h1>\n\n<h2 id=\"header-two\">Header two</h2>\n",
+	}, EXTENSION_HEADER_IDS|EXTENSION_AUTO_HEADER_IDS)
+}
+
 func TestHorizontalRule(t *testing.T) {
 	var tests = []string{
 		"-\n",
@@ -839,6 +877,15 @@ func TestOrderedList(t *testing.T) {
 		"1. numbers\n1. are ignored\n",
 		"<ol>\n<li>numbers</li>\n<li>are ignored</li>\n</ol>\n",
 
+		"7. but the first\n1. sets the start\n",
+		"<ol start=\"7\">\n<li>but the first</li>\n<li>sets the start</li>\n</ol>\n",
+
+		"0. even zero\n",
+		"<ol start=\"0\">\n<li>even zero</li>\n</ol>\n",
+
+		"1) Paren\n",
+		"<p>1) Paren</p>\n",
+
 		`1. Foo
 
         bar
@@ -862,6 +909,183 @@ qux
 	doTestsBlock(t, tests, 0)
 }
 
+func TestOrderedListDelimiter(t *testing.T) {
+	var tests = []string{
+		"1) Yin\n2) Yang\n",
+		"<ol>\n<li>Yin</li>\n<li>Yang</li>\n</ol>\n",
+
+		"3) Yin\n4) Yang\n",
+		"<ol start=\"3\">\n<li>Yin</li>\n<li>Yang</li>\n</ol>\n",
+
+		"1. Yin\n2) Yang\n",
+		"<ol>\n<li>Yin</li>\n</ol>\n\n<ol start=\"2\">\n<li>Yang</li>\n</ol>\n",
+
+		"1) List\n    1. Nested\n",
+		"<ol>\n<li>List\n<ol>\n<li>Nested</li>\n</ol></li>\n</ol>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_COMMONMARK)
+}
+
+func TestFancyLists(t *testing.T) {
+	var tests = []string{
+		"a. one\nb. two\n",
+		"<ol type=\"a\">\n<li>one</li>\n<li>two</li>\n</ol>\n",
+
+		"C) three\n",
+		"<ol type=\"A\" start=\"3\">\n<li>three</li>\n</ol>\n",
+
+		"iv. four\nv. five\n",
+		"<ol type=\"i\" start=\"4\">\n<li>four</li>\n<li>five</li>\n</ol>\n",
+
+		"x. ten\n",
+		"<ol type=\"i\" start=\"10\">\n<li>ten</li>\n</ol>\n",
+
+		"I.  Introduction\nII. Background\n",
+		"<ol type=\"I\">\n<li>Introduction</li>\n<li>Background</li>\n</ol>\n",
+
+		"B. Russell wrote it.\n",
+		"<p>B. Russell wrote it.</p>\n",
+
+		"did. not\n",
+		"<p>did. not</p>\n",
+
+		"1. one\n    a) nested\n",
+		"<ol>\n<li>one\n\n<ol type=\"a\">\n<li>nested</li>\n</ol></li>\n</ol>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_FANCY_LISTS)
+}
+
+func TestExampleLists(t *testing.T) {
+	var tests = []string{
+		"(@) First\n(@good) Second\n\nAs (@good) shows.\n\n(@) Third\n",
+		"<ol class=\"example\">\n<li>First</li>\n<li>Second</li>\n</ol>\n\n" +
+			"<p>As (2) shows.</p>\n\n<ol class=\"example\" start=\"3\">\n<li>Third</li>\n</ol>\n",
+
+		"See (@later).\n\n(@) a\n(@later) b\n",
+		"<p>See (2).</p>\n\n<ol class=\"example\">\n<li>a</li>\n<li>b</li>\n</ol>\n",
+
+		"Unknown (@nope) and (@ not a label).\n",
+		"<p>Unknown (@nope) and (@ not a label).</p>\n",
+
+		"(@)No space\n",
+		"<p>(@)No space</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_EXAMPLE_LISTS)
+}
+
+func TestSpoilers(t *testing.T) {
+	var tests = []string{
+		">! The butler\n>! did it.\n",
+		"<details class=\"spoiler\">\n<p>The butler\ndid it.</p>\n</details>\n",
+
+		">! # Twist\n>!\n>! Text with ||more||.\n\nAfter\n",
+		"<details class=\"spoiler\">\n<h1>Twist</h1>\n\n<p>Text with <span class=\"spoiler\">more</span>.</p>\n</details>\n\n<p>After</p>\n",
+
+		">! Spoiler\nnot lazy\n",
+		"<details class=\"spoiler\">\n<p>Spoiler</p>\n</details>\n\n<p>not lazy</p>\n",
+
+		"> Quote\n",
+		"<blockquote>\n<p>Quote</p>\n</blockquote>\n",
+
+		"a || b ||| c |||| d\n",
+		"<p>a || b ||| c |||| d</p>\n",
+
+		"||*Rosebud*|| was ||a sled||\n",
+		"<p><span class=\"spoiler\"><em>Rosebud</em></span> was <span class=\"spoiler\">a sled</span></p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_SPOILERS)
+}
+
+func TestFrontMatter(t *testing.T) {
+	var tests = []string{
+		"---\ntitle: YAML\n---\n# Header\n",
+		"<h1>Header</h1>\n",
+
+		"+++\ntitle = \"TOML\"\n+++\n\nText\n",
+		"<p>Text</p>\n",
+
+		"{\n  \"title\": \"JSON\"\n}\nText\n",
+		"<p>Text</p>\n",
+
+		"---\ntitle: YAML\n...\nText\n",
+		"<p>Text</p>\n",
+
+		"---\nnot closed\n",
+		"<hr />\n\n<p>not closed</p>\n",
+
+		"Text\n\n---\ntitle: YAML\n---\n",
+		"<p>Text</p>\n\n<hr />\n\n<h2>title: YAML</h2>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_FRONT_MATTER)
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	var tests = []struct {
+		input  string
+		opts   Options
+		format int
+		data   string
+		rest   string
+	}{
+		{"---\na: 1\n---\nText\n", Options{}, FRONT_MATTER_YAML, "a: 1\n", "Text\n"},
+		{"+++ \r\na = 1\r\n+++\r\nText\n", Options{}, FRONT_MATTER_TOML, "a = 1\r\n", "Text\n"},
+		{"{\n\"a\": 1\n}\n", Options{}, FRONT_MATTER_JSON, "{\n\"a\": 1\n}", ""},
+		{";;;\na: 1\n;;;\nText\n", Options{FrontMatterDelimiters: [2]string{";;;"}}, FRONT_MATTER_YAML, "a: 1\n", "Text\n"},
+		{"---\na: 1\n", Options{}, FRONT_MATTER_NONE, "", "---\na: 1\n"},
+	}
+	for _, test := range tests {
+		fm, rest := SplitFrontMatter([]byte(test.input), test.opts)
+		if fm.Format != test.format || string(fm.Data) != test.data || string(rest) != test.rest {
+			t.Errorf("Input [%q]:\nExpected[%d %q %q]\nActual  [%d %q %q]",
+				test.input, test.format, test.data, test.rest, fm.Format, fm.Data, rest)
+		}
+	}
+}
+
+func TestShortcodes(t *testing.T) {
+	var tests = []string{
+		"{{< figure src=\"a_b.png\" title=\"*A* & B\" >}}\n",
+		"{{< figure src=\"a_b.png\" title=\"*A* & B\" >}}\n",
+
+		"Text\n\n{% include note.html %}  \n\nMore\n",
+		"<p>Text</p>\n\n{% include note.html %}\n\n<p>More</p>\n",
+
+		"See {{< ref \"my_page.md\" >}} and {{% param \"**x**\" %}}.\n",
+		"<p>See {{< ref \"my_page.md\" >}} and {{% param \"**x**\" %}}.</p>\n",
+
+		"{{< a >}} and text\n",
+		"<p>{{< a >}} and text</p>\n",
+
+		"{{< not closed\n",
+		"<p>{{&lt; not closed</p>\n",
+
+		// openers before the same closer, and one after it
+		"{% a\n\n{% b\n\n{% c %} text\n\n{% d %}\n",
+		"<p>{% a</p>\n\n<p>{% b</p>\n\n<p>{% c %} text</p>\n\n{% d %}\n",
+
+		"`{{< code >}}`\n",
+		"<p><code>{{&lt; code &gt;}}</code></p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_SHORTCODES)
+}
+
+func TestLooseList(t *testing.T) {
+	var tests = []string{
+		"- a\n- b\n\n- c\n",
+		"<ul>\n<li><p>a</p></li>\n\n<li><p>b</p></li>\n\n<li><p>c</p></li>\n</ul>\n",
+
+		"1. a\n\n    b\n2. c\n",
+		"<ol>\n<li><p>a</p>\n\n<p>b</p></li>\n\n<li><p>c</p></li>\n</ol>\n",
+
+		"- a\n- b\n\nParagraph\n",
+		"<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n\n<p>Paragraph</p>\n",
+
+		"- a\n\n  - b\n  - c\n",
+		"<ul>\n<li><p>a</p>\n\n<ul>\n<li>b</li>\n<li>c</li>\n</ul></li>\n</ul>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_COMMONMARK)
+}
+
 func TestDefinitionList(t *testing.T) {
 	var tests = []string{
 		"Term 1\n:   Definition a\n",
@@ -1048,6 +1272,90 @@ func TestPreformattedHtmlLax(t *testing.T) {
 	doTestsBlock(t, tests, EXTENSION_LAX_HTML_BLOCKS)
 }
 
+func TestPreformattedHtmlCommonMark(t *testing.T) {
+	var tests = []string{
+		"Paragraph\n<div>\n*not emphasis*\n\n*emphasis*\n",
+		"<p>Paragraph</p>\n\n<div>\n*not emphasis*\n\n<p><em>emphasis</em></p>\n",
+
+		"  <!-- indented\n\ncomment -->\ntext\n",
+		"  <!-- indented\n\ncomment -->\n\n<p>text</p>\n",
+
+		"    <div>\n",
+		"<pre><code>&lt;div&gt;\n</code></pre>\n",
+
+		"<pre>\n\n**raw**\n</pre>\ntext\n",
+		"<pre>\n\n**raw**\n</pre>\n\n<p>text</p>\n",
+
+		"Paragraph\n<span>\n",
+		"<p>Paragraph\n<span></p>\n",
+
+		"<span>\n*raw*\n",
+		"<span>\n*raw*\n",
+
+		"<!--- hidden --->\ntext\n",
+		"<p>text</p>\n",
+
+		"<div markdown=\"1\">\n*emphasis*\n</div>\n",
+		"<div>\n\n<p><em>emphasis</em></p>\n\n</div>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_COMMONMARK|EXTENSION_COMMENTS|EXTENSION_MARKDOWN_ATTRIBUTE)
+}
+
+func TestListCommonMark(t *testing.T) {
+	var tests = []string{
+		"- one\n\n  two\n- three\n",
+		"<ul>\n<li><p>one</p>\n\n<p>two</p></li>\n\n<li><p>three</p></li>\n</ul>\n",
+
+		"- a\n  - b\n\n    c\n- d\n",
+		"<ul>\n<li>a\n<ul>\n<li><p>b</p>\n\n<p>c</p></li>\n</ul></li>\n<li>d</li>\n</ul>\n",
+
+		"1. a\n\n   b\n",
+		"<ol>\n<li><p>a</p>\n\n<p>b</p></li>\n</ol>\n",
+
+		"- a\nlazy\n",
+		"<ul>\n<li>a\nlazy</li>\n</ul>\n",
+
+		"- a\n-\n- c\n",
+		"<ul>\n<li>a</li>\n<li></li>\n<li>c</li>\n</ul>\n",
+
+		"> quote\nlazy\n",
+		"<blockquote>\n<p>quote\nlazy</p>\n</blockquote>\n",
+
+		"> - a\n> b\n",
+		"<blockquote>\n<ul>\n<li>a\nb</li>\n</ul>\n</blockquote>\n",
+
+		"> ```\nnot code\n",
+		"<blockquote>\n<p>```</p>\n</blockquote>\n\n<p>not code</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_COMMONMARK|EXTENSION_FENCED_CODE)
+}
+
+func TestReferenceCommonMark(t *testing.T) {
+	var tests = []string{
+		"Foo\n[bar]: /url\n\n[bar]\n",
+		"<p>Foo\n[bar]: /url</p>\n\n<p>[bar]</p>\n",
+
+		"[foo]: /url 'title' junk\n\n[foo]\n",
+		"<p>[foo]: /url 'title' junk</p>\n\n<p>[foo]</p>\n",
+
+		"[foo]: /url\n'title' junk\n\n[foo]\n",
+		"<p>'title' junk</p>\n\n<p><a href=\"/url\">foo</a></p>\n",
+
+		"[\nfoo\n]: /url\n\n[foo]\n",
+		"<p><a href=\"/url\">foo</a></p>\n",
+
+		"[foo]:\n<my url>\n'the\ntitle'\n\n[Foo]\n",
+		"<p><a href=\"my url\" title=\"the\ntitle\">Foo</a></p>\n",
+
+		"[foo]: <>\n\n[foo]\n",
+		"<p><a href=\"\">foo</a></p>\n",
+
+		"[foo]:\n\n[foo]\n",
+		"<p>[foo]:</p>\n\n<p>[foo]</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_COMMONMARK)
+}
+
 func TestFencedCodeBlock(t *testing.T) {
 	var tests = []string{
 		"``` go\nfunc foo() bool {\n\treturn true;\n}\n```\n",
@@ -1081,7 +1389,7 @@ func TestFencedCodeBlock(t *testing.T) {
 		"<pre><code class=\"language-python\">extra\n</code></pre>\n",
 
 		"~~~ perl\nthree to start, four to end\n~~~~\n",
-		"<p>~~~ perl\nthree to start, four to end\n~~~~</p>\n",
+		"<pre><code class=\"language-perl\">three to start, four to end\n</code></pre>\n",
 
 		"~~~~ perl\nfour to start, three to end\n~~~\n",
 		"<p>~~~~ perl\nfour to start, three to end\n~~~</p>\n",
@@ -1089,6 +1397,18 @@ func TestFencedCodeBlock(t *testing.T) {
 		"~~~ bash\ntildes\n~~~\n",
 		"<pre><code class=\"language-bash\">tildes\n</code></pre>\n",
 
+		"````\n```\nnested\n```\n``````\n",
+		"<pre><code>```\nnested\n```\n</code></pre>\n",
+
+		"~~~\n```\nmixed\n~~~\n",
+		"<pre><code>```\nmixed\n</code></pre>\n",
+
+		"  ```go\n  indented\n    more\n none\n  ```\n",
+		"<pre><code class=\"language-go\">indented\n  more\nnone\n</code></pre>\n",
+
+		"   ~~~\n   three\n      deeper\n ~~~\n",
+		"<pre><code>three\n   deeper\n</code></pre>\n",
+
 		"``` lisp\nno ending\n",
 		"<p>``` lisp\nno ending</p>\n",
 
@@ -1138,6 +1458,213 @@ func TestFencedCodeBlock(t *testing.T) {
 		"<pre><code>[]:()\n[]:)\n[]:(\n[]:x\n[]:testing\n[:testing\n\n[]:\nlinebreak\n[]()\n\n[]:\n[]()\n</code></pre>\n",
 	}
 	doTestsBlock(t, tests, EXTENSION_FENCED_CODE)
+
+	// in CommonMark, an info string can't hold backticks
+	tests = []string{
+		"``` a`b\ncode\n```\n",
+		"<p><code>a`b\ncode\n</code></p>\n",
+
+		"~~~ a`b\ncode\n~~~\n",
+		"<pre><code class=\"language-a`b\">code\n</code></pre>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_FENCED_CODE|EXTENSION_COMMONMARK)
+}
+
+// testHighlighter marks up the code with its language, and fails for
+// languages it doesn't know.
+type testHighlighter struct{}
+
+func (testHighlighter) Highlight(w io.Writer, code []byte, lang string) error {
+	if lang == "cobol" {
+		return errors.New("unknown language")
+	}
+	fmt.Fprintf(w, "<pre class=\"hl\" data-lang=\"%s\">%s</pre>\n", lang, bytes.ToUpper(code))
+	return nil
+}
+
+func TestCodeHighlighter(t *testing.T) {
+	var tests = []string{
+		"``` go\nfunc main() {}\n```\n",
+		"<pre class=\"hl\" data-lang=\"go\">FUNC MAIN() {}\n</pre>\n",
+
+		"``` {.python .numbered}\nprint(1)\n```\n",
+		"<pre class=\"hl\" data-lang=\"python\">PRINT(1)\n</pre>\n",
+
+		"text\n\n    indented\n",
+		"<p>text</p>\n\n<pre class=\"hl\" data-lang=\"\">INDENTED\n</pre>\n",
+
+		"``` cobol\nDISPLAY 'x' & 'y'.\n```\n",
+		"<pre><code class=\"language-cobol\">DISPLAY 'x' &amp; 'y'.\n</code></pre>\n",
+	}
+	parameters := HtmlRendererParameters{CodeHighlighter: testHighlighter{}}
+	doTestsBlockWithRunner(t, tests, EXTENSION_FENCED_CODE, runnerWithRendererParameters(parameters))
+}
+
+func TestHeaderNumbering(t *testing.T) {
+	runner := func(params HtmlRendererParameters) func(string, Extensions) string {
+		return func(input string, extensions Extensions) string {
+			renderer := HtmlRendererWithParameters(HTML_NUMBER_HEADERS, "", "", params)
+			return runMarkdownBlockWithRenderer(input, extensions, renderer)
+		}
+	}
+
+	var tests = []string{
+		"# A\n## B\n### C\n## D\n# E\n### F\n",
+		"<h1><span class=\"header-number\">1</span> A</h1>\n\n" +
+			"<h2><span class=\"header-number\">1.1</span> B</h2>\n\n" +
+			"<h3><span class=\"header-number\">1.1.1</span> C</h3>\n\n" +
+			"<h2><span class=\"header-number\">1.2</span> D</h2>\n\n" +
+			"<h1><span class=\"header-number\">2</span> E</h1>\n\n" +
+			"<h3><span class=\"header-number\">2.0.1</span> F</h3>\n",
+
+		"## A\n## Preface {.unnumbered}\n## B\n",
+		"<h2><span class=\"header-number\">0.1</span> A</h2>\n\n" +
+			"<h2 class=\"unnumbered\">Preface</h2>\n\n" +
+			"<h2><span class=\"header-number\">0.2</span> B</h2>\n",
+	}
+	doTestsBlockWithRunner(t, tests, EXTENSION_ATTRIBUTES, runner(HtmlRendererParameters{}))
+
+	// leave the title and the smallest headers out
+	tests = []string{
+		"# Title\n## A\n### B\n#### C\n## D\n",
+		"<h1>Title</h1>\n\n<h2><span class=\"header-number\">1</span> A</h2>\n\n" +
+			"<h3><span class=\"header-number\">1.1</span> B</h3>\n\n<h4>C</h4>\n\n" +
+			"<h2><span class=\"header-number\">2</span> D</h2>\n",
+	}
+	doTestsBlockWithRunner(t, tests, 0, runner(HtmlRendererParameters{
+		HeaderNumbering: HeaderNumbering{MinLevel: 2, MaxLevel: 3},
+	}))
+}
+
+func TestComments(t *testing.T) {
+	var tests = []string{
+		"%% a note to self\nText\n  %% another\ngoes on.\n",
+		"<p>Text\ngoes on.</p>\n",
+
+		"<!--- hidden\nover lines --->\n\n<!-- kept -->\n",
+		"<!-- kept -->\n",
+
+		"Some <!--- hidden ---> text <!-- kept -->\n",
+		"<p>Some  text <!-- kept --></p>\n",
+
+		"```\n%% code\n<!--- code --->\n```\n\n    %% code\n",
+		"<pre><code>%% code\n&lt;!--- code ---&gt;\n</code></pre>\n\n<pre><code>%% code\n</code></pre>\n",
+
+		"`<!--- code --->` 100 %% done\n",
+		"<p><code>&lt;!--- code ---&gt;</code> 100 %% done</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_FENCED_CODE|EXTENSION_COMMENTS)
+
+	// without the extension they are kept
+	doTestsBlock(t, []string{
+		"%% note\n\n<!--- note --->\n",
+		"<p>%% note</p>\n\n<!--- note --->\n",
+	}, 0)
+}
+
+func TestCodeBlockRenderers(t *testing.T) {
+	var tests = []string{
+		"``` mermaid\ngraph TD; A-->B;\n```\n",
+		"<div class=\"mermaid\">graph TD; A--&gt;B;\n</div>\n",
+
+		"``` {.dot .wide}\ndigraph { a -> b }\n```\n",
+		"<div class=\"graphviz\">digraph { a -&gt; b }\n</div>\n",
+
+		"``` go\nfunc main() {}\n```\n",
+		"<pre class=\"hl\" data-lang=\"go\">FUNC MAIN() {}\n</pre>\n",
+
+		"    indented\n",
+		"<pre class=\"hl\" data-lang=\"\">INDENTED\n</pre>\n",
+	}
+	parameters := HtmlRendererParameters{
+		CodeHighlighter: testHighlighter{},
+		CodeBlockRenderers: map[string]CodeBlockFunc{
+			"mermaid": DiagramBlock("mermaid"),
+			"dot":     DiagramBlock("graphviz"),
+			"":        DiagramBlock("never"),
+		},
+	}
+	doTestsBlockWithRunner(t, tests, EXTENSION_FENCED_CODE, runnerWithRendererParameters(parameters))
+}
+
+func TestLanguageGuesser(t *testing.T) {
+	var tests = []string{
+		"    package main\n",
+		"<pre><code class=\"language-go\">package main\n</code></pre>\n",
+
+		"```\n$ ls\n```\n",
+		"<pre><code class=\"language-sh\">$ ls\n</code></pre>\n",
+
+		"    ???\n",
+		"<pre><code>???\n</code></pre>\n",
+
+		"```python\npackage main\n```\n",
+		"<pre><code class=\"language-python\">package main\n</code></pre>\n",
+	}
+	parameters := HtmlRendererParameters{LanguageGuesser: func(code []byte) string {
+		switch {
+		case bytes.HasPrefix(code, []byte("package ")):
+			return "go"
+		case bytes.HasPrefix(code, []byte("$ ")):
+			return "sh"
+		}
+		return ""
+	}}
+	doTestsBlockWithRunner(t, tests, EXTENSION_FENCED_CODE, runnerWithRendererParameters(parameters))
+
+	// the guessed language is given to the highlighter
+	doTestsBlockWithRunner(t, []string{
+		"    package main\n",
+		"<pre class=\"hl\" data-lang=\"go\">PACKAGE MAIN\n</pre>\n",
+	}, 0, runnerWithRendererParameters(HtmlRendererParameters{
+		LanguageGuesser: parameters.LanguageGuesser,
+		CodeHighlighter: testHighlighter{},
+	}))
+}
+
+func TestCodeMetadata(t *testing.T) {
+	var tests = []string{
+		"```go title=\"main.go\"\nfunc main() {}\n```\n",
+		"<div class=\"code-title\">main.go</div>\n<pre><code class=\"language-go\">func main() {}\n</code></pre>\n",
+
+		"```go linenos hl_lines=\"2-3\"\na\nb <\nc\nd\n```\n",
+		"<pre><code class=\"language-go\"><span class=\"line\"><span class=\"line-number\">1</span>a</span>\n" +
+			"<span class=\"line hl\"><span class=\"line-number\">2</span>b &lt;</span>\n" +
+			"<span class=\"line hl\"><span class=\"line-number\">3</span>c</span>\n" +
+			"<span class=\"line\"><span class=\"line-number\">4</span>d</span>\n</code></pre>\n",
+
+		"``` sh hl_lines=1,9 data-x=y\n$ ls\n```\n",
+		"<pre><code class=\"language-sh\" data-x=\"y\"><span class=\"line hl\">$ ls</span>\n</code></pre>\n",
+
+		"``` {.py title='a b.py'}\nx\n```\n",
+		"<div class=\"code-title\">a b.py</div>\n<pre><code class=\"language-py\">x\n</code></pre>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_FENCED_CODE|EXTENSION_CODE_METADATA)
+
+	// without the extension such a line doesn't start a code block
+	doTestsBlock(t, []string{
+		"```go title=\"main.go\"\nx\n```\n",
+		"<p><code>go title=&quot;main.go&quot;\nx\n</code></p>\n",
+	}, EXTENSION_FENCED_CODE)
+}
+
+func TestCodeClassFormat(t *testing.T) {
+	var tests = []string{
+		"``` go\nfunc main() {}\n```\n",
+		"<pre><code class=\"go\">func main() {}\n</code></pre>\n",
+
+		"``` {.python .numbered}\nprint(1)\n```\n",
+		"<pre><code class=\"python numbered\">print(1)\n</code></pre>\n",
+	}
+	parameters := HtmlRendererParameters{CodeClassFormat: "%s", ClassPrefix: "md-"}
+	doTestsBlockWithRunner(t, tests, EXTENSION_FENCED_CODE, runnerWithRendererParameters(parameters))
+
+	tests = []string{
+		"``` go\nfunc main() {}\n```\n",
+		"<pre><code class=\"lang-go\">func main() {}\n</code></pre>\n",
+	}
+	parameters = HtmlRendererParameters{CodeClassFormat: "lang-%s"}
+	doTestsBlockWithRunner(t, tests, EXTENSION_FENCED_CODE, runnerWithRendererParameters(parameters))
 }
 
 func TestFencedCodeInsideBlockquotes(t *testing.T) {
@@ -1299,6 +1826,41 @@ func TestTable(t *testing.T) {
 	doTestsBlock(t, tests, EXTENSION_TABLES)
 }
 
+func TestTableExtras(t *testing.T) {
+	var tests = []string{
+		"a | b\n---|---\nc | d\n\nTable: *Some* numbers\n",
+		"<table>\n<caption><em>Some</em> numbers</caption>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>c</td>\n<td>d</td>\n</tr>\n</tbody>\n</table>\n",
+
+		"| a | b | c |\n|:--|---|--:|\n| wide || x |\n| y | z ||\n",
+		"<table>\n<thead>\n<tr>\n<th align=\"left\">a</th>\n<th>b</th>\n<th align=\"right\">c</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td align=\"left\" colspan=\"2\">wide</td>\n<td align=\"right\">x</td>\n</tr>\n\n" +
+			"<tr>\n<td align=\"left\">y</td>\n<td colspan=\"2\">z</td>\n</tr>\n</tbody>\n</table>\n",
+
+		"| head ||\n|---|---|\n| 1 | 2 |\nTable: right after\n",
+		"<table>\n<caption>right after</caption>\n<thead>\n<tr>\n<th colspan=\"2\">head</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n",
+
+		// spans stop at the last column
+		"a | b\n---|---\nc ||||\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td colspan=\"2\">c</td>\n</tr>\n</tbody>\n</table>\n",
+
+		// not captions
+		"a | b\n---|---\nc | d\n\nTable:\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>c</td>\n<td>d</td>\n</tr>\n</tbody>\n</table>\n\n<p>Table:</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_TABLES|EXTENSION_TABLE_EXTRAS)
+
+	// without the extension empty cells are kept apart
+	doTestsBlock(t, []string{
+		"a | b | c\n---|---|---\nd || e\n\nTable: text\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n<th>c</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>d</td>\n<td></td>\n<td>e</td>\n</tr>\n</tbody>\n</table>\n\n<p>Table: text</p>\n",
+	}, EXTENSION_TABLES)
+}
+
 func TestUnorderedListWith_EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK(t *testing.T) {
 	var tests = []string{
 		"* Hello\n",
@@ -1539,7 +2101,7 @@ func TestFencedCodeBlock_EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK(t *testing.T) {
 		"<pre><code class=\"language-python\">extra\n</code></pre>\n",
 
 		"~~~ perl\nthree to start, four to end\n~~~~\n",
-		"<p>~~~ perl\nthree to start, four to end\n~~~~</p>\n",
+		"<pre><code class=\"language-perl\">three to start, four to end\n</code></pre>\n",
 
 		"~~~~ perl\nfour to start, three to end\n~~~\n",
 		"<p>~~~~ perl\nfour to start, three to end\n~~~</p>\n",
@@ -1699,7 +2261,7 @@ func TestIsFenceLine(t *testing.T) {
 		if test.syntaxRequested {
 			syntax = new(string)
 		}
-		end, marker := isFenceLine(test.data, syntax, "```", test.newlineOptional)
+		end, marker := isFenceLine(test.data, syntax, nil, "```", test.newlineOptional)
 		if got, want := end, test.wantEnd; got != want {
 			t.Errorf("got end %v, want %v", got, want)
 		}
@@ -1739,6 +2301,270 @@ func TestJoinLines(t *testing.T) {
 	}
 }
 
+func TestEastAsianLineBreaks(t *testing.T) {
+	var tests = []string{
+		"日本語の\n文章です。\n",
+		"<p>日本語の文章です。</p>\n",
+
+		"中文句子，\n  继续。\n",
+		"<p>中文句子，继续。</p>\n",
+
+		"日本語と\nEnglish text\nand more\n",
+		"<p>日本語と\nEnglish text\nand more</p>\n",
+
+		"한국어\n문장\n",
+		"<p>한국어\n문장</p>\n",
+
+		"改行  \nします\n",
+		"<p>改行<br />\nします</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_EAST_ASIAN_LINE_BREAKS)
+}
+
+func TestAdmonitions(t *testing.T) {
+	var tests = []string{
+		"> [!NOTE]\n> Useful information.\n",
+		"<div class=\"admonition note\">\n<p class=\"admonition-title\">Note</p>\n<p>Useful information.</p>\n</div>\n",
+
+		"> [!Warning] Don't <panic>\n>\n> - one\n> - two\n",
+		"<div class=\"admonition warning\">\n<p class=\"admonition-title\">Don't &lt;panic&gt;</p>\n<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n</div>\n",
+
+		"> [!TIP]\n",
+		"<div class=\"admonition tip\">\n<p class=\"admonition-title\">Tip</p>\n</div>\n",
+
+		// not admonitions
+		"> [!] empty\n",
+		"<blockquote>\n<p>[!] empty</p>\n</blockquote>\n",
+
+		"> text\n> [!NOTE]\n",
+		"<blockquote>\n<p>text\n[!NOTE]</p>\n</blockquote>\n",
+
+		"[!NOTE] outside\n",
+		"<p>[!NOTE] outside</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_ADMONITIONS)
+
+	// without the extension they stay block quotes
+	doTestsBlock(t, []string{
+		"> [!NOTE]\n> text\n",
+		"<blockquote>\n<p>[!NOTE]\ntext</p>\n</blockquote>\n",
+	}, 0)
+}
+
+func TestQuoteCitations(t *testing.T) {
+	var tests = []string{
+		"> Simplicity is prerequisite for reliability.\n> -- Edsger W. Dijkstra, *EWD498*\n",
+		"<figure>\n<blockquote>\n<p>Simplicity is prerequisite for reliability.</p>\n</blockquote>\n" +
+			"<figcaption>&mdash; Edsger W. Dijkstra, <em>EWD498</em></figcaption>\n</figure>\n",
+
+		"> One.\n>\n> Two.\n>\n> \u2014 Someone\n\nafter\n",
+		"<figure>\n<blockquote>\n<p>One.</p>\n\n<p>Two.</p>\n</blockquote>\n" +
+			"<figcaption>&mdash; Someone</figcaption>\n</figure>\n\n<p>after</p>\n",
+
+		// not citations
+		"> -- Nobody\n",
+		"<blockquote>\n<p>-- Nobody</p>\n</blockquote>\n",
+
+		"> text\n> --\n",
+		"<blockquote>\n<h2>text</h2>\n</blockquote>\n",
+
+		"> text\n> -- a\n> more\n",
+		"<blockquote>\n<p>text\n-- a\nmore</p>\n</blockquote>\n",
+
+		">     code\n>     -- a\n",
+		"<blockquote>\n<pre><code>code\n-- a\n</code></pre>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_QUOTE_CITATIONS)
+
+	tests = []string{
+		"> Quote.\n> -- Author\n",
+		"<div class=\"quotation\">\n<blockquote>\n<p>Quote.</p>\n</blockquote>\n" +
+			"<p class=\"attribution\">&#8212; Author</p>\n</div>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_QUOTE_CITATIONS}, HTML_EPUB, HtmlRendererParameters{})
+
+	// without the extension they stay in the block quote
+	doTestsBlock(t, []string{
+		"> Quote.\n> -- Author\n",
+		"<blockquote>\n<p>Quote.\n-- Author</p>\n</blockquote>\n",
+	}, 0)
+}
+
+func TestLineBlocks(t *testing.T) {
+	var tests = []string{
+		"| The limerick packs laughs *anatomical*\n|     Into space\n|\n| that is\n  quite economical.\n",
+		"<div class=\"line-block\">The limerick packs laughs <em>anatomical</em><br />\n" +
+			"\u00a0\u00a0\u00a0\u00a0Into space<br />\n<br />\nthat is quite economical.</div>\n",
+
+		"| 200 Main St.\n| Berkeley, CA 94718\n\nafter\n",
+		"<div class=\"line-block\">200 Main St.<br />\nBerkeley, CA 94718</div>\n\n<p>after</p>\n",
+
+		// tables come first
+		"| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n",
+
+		// not line blocks
+		"|no space\n",
+		"<p>|no space</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_LINE_BLOCKS|EXTENSION_TABLES)
+
+	doTestsBlock(t, []string{
+		"| a\n| b\n",
+		"<p>| a\n| b</p>\n",
+	}, 0)
+}
+
+func TestGridTables(t *testing.T) {
+	var tests = []string{
+		"+-------+-----------+\n| Fruit | Notes     |\n+=======+===========+\n" +
+			"| Apple | - crunchy |\n|       | - red     |\n+-------+-----------+\n" +
+			"| Pear  | Soft and  |\n|       | *sweet*   |\n+-------+-----------+\n",
+		"<table>\n<thead>\n<tr>\n<th>Fruit</th>\n<th>Notes</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>Apple</td>\n<td><ul>\n<li>crunchy</li>\n<li>red</li>\n</ul>\n</td>\n</tr>\n\n" +
+			"<tr>\n<td>Pear</td>\n<td>Soft and\n<em>sweet</em></td>\n</tr>\n</tbody>\n</table>\n",
+
+		// without a header the top border sets the alignment
+		"+:----+----:+\n| a   |   b |\n+-----+-----+\n| c   | d   |\n|     |     |\n| e   |     |\n+-----+-----+\n\nafter\n",
+		"<table>\n<tbody>\n<tr>\n<td align=\"left\">a</td>\n<td align=\"right\">b</td>\n</tr>\n\n" +
+			"<tr>\n<td align=\"left\"><p>c</p>\n\n<p>e</p>\n</td>\n<td align=\"right\">d</td>\n</tr>\n</tbody>\n</table>\n\n" +
+			"<p>after</p>\n",
+
+		"+---+---+\n| é | b |\n+---+---+\n",
+		"<table>\n<tbody>\n<tr>\n<td>é</td>\n<td>b</td>\n</tr>\n</tbody>\n</table>\n",
+
+		// not grid tables
+		"+---+---+\n| a | b |\n",
+		"<p>+---+---+\n| a | b |</p>\n",
+
+		"+---+---+\n| a | b |\n+----+--+\n",
+		"<p>+---+---+\n| a | b |\n+----+--+</p>\n",
+
+		"+---+\n+---+\n",
+		"<p>+---+\n+---+</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_GRID_TABLES)
+}
+
+func TestMarkdownAttribute(t *testing.T) {
+	var tests = []string{
+		"<div class=\"note\" markdown=\"1\">\nSome *text*.\n\n* a list\n</div>\n",
+		"<div class=\"note\">\n\n<p>Some <em>text</em>.</p>\n\n<ul>\n<li>a list</li>\n</ul>\n\n</div>\n",
+
+		"<div markdown=1>\n<div>\n*raw*\n</div>\n\n<section markdown='1'>*nested*</section>\n</div>\n\nAfter\n",
+		"<div>\n\n<div>\n*raw*\n</div>\n\n<section>\n\n<p><em>nested</em></p>\n\n</section>\n\n</div>\n\n<p>After</p>\n",
+
+		"<p markdown=\"1\">Some *text*</p>\n",
+		"<p>\nSome <em>text</em>\n</p>\n",
+
+		"<div markdown=\"1\">\nnot closed\n",
+		"<p><div markdown=\"1\">\nnot closed</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_MARKDOWN_ATTRIBUTE)
+
+	// without the extension the contents are left alone
+	doTestsBlock(t, []string{
+		"<div markdown=\"1\">\n*raw*\n</div>\n",
+		"<div markdown=\"1\">\n*raw*\n</div>\n",
+	}, 0)
+}
+
+func TestDetails(t *testing.T) {
+	var tests = []string{
+		"<details>\n<summary>More *detail*</summary>\n\nSome *text*.\n\n* a list\n</details>\n",
+		"<details>\n<summary>More <em>detail</em></summary>\n<p>Some <em>text</em>.</p>\n\n<ul>\n<li>a list</li>\n</ul>\n</details>\n",
+
+		"<details open><summary>Open</summary>\nText\n</details>\n",
+		"<details open>\n<summary>Open</summary>\n<p>Text</p>\n</details>\n",
+
+		"<details>\nNo summary\n<details>\n<summary>Inner</summary>\n\n```\n</details>\n```\n</details>\n</details>\n\nAfter\n",
+		"<details>\n<p>No summary</p>\n\n<details>\n<summary>Inner</summary>\n<pre><code>&lt;/details&gt;\n</code></pre>\n</details>\n</details>\n\n<p>After</p>\n",
+
+		"<details>\nnot closed\n",
+		"<p><details>\nnot closed</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_DETAILS|EXTENSION_FENCED_CODE)
+
+	// without the extension the contents are left alone
+	doTestsBlock(t, []string{
+		"<details>\n*raw*\n</details>\n",
+		"<p><details>\n<em>raw</em>\n</details></p>\n",
+	}, 0)
+}
+
+func TestFencedDivs(t *testing.T) {
+	var tests = []string{
+		"::: warning\nCareful *now*.\n:::\n",
+		"<div class=\"warning\">\n<p>Careful <em>now</em>.</p>\n</div>\n",
+
+		":::: {.sidebar #aside lang=en} ::::\n# Title\n\n- one\n::::\n",
+		"<div id=\"aside\" class=\"sidebar\">\n<h1>Title</h1>\n\n<ul>\n<li>one</li>\n</ul>\n</div>\n",
+
+		"::: outer\n::: inner\ntext\n:::\n:::\n",
+		"<div class=\"outer\">\n<div class=\"inner\">\n<p>text</p>\n</div>\n</div>\n",
+
+		"::: empty\n:::\n",
+		"<div class=\"empty\">\n</div>\n",
+
+		"::: code\n```\n:::\n```\n:::\n",
+		"<div class=\"code\">\n<pre><code>:::\n</code></pre>\n</div>\n",
+
+		"Para\n\n::: note\nNote.\n:::\n\nAfter\n",
+		"<p>Para</p>\n\n<div class=\"note\">\n<p>Note.</p>\n</div>\n\n<p>After</p>\n",
+
+		// not fenced divs
+		":::\ntext\n:::\n",
+		"<p>:::\ntext\n:::</p>\n",
+
+		"::: open\nnever closed\n",
+		"<p>::: open\nnever closed</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_FENCED_DIVS|EXTENSION_FENCED_CODE)
+
+	// without the extension they are paragraphs
+	doTestsBlock(t, []string{
+		"::: warning\ntext\n:::\n",
+		"<p>::: warning\ntext\n:::</p>\n",
+	}, 0)
+}
+
+func TestBlockAttributes(t *testing.T) {
+	var tests = []string{
+		"# Title {#top .main data-level=1}\n",
+		"<h1 id=\"top\" class=\"main\" data-level=\"1\">Title</h1>\n",
+
+		"Setext {.big}\n======\n",
+		"<h1 class=\"big\">Setext</h1>\n",
+
+		"## Closed ## {.x}\n",
+		"<h2 class=\"x\">Closed</h2>\n",
+
+		"```{.go .numberLines #main startFrom=10}\nx := 1\n```\n",
+		"<pre><code class=\"language-go numberLines\" id=\"main\" startFrom=\"10\">x := 1\n</code></pre>\n",
+
+		"```{#noclass}\nx\n```\n",
+		"<pre><code id=\"noclass\">x\n</code></pre>\n",
+
+		"```go\nx\n```\n",
+		"<pre><code class=\"language-go\">x\n</code></pre>\n",
+
+		// not attribute lists
+		"# Set {notes}\n",
+		"<h1>Set {notes}</h1>\n",
+
+		"# Braces {.a} in the middle\n",
+		"<h1>Braces {.a} in the middle</h1>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_ATTRIBUTES|EXTENSION_FENCED_CODE)
+
+	// the {#id} of EXTENSION_HEADER_IDS still works alongside
+	doTestsBlock(t, []string{
+		"# Title {#id}\n",
+		"<h1 id=\"id\">Title</h1>\n",
+	}, EXTENSION_ATTRIBUTES|EXTENSION_HEADER_IDS)
+}
+
 func TestSanitizedAnchorName(t *testing.T) {
 	tests := []struct {
 		text string
@@ -1779,3 +2605,29 @@ func TestSanitizedAnchorName(t *testing.T) {
 		}
 	}
 }
+
+func TestTaskList(t *testing.T) {
+	var tests = []string{
+		"- [ ] todo\n- [x] done\n- [X] also done\n",
+		"<ul>\n<li><input type=\"checkbox\" disabled=\"\" /> todo</li>\n<li><input type=\"checkbox\" checked=\"\" disabled=\"\" /> done</li>\n<li><input type=\"checkbox\" checked=\"\" disabled=\"\" /> also done</li>\n</ul>\n",
+
+		"1. [x] first\n2. second\n",
+		"<ol>\n<li><input type=\"checkbox\" checked=\"\" disabled=\"\" /> first</li>\n<li>second</li>\n</ol>\n",
+
+		"- [ ] outer\n    - [x] inner\n",
+		"<ul>\n<li><input type=\"checkbox\" disabled=\"\" /> outer\n\n<ul>\n<li><input type=\"checkbox\" checked=\"\" disabled=\"\" /> inner</li>\n</ul></li>\n</ul>\n",
+
+		"- [y] not a task\n- [] not a task either\n",
+		"<ul>\n<li>[y] not a task</li>\n<li>[] not a task either</li>\n</ul>\n",
+
+		"[ ] not in a list\n",
+		"<p>[ ] not in a list</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_TASK_LISTS)
+
+	// without the extension the markers are left alone
+	doTestsBlock(t, []string{
+		"- [ ] todo\n",
+		"<ul>\n<li>[ ] todo</li>\n</ul>\n",
+	}, 0)
+}
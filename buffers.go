@@ -0,0 +1,51 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Scratch buffers shared between blocks and documents
+//
+//
+
+//go:build go1.3
+// +build go1.3
+
+package blackfriday
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Scratch buffers are kept in a sync.Pool, and used again.
+const pooledBuffers = true
+
+// Buffers that grew larger than this are left to the garbage collector
+// rather than kept around for the next block.
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer returns an empty scratch buffer. It is handed back with
+// putBuffer once nothing refers to its contents any more.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
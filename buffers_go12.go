@@ -0,0 +1,36 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Scratch buffers before Go 1.3, which has no sync.Pool
+//
+//
+
+//go:build !go1.3
+// +build !go1.3
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// Scratch buffers are not used again.
+const pooledBuffers = false
+
+// getBuffer returns an empty scratch buffer. Without sync.Pool, each one
+// is a new buffer.
+func getBuffer() *bytes.Buffer {
+	return new(bytes.Buffer)
+}
+
+// putBuffer leaves buf to the garbage collector.
+func putBuffer(buf *bytes.Buffer) {
+}
@@ -0,0 +1,106 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Citations with EXTENSION_CITATIONS
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strings"
+)
+
+// CitationItem is one of the works cited by a citation such as
+// [@doe2019, p. 30; @roe2020].
+type CitationItem struct {
+	Key     string `json:"key"`               // citation key, without the '@'
+	Locator string `json:"locator,omitempty"` // text after the comma, such as "p. 30"
+}
+
+// citation parses a bracketed citation at the beginning of data: one or
+// more keys starting with '@', separated by semicolons, each optionally
+// followed by a comma and a locator. It returns the items and the index
+// just past the closing ']', or 0 if data doesn't start with a citation.
+func citation(data []byte) ([]CitationItem, int) {
+	if len(data) < 3 || data[0] != '[' || data[1] != '@' {
+		return nil, 0
+	}
+	end := bytes.IndexByte(data, ']')
+	if end < 0 {
+		return nil, 0
+	}
+
+	var items []CitationItem
+	for _, part := range bytes.Split(data[1:end], []byte(";")) {
+		part = bytes.TrimSpace(part)
+		if len(part) < 2 || part[0] != '@' {
+			return nil, 0
+		}
+		i := 1
+		for i < len(part) && isCitationKeyChar(part[i]) {
+			i++
+		}
+		// keys may contain punctuation, but don't end with it
+		if i == 1 || !isalnum(part[i-1]) && part[i-1] != '_' {
+			return nil, 0
+		}
+		item := CitationItem{Key: string(part[1:i])}
+
+		rest := bytes.TrimSpace(part[i:])
+		if len(rest) > 0 {
+			if rest[0] != ',' {
+				return nil, 0
+			}
+			item.Locator = string(bytes.Join(bytes.Fields(rest[1:]), []byte(" ")))
+		}
+		items = append(items, item)
+	}
+	return items, end + 1
+}
+
+// isCitationKeyChar reports whether c can appear in a citation key. Keys
+// are made of letters, digits and the punctuation Pandoc allows in them.
+func isCitationKeyChar(c byte) bool {
+	return isalnum(c) || strings.IndexByte("_:.#$%&-+?<>~/", c) >= 0
+}
+
+// citationText writes the items back in the citation syntax.
+func citationText(items []CitationItem) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString("@" + item.Key)
+		if item.Locator != "" {
+			buf.WriteString(", " + item.Locator)
+		}
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// cite records the keys of a citation for the bibliography, in the order
+// they are first cited.
+func (p *parser) cite(items []CitationItem) {
+	if p.cited == nil {
+		p.cited = make(map[string]struct{})
+	}
+	for _, item := range items {
+		if _, ok := p.cited[item.Key]; !ok {
+			p.cited[item.Key] = struct{}{}
+			p.citations = append(p.citations, item.Key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// CommonMark spec tests
+//
+
+package blackfriday
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// commonmarkPassing is the number of examples of each section of the spec
+// known to pass. The numbers only ever go up: raise them when a change
+// makes more examples pass.
+var commonmarkPassing = map[string]int{
+	"ATX headings":                 13,
+	"Autolinks":                    14,
+	"Backslash escapes":            9,
+	"Blank lines":                  1,
+	"Block quotes":                 24,
+	"Code spans":                   10,
+	"Emphasis and strong emphasis": 132,
+	"Entity and numeric character references": 4,
+	"Fenced code blocks":                      20,
+	"HTML blocks":                             44,
+	"Hard line breaks":                        13,
+	"Images":                                  13,
+	"Indented code blocks":                    11,
+	"Inlines":                                 1,
+	"Link reference definitions":              23,
+	"Links":                                   55,
+	"List items":                              48,
+	"Lists":                                   26,
+	"Paragraphs":                              8,
+	"Precedence":                              1,
+	"Raw HTML":                                8,
+	"Setext headings":                         26,
+	"Soft line breaks":                        2,
+	"Tabs":                                    8,
+	"Textual content":                         3,
+	"Thematic breaks":                         19,
+}
+
+// commonmarkExample is one entry of a spec.json file as generated by the
+// CommonMark spec tools, so the full upstream corpus can be dropped in.
+type commonmarkExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+// whitespace around block-level tags is not significant, and neither is
+// how quotes are written, which is also how the spec's own test runner
+// compares output
+var commonmarkBlockSpace = regexp.MustCompile(`\s*(</?(?:address|article|aside|blockquote|body|dd|details|div|dl|dt|figure|footer|h[1-6]|header|hr|html|li|ol|p|pre|section|table|tbody|td|tfoot|th|thead|tr|ul)\b[^>]*>)\s*`)
+
+func normalizeCommonMarkHTML(html string) string {
+	html = commonmarkBlockSpace.ReplaceAllString(html, "$1")
+	return strings.Replace(html, "&quot;", "\"", -1)
+}
+
+func TestCommonMarkSpec(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "commonmark", "spec.json"))
+	if err != nil {
+		t.Fatalf("couldn't read spec examples: %v", err)
+	}
+	var examples []commonmarkExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatalf("couldn't parse spec examples: %v", err)
+	}
+
+	extensions := EXTENSION_COMMONMARK |
+		EXTENSION_FENCED_CODE |
+		EXTENSION_BACKSLASH_LINE_BREAK
+	passed := make(map[string]int)
+	failed := make(map[string][]string)
+	total := 0
+	for _, ex := range examples {
+		renderer := HtmlRenderer(HTML_USE_XHTML, "", "")
+		actual := string(Markdown([]byte(ex.Markdown), renderer, extensions))
+		if normalizeCommonMarkHTML(actual) == normalizeCommonMarkHTML(ex.HTML) {
+			passed[ex.Section]++
+			total++
+			continue
+		}
+		failed[ex.Section] = append(failed[ex.Section],
+			fmt.Sprintf("example %d\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				ex.Example, ex.Markdown, ex.HTML, actual))
+	}
+
+	t.Logf("%d of %d CommonMark examples pass", total, len(examples))
+	for section, want := range commonmarkPassing {
+		if passed[section] < want {
+			t.Errorf("CommonMark compliance regressed in %q: %d examples pass, expected at least %d\n%s",
+				section, passed[section], want, strings.Join(failed[section], "\n"))
+		}
+	}
+}
@@ -0,0 +1,543 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Overriding some of the callbacks of a renderer
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// RendererFuncs holds the callbacks that ComposeRenderer overrides. Each
+// one is called in place of the method of the same name, with the
+// renderer it overrides as base, so that it can write out what base would
+// around what it adds. The callbacks that are nil are left to base.
+type RendererFuncs struct {
+	// block-level callbacks
+	BlockCode          func(base Renderer, out *bytes.Buffer, text []byte, lang string, attrs Attributes)
+	BlockQuote         func(base Renderer, out *bytes.Buffer, text []byte)
+	BlockQuoteCitation func(base Renderer, out *bytes.Buffer, text []byte, citation []byte)
+	Admonition         func(base Renderer, out *bytes.Buffer, kind string, title []byte, text []byte)
+	Container          func(base Renderer, out *bytes.Buffer, info string, text []byte)
+	Details            func(base Renderer, out *bytes.Buffer, summary []byte, text []byte, flags int)
+	BlockSpoiler       func(base Renderer, out *bytes.Buffer, text []byte)
+	BlockShortcode     func(base Renderer, out *bytes.Buffer, text []byte)
+	BlockHtml          func(base Renderer, out *bytes.Buffer, text []byte)
+	Header             func(base Renderer, out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes)
+	HRule              func(base Renderer, out *bytes.Buffer)
+	List               func(base Renderer, out *bytes.Buffer, text func() bool, flags int, start int)
+	ListItem           func(base Renderer, out *bytes.Buffer, text []byte, flags int)
+	Paragraph          func(base Renderer, out *bytes.Buffer, text func() bool)
+	Table              func(base Renderer, out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte)
+	TableRow           func(base Renderer, out *bytes.Buffer, text []byte, flags int)
+	TableHeaderCell    func(base Renderer, out *bytes.Buffer, text []byte, flags int, span int)
+	TableCell          func(base Renderer, out *bytes.Buffer, text []byte, flags int, span int)
+	Footnotes          func(base Renderer, out *bytes.Buffer, text func() bool)
+	FootnoteItem       func(base Renderer, out *bytes.Buffer, name, text []byte, flags int)
+	TitleBlock         func(base Renderer, out *bytes.Buffer, text []byte)
+	Bibliography       func(base Renderer, out *bytes.Buffer, keys []string)
+	LineBlock          func(base Renderer, out *bytes.Buffer, lines [][]byte)
+
+	// span-level callbacks
+	AutoLink       func(base Renderer, out *bytes.Buffer, link []byte, kind int)
+	CodeSpan       func(base Renderer, out *bytes.Buffer, text []byte)
+	DoubleEmphasis func(base Renderer, out *bytes.Buffer, text []byte)
+	Emphasis       func(base Renderer, out *bytes.Buffer, text []byte)
+	Image          func(base Renderer, out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes)
+	LineBreak      func(base Renderer, out *bytes.Buffer)
+	Link           func(base Renderer, out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes)
+	RawHtmlTag     func(base Renderer, out *bytes.Buffer, tag []byte)
+	TripleEmphasis func(base Renderer, out *bytes.Buffer, text []byte)
+	StrikeThrough  func(base Renderer, out *bytes.Buffer, text []byte)
+	Highlight      func(base Renderer, out *bytes.Buffer, text []byte)
+	Insert         func(base Renderer, out *bytes.Buffer, text []byte)
+	CriticMarkup   func(base Renderer, out *bytes.Buffer, kind int, text []byte, replacement []byte)
+	FootnoteRef    func(base Renderer, out *bytes.Buffer, ref []byte, id int)
+	Abbreviation   func(base Renderer, out *bytes.Buffer, abbr []byte, title []byte)
+	Mention        func(base Renderer, out *bytes.Buffer, kind int, token []byte)
+	Citation       func(base Renderer, out *bytes.Buffer, items []CitationItem)
+	MediaEmbed     func(base Renderer, out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte)
+	Kbd            func(base Renderer, out *bytes.Buffer, key []byte)
+	Ruby           func(base Renderer, out *bytes.Buffer, text []byte, annotation []byte)
+	Spoiler        func(base Renderer, out *bytes.Buffer, text []byte)
+	Shortcode      func(base Renderer, out *bytes.Buffer, text []byte)
+
+	// low-level callbacks
+	Entity     func(base Renderer, out *bytes.Buffer, entity []byte)
+	NormalText func(base Renderer, out *bytes.Buffer, text []byte)
+
+	// header and footer
+	DocumentHeader func(base Renderer, out *bytes.Buffer)
+	DocumentFooter func(base Renderer, out *bytes.Buffer)
+}
+
+// ComposeRenderer returns a renderer that calls the callbacks of funcs that
+// are set and the methods of base for the rest, for example to change how
+// the HTML renderer writes out images and nothing else:
+//
+//	renderer := blackfriday.ComposeRenderer(blackfriday.HtmlRenderer(flags, "", ""), blackfriday.RendererFuncs{
+//	    Image: func(base blackfriday.Renderer, out *bytes.Buffer, link, title, alt []byte, attrs blackfriday.Attributes) {
+//	        out.WriteString("<figure>")
+//	        base.Image(out, link, title, alt, attrs)
+//	        out.WriteString("</figure>")
+//	    },
+//	})
+//
+// Unlike a type that embeds base, the renderer it returns renders each
+// document with a renderer of its own from ForDocument(base), and the
+// callbacks are given that one as base. It can be used by several
+// goroutines at once if base can and the callbacks keep no state of their
+// own.
+func ComposeRenderer(base Renderer, funcs RendererFuncs) Renderer {
+	return &composedRenderer{base: base, funcs: funcs}
+}
+
+// CaptureText calls text, the closure that the Header, List, Paragraph and
+// Footnotes callbacks are given to write out their content, and returns
+// what it wrote instead of leaving it in out. If ok is false the parser
+// gave up on the block, and the callback should write nothing.
+//
+// Together with TextFunc, it lets a callback look at the rendered content
+// of a block before handing it on, for example to collect the headers of
+// a document:
+//
+//	Header: func(base blackfriday.Renderer, out *bytes.Buffer, text func() bool, level int, id string, attrs blackfriday.Attributes) {
+//	    content, ok := blackfriday.CaptureText(out, text)
+//	    if ok {
+//	        headers = append(headers, string(content))
+//	        base.Header(out, blackfriday.TextFunc(out, content), level, id, attrs)
+//	    }
+//	},
+func CaptureText(out *bytes.Buffer, text func() bool) (content []byte, ok bool) {
+	marker := out.Len()
+	ok = text()
+	content = append([]byte(nil), out.Bytes()[marker:]...)
+	out.Truncate(marker)
+	return content, ok
+}
+
+// TextFunc returns a closure that writes content to out, to pass content
+// captured with CaptureText on to a Header, List, Paragraph or Footnotes
+// callback.
+func TextFunc(out *bytes.Buffer, content []byte) func() bool {
+	return func() bool {
+		out.Write(content)
+		return true
+	}
+}
+
+type composedRenderer struct {
+	base  Renderer
+	funcs RendererFuncs
+}
+
+func (r *composedRenderer) NewDocument() Renderer {
+	return &composedRenderer{base: ForDocument(r.base), funcs: r.funcs}
+}
+
+func (r *composedRenderer) GetFlags() int {
+	return r.base.GetFlags()
+}
+
+func (r *composedRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
+	if r.funcs.BlockCode != nil {
+		r.funcs.BlockCode(r.base, out, text, lang, attrs)
+	} else {
+		r.base.BlockCode(out, text, lang, attrs)
+	}
+}
+
+func (r *composedRenderer) BlockQuote(out *bytes.Buffer, text []byte) {
+	if r.funcs.BlockQuote != nil {
+		r.funcs.BlockQuote(r.base, out, text)
+	} else {
+		r.base.BlockQuote(out, text)
+	}
+}
+
+func (r *composedRenderer) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
+	if r.funcs.BlockQuoteCitation != nil {
+		r.funcs.BlockQuoteCitation(r.base, out, text, citation)
+	} else {
+		r.base.BlockQuoteCitation(out, text, citation)
+	}
+}
+
+func (r *composedRenderer) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
+	if r.funcs.Admonition != nil {
+		r.funcs.Admonition(r.base, out, kind, title, text)
+	} else {
+		r.base.Admonition(out, kind, title, text)
+	}
+}
+
+func (r *composedRenderer) Container(out *bytes.Buffer, info string, text []byte) {
+	if r.funcs.Container != nil {
+		r.funcs.Container(r.base, out, info, text)
+	} else {
+		r.base.Container(out, info, text)
+	}
+}
+
+func (r *composedRenderer) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	if r.funcs.Details != nil {
+		r.funcs.Details(r.base, out, summary, text, flags)
+	} else {
+		r.base.Details(out, summary, text, flags)
+	}
+}
+
+func (r *composedRenderer) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	if r.funcs.BlockSpoiler != nil {
+		r.funcs.BlockSpoiler(r.base, out, text)
+	} else {
+		r.base.BlockSpoiler(out, text)
+	}
+}
+
+func (r *composedRenderer) BlockShortcode(out *bytes.Buffer, text []byte) {
+	if r.funcs.BlockShortcode != nil {
+		r.funcs.BlockShortcode(r.base, out, text)
+	} else {
+		r.base.BlockShortcode(out, text)
+	}
+}
+
+func (r *composedRenderer) BlockHtml(out *bytes.Buffer, text []byte) {
+	if r.funcs.BlockHtml != nil {
+		r.funcs.BlockHtml(r.base, out, text)
+	} else {
+		r.base.BlockHtml(out, text)
+	}
+}
+
+func (r *composedRenderer) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	if r.funcs.Header != nil {
+		r.funcs.Header(r.base, out, text, level, id, attrs)
+	} else {
+		r.base.Header(out, text, level, id, attrs)
+	}
+}
+
+func (r *composedRenderer) HRule(out *bytes.Buffer) {
+	if r.funcs.HRule != nil {
+		r.funcs.HRule(r.base, out)
+	} else {
+		r.base.HRule(out)
+	}
+}
+
+func (r *composedRenderer) List(out *bytes.Buffer, text func() bool, flags int, start int) {
+	if r.funcs.List != nil {
+		r.funcs.List(r.base, out, text, flags, start)
+	} else {
+		r.base.List(out, text, flags, start)
+	}
+}
+
+func (r *composedRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	if r.funcs.ListItem != nil {
+		r.funcs.ListItem(r.base, out, text, flags)
+	} else {
+		r.base.ListItem(out, text, flags)
+	}
+}
+
+func (r *composedRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
+	if r.funcs.Paragraph != nil {
+		r.funcs.Paragraph(r.base, out, text)
+	} else {
+		r.base.Paragraph(out, text)
+	}
+}
+
+func (r *composedRenderer) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	if r.funcs.Table != nil {
+		r.funcs.Table(r.base, out, header, body, columnData, caption)
+	} else {
+		r.base.Table(out, header, body, columnData, caption)
+	}
+}
+
+func (r *composedRenderer) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	if r.funcs.TableRow != nil {
+		r.funcs.TableRow(r.base, out, text, flags)
+	} else {
+		r.base.TableRow(out, text, flags)
+	}
+}
+
+func (r *composedRenderer) TableHeaderCell(out *bytes.Buffer, text []byte, flags int, span int) {
+	if r.funcs.TableHeaderCell != nil {
+		r.funcs.TableHeaderCell(r.base, out, text, flags, span)
+	} else {
+		r.base.TableHeaderCell(out, text, flags, span)
+	}
+}
+
+func (r *composedRenderer) TableCell(out *bytes.Buffer, text []byte, flags int, span int) {
+	if r.funcs.TableCell != nil {
+		r.funcs.TableCell(r.base, out, text, flags, span)
+	} else {
+		r.base.TableCell(out, text, flags, span)
+	}
+}
+
+func (r *composedRenderer) Footnotes(out *bytes.Buffer, text func() bool) {
+	if r.funcs.Footnotes != nil {
+		r.funcs.Footnotes(r.base, out, text)
+	} else {
+		r.base.Footnotes(out, text)
+	}
+}
+
+func (r *composedRenderer) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	if r.funcs.FootnoteItem != nil {
+		r.funcs.FootnoteItem(r.base, out, name, text, flags)
+	} else {
+		r.base.FootnoteItem(out, name, text, flags)
+	}
+}
+
+func (r *composedRenderer) TitleBlock(out *bytes.Buffer, text []byte) {
+	if r.funcs.TitleBlock != nil {
+		r.funcs.TitleBlock(r.base, out, text)
+	} else {
+		r.base.TitleBlock(out, text)
+	}
+}
+
+func (r *composedRenderer) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	if r.funcs.LineBlock != nil {
+		r.funcs.LineBlock(r.base, out, lines)
+	} else {
+		r.base.LineBlock(out, lines)
+	}
+}
+
+func (r *composedRenderer) Bibliography(out *bytes.Buffer, keys []string) {
+	if r.funcs.Bibliography != nil {
+		r.funcs.Bibliography(r.base, out, keys)
+	} else {
+		r.base.Bibliography(out, keys)
+	}
+}
+
+func (r *composedRenderer) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	if r.funcs.AutoLink != nil {
+		r.funcs.AutoLink(r.base, out, link, kind)
+	} else {
+		r.base.AutoLink(out, link, kind)
+	}
+}
+
+func (r *composedRenderer) CodeSpan(out *bytes.Buffer, text []byte) {
+	if r.funcs.CodeSpan != nil {
+		r.funcs.CodeSpan(r.base, out, text)
+	} else {
+		r.base.CodeSpan(out, text)
+	}
+}
+
+func (r *composedRenderer) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	if r.funcs.DoubleEmphasis != nil {
+		r.funcs.DoubleEmphasis(r.base, out, text)
+	} else {
+		r.base.DoubleEmphasis(out, text)
+	}
+}
+
+func (r *composedRenderer) Emphasis(out *bytes.Buffer, text []byte) {
+	if r.funcs.Emphasis != nil {
+		r.funcs.Emphasis(r.base, out, text)
+	} else {
+		r.base.Emphasis(out, text)
+	}
+}
+
+func (r *composedRenderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
+	if r.funcs.Image != nil {
+		r.funcs.Image(r.base, out, link, title, alt, attrs)
+	} else {
+		r.base.Image(out, link, title, alt, attrs)
+	}
+}
+
+func (r *composedRenderer) LineBreak(out *bytes.Buffer) {
+	if r.funcs.LineBreak != nil {
+		r.funcs.LineBreak(r.base, out)
+	} else {
+		r.base.LineBreak(out)
+	}
+}
+
+func (r *composedRenderer) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
+	if r.funcs.Link != nil {
+		r.funcs.Link(r.base, out, link, title, content, attrs)
+	} else {
+		r.base.Link(out, link, title, content, attrs)
+	}
+}
+
+func (r *composedRenderer) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	if r.funcs.RawHtmlTag != nil {
+		r.funcs.RawHtmlTag(r.base, out, tag)
+	} else {
+		r.base.RawHtmlTag(out, tag)
+	}
+}
+
+func (r *composedRenderer) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	if r.funcs.TripleEmphasis != nil {
+		r.funcs.TripleEmphasis(r.base, out, text)
+	} else {
+		r.base.TripleEmphasis(out, text)
+	}
+}
+
+func (r *composedRenderer) StrikeThrough(out *bytes.Buffer, text []byte) {
+	if r.funcs.StrikeThrough != nil {
+		r.funcs.StrikeThrough(r.base, out, text)
+	} else {
+		r.base.StrikeThrough(out, text)
+	}
+}
+
+func (r *composedRenderer) Highlight(out *bytes.Buffer, text []byte) {
+	if r.funcs.Highlight != nil {
+		r.funcs.Highlight(r.base, out, text)
+	} else {
+		r.base.Highlight(out, text)
+	}
+}
+
+func (r *composedRenderer) Insert(out *bytes.Buffer, text []byte) {
+	if r.funcs.Insert != nil {
+		r.funcs.Insert(r.base, out, text)
+	} else {
+		r.base.Insert(out, text)
+	}
+}
+
+func (r *composedRenderer) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	if r.funcs.CriticMarkup != nil {
+		r.funcs.CriticMarkup(r.base, out, kind, text, replacement)
+	} else {
+		r.base.CriticMarkup(out, kind, text, replacement)
+	}
+}
+
+func (r *composedRenderer) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	if r.funcs.FootnoteRef != nil {
+		r.funcs.FootnoteRef(r.base, out, ref, id)
+	} else {
+		r.base.FootnoteRef(out, ref, id)
+	}
+}
+
+func (r *composedRenderer) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	if r.funcs.Abbreviation != nil {
+		r.funcs.Abbreviation(r.base, out, abbr, title)
+	} else {
+		r.base.Abbreviation(out, abbr, title)
+	}
+}
+
+func (r *composedRenderer) Mention(out *bytes.Buffer, kind int, token []byte) {
+	if r.funcs.Mention != nil {
+		r.funcs.Mention(r.base, out, kind, token)
+	} else {
+		r.base.Mention(out, kind, token)
+	}
+}
+
+func (r *composedRenderer) Citation(out *bytes.Buffer, items []CitationItem) {
+	if r.funcs.Citation != nil {
+		r.funcs.Citation(r.base, out, items)
+	} else {
+		r.base.Citation(out, items)
+	}
+}
+
+func (r *composedRenderer) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	if r.funcs.MediaEmbed != nil {
+		r.funcs.MediaEmbed(r.base, out, kind, link, title, alt)
+	} else {
+		r.base.MediaEmbed(out, kind, link, title, alt)
+	}
+}
+
+func (r *composedRenderer) Kbd(out *bytes.Buffer, key []byte) {
+	if r.funcs.Kbd != nil {
+		r.funcs.Kbd(r.base, out, key)
+	} else {
+		r.base.Kbd(out, key)
+	}
+}
+
+func (r *composedRenderer) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	if r.funcs.Ruby != nil {
+		r.funcs.Ruby(r.base, out, text, annotation)
+	} else {
+		r.base.Ruby(out, text, annotation)
+	}
+}
+
+func (r *composedRenderer) Spoiler(out *bytes.Buffer, text []byte) {
+	if r.funcs.Spoiler != nil {
+		r.funcs.Spoiler(r.base, out, text)
+	} else {
+		r.base.Spoiler(out, text)
+	}
+}
+
+func (r *composedRenderer) Shortcode(out *bytes.Buffer, text []byte) {
+	if r.funcs.Shortcode != nil {
+		r.funcs.Shortcode(r.base, out, text)
+	} else {
+		r.base.Shortcode(out, text)
+	}
+}
+
+func (r *composedRenderer) Entity(out *bytes.Buffer, entity []byte) {
+	if r.funcs.Entity != nil {
+		r.funcs.Entity(r.base, out, entity)
+	} else {
+		r.base.Entity(out, entity)
+	}
+}
+
+func (r *composedRenderer) NormalText(out *bytes.Buffer, text []byte) {
+	if r.funcs.NormalText != nil {
+		r.funcs.NormalText(r.base, out, text)
+	} else {
+		r.base.NormalText(out, text)
+	}
+}
+
+func (r *composedRenderer) DocumentHeader(out *bytes.Buffer) {
+	if r.funcs.DocumentHeader != nil {
+		r.funcs.DocumentHeader(r.base, out)
+	} else {
+		r.base.DocumentHeader(out)
+	}
+}
+
+func (r *composedRenderer) DocumentFooter(out *bytes.Buffer) {
+	if r.funcs.DocumentFooter != nil {
+		r.funcs.DocumentFooter(r.base, out)
+	} else {
+		r.base.DocumentFooter(out)
+	}
+}
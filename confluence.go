@@ -0,0 +1,525 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Confluence wiki markup rendering backend
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Confluence is a type that implements the Renderer interface for
+// Confluence wiki markup.
+//
+// Do not create this directly, instead use the ConfluenceRenderer function.
+type Confluence struct {
+	flags int
+
+	// bullets of the lists being rendered, innermost last: '*' for
+	// unordered lists, '#' for ordered ones
+	listMarkers []byte
+}
+
+// ConfluenceRenderer creates and configures a Confluence object, which
+// satisfies the Renderer interface.
+//
+// flags is a set of CONFLUENCE_* options ORed together (currently no such
+// options are defined).
+//
+// The output is the wiki markup accepted by Confluence's editor and by the
+// "wiki" representation of its REST API. Raw HTML has no equivalent there
+// and is dropped.
+func ConfluenceRenderer(flags int) Renderer {
+	return &Confluence{flags: flags}
+}
+
+func (options *Confluence) GetFlags() int {
+	return options.flags
+}
+
+func (options *Confluence) newDocument() Renderer {
+	return &Confluence{flags: options.flags}
+}
+
+// A blank line ends paragraphs, lists and tables alike, so every block
+// ends with one.
+
+func (options *Confluence) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
+	out.WriteString("{code")
+	if fields := strings.Fields(lang); len(fields) > 0 {
+		out.WriteString(":language=")
+		out.WriteString(strings.TrimPrefix(fields[0], "."))
+	}
+	out.WriteString("}\n")
+	out.Write(text)
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString("{code}\n\n")
+}
+
+func (options *Confluence) TitleBlock(out *bytes.Buffer, text []byte) {
+	text = bytes.TrimPrefix(text, []byte("% "))
+	text = bytes.Replace(text, []byte("\n% "), []byte(" "), -1)
+	out.WriteString("h1. ")
+	options.NormalText(out, bytes.TrimSpace(text))
+	out.WriteString("\n\n")
+}
+
+// Confluence breaks lines where the source does, and an empty line would
+// end the paragraph, so empty lines are written as forced line breaks.
+func (options *Confluence) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	for i, line := range lines {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		if len(line) == 0 {
+			out.WriteString("\\\\")
+		}
+		out.Write(line)
+	}
+	out.WriteString("\n\n")
+}
+
+func (options *Confluence) Bibliography(out *bytes.Buffer, keys []string) {
+}
+
+func (options *Confluence) BlockQuote(out *bytes.Buffer, text []byte) {
+	out.WriteString("{quote}\n")
+	out.Write(bytes.TrimRight(text, "\n"))
+	out.WriteString("\n{quote}\n\n")
+}
+
+func (options *Confluence) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
+	out.WriteString("{quote}\n")
+	out.Write(bytes.TrimRight(text, "\n"))
+	out.WriteString("\n\n\u2014 ")
+	out.Write(citation)
+	out.WriteString("\n{quote}\n\n")
+}
+
+// Admonitions are written with the panel macro closest to their kind.
+func (options *Confluence) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
+	macro := "info"
+	switch kind {
+	case "tip", "warning":
+		macro = kind
+	case "important":
+		macro = "note"
+	case "caution":
+		macro = "warning"
+	}
+	out.WriteString("{" + macro)
+	if len(title) > 0 {
+		out.WriteString(":title=")
+		confluenceEscape(out, title)
+	}
+	out.WriteString("}\n")
+	out.Write(bytes.TrimRight(text, "\n"))
+	out.WriteString("\n{" + macro + "}\n\n")
+}
+
+func (options *Confluence) Container(out *bytes.Buffer, info string, text []byte) {
+	out.Write(text)
+}
+
+// Details become an expand macro, which is collapsed to begin with.
+func (options *Confluence) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	out.WriteString("{expand")
+	if len(summary) > 0 {
+		out.WriteString(":title=")
+		out.Write(summary)
+	}
+	out.WriteString("}\n")
+	out.Write(bytes.TrimRight(text, "\n"))
+	out.WriteString("\n{expand}\n\n")
+}
+
+func (options *Confluence) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	options.Details(out, []byte("Spoiler"), text, 0)
+}
+
+func (options *Confluence) BlockShortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteString("\n\n")
+}
+
+func (options *Confluence) BlockHtml(out *bytes.Buffer, text []byte) {
+}
+
+func (options *Confluence) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	marker := out.Len()
+	out.WriteString("h")
+	out.WriteString(strconv.Itoa(level))
+	out.WriteString(". ")
+	if id != "" {
+		out.WriteString("{anchor:")
+		out.WriteString(id)
+		out.WriteString("}")
+	}
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n\n")
+}
+
+func (options *Confluence) HRule(out *bytes.Buffer) {
+	out.WriteString("----\n\n")
+}
+
+// Nested list items repeat the bullets of their parents, as in "*#", so
+// the list callbacks keep track of the bullets in use.
+func (options *Confluence) List(out *bytes.Buffer, text func() bool, flags int, start int) {
+	marker := out.Len()
+	markers := options.listMarkers
+	switch {
+	case flags&LIST_TYPE_DEFINITION != 0:
+	case flags&LIST_TYPE_ORDERED != 0:
+		options.listMarkers = append(options.listMarkers, '#')
+	default:
+		options.listMarkers = append(options.listMarkers, '*')
+	}
+	// a nested list starts on a line of its own
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+		out.Truncate(len(bytes.TrimRight(out.Bytes(), " ")))
+		out.WriteString("\n")
+		marker = out.Len()
+	}
+	ok := text()
+	options.listMarkers = markers
+	if !ok {
+		out.Truncate(marker)
+		return
+	}
+	if len(options.listMarkers) == 0 {
+		out.WriteString("\n")
+	}
+}
+
+// Confluence has no definition lists, so terms are written in bold on a
+// line of their own, followed by their definitions.
+func (options *Confluence) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	text = confluenceCollapseLines(text)
+	switch {
+	case flags&LIST_TYPE_TERM != 0:
+		out.WriteString("*")
+		out.Write(text)
+		out.WriteString("*\n")
+		return
+	case flags&LIST_TYPE_DEFINITION != 0:
+		out.Write(text)
+		out.WriteString("\n")
+		return
+	}
+
+	out.Write(options.listMarkers)
+	out.WriteString(" ")
+	switch {
+	case flags&LIST_ITEM_CHECKED != 0:
+		out.WriteString("(/) ")
+	case flags&LIST_ITEM_TASK != 0:
+		out.WriteString("(x) ")
+	}
+	out.Write(text)
+	out.WriteString("\n")
+}
+
+var confluenceBlankLines = regexp.MustCompile(`\n{2,}`)
+
+// A blank line ends a list, so the paragraphs of loose list items are
+// kept on adjacent lines.
+func confluenceCollapseLines(text []byte) []byte {
+	return confluenceBlankLines.ReplaceAll(bytes.Trim(text, " \n"), []byte("\n"))
+}
+
+func (options *Confluence) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n\n")
+}
+
+// The column alignment can't be expressed in wiki markup and is dropped.
+func (options *Confluence) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	out.Write(header)
+	out.Write(body)
+	if len(caption) > 0 {
+		out.Write(caption)
+		out.WriteString("\n")
+	}
+	out.WriteString("\n")
+}
+
+func (options *Confluence) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	out.Write(text)
+	if flags&TABLE_ROW_HEADER != 0 {
+		out.WriteString("||\n")
+	} else {
+		out.WriteString("|\n")
+	}
+}
+
+// Confluence tables can't span columns, so spanning cells are followed by
+// empty ones instead.
+func (options *Confluence) TableHeaderCell(out *bytes.Buffer, text []byte, align int, span int) {
+	out.WriteString("||")
+	out.Write(text)
+	if span > 1 {
+		out.WriteString(strings.Repeat("|| ", span-1))
+	}
+}
+
+func (options *Confluence) TableCell(out *bytes.Buffer, text []byte, align int, span int) {
+	out.WriteString("|")
+	// an empty cell would run into the next separator
+	if len(text) == 0 {
+		out.WriteString(" ")
+	}
+	out.Write(text)
+	if span > 1 {
+		out.WriteString(strings.Repeat("| ", span-1))
+	}
+}
+
+// Footnotes are written as a numbered list after a rule, matching the
+// superscript numbers written by FootnoteRef.
+func (options *Confluence) Footnotes(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	out.WriteString("----\n")
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n")
+}
+
+func (options *Confluence) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	out.WriteString("# ")
+	out.Write(confluenceCollapseLines(text))
+	out.WriteString("\n")
+}
+
+func (options *Confluence) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.WriteString("[")
+	switch kind {
+	case LINK_TYPE_EMAIL:
+		out.WriteString("mailto:")
+	case LINK_TYPE_WWW:
+		out.WriteString("http://")
+	}
+	out.Write(link)
+	out.WriteString("]")
+}
+
+func (options *Confluence) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.WriteString("{{")
+	confluenceEscape(out, text)
+	out.WriteString("}}")
+}
+
+func (options *Confluence) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("*")
+	out.Write(text)
+	out.WriteString("*")
+}
+
+func (options *Confluence) Emphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("_")
+	out.Write(text)
+	out.WriteString("_")
+}
+
+func (options *Confluence) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
+	out.WriteString("!")
+	out.Write(link)
+	var params []string
+	if len(alt) > 0 {
+		params = append(params, "alt="+confluenceAttr(alt))
+	}
+	if len(title) > 0 {
+		params = append(params, "title="+confluenceAttr(title))
+	}
+	if len(params) > 0 {
+		out.WriteString("|")
+		out.WriteString(strings.Join(params, ","))
+	}
+	out.WriteString("!")
+}
+
+// confluenceAttr quotes an image attribute value if it contains one of the
+// separators of the attribute list.
+func confluenceAttr(value []byte) string {
+	if bytes.IndexAny(value, ",|!") < 0 {
+		return string(value)
+	}
+	return "\"" + strings.Replace(string(value), "\"", "'", -1) + "\""
+}
+
+// Within a paragraph, Confluence keeps line breaks as they are.
+func (options *Confluence) LineBreak(out *bytes.Buffer) {
+	out.WriteString("\n")
+}
+
+func (options *Confluence) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
+	out.WriteString("[")
+	out.Write(content)
+	out.WriteString("|")
+	out.Write(link)
+	if len(title) > 0 {
+		out.WriteString("|")
+		confluenceEscape(out, title)
+	}
+	out.WriteString("]")
+}
+
+func (options *Confluence) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+}
+
+func (options *Confluence) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("*_")
+	out.Write(text)
+	out.WriteString("_*")
+}
+
+func (options *Confluence) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString("-")
+	out.Write(text)
+	out.WriteString("-")
+}
+
+// Confluence has no markup for highlighted text.
+func (options *Confluence) Highlight(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Confluence) Insert(out *bytes.Buffer, text []byte) {
+	out.WriteString("+")
+	out.Write(text)
+	out.WriteString("+")
+}
+
+// Comments are left out, as wiki markup has no place for them.
+func (options *Confluence) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	criticChanges(options, out, kind, text, replacement)
+}
+
+func (options *Confluence) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("^")
+	out.WriteString(strconv.Itoa(id))
+	out.WriteString("^")
+}
+
+func (options *Confluence) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	confluenceEscape(out, abbr)
+}
+
+// User mentions become links to the Confluence user of the same name.
+func (options *Confluence) Mention(out *bytes.Buffer, kind int, token []byte) {
+	if kind == MENTION_TYPE_ISSUE {
+		out.WriteString("\\#")
+		confluenceEscape(out, token)
+		return
+	}
+	out.WriteString("[~")
+	out.Write(token)
+	out.WriteString("]")
+}
+
+func (options *Confluence) Citation(out *bytes.Buffer, items []CitationItem) {
+	confluenceEscape(out, citationText(items))
+}
+
+// Video and audio files are embedded like images, the pages of providers
+// with the widget macro.
+func (options *Confluence) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	if kind != MEDIA_EMBED {
+		options.Image(out, link, title, alt, nil)
+		return
+	}
+	out.WriteString("{widget:url=")
+	out.Write(link)
+	out.WriteString("}")
+}
+
+// Confluence has no markup for keys, so they are set in monospace.
+func (options *Confluence) Kbd(out *bytes.Buffer, key []byte) {
+	options.CodeSpan(out, key)
+}
+
+func (options *Confluence) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	out.Write(text)
+	out.WriteString("(")
+	out.Write(annotation)
+	out.WriteString(")")
+}
+
+func (options *Confluence) Spoiler(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Confluence) Shortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Confluence) Entity(out *bytes.Buffer, entity []byte) {
+	confluenceEscape(out, []byte(html.UnescapeString(string(entity))))
+}
+
+// Confluence breaks lines where the source does, so the newlines within a
+// paragraph are turned into spaces. A line starting with a list bullet,
+// a header or a rule is escaped.
+func (options *Confluence) NormalText(out *bytes.Buffer, text []byte) {
+	text = bytes.Replace(text, []byte("\n"), []byte(" "), -1)
+	if out.Len() == 0 || out.Bytes()[out.Len()-1] == '\n' {
+		text = bytes.TrimLeft(text, " ")
+		if len(text) > 0 && (text[0] == '#' || text[0] == '-' || confluenceHeaderStart.Match(text)) {
+			out.WriteByte('\\')
+		}
+	}
+	confluenceEscape(out, text)
+}
+
+var confluenceHeaderStart = regexp.MustCompile(`^(h[1-6]|bq)\.`)
+
+// The '!' of images is left alone: the parser removes it from the text
+// written before an image, which an escape would get in the way of.
+func confluenceEscape(out *bytes.Buffer, text []byte) {
+	for _, c := range text {
+		if strings.IndexByte("\\{}[]|*_^~+?", c) >= 0 {
+			out.WriteByte('\\')
+		}
+		out.WriteByte(c)
+	}
+}
+
+// header and footer
+func (options *Confluence) DocumentHeader(out *bytes.Buffer) {
+	options.listMarkers = nil
+}
+
+// Trailing blank lines are trimmed, leaving a single newline.
+func (options *Confluence) DocumentFooter(out *bytes.Buffer) {
+	doc := bytes.TrimRight(out.Bytes(), "\n")
+	out.Truncate(len(doc))
+	if len(doc) > 0 {
+		out.WriteString("\n")
+	}
+}
@@ -0,0 +1,89 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for Confluence rendering
+//
+
+package blackfriday
+
+import (
+	"testing"
+)
+
+func runMarkdownConfluence(input string, extensions Extensions) string {
+	return string(Markdown([]byte(input), ConfluenceRenderer(0), extensions))
+}
+
+func doTestsConfluence(t *testing.T, tests []string, extensions Extensions) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runMarkdownConfluence(input, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestConfluenceBlocks(t *testing.T) {
+	var tests = []string{
+		"# Title {#top}\n\nSome\ntext.\n",
+		"h1. {anchor:top}Title\n\nSome text.\n",
+
+		"```go\nx := map[string]int{}\n```\n",
+		"{code:language=go}\nx := map[string]int{}\n{code}\n",
+
+		"> quoted\n",
+		"{quote}\nquoted\n{quote}\n",
+
+		"- a\n- b\n    1. c\n    2. d\n- e\n\nafter\n",
+		"* a\n* b\n*# c\n*# d\n* e\n\nafter\n",
+
+		"- one\n\n    more\n\n- two\n",
+		"* one\nmore\n* two\n",
+
+		"| a | b |\n|---|--:|\n| 1 |   |\n",
+		"||a||b||\n|1| |\n",
+
+		"***\n",
+		"----\n",
+
+		"<div>raw</div>\n\ntext\n",
+		"text\n",
+
+		"> [!CAUTION] Hot\n> Don't touch.\n",
+		"{warning:title=Hot}\nDon't touch.\n{warning}\n",
+	}
+	doTestsConfluence(t, tests, EXTENSION_HEADER_IDS|EXTENSION_FENCED_CODE|EXTENSION_TABLES|EXTENSION_ADMONITIONS)
+}
+
+func TestConfluenceInline(t *testing.T) {
+	var tests = []string{
+		"*a* **b** ***c*** ~~d~~ `e{}`\n",
+		"_a_ *b* *_c_* -d- {{e\\{\\}}}\n",
+
+		"[text](http://example.com/ \"tip\") and <http://example.com/>\n",
+		"[text|http://example.com/|tip] and [http://example.com/]\n",
+
+		"![alt](/a.png \"T\")\n",
+		"!/a.png|alt=alt,title=T!\n",
+
+		"a [b] {c} | d &amp; e\n",
+		"a \\[b\\] \\{c\\} \\| d & e\n",
+
+		"line  \nbreak\n",
+		"line\nbreak\n",
+
+		"Text[^1].\n\n[^1]: The note.\n",
+		"Text^1^.\n\n----\n# The note.\n",
+	}
+	doTestsConfluence(t, tests, EXTENSION_STRIKETHROUGH|EXTENSION_FOOTNOTES)
+}
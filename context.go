@@ -0,0 +1,48 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Rendering with a deadline
+//
+//
+
+//go:build go1.7
+// +build go1.7
+
+package blackfriday
+
+import (
+	"context"
+)
+
+// MarkdownContext is like Markdown, but gives up on the document once ctx
+// is done, so that a request handler can bound the time spent rendering
+// untrusted input. ctx is checked before each block, at every level of
+// nesting; a block that has been started is parsed to its end.
+//
+// If ctx is done by the time the document is rendered, MarkdownContext
+// returns no output and ctx.Err(). Otherwise it returns the output, or an
+// error if the parser or the renderer fails.
+func MarkdownContext(ctx context.Context, input []byte, renderer Renderer, extensions Extensions) ([]byte, error) {
+	if renderer == nil {
+		return nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := newParser(renderer, Options{Extensions: extensions})
+	p.done = ctx.Done()
+	output, err := p.render(input)
+	if p.cancelled() {
+		return nil, ctx.Err()
+	}
+	return output, err
+}
@@ -0,0 +1,75 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for rendering with a deadline
+//
+
+//go:build go1.7
+// +build go1.7
+
+package blackfriday
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// cancelParagraphs cancels the rendering once it has rendered a number of
+// paragraphs
+type cancelParagraphs struct {
+	Renderer
+	cancel      func()
+	limit, seen int
+}
+
+func (r *cancelParagraphs) Paragraph(out *bytes.Buffer, text func() bool) {
+	r.Renderer.Paragraph(out, text)
+	if r.seen++; r.seen == r.limit {
+		r.cancel()
+	}
+}
+
+func TestMarkdownContext(t *testing.T) {
+	input := []byte("# Title\n\nSome *text*.\n\n- one\n- two\n")
+	output, err := MarkdownContext(context.Background(), input, HtmlRenderer(0, "", ""), commonExtensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := Markdown(input, HtmlRenderer(0, "", ""), commonExtensions); !bytes.Equal(output, expected) {
+		t.Errorf("\nExpected[%q]\nActual  [%q]", expected, output)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	output, err = MarkdownContext(ctx, input, HtmlRenderer(0, "", ""), 0)
+	if err != context.Canceled || output != nil {
+		t.Errorf("done context: got %q, %v", output, err)
+	}
+}
+
+func TestMarkdownContextCancel(t *testing.T) {
+	for _, input := range []string{
+		strings.Repeat("para\n\n", 100),
+		"> " + strings.Repeat("quoted\n>\n> ", 100) + "\n",
+		strings.Repeat("- item\n\n  para\n\n", 100),
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &cancelParagraphs{Renderer: HtmlRenderer(0, "", ""), cancel: cancel, limit: 3}
+		output, err := MarkdownContext(ctx, []byte(input), r, 0)
+		if err != context.Canceled || output != nil {
+			t.Errorf("\nInput [%q]\ngot %q, %v", input, output, err)
+		}
+		if r.seen != r.limit {
+			t.Errorf("\nInput [%q]\n%d paragraphs rendered after cancelling", input, r.seen-r.limit)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// CriticMarkup with EXTENSION_CRITIC_MARKUP
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// The delimiters of each kind of annotation.
+var criticDelimiters = []struct {
+	open, close string
+	kind        int
+}{
+	{"{++", "++}", CRITIC_ADDITION},
+	{"{--", "--}", CRITIC_DELETION},
+	{"{~~", "~~}", CRITIC_SUBSTITUTION},
+	{"{>>", "<<}", CRITIC_COMMENT},
+	{"{==", "==}", CRITIC_HIGHLIGHT},
+}
+
+// '{': a CriticMarkup annotation. Annotations don't span paragraphs.
+func criticMarkup(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	data = data[offset:]
+	for _, delim := range criticDelimiters {
+		if !bytes.HasPrefix(data, []byte(delim.open)) {
+			continue
+		}
+		end := p.nextString(data, 3, delim.close) - 3
+		if end+3 == len(data) {
+			return 0
+		}
+		body := data[3 : 3+end]
+
+		var text, replacement bytes.Buffer
+		if delim.kind == CRITIC_SUBSTITUTION {
+			sep := p.nextString(data, 3, "~>") - 3
+			if sep+2 > end {
+				return 0
+			}
+			p.inline(&text, body[:sep])
+			p.inline(&replacement, body[sep+2:])
+		} else {
+			p.inline(&text, body)
+		}
+
+		p.r.CriticMarkup(out, delim.kind, text.Bytes(), replacement.Bytes())
+		return end + 6
+	}
+	return 0
+}
+
+// isCriticStart checks whether data starts with the opening delimiter of
+// an annotation.
+func isCriticStart(data []byte) bool {
+	for _, delim := range criticDelimiters {
+		if bytes.HasPrefix(data, []byte(delim.open)) {
+			return true
+		}
+	}
+	return false
+}
+
+// criticDelimiter returns the delimiters of the given kind of annotation.
+func criticDelimiter(kind int) (open, close string) {
+	for _, delim := range criticDelimiters {
+		if delim.kind == kind {
+			return delim.open, delim.close
+		}
+	}
+	return "", ""
+}
+
+// criticChanges renders an annotation with the renderer's own callbacks for
+// inserted, struck through and highlighted text, for the renderers that
+// show the changes. Comments are left to the renderer.
+func criticChanges(r Renderer, out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	switch kind {
+	case CRITIC_ADDITION:
+		r.Insert(out, text)
+	case CRITIC_DELETION:
+		r.StrikeThrough(out, text)
+	case CRITIC_SUBSTITUTION:
+		r.StrikeThrough(out, text)
+		r.Insert(out, replacement)
+	case CRITIC_HIGHLIGHT:
+		r.Highlight(out, text)
+	}
+}
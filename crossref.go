@@ -0,0 +1,73 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Cross-references to headers with EXTENSION_CROSS_REFERENCES
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strings"
+)
+
+// index finds the ids and titles of all the headers of the document, and
+// the labels of its examples, before it is rendered, so that references to
+// them can point forward. The document is parsed once without output, by a
+// copy of the parser that shares only these indexes with it.
+func (p *parser) index(doc []byte) {
+	p.headerRefs = make(map[string]string)
+	p.headerTitles = make(map[string][]byte)
+	p.examples = make(map[string]int)
+
+	q := *p
+	q.r = BaseRenderer{}
+	q.indexing = true
+	q.headerIDs = make(map[string]int)
+	q.notes = append([]*reference(nil), p.notes...)
+	q.notesRecord = make(map[string]struct{}, len(p.notesRecord))
+	for k := range p.notesRecord {
+		q.notesRecord[k] = struct{}{}
+	}
+	q.citations, q.cited = nil, nil
+	q.diagnosing = false
+
+	var out bytes.Buffer
+	q.block(&out, doc)
+}
+
+// indexHeader records a header while the headers are indexed. A title used
+// by more than one header refers to the first.
+func (p *parser) indexHeader(id string, title []byte) {
+	if !p.indexing {
+		return
+	}
+	title = bytes.TrimSpace(title)
+	key := strings.ToLower(strings.Join(strings.Fields(string(title)), " "))
+	if _, ok := p.headerRefs[key]; !ok {
+		p.headerRefs[key] = id
+	}
+	p.headerTitles[id] = title
+}
+
+// headerRef returns a reference to the header with the given title, for
+// [Header Title] links.
+func (p *parser) headerRef(title string) (*reference, bool) {
+	if p.flags&EXTENSION_CROSS_REFERENCES == 0 {
+		return nil, false
+	}
+	id, ok := p.headerRefs[strings.ToLower(strings.Join(strings.Fields(title), " "))]
+	if !ok {
+		return nil, false
+	}
+	return &reference{link: []byte("#" + id)}, true
+}
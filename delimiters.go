@@ -0,0 +1,471 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Finding closing delimiters in linear time
+//
+//
+
+package blackfriday
+
+import (
+	"sort"
+	"strings"
+)
+
+// Look-aheads up to this long are scanned directly. Longer ones go through
+// the index of the span, which keeps the time taken by spans with many
+// openers and no closers, such as thousands of "[", linear in their length.
+const spanScanLimit = 64
+
+// spanIndex records where the delimiters of a span of inline text are, so
+// that the span parsers find the one that closes a link, a link
+// destination, a code span, a tag or an annotation without scanning the
+// rest of the span each time.
+// Each of its tables is built the first time it is needed, in one pass
+// over the span, and answers a look-ahead from any position in constant
+// time.
+type spanIndex struct {
+	data       []byte
+	escaped    []bool              // whether a backslash escapes each byte
+	next       map[nextKey][]int32 // the next byte of a set at or after each position
+	brackets   []int32             // the ']' that closes the '[' at each position
+	parens     []int32             // the unmatched ')' at or after each position
+	comments   []int32             // the end of the next "-->" at or after each position
+	substrings map[string][]int32  // the next occurrence of a string at or after each position
+	backticks  *backtickRuns       // the runs of backticks
+}
+
+// backtickRuns are the runs of backticks of a span, with a tree of the
+// longest run in each range of them, to find the next run that is long
+// enough to close a code span
+type backtickRuns struct {
+	starts  []int32 // where each run starts, in order
+	longest []int32 // the longest run in each range: the two halves of k are 2k and 2k+1
+	size    int     // the number of leaves of the tree, a power of two
+}
+
+type nextKey struct {
+	set       string
+	unescaped bool // skip the bytes escaped by a backslash
+	others    bool // look for the bytes that aren't in set instead
+}
+
+// spanIndexOf returns the index of the span data belongs to, and where data
+// starts in it. The span is the text being parsed by inline; data that is
+// not part of it gets an index of its own.
+func (p *parser) spanIndexOf(data []byte) (*spanIndex, int) {
+	if ix := p.spanIndex; ix != nil {
+		if off, ok := sliceOffset(ix.data, data); ok {
+			return ix, off
+		}
+	}
+	span := p.span
+	off, ok := sliceOffset(span, data)
+	if !ok {
+		span, off = data, 0
+	}
+	p.span, p.spanIndex = span, &spanIndex{data: span}
+	return p.spanIndex, off
+}
+
+// sliceOffset returns where data starts in span, if it is a part of it
+func sliceOffset(span, data []byte) (int, bool) {
+	if len(data) == 0 || cap(data) > cap(span) {
+		return 0, false
+	}
+	// a slice of the span ends where the span does
+	off := cap(span) - cap(data)
+	if off+len(data) > len(span) || &span[off] != &data[0] {
+		return 0, false
+	}
+	return off, true
+}
+
+// lookup turns a position in the span from one of the tables into one in
+// data, which starts at off, or len(data) if it is past the end of data
+func lookup(table []int32, data []byte, off, i int) int {
+	if j := int(table[off+i]) - off; j < len(data) {
+		return j
+	}
+	return len(data)
+}
+
+// nextByte returns the position of the first c in data at or after i, or
+// len(data) if there is none.
+func (p *parser) nextByte(data []byte, i int, c byte) int {
+	for end := i + spanScanLimit; i < len(data); i++ {
+		if data[i] == c {
+			return i
+		}
+		if i == end {
+			ix, off := p.spanIndexOf(data)
+			return lookup(ix.nextTable(nextKey{set: string(c)}), data, off, i)
+		}
+	}
+	return len(data)
+}
+
+// skipBytes returns the position of the first byte in data at or after i
+// that isn't in set, or len(data) if there is none.
+func (p *parser) skipBytes(data []byte, i int, set string) int {
+	for end := i + spanScanLimit; i < len(data); i++ {
+		if strings.IndexByte(set, data[i]) < 0 {
+			return i
+		}
+		if i == end {
+			ix, off := p.spanIndexOf(data)
+			return lookup(ix.nextTable(nextKey{set: set, others: true}), data, off, i)
+		}
+	}
+	return len(data)
+}
+
+// nextUnescaped returns the position of the first byte of set in data at
+// or after i that isn't escaped by a backslash, or len(data) if there is
+// none. data[i-1] must not be a backslash.
+func (p *parser) nextUnescaped(data []byte, i int, set string) int {
+	for end := i + spanScanLimit; i < len(data); i++ {
+		if i >= end {
+			ix, off := p.spanIndexOf(data)
+			return lookup(ix.nextTable(nextKey{set: set, unescaped: true}), data, off, i)
+		}
+		if data[i] == '\\' {
+			i++
+		} else if strings.IndexByte(set, data[i]) >= 0 {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// closingBracket returns the position of the ']' that closes the '[' at
+// data[0], looking from i on, or len(data) if there is none. Brackets
+// right after a backslash don't count.
+func (p *parser) closingBracket(data []byte, i int) int {
+	level := 1
+	for end := i + spanScanLimit; i < len(data); i++ {
+		if i == end {
+			ix, off := p.spanIndexOf(data)
+			return lookup(ix.bracketTable(), data, off, 0)
+		}
+		switch {
+		case data[i-1] == '\\':
+		case data[i] == '[':
+			level++
+		case data[i] == ']':
+			if level--; level == 0 {
+				return i
+			}
+		}
+	}
+	return len(data)
+}
+
+// linkDestinationEnd returns the position of the quote or the unmatched
+// ')' that ends the link destination starting at data[i], or len(data) if
+// there is none. Parentheses escaped by a backslash don't count.
+// data[i-1] must not be a backslash.
+func (p *parser) linkDestinationEnd(data []byte, i int) int {
+	start, depth := i, 0
+	for end := i + spanScanLimit; i < len(data); i++ {
+		if i >= end {
+			// from the start, so that the parentheses seen so far count
+			ix, off := p.spanIndexOf(data)
+			quote := lookup(ix.nextTable(nextKey{set: "'\"", unescaped: true}), data, off, start)
+			if paren := lookup(ix.parenTable(), data, off, start); paren < quote {
+				return paren
+			}
+			return quote
+		}
+		switch data[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case '\'', '"':
+			return i
+		}
+	}
+	return len(data)
+}
+
+// closingBackticks returns the position just past the backticks that close
+// the code span opened by the nb backticks at data[0], or 0 if there are
+// none. Those are the first nb of the next run of at least nb.
+func (p *parser) closingBackticks(data []byte, nb int) int {
+	run := 0
+	for i, end := nb, nb+spanScanLimit; i < len(data); i++ {
+		if i == end {
+			// from the start of the run being counted
+			ix, off := p.spanIndexOf(data)
+			if start := ix.backtickRun(off+i-run, nb) - off; start+nb <= len(data) {
+				return start + nb
+			}
+			return 0
+		}
+		if data[i] != '`' {
+			run = 0
+		} else if run++; run == nb {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// commentEnd returns the position of the '>' that ends the first "-->" in
+// data that ends at or after i, or len(data) if there is none. i must be
+// at least 2.
+func (p *parser) commentEnd(data []byte, i int) int {
+	for end := i + spanScanLimit; i < len(data); i++ {
+		if i == end {
+			ix, off := p.spanIndexOf(data)
+			return lookup(ix.commentTable(), data, off, i)
+		}
+		if data[i-2] == '-' && data[i-1] == '-' && data[i] == '>' {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// nextString returns the position of the first s in data at or after i, or
+// len(data) if there is none. It keeps the time taken by openers without a
+// closer, such as "{{" and "{%", linear.
+func (p *parser) nextString(data []byte, i int, s string) int {
+	for end := i + spanScanLimit; i+len(s) <= len(data); i++ {
+		if i == end {
+			ix, off := p.spanIndexOf(data)
+			// the span may go on past data
+			if j := lookup(ix.stringTable(s), data, off, i); j+len(s) <= len(data) {
+				return j
+			}
+			return len(data)
+		}
+		if data[i] == s[0] && string(data[i:i+len(s)]) == s {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// escapes returns which bytes of the span are escaped by a backslash: those
+// after an odd number of backslashes
+func (ix *spanIndex) escapes() []bool {
+	if ix.escaped == nil {
+		ix.escaped = make([]bool, len(ix.data))
+		for i := 1; i < len(ix.data); i++ {
+			ix.escaped[i] = ix.data[i-1] == '\\' && !ix.escaped[i-1]
+		}
+	}
+	return ix.escaped
+}
+
+// newTable returns a table for the span with len(data) at the end, for
+// look-aheads that find nothing
+func (ix *spanIndex) newTable() []int32 {
+	table := make([]int32, len(ix.data)+1)
+	table[len(ix.data)] = int32(len(ix.data))
+	return table
+}
+
+func (ix *spanIndex) nextTable(key nextKey) []int32 {
+	if table, ok := ix.next[key]; ok {
+		return table
+	}
+	var escaped []bool
+	if key.unescaped {
+		escaped = ix.escapes()
+	}
+	table := ix.newTable()
+	for i := len(ix.data) - 1; i >= 0; i-- {
+		table[i] = table[i+1]
+		if (strings.IndexByte(key.set, ix.data[i]) >= 0) != key.others && (escaped == nil || !escaped[i]) {
+			table[i] = int32(i)
+		}
+	}
+	if ix.next == nil {
+		ix.next = make(map[nextKey][]int32)
+	}
+	ix.next[key] = table
+	return table
+}
+
+// bracketTable matches the brackets of the span. With the brackets that
+// open counted up and those that close counted down, the ']' that closes
+// a '[' is the first one after it where the count drops below what it was
+// at the '['. Going backwards, the last position seen at each count finds
+// it.
+func (ix *spanIndex) bracketTable() []int32 {
+	if ix.brackets != nil {
+		return ix.brackets
+	}
+	data := ix.data
+	counts := make([]int32, len(data))
+	count := int32(0)
+	for i, c := range data {
+		if i == 0 || data[i-1] != '\\' {
+			switch c {
+			case '[':
+				count++
+			case ']':
+				count--
+			}
+		}
+		counts[i] = count
+	}
+
+	// counts range from -len(data) to len(data)
+	bias := int32(len(data)) + 1
+	seen := make([]int32, 2*bias+1)
+	for i := range seen {
+		seen[i] = int32(len(data))
+	}
+	ix.brackets = ix.newTable()
+	for i := len(data) - 1; i >= 0; i-- {
+		ix.brackets[i] = seen[counts[i]-1+bias]
+		seen[counts[i]+bias] = int32(i)
+	}
+	return ix.brackets
+}
+
+// parenTable finds, from each position on, the first ')' that isn't
+// matched by a '(' in between, as bracketTable does for brackets
+func (ix *spanIndex) parenTable() []int32 {
+	if ix.parens != nil {
+		return ix.parens
+	}
+	data, escaped := ix.data, ix.escapes()
+	counts := make([]int32, len(data))
+	count := int32(0)
+	for i, c := range data {
+		if !escaped[i] {
+			switch c {
+			case '(':
+				count++
+			case ')':
+				count--
+			}
+		}
+		counts[i] = count
+	}
+
+	bias := int32(len(data)) + 1
+	seen := make([]int32, 2*bias+1)
+	for i := range seen {
+		seen[i] = int32(len(data))
+	}
+	ix.parens = ix.newTable()
+	for i := len(data) - 1; i >= 0; i-- {
+		seen[counts[i]+bias] = int32(i)
+		before := int32(0)
+		if i > 0 {
+			before = counts[i-1]
+		}
+		ix.parens[i] = seen[before-1+bias]
+	}
+	return ix.parens
+}
+
+func (ix *spanIndex) stringTable(s string) []int32 {
+	if table, ok := ix.substrings[s]; ok {
+		return table
+	}
+	data := ix.data
+	table := ix.newTable()
+	for i := len(data) - 1; i >= 0; i-- {
+		table[i] = table[i+1]
+		if i+len(s) <= len(data) && string(data[i:i+len(s)]) == s {
+			table[i] = int32(i)
+		}
+	}
+	if ix.substrings == nil {
+		ix.substrings = make(map[string][]int32)
+	}
+	ix.substrings[s] = table
+	return table
+}
+
+func (ix *spanIndex) commentTable() []int32 {
+	if ix.comments == nil {
+		data := ix.data
+		ix.comments = ix.newTable()
+		for i := len(data) - 1; i >= 0; i-- {
+			ix.comments[i] = ix.comments[i+1]
+			if i >= 2 && data[i-2] == '-' && data[i-1] == '-' && data[i] == '>' {
+				ix.comments[i] = int32(i)
+			}
+		}
+	}
+	return ix.comments
+}
+
+// backtickRun returns where the first run of at least n backticks that
+// starts at or after i is, or len(data) if there is none
+func (ix *spanIndex) backtickRun(i, n int) int {
+	runs := ix.backtickTable()
+	k := sort.Search(len(runs.starts), func(k int) bool { return int(runs.starts[k]) >= i })
+	if k = runs.first(1, 0, runs.size, k, int32(n)); k < 0 {
+		return len(ix.data)
+	}
+	return int(runs.starts[k])
+}
+
+func (ix *spanIndex) backtickTable() *backtickRuns {
+	if ix.backticks != nil {
+		return ix.backticks
+	}
+	var starts, lengths []int32
+	for i := 0; i < len(ix.data); i++ {
+		if ix.data[i] == '`' && (i == 0 || ix.data[i-1] != '`') {
+			starts = append(starts, int32(i))
+			lengths = append(lengths, 0)
+		}
+		if ix.data[i] == '`' {
+			lengths[len(lengths)-1]++
+		}
+	}
+
+	runs := &backtickRuns{starts: starts, size: 1}
+	for runs.size < len(starts) {
+		runs.size *= 2
+	}
+	runs.longest = make([]int32, 2*runs.size)
+	copy(runs.longest[runs.size:], lengths)
+	for k := runs.size - 1; k > 0; k-- {
+		runs.longest[k] = runs.longest[2*k]
+		if runs.longest[2*k+1] > runs.longest[k] {
+			runs.longest[k] = runs.longest[2*k+1]
+		}
+	}
+	ix.backticks = runs
+	return runs
+}
+
+// first returns the first run from k on that is at least n long, or -1 if
+// there is none, looking in the runs lo to hi under node of the tree
+func (runs *backtickRuns) first(node, lo, hi, k int, n int32) int {
+	if hi <= k || runs.longest[node] < n {
+		return -1
+	}
+	if hi-lo == 1 {
+		return lo
+	}
+	mid := (lo + hi) / 2
+	if j := runs.first(2*node, lo, mid, k, n); j >= 0 {
+		return j
+	}
+	return runs.first(2*node+1, mid, hi, k, n)
+}
@@ -0,0 +1,97 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Collapsible sections with EXTENSION_DETAILS
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"regexp"
+)
+
+var (
+	// <details> or <details open>, optionally followed by the summary
+	detailsOpenRe = regexp.MustCompile(`^ {0,3}<details(\s[^>\n]*)?>[ \t]*(?:<summary>(.*)</summary>[ \t]*)?(?:\n|$)`)
+
+	detailsCloseRe = regexp.MustCompile(`^ {0,3}</details>[ \t]*(?:\n|$)`)
+
+	// a summary on a line of its own
+	summaryRe = regexp.MustCompile(`^ {0,3}<summary>(.*)</summary>[ \t]*(?:\n|$)`)
+
+	openAttrRe = regexp.MustCompile(`(?:^|\s)open(?:\s|=|$)`)
+)
+
+// A <details> element is parsed as Markdown, instead of being passed
+// through as an HTML block:
+//
+//	<details>
+//	<summary>More *detail*</summary>
+//
+//	Any blocks.
+//	</details>
+//
+// The opening and closing tags must be on lines of their own, and the
+// summary on the line of the opening tag or the one after it. Details can
+// be nested, so the tags are counted to find the one that closes it.
+func (p *parser) details(out *bytes.Buffer, data []byte) int {
+	m := detailsOpenRe.FindSubmatchIndex(data)
+	if m == nil {
+		return 0
+	}
+	flags := 0
+	if m[2] >= 0 && openAttrRe.Match(data[m[2]:m[3]]) {
+		flags |= DETAILS_OPEN
+	}
+	var summary []byte
+	if m[4] >= 0 {
+		summary = data[m[4]:m[5]]
+	}
+	beg := m[1]
+	if summary == nil {
+		if s := summaryRe.FindSubmatchIndex(data[beg:]); s != nil {
+			summary = data[beg+s[2] : beg+s[3]]
+			beg += s[1]
+		}
+	}
+
+	depth := 1
+	for i := beg; i < len(data); {
+		// tags inside fenced code don't count
+		if p.flags&EXTENSION_FENCED_CODE != 0 {
+			if n := p.fencedCodeBlock(out, data[i:], false); n > 0 {
+				i += n
+				continue
+			}
+		}
+
+		if detailsOpenRe.Match(data[i:]) {
+			depth++
+		} else if end := detailsCloseRe.FindIndex(data[i:]); end != nil {
+			if depth--; depth == 0 {
+				var title, work bytes.Buffer
+				p.inline(&title, bytes.TrimSpace(summary))
+				if i > beg {
+					p.block(&work, data[beg:i])
+				}
+				p.r.Details(out, title.Bytes(), work.Bytes(), flags)
+				return i + end[1]
+			}
+		}
+		i = skipUntilChar(data, i, '\n') + 1
+	}
+
+	p.diagnose(DIAGNOSTIC_WARNING, data, "<details> without a closing tag")
+	p.lookedAhead(data, len(data))
+	return 0
+}
@@ -0,0 +1,118 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Diagnostics about problems in the input
+//
+//
+
+package blackfriday
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// These are the possible severities of a Diagnostic.
+const (
+	DIAGNOSTIC_WARNING = iota // the input probably doesn't mean what it says
+	DIAGNOSTIC_ERROR          // part of the input was left out of the output
+)
+
+// Diagnostic describes a problem found in the input while parsing it, such
+// as a link to a reference that is never defined.
+type Diagnostic struct {
+	Severity int    // DIAGNOSTIC_* value
+	Message  string // description of the problem
+	Line     int    // line of the input, counting from 1, or 0 if unknown
+}
+
+func (d Diagnostic) String() string {
+	severity := "warning"
+	if d.Severity == DIAGNOSTIC_ERROR {
+		severity = "error"
+	}
+	if d.Line == 0 {
+		return severity + ": " + d.Message
+	}
+	return "line " + strconv.Itoa(d.Line) + ": " + severity + ": " + d.Message
+}
+
+// MarkdownDiagnostics is just like MarkdownOptions, but also returns the
+// problems found in the input. The parser doesn't stop at any of them, so
+// the output is the same as that of MarkdownOptions. If the parser or the
+// renderer fails, the error is returned instead of the output.
+//
+// Reported are links to undefined references and footnotes, fenced code
+// blocks without a closing fence, and elements nested deeper than the
+// parser goes.
+func MarkdownDiagnostics(input []byte, renderer Renderer, opts Options) ([]byte, []Diagnostic, error) {
+	if renderer == nil {
+		return nil, nil, nil
+	}
+
+	p := newParser(renderer, opts)
+	p.diagnosing = true
+	output, err := p.render(input)
+	return output, p.diagnostics, err
+}
+
+// diagnose records a problem found at data, unless the same one has been
+// recorded already. Problems are only looked into for MarkdownDiagnostics.
+func (p *parser) diagnose(severity int, data []byte, format string, args ...interface{}) {
+	if !p.diagnosing {
+		return
+	}
+	p.diagnoseLine(severity, p.lineOf(data), format, args...)
+}
+
+// diagnoseLine records a problem found on the given input line, unless the
+// same one has been recorded already.
+func (p *parser) diagnoseLine(severity int, line int, format string, args ...interface{}) {
+	if !p.diagnosing {
+		return
+	}
+	d := Diagnostic{
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Line:     line,
+	}
+	if p.diagnosed[d] {
+		return
+	}
+	if p.diagnosed == nil {
+		p.diagnosed = make(map[Diagnostic]bool)
+	}
+	p.diagnosed[d] = true
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+// lineOf finds the input line of the start of data. Only the text that
+// the parser takes straight from the output of the first pass can be
+// traced back: the contents of block quotes and list items are copied
+// before they are parsed, and give 0.
+func (p *parser) lineOf(data []byte) int {
+	if len(data) == 0 || cap(data) > cap(p.doc) {
+		return 0
+	}
+
+	// a slice of the document ends where the document does
+	offset := cap(p.doc) - cap(data)
+	if offset >= len(p.doc) || &p.doc[offset] != &data[0] {
+		return 0
+	}
+	p.lineTables()
+	line := sort.SearchInts(p.docLines, offset+1) - 1
+	if line >= len(p.lines) {
+		return 0
+	}
+	return p.lines[line]
+}
@@ -0,0 +1,140 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for diagnostics
+//
+
+package blackfriday
+
+import (
+	"strings"
+	"testing"
+)
+
+func runMarkdownDiagnostics(input string, extensions Extensions) string {
+	_, diagnostics, err := MarkdownDiagnostics([]byte(input), HtmlRenderer(0, "", ""), Options{Extensions: extensions})
+	if err != nil {
+		return err.Error()
+	}
+	var lines []string
+	for _, d := range diagnostics {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func doTestsDiagnostics(t *testing.T, tests []string, extensions Extensions) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runMarkdownDiagnostics(input, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%s]\nActual  [%s]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	var tests = []string{
+		"[ok][a] and [ok][]\n\n[a]: /a\n[ok]: /ok\n",
+		"",
+
+		// a plain [text] is not reported, as it often isn't meant as a link
+		"[plain] text\n",
+		"",
+
+		"[a]: /a\n\nSee [this][a] and\n[that][b], [b][].\n",
+		"line 4: warning: link to undefined reference \"b\"",
+
+		"Text[^1] and [^2].\n\n[^1]: Note.\n",
+		"line 1: warning: undefined footnote \"2\"",
+
+		"para\n\n```go\ncode\n",
+		"line 3: warning: fenced code block without a closing fence",
+
+		"- item with [broken][x]\n",
+		"warning: link to undefined reference \"x\"",
+
+		strings.Repeat("> ", 20) + "deep\n",
+		"error: blocks nested more than 16 deep left out",
+	}
+	doTestsDiagnostics(t, tests, EXTENSION_FOOTNOTES|EXTENSION_FENCED_CODE)
+}
+
+func TestDiagnosticsOutput(t *testing.T) {
+	input := []byte("[a][missing]\n\n```\nunclosed\n")
+	renderer := HtmlRenderer(0, "", "")
+	opts := Options{Extensions: EXTENSION_FENCED_CODE}
+
+	output, diagnostics, err := MarkdownDiagnostics(input, renderer, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := string(MarkdownOptions(input, renderer, opts)); string(output) != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, string(output))
+	}
+	if len(diagnostics) != 2 {
+		t.Errorf("expected 2 diagnostics, got %v", diagnostics)
+	}
+}
+
+func TestDiagnosticsVariables(t *testing.T) {
+	input := []byte("{{a}} and\n{{b}}\n")
+	opts := Options{Extensions: EXTENSION_VARIABLES, Variables: map[string]string{"a": "A"}}
+
+	_, diagnostics, err := MarkdownDiagnostics(input, HtmlRenderer(0, "", ""), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "line 2: warning: undefined variable \"b\""
+	if len(diagnostics) != 1 || diagnostics[0].String() != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%v]", expected, diagnostics)
+	}
+}
+
+func TestDiagnosticsCrossReferences(t *testing.T) {
+	var tests = []string{
+		"[](#later) [](#missing)\n\n# Later\n",
+		"line 1: warning: link to undefined header \"#missing\"",
+	}
+	doTestsDiagnostics(t, tests, EXTENSION_CROSS_REFERENCES)
+}
+
+func TestDiagnosticsExampleLists(t *testing.T) {
+	var tests = []string{
+		"(@a) One\n(@a) Two\n\nSee (@b).\n",
+		"line 2: warning: example \"a\" is defined more than once\n" +
+			"line 4: warning: undefined example \"b\"",
+	}
+	doTestsDiagnostics(t, tests, EXTENSION_EXAMPLE_LISTS)
+}
+
+func TestDiagnosticsFrontMatter(t *testing.T) {
+	var tests = []string{
+		"---\ntitle: Example\n---\n(@a) One\n(@a) Two\n",
+		"line 5: warning: example \"a\" is defined more than once",
+	}
+	doTestsDiagnostics(t, tests, EXTENSION_FRONT_MATTER|EXTENSION_EXAMPLE_LISTS)
+}
+
+func TestDiagnosticsAllowedSchemes(t *testing.T) {
+	input := []byte("ok [a](http://a)\n\n[b](javascript:void(0))\n")
+	opts := Options{AllowedSchemes: DefaultAllowedSchemes}
+
+	_, diagnostics, err := MarkdownDiagnostics(input, HtmlRenderer(0, "", ""), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "line 3: warning: link to \"javascript:void(0)\" not allowed"
+	if len(diagnostics) != 1 || diagnostics[0].String() != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%v]", expected, diagnostics)
+	}
+}
@@ -0,0 +1,582 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// DocBook rendering backend
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// DocBook renderer configuration options.
+const (
+	DOCBOOK_COMPLETE_DOCUMENT = 1 << iota // wrap the output in a DocBook 5 <article>
+)
+
+// DocBook is a type that implements the Renderer interface for DocBook 5
+// output.
+//
+// Do not create this directly, instead use the DocBookRenderer function.
+type DocBook struct {
+	flags    int   // DOCBOOK_* options
+	sections []int // levels of the headers whose sections are still open
+	column   int   // column of the next table cell in its row
+
+	// footnote texts by name, put in place of their references at the end
+	// of the document
+	notes map[string][]byte
+}
+
+// DocBookRenderer creates and configures a DocBook object, which
+// satisfies the Renderer interface.
+//
+// flags is a set of DOCBOOK_* options ORed together.
+//
+// Headers open nested <section> elements, code blocks become
+// <programlisting> elements and tables become CALS tables. Raw HTML and
+// horizontal rules have no DocBook equivalent and are dropped.
+func DocBookRenderer(flags int) Renderer {
+	return &DocBook{flags: flags}
+}
+
+func (options *DocBook) GetFlags() int {
+	return options.flags
+}
+
+func (options *DocBook) newDocument() Renderer {
+	return &DocBook{flags: options.flags}
+}
+
+func (options *DocBook) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
+	out.WriteString("<programlisting")
+	if fields := strings.Fields(lang); len(fields) > 0 {
+		out.WriteString(" language=\"")
+		attrEscape(out, []byte(strings.TrimPrefix(fields[0], ".")))
+		out.WriteString("\"")
+	}
+	out.WriteString(">")
+	attrEscape(out, text)
+	out.WriteString("</programlisting>\n")
+}
+
+func (options *DocBook) TitleBlock(out *bytes.Buffer, text []byte) {
+	text = bytes.TrimPrefix(text, []byte("% "))
+	text = bytes.Replace(text, []byte("\n% "), []byte("\n"), -1)
+	out.WriteString("<info><title>")
+	attrEscape(out, text)
+	out.WriteString("</title></info>\n")
+}
+
+func (options *DocBook) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	out.WriteString("<literallayout>")
+	out.Write(bytes.Join(lines, []byte("\n")))
+	out.WriteString("</literallayout>\n")
+}
+
+func (options *DocBook) Bibliography(out *bytes.Buffer, keys []string) {
+}
+
+func (options *DocBook) BlockQuote(out *bytes.Buffer, text []byte) {
+	out.WriteString("<blockquote>\n")
+	out.Write(text)
+	out.WriteString("</blockquote>\n")
+}
+
+func (options *DocBook) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
+	out.WriteString("<blockquote>\n<attribution>")
+	out.Write(citation)
+	out.WriteString("</attribution>\n")
+	out.Write(text)
+	out.WriteString("</blockquote>\n")
+}
+
+// Admonitions of the kinds DocBook knows become elements of their own,
+// and any others notes.
+func (options *DocBook) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
+	element := "note"
+	switch kind {
+	case "tip", "important", "warning", "caution":
+		element = kind
+	}
+	out.WriteString("<" + element + ">\n")
+	if len(title) > 0 {
+		out.WriteString("<title>")
+		attrEscape(out, title)
+		out.WriteString("</title>\n")
+	}
+	out.Write(text)
+	out.WriteString("</" + element + ">\n")
+}
+
+func (options *DocBook) Container(out *bytes.Buffer, info string, text []byte) {
+	out.Write(text)
+}
+
+func (options *DocBook) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	out.WriteString("<sidebar>\n")
+	if len(summary) > 0 {
+		out.WriteString("<title>")
+		out.Write(summary)
+		out.WriteString("</title>\n")
+	}
+	out.Write(text)
+	out.WriteString("</sidebar>\n")
+}
+
+func (options *DocBook) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	out.WriteString("<blockquote role=\"spoiler\">\n")
+	out.Write(text)
+	out.WriteString("</blockquote>\n")
+}
+
+func (options *DocBook) BlockShortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteString("\n")
+}
+
+func (options *DocBook) BlockHtml(out *bytes.Buffer, text []byte) {
+}
+
+// Header closes the sections of headers at the same or a deeper level and
+// opens a new one.
+func (options *DocBook) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	marker := out.Len()
+	sections := options.sections
+	options.closeSections(out, level)
+
+	out.WriteString("<section")
+	if id != "" {
+		out.WriteString(" xml:id=\"")
+		attrEscape(out, []byte(id))
+		out.WriteString("\"")
+	}
+	out.WriteString(">\n<title>")
+	if !text() {
+		out.Truncate(marker)
+		options.sections = sections
+		return
+	}
+	out.WriteString("</title>\n")
+	options.sections = append(options.sections, level)
+}
+
+func (options *DocBook) closeSections(out *bytes.Buffer, level int) {
+	for len(options.sections) > 0 && options.sections[len(options.sections)-1] >= level {
+		out.WriteString("</section>\n")
+		options.sections = options.sections[:len(options.sections)-1]
+	}
+}
+
+func (options *DocBook) HRule(out *bytes.Buffer) {
+}
+
+func (options *DocBook) List(out *bytes.Buffer, text func() bool, flags int, start int) {
+	marker := out.Len()
+	tag := "itemizedlist"
+	switch {
+	case flags&LIST_TYPE_DEFINITION != 0:
+		tag = "variablelist"
+	case flags&LIST_TYPE_ORDERED != 0:
+		tag = "orderedlist"
+	}
+	out.WriteString("<" + tag)
+	if tag == "orderedlist" {
+		switch {
+		case flags&LIST_STYLE_LOWER_ALPHA != 0:
+			out.WriteString(" numeration=\"loweralpha\"")
+		case flags&LIST_STYLE_UPPER_ALPHA != 0:
+			out.WriteString(" numeration=\"upperalpha\"")
+		case flags&LIST_STYLE_LOWER_ROMAN != 0:
+			out.WriteString(" numeration=\"lowerroman\"")
+		case flags&LIST_STYLE_UPPER_ROMAN != 0:
+			out.WriteString(" numeration=\"upperroman\"")
+		}
+		if start != 1 {
+			out.WriteString(" startingnumber=\"" + strconv.Itoa(start) + "\"")
+		}
+	}
+	out.WriteString(">\n")
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("</" + tag + ">\n")
+}
+
+func (options *DocBook) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	if flags&LIST_TYPE_TERM != 0 {
+		out.WriteString("<varlistentry><term>")
+		out.Write(text)
+		out.WriteString("</term>\n")
+		return
+	}
+
+	out.WriteString("<listitem>")
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 {
+		out.WriteString("\n")
+		out.Write(text)
+		out.WriteString("\n")
+	} else {
+		out.WriteString("<para>")
+		switch {
+		case flags&LIST_ITEM_CHECKED != 0:
+			out.WriteString("[x] ")
+		case flags&LIST_ITEM_TASK != 0:
+			out.WriteString("[ ] ")
+		}
+		out.Write(text)
+		out.WriteString("</para>")
+	}
+	out.WriteString("</listitem>\n")
+	if flags&LIST_TYPE_DEFINITION != 0 {
+		out.WriteString("</varlistentry>\n")
+	}
+}
+
+func (options *DocBook) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	out.WriteString("<para>")
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("</para>\n")
+}
+
+// Tables are written as CALS tables, with the column alignment given in
+// the colspec elements.
+func (options *DocBook) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	// tables with a title are formal tables
+	if len(caption) > 0 {
+		out.WriteString("<table frame=\"all\">\n<title>")
+		out.Write(caption)
+		out.WriteString("</title>\n")
+	} else {
+		out.WriteString("<informaltable frame=\"all\">\n")
+	}
+	out.WriteString("<tgroup cols=\"")
+	out.WriteString(strconv.Itoa(len(columnData)))
+	out.WriteString("\">\n")
+	for i, align := range columnData {
+		out.WriteString("<colspec colname=\"c")
+		out.WriteString(strconv.Itoa(i + 1))
+		out.WriteString("\"")
+		switch align {
+		case TABLE_ALIGNMENT_LEFT:
+			out.WriteString(" align=\"left\"")
+		case TABLE_ALIGNMENT_RIGHT:
+			out.WriteString(" align=\"right\"")
+		case TABLE_ALIGNMENT_CENTER:
+			out.WriteString(" align=\"center\"")
+		}
+		out.WriteString("/>\n")
+	}
+	if len(header) > 0 {
+		out.WriteString("<thead>\n")
+		out.Write(header)
+		out.WriteString("</thead>\n")
+	}
+	out.WriteString("<tbody>\n")
+	out.Write(body)
+	out.WriteString("</tbody>\n</tgroup>\n")
+	if len(caption) > 0 {
+		out.WriteString("</table>\n")
+	} else {
+		out.WriteString("</informaltable>\n")
+	}
+}
+
+func (options *DocBook) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	out.WriteString("<row>\n")
+	out.Write(text)
+	out.WriteString("</row>\n")
+}
+
+func (options *DocBook) TableHeaderCell(out *bytes.Buffer, text []byte, align int, span int) {
+	options.TableCell(out, text, align, span)
+}
+
+func (options *DocBook) TableCell(out *bytes.Buffer, text []byte, align int, span int) {
+	// the cells of a row are written to a buffer of their own
+	if out.Len() == 0 {
+		options.column = 0
+	}
+	options.column += span
+
+	// spans are given by the names of the columns from colspec
+	if span > 1 {
+		out.WriteString("<entry namest=\"c")
+		out.WriteString(strconv.Itoa(options.column - span + 1))
+		out.WriteString("\" nameend=\"c")
+		out.WriteString(strconv.Itoa(options.column))
+		out.WriteString("\">")
+	} else {
+		out.WriteString("<entry>")
+	}
+	out.Write(text)
+	out.WriteString("</entry>\n")
+}
+
+// DocBook footnotes are written where they are referenced, but the parser
+// only hands over their texts at the end of the document. Until then,
+// FootnoteRef writes a placeholder that DocumentFooter replaces.
+func (options *DocBook) Footnotes(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	text()
+	out.Truncate(marker)
+}
+
+func (options *DocBook) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	var note bytes.Buffer
+	note.WriteString("<footnote>")
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 {
+		note.Write(text)
+	} else {
+		note.WriteString("<para>")
+		note.Write(bytes.TrimRight(text, "\n"))
+		note.WriteString("</para>")
+	}
+	note.WriteString("</footnote>")
+	options.notes[string(name)] = note.Bytes()
+}
+
+func docbookFootnotePlaceholder(name []byte) []byte {
+	return []byte("\x00footnote:" + string(name) + "\x00")
+}
+
+func (options *DocBook) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.WriteString("<link xlink:href=\"")
+	switch kind {
+	case LINK_TYPE_EMAIL:
+		out.WriteString("mailto:")
+	case LINK_TYPE_WWW:
+		out.WriteString("http://")
+	}
+	attrEscape(out, link)
+	out.WriteString("\">")
+	attrEscape(out, link)
+	out.WriteString("</link>")
+}
+
+func (options *DocBook) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.WriteString("<literal>")
+	attrEscape(out, text)
+	out.WriteString("</literal>")
+}
+
+func (options *DocBook) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("<emphasis role=\"strong\">")
+	out.Write(text)
+	out.WriteString("</emphasis>")
+}
+
+func (options *DocBook) Emphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("<emphasis>")
+	out.Write(text)
+	out.WriteString("</emphasis>")
+}
+
+func (options *DocBook) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
+	out.WriteString("<inlinemediaobject><imageobject><imagedata fileref=\"")
+	attrEscape(out, link)
+	out.WriteString("\"/></imageobject>")
+	if len(alt) > 0 {
+		out.WriteString("<textobject><phrase>")
+		attrEscape(out, alt)
+		out.WriteString("</phrase></textobject>")
+	}
+	out.WriteString("</inlinemediaobject>")
+}
+
+func (options *DocBook) LineBreak(out *bytes.Buffer) {
+	out.WriteString("<?linebreak?>\n")
+}
+
+func (options *DocBook) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
+	out.WriteString("<link xlink:href=\"")
+	attrEscape(out, link)
+	if len(title) > 0 {
+		out.WriteString("\" xlink:title=\"")
+		attrEscape(out, title)
+	}
+	out.WriteString("\">")
+	out.Write(content)
+	out.WriteString("</link>")
+}
+
+func (options *DocBook) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+}
+
+func (options *DocBook) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("<emphasis role=\"strong\"><emphasis>")
+	out.Write(text)
+	out.WriteString("</emphasis></emphasis>")
+}
+
+func (options *DocBook) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString("<emphasis role=\"strikethrough\">")
+	out.Write(text)
+	out.WriteString("</emphasis>")
+}
+
+func (options *DocBook) Highlight(out *bytes.Buffer, text []byte) {
+	out.WriteString("<emphasis role=\"highlight\">")
+	out.Write(text)
+	out.WriteString("</emphasis>")
+}
+
+func (options *DocBook) Insert(out *bytes.Buffer, text []byte) {
+	out.WriteString("<emphasis role=\"underline\">")
+	out.Write(text)
+	out.WriteString("</emphasis>")
+}
+
+func (options *DocBook) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	if kind == CRITIC_COMMENT {
+		out.WriteString("<remark>")
+		out.Write(text)
+		out.WriteString("</remark>")
+		return
+	}
+	criticChanges(options, out, kind, text, replacement)
+}
+
+func (options *DocBook) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.Write(docbookFootnotePlaceholder(ref))
+}
+
+func (options *DocBook) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	out.WriteString("<abbrev>")
+	attrEscape(out, abbr)
+	out.WriteString("</abbrev>")
+}
+
+func (options *DocBook) Mention(out *bytes.Buffer, kind int, token []byte) {
+	if kind == MENTION_TYPE_ISSUE {
+		out.WriteByte('#')
+	} else {
+		out.WriteByte('@')
+	}
+	attrEscape(out, token)
+}
+
+func (options *DocBook) Citation(out *bytes.Buffer, items []CitationItem) {
+	out.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			out.WriteString("; ")
+		}
+		out.WriteString("<citation>")
+		attrEscape(out, []byte(item.Key))
+		out.WriteString("</citation>")
+		if item.Locator != "" {
+			out.WriteString(", ")
+			attrEscape(out, []byte(item.Locator))
+		}
+	}
+	out.WriteByte(']')
+}
+
+func (options *DocBook) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	var object, data string
+	switch kind {
+	case MEDIA_VIDEO:
+		object, data = "videoobject", "videodata"
+	case MEDIA_AUDIO:
+		object, data = "audioobject", "audiodata"
+	default:
+		if len(alt) == 0 {
+			alt = link
+		}
+		var text bytes.Buffer
+		attrEscape(&text, alt)
+		options.Link(out, link, title, text.Bytes(), nil)
+		return
+	}
+	out.WriteString("<inlinemediaobject><" + object + "><" + data + " fileref=\"")
+	attrEscape(out, link)
+	out.WriteString("\"/></" + object + ">")
+	if len(alt) > 0 {
+		out.WriteString("<textobject><phrase>")
+		attrEscape(out, alt)
+		out.WriteString("</phrase></textobject>")
+	}
+	out.WriteString("</inlinemediaobject>")
+}
+
+func (options *DocBook) Kbd(out *bytes.Buffer, key []byte) {
+	out.WriteString("<keycap>")
+	attrEscape(out, key)
+	out.WriteString("</keycap>")
+}
+
+// DocBook has no ruby, so the readings follow their text in parentheses.
+func (options *DocBook) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	out.Write(text)
+	out.WriteString("(")
+	out.Write(annotation)
+	out.WriteString(")")
+}
+
+func (options *DocBook) Spoiler(out *bytes.Buffer, text []byte) {
+	out.WriteString("<phrase role=\"spoiler\">")
+	out.Write(text)
+	out.WriteString("</phrase>")
+}
+
+func (options *DocBook) Shortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+// XML only knows a handful of named entities, so HTML entities are
+// written as the characters they stand for.
+func (options *DocBook) Entity(out *bytes.Buffer, entity []byte) {
+	attrEscape(out, []byte(html.UnescapeString(string(entity))))
+}
+
+func (options *DocBook) NormalText(out *bytes.Buffer, text []byte) {
+	attrEscape(out, text)
+}
+
+// header and footer
+func (options *DocBook) DocumentHeader(out *bytes.Buffer) {
+	options.sections = nil
+	options.notes = make(map[string][]byte)
+	if options.flags&DOCBOOK_COMPLETE_DOCUMENT == 0 {
+		return
+	}
+	out.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	out.WriteString("<article xmlns=\"http://docbook.org/ns/docbook\"")
+	out.WriteString(" xmlns:xlink=\"http://www.w3.org/1999/xlink\" version=\"5.0\">\n")
+}
+
+func (options *DocBook) DocumentFooter(out *bytes.Buffer) {
+	options.closeSections(out, 0)
+
+	if len(options.notes) > 0 {
+		doc := out.Bytes()
+		for name, note := range options.notes {
+			doc = bytes.Replace(doc, docbookFootnotePlaceholder([]byte(name)), note, -1)
+		}
+		out.Reset()
+		out.Write(doc)
+	}
+
+	if options.flags&DOCBOOK_COMPLETE_DOCUMENT != 0 {
+		out.WriteString("</article>\n")
+	}
+}
@@ -0,0 +1,139 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for DocBook rendering
+//
+
+package blackfriday
+
+import (
+	"testing"
+)
+
+func runMarkdownDocBook(input string, extensions Extensions) string {
+	return string(Markdown([]byte(input), DocBookRenderer(0), extensions))
+}
+
+func doTestsDocBook(t *testing.T, tests []string, extensions Extensions) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runMarkdownDocBook(input, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestDocBookSections(t *testing.T) {
+	var tests = []string{
+		"# One\n\ntext\n\n## Two\n\n## Three\n\n# Four\n",
+		"<section>\n<title>One</title>\n<para>text</para>\n" +
+			"<section>\n<title>Two</title>\n</section>\n" +
+			"<section>\n<title>Three</title>\n</section>\n</section>\n" +
+			"<section>\n<title>Four</title>\n</section>\n",
+
+		"## Deep {#deep}\n\n# Shallow\n",
+		"<section xml:id=\"deep\">\n<title>Deep</title>\n</section>\n" +
+			"<section>\n<title>Shallow</title>\n</section>\n",
+	}
+	doTestsDocBook(t, tests, EXTENSION_HEADER_IDS)
+}
+
+func TestDocBookBlocks(t *testing.T) {
+	var tests = []string{
+		"```go\nif a < b {}\n```\n",
+		"<programlisting language=\"go\">if a &lt; b {}\n</programlisting>\n",
+
+		"    plain\n",
+		"<programlisting>plain\n</programlisting>\n",
+
+		"> quote\n",
+		"<blockquote>\n<para>quote</para>\n</blockquote>\n",
+
+		"> quote\n> -- Author\n",
+		"<blockquote>\n<attribution>Author</attribution>\n<para>quote</para>\n</blockquote>\n",
+
+		"| one\n|   two\n",
+		"<literallayout>one\n\u00a0\u00a0two</literallayout>\n",
+
+		"- a\n- b\n",
+		"<itemizedlist>\n<listitem><para>a</para></listitem>\n<listitem><para>b</para></listitem>\n</itemizedlist>\n",
+
+		"1. a\n\n2. b\n",
+		"<orderedlist>\n<listitem>\n<para>a</para>\n</listitem>\n<listitem>\n<para>b</para>\n</listitem>\n</orderedlist>\n",
+
+		"c. a\n",
+		"<orderedlist numeration=\"loweralpha\" startingnumber=\"3\">\n<listitem><para>a</para></listitem>\n</orderedlist>\n",
+
+		"Term\n: Definition\n",
+		"<variablelist>\n<varlistentry><term>Term</term>\n<listitem><para>Definition</para></listitem>\n</varlistentry>\n</variablelist>\n",
+
+		"| a | b |\n|:--|--:|\n| 1 | 2 |\n",
+		"<informaltable frame=\"all\">\n<tgroup cols=\"2\">\n" +
+			"<colspec colname=\"c1\" align=\"left\"/>\n<colspec colname=\"c2\" align=\"right\"/>\n" +
+			"<thead>\n<row>\n<entry>a</entry>\n<entry>b</entry>\n</row>\n</thead>\n" +
+			"<tbody>\n<row>\n<entry>1</entry>\n<entry>2</entry>\n</row>\n</tbody>\n" +
+			"</tgroup>\n</informaltable>\n",
+
+		"<div>dropped</div>\n\n---\n",
+		"",
+
+		"> [!WARNING] Careful\n> Sharp.\n\ntext\n\n> [!TODO]\n> Later.\n",
+		"<warning>\n<title>Careful</title>\n<para>Sharp.</para>\n</warning>\n<para>text</para>\n<note>\n<para>Later.</para>\n</note>\n",
+	}
+	doTestsDocBook(t, tests, EXTENSION_FENCED_CODE|EXTENSION_TABLES|EXTENSION_DEFINITION_LISTS|EXTENSION_ADMONITIONS|EXTENSION_FANCY_LISTS|EXTENSION_QUOTE_CITATIONS|EXTENSION_LINE_BLOCKS)
+
+	tests = []string{
+		"| a | b | c |\n|---|---|---|\n| 1 || 2 |\n\nTable: Totals\n",
+		"<table frame=\"all\">\n<title>Totals</title>\n<tgroup cols=\"3\">\n" +
+			"<colspec colname=\"c1\"/>\n<colspec colname=\"c2\"/>\n<colspec colname=\"c3\"/>\n" +
+			"<thead>\n<row>\n<entry>a</entry>\n<entry>b</entry>\n<entry>c</entry>\n</row>\n</thead>\n" +
+			"<tbody>\n<row>\n<entry namest=\"c1\" nameend=\"c2\">1</entry>\n<entry>2</entry>\n</row>\n</tbody>\n" +
+			"</tgroup>\n</table>\n",
+	}
+	doTestsDocBook(t, tests, EXTENSION_TABLES|EXTENSION_TABLE_EXTRAS)
+}
+
+func TestDocBookInline(t *testing.T) {
+	var tests = []string{
+		"*a* **b** ***c*** ~~d~~ `e<f`\n",
+		"<para><emphasis>a</emphasis> <emphasis role=\"strong\">b</emphasis> " +
+			"<emphasis role=\"strong\"><emphasis>c</emphasis></emphasis> " +
+			"<emphasis role=\"strikethrough\">d</emphasis> <literal>e&lt;f</literal></para>\n",
+
+		"[link](/url \"title\") and http://example.com/\n",
+		"<para><link xlink:href=\"/url\" xlink:title=\"title\">link</link> and " +
+			"<link xlink:href=\"http://example.com/\">http://example.com/</link></para>\n",
+
+		"![alt](/img.png)\n",
+		"<para><inlinemediaobject><imageobject><imagedata fileref=\"/img.png\"/></imageobject>" +
+			"<textobject><phrase>alt</phrase></textobject></inlinemediaobject></para>\n",
+
+		"AT&amp;T &copy; <b>x</b>\n",
+		"<para>AT&amp;T © x</para>\n",
+
+		"Text[^1] and more[^2].\n\n[^1]: First.\n[^2]: Second.\n",
+		"<para>Text<footnote><para>First.</para></footnote> and more" +
+			"<footnote><para>Second.</para></footnote>.</para>\n",
+	}
+	doTestsDocBook(t, tests, EXTENSION_AUTOLINK|EXTENSION_STRIKETHROUGH|EXTENSION_FOOTNOTES)
+}
+
+func TestDocBookCompleteDocument(t *testing.T) {
+	out := string(Markdown([]byte("# Title\n"), DocBookRenderer(DOCBOOK_COMPLETE_DOCUMENT), 0))
+	expected := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		"<article xmlns=\"http://docbook.org/ns/docbook\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" version=\"5.0\">\n" +
+		"<section>\n<title>Title</title>\n</section>\n</article>\n"
+	if out != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, out)
+	}
+}
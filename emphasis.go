@@ -0,0 +1,302 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Emphasis by the rules of CommonMark
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// emphasisIndex records which runs of '*' and '_' in a span of inline text
+// open and close emphasis, as CommonMark's delimiter algorithm pairs them
+// up. That takes the whole span into account, so the span is matched in
+// one pass the first time emphasis parses a delimiter of it, and the text
+// between a pair, which inline parses on its own, reuses the result.
+type emphasisIndex struct {
+	data    []byte
+	runs    []emphasisRun         // the runs of delimiters, in order
+	matches map[int]emphasisMatch // the pairs, by where they open
+}
+
+// emphasisMatch is a pair of delimiters: 1 or 2 of them open emphasis or
+// strong emphasis, and as many at close end it.
+type emphasisMatch struct {
+	close, width int
+}
+
+// emphasisRun is a run of '*' or '_', which can open emphasis if it is left
+// flanking and close it if it is right flanking.
+type emphasisRun struct {
+	pos, length       int
+	start, end        int // the delimiters of the run that aren't matched yet
+	canOpen, canClose bool
+	prev, next        int // the runs around it that are still unmatched
+	c                 byte
+}
+
+// emphasesOf returns the emphasis index of the span data belongs to, and
+// where i is in it. Text that was left out of the span's index, such as
+// that of a link, gets an index of its own.
+func (p *parser) emphasesOf(data []byte, i int) (*emphasisIndex, int) {
+	if ix := p.emphases; ix != nil {
+		if off, ok := sliceOffset(ix.data, data); ok && (ix.covers(off+i) || off == 0 && len(data) == len(ix.data)) {
+			return ix, off + i
+		}
+	}
+	p.emphases = p.indexEmphasis(data)
+	return p.emphases, i
+}
+
+// covers reports whether the delimiter at i is part of a run of the index
+func (ix *emphasisIndex) covers(i int) bool {
+	k := sort.Search(len(ix.runs), func(k int) bool { return ix.runs[k].pos+ix.runs[k].length > i })
+	return k < len(ix.runs) && ix.runs[k].pos <= i
+}
+
+// indexEmphasis finds the runs of delimiters of data, leaving out those in
+// code spans, tags, autolinks and links, which take precedence, and pairs
+// them up.
+func (p *parser) indexEmphasis(data []byte) *emphasisIndex {
+	ix := &emphasisIndex{data: data, matches: make(map[int]emphasisMatch)}
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == '\\' && i+1 < len(data) && ispunct(data[i+1]):
+			i += 2
+		case c == '`':
+			n := p.skipBytes(data, i, "`") - i
+			if end := p.closingBackticks(data[i:], n); end > 0 {
+				i += end
+			} else {
+				i += n
+			}
+		case c == '<':
+			autolink := 0
+			if n := tagLength(p, data[i:], &autolink); n > 0 {
+				i += n
+			} else {
+				i++
+			}
+		case c == '[':
+			if n := p.linkLength(data[i:]); n > 0 {
+				i += n
+			} else {
+				i++
+			}
+		case c == '*' || c == '_':
+			set := "*"
+			if c == '_' {
+				set = "_"
+			}
+			end := p.skipBytes(data, i, set)
+			ix.runs = append(ix.runs, newEmphasisRun(data, i, end, len(ix.runs)))
+			i = end
+		default:
+			i++
+		}
+	}
+	ix.match()
+	return ix
+}
+
+// newEmphasisRun returns the run of delimiters at data[pos:end], the kth
+// of its span
+func newEmphasisRun(data []byte, pos, end, k int) emphasisRun {
+	// the ends of the span count as whitespace
+	before, after := ' ', ' '
+	if pos > 0 {
+		before, _ = utf8.DecodeLastRune(data[:pos])
+	}
+	if end < len(data) {
+		after, _ = utf8.DecodeRune(data[end:])
+	}
+	left := !isSpaceRune(after) && (!isPunctRune(after) || isSpaceRune(before) || isPunctRune(before))
+	right := !isSpaceRune(before) && (!isPunctRune(before) || isSpaceRune(after) || isPunctRune(after))
+
+	run := emphasisRun{
+		pos: pos, length: end - pos,
+		start: pos, end: end,
+		canOpen: left, canClose: right,
+		prev: k - 1, next: k + 1,
+		c: data[pos],
+	}
+	// underscores don't open or close emphasis inside a word
+	if run.c == '_' {
+		run.canOpen = left && (!right || isPunctRune(before))
+		run.canClose = right && (!left || isPunctRune(after))
+	}
+	return run
+}
+
+// match pairs up the runs of the index, going through the closers in order
+// and matching each with the nearest opener before it. The runs between a
+// pair can't be matched any more. For each kind of closer, the openers
+// found not to match it aren't looked at again, which keeps it linear.
+func (ix *emphasisIndex) match() {
+	runs := ix.runs
+	if len(runs) > 0 {
+		runs[len(runs)-1].next = -1
+	}
+	// by delimiter, whether the closer can open, and its length mod 3
+	var bottoms [2][2][3]int
+	for a := range bottoms {
+		for b := range bottoms[a] {
+			for c := range bottoms[a][b] {
+				bottoms[a][b][c] = -1
+			}
+		}
+	}
+	remove := func(k int) {
+		if prev := runs[k].prev; prev >= 0 {
+			runs[prev].next = runs[k].next
+		}
+		if next := runs[k].next; next >= 0 {
+			runs[next].prev = runs[k].prev
+		}
+	}
+
+	for closer := 0; closer >= 0 && closer < len(runs); {
+		r := &runs[closer]
+		if !r.canClose {
+			closer = r.next
+			continue
+		}
+		bottom := &bottoms[boolIndex(r.c == '_')][boolIndex(r.canOpen)][r.length%3]
+		opener := r.prev
+		for ; opener > *bottom; opener = runs[opener].prev {
+			o := &runs[opener]
+			// when either run could go both ways, the two can't add up
+			// to a multiple of 3, unless both are
+			if o.c == r.c && o.canOpen &&
+				!((o.canClose || r.canOpen) && r.length%3 != 0 && (o.length+r.length)%3 == 0) {
+				break
+			}
+		}
+		if opener <= *bottom {
+			*bottom = r.prev
+			next := r.next
+			if !r.canOpen {
+				remove(closer)
+			}
+			closer = next
+			continue
+		}
+
+		o := &runs[opener]
+		width := 1
+		if o.end-o.start >= 2 && r.end-r.start >= 2 {
+			width = 2
+		}
+		o.end -= width
+		ix.matches[o.end] = emphasisMatch{close: r.start, width: width}
+		r.start += width
+
+		// the runs in between are left unmatched
+		o.next, r.prev = closer, opener
+		if o.start == o.end {
+			remove(opener)
+		}
+		if r.start == r.end {
+			next := r.next
+			remove(closer)
+			closer = next
+		}
+	}
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// isSpaceRune reports whether r is whitespace, as CommonMark has it
+func isSpaceRune(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\f' || r == '\r' || unicode.Is(unicode.Zs, r)
+}
+
+// isPunctRune reports whether r is punctuation, as CommonMark has it,
+// which takes in symbols
+func isPunctRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return ispunct(byte(r))
+	}
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// commonmarkEmphasis parses emphasis or strong emphasis opened by the '*'
+// or '_' at data[offset], as the emphasis index pairs them up.
+func commonmarkEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	ix, i := p.emphasesOf(data, offset)
+	m, ok := ix.matches[i]
+	if !ok {
+		return 0
+	}
+	end := offset + m.close - i
+
+	var work bytes.Buffer
+	p.inline(&work, data[offset+m.width:end])
+	if m.width == 2 {
+		p.r.DoubleEmphasis(out, work.Bytes())
+	} else {
+		p.r.Emphasis(out, work.Bytes())
+	}
+	return end + m.width - offset
+}
+
+// linkLength returns the length of the link, image or footnote whose text
+// starts with the '[' at data[0], or 0 if the bracket doesn't start one, as
+// far as can be told without parsing it.
+func (p *parser) linkLength(data []byte) int {
+	end := p.closingBracket(data, 1)
+	if end >= len(data) {
+		return 0
+	}
+	if data[1] == '^' && p.flags&EXTENSION_FOOTNOTES != 0 {
+		return end + 1
+	}
+	i := p.skipBytes(data, end+1, " \n")
+	switch {
+	case i < len(data) && data[i] == '(':
+		i = p.linkDestinationEnd(data, p.skipBytes(data, i+1, " \n"))
+		if i < len(data) && data[i] != ')' {
+			i = p.nextUnescaped(data, i+1, ")")
+		}
+		if i >= len(data) {
+			return 0
+		}
+		return i + 1
+	case i < len(data) && data[i] == '[':
+		idEnd := p.nextByte(data, i+1, ']')
+		if idEnd >= len(data) {
+			return 0
+		}
+		id := data[i+1 : idEnd]
+		if len(id) == 0 {
+			id = data[1:end]
+		}
+		if _, ok := p.getRef(id); ok {
+			return idEnd + 1
+		}
+		return 0
+	}
+	if _, ok := p.getRef(data[1:end]); ok {
+		return end + 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Checking the encoding of the input
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned for input that isn't valid UTF-8 with
+// INVALID_UTF8_REJECT.
+var ErrInvalidUTF8 = errors.New("blackfriday: input is not valid UTF-8")
+
+var byteOrderMark = []byte("\xef\xbb\xbf")
+
+// checkUTF8 returns ErrInvalidUTF8 if the input is to be rejected
+func (p *parser) checkUTF8(input []byte) error {
+	if p.invalidUTF8 == INVALID_UTF8_REJECT && !utf8.Valid(input) {
+		return ErrInvalidUTF8
+	}
+	return nil
+}
+
+// cleanUTF8 leaves out the byte order mark at the start of input and
+// replaces or decodes the bytes that are out of place, as
+// Options.InvalidUTF8 asks. Valid input is returned as it is, without the
+// byte order mark.
+func (p *parser) cleanUTF8(input []byte) []byte {
+	if p.invalidUTF8 == INVALID_UTF8_KEEP {
+		return input
+	}
+	input = bytes.TrimPrefix(input, byteOrderMark)
+	if p.invalidUTF8 == INVALID_UTF8_REJECT || utf8.Valid(input) {
+		return input
+	}
+	if p.invalidUTF8 == INVALID_UTF8_WINDOWS_1252 {
+		return decodeWindows1252(input)
+	}
+
+	out := make([]byte, 0, len(input)+len(input)/8)
+	for len(input) > 0 {
+		r, size := utf8.DecodeRune(input)
+		if r == utf8.RuneError && size == 1 {
+			out = append(out, "\uFFFD"...)
+		} else {
+			out = append(out, input[:size]...)
+		}
+		input = input[size:]
+	}
+	return out
+}
+
+// The characters of the bytes 0x80 to 0x9F in Windows-1252. The five bytes
+// it leaves undefined are taken as the control characters of Latin-1, as
+// web browsers do. Latin-1 and Windows-1252 agree on the other bytes,
+// which are the first 256 code points.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// decodeWindows1252 returns Windows-1252 text as UTF-8
+func decodeWindows1252(input []byte) []byte {
+	out := make([]byte, 0, len(input)+len(input)/4)
+	var buf [utf8.UTFMax]byte
+	for _, c := range input {
+		switch {
+		case c < utf8.RuneSelf:
+			out = append(out, c)
+		case c < 0xA0:
+			out = append(out, buf[:utf8.EncodeRune(buf[:], windows1252[c-0x80])]...)
+		default:
+			out = append(out, buf[:utf8.EncodeRune(buf[:], rune(c))]...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,172 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Parsing a document into a stream of events
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// These are the possible kinds of an Event.
+const (
+	EVENT_ENTER = iota // the start of a node, before its children
+	EVENT_EXIT         // the end of a node, after its children
+)
+
+// Event is a step through a document, into or out of one of its nodes.
+// Node.Pos and Node.End give where the node is in the input.
+type Event struct {
+	Kind int   // EVENT_ENTER or EVENT_EXIT
+	Node *Node // the node entered or left, with its children
+	Line int   // input line of the start of the node, or 0 if unknown
+}
+
+// EventHandler is called with each Event of a document. If it returns an
+// error, the parser stops there.
+type EventHandler func(event Event) error
+
+// ParseEvents parses a block of markdown-encoded text with the extensions
+// and other settings given in opts, and calls handler with the events of
+// its document tree, as Walk would visit it, without the NODE_DOCUMENT
+// node. Footnotes and the bibliography come last.
+//
+// The top-level blocks are parsed one at a time, as the handler gets to
+// them, and let go of afterwards, so that only one of them is held as a
+// tree at any time. The first pass over the input is not streamed, as
+// references can be defined anywhere in it: see NewIterator for what is
+// held throughout. A handler that returns an error saves the parsing of
+// the rest of the document; ParseEvents returns that error.
+func ParseEvents(input []byte, opts Options, handler EventHandler) error {
+	it := NewIterator(input, opts)
+	for {
+		event, ok := it.Next()
+		if !ok {
+			return it.Err()
+		}
+		if err := handler(event); err != nil {
+			return err
+		}
+	}
+}
+
+// Iterator pulls the events of a document one at a time; see NewIterator.
+type Iterator struct {
+	p      *parser
+	r      *astRecorder
+	data   []byte  // the blocks yet to be parsed
+	notes  bool    // whether the footnotes have been parsed
+	events []Event // the events of the last block parsed yet to be sent
+	err    error
+}
+
+// NewIterator returns an Iterator over the events that ParseEvents would
+// send for a block of markdown-encoded text, parsed with the extensions
+// and other settings given in opts.
+//
+// The blocks of the document are parsed as Next gets to them, so a caller
+// that only wants the start of the document, such as its first header and
+// paragraph for an excerpt, can stop early without paying for the rest.
+// Only the first pass over the input, and the indexing of headers and
+// examples with EXTENSION_CROSS_REFERENCES or EXTENSION_EXAMPLE_LISTS, go
+// through the whole document up front.
+//
+// The memory held throughout is in proportion to the input, not to a
+// block: the output of the first pass, which is the input itself if there
+// is nothing for the first pass to change, such as tabs, carriage returns
+// or references, and a copy of it otherwise; the references,
+// abbreviations and footnotes found in it; and a few words per line to
+// find the input line and position of each node.
+func NewIterator(input []byte, opts Options) *Iterator {
+	it := &Iterator{r: new(astRecorder)}
+	it.p = newParser(it.r, opts)
+	if it.err = it.p.checkUTF8(input); it.err != nil {
+		return it
+	}
+	it.step(func() {
+		it.data = firstPass(it.p, input)
+		it.p.doc = it.data
+		if it.p.flags&(EXTENSION_CROSS_REFERENCES|EXTENSION_EXAMPLE_LISTS) != 0 {
+			it.p.index(it.data)
+		}
+	})
+	return it
+}
+
+// Next returns the next event of the document. It returns false once there
+// are no more, or if the parser has failed; Err tells them apart.
+func (it *Iterator) Next() (Event, bool) {
+	for len(it.events) == 0 {
+		if it.err != nil || len(it.data) == 0 && it.notes {
+			return Event{}, false
+		}
+		it.step(it.parse)
+	}
+	event := it.events[0]
+	it.events = it.events[1:]
+	return event, true
+}
+
+// Err returns the error that stopped the parser, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// parse parses the next top-level block, or the footnotes and bibliography
+// after the last one, into events
+func (it *Iterator) parse() {
+	var out bytes.Buffer
+	if len(it.data) > 0 {
+		// as in block
+		it.p.nesting++
+		it.data = it.data[it.p.nextBlock(&out, it.data):]
+		it.p.nesting--
+	} else {
+		it.p.endNotes(&out)
+		it.notes = true
+	}
+
+	nodes := it.r.children(out.Bytes())
+	it.p.placeTree(nodes)
+	for _, n := range nodes {
+		it.events = it.p.appendEvents(it.events, n)
+	}
+	// the nodes are in the events now
+	for i := range it.r.nodes {
+		it.r.nodes[i] = nil
+	}
+	it.r.nodes = it.r.nodes[:0]
+	it.r.placed = it.r.placed[:0]
+}
+
+// step runs a step of the parser, turning a panic into an error
+func (it *Iterator) step(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			it.err = fmt.Errorf("blackfriday: %v", r)
+		}
+	}()
+	fn()
+}
+
+// appendEvents appends the events of a node and its descendants
+func (p *parser) appendEvents(events []Event, n *Node) []Event {
+	line := p.inputLine(n)
+	events = append(events, Event{Kind: EVENT_ENTER, Node: n, Line: line})
+	for _, child := range n.Children {
+		events = p.appendEvents(events, child)
+	}
+	return append(events, Event{Kind: EVENT_EXIT, Node: n, Line: line})
+}
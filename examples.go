@@ -0,0 +1,73 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Numbered examples with EXTENSION_EXAMPLE_LISTS
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// exampleMarker returns the label of an example list marker, (@) or
+// (@label), and the length of the marker, or 0 if data doesn't start with
+// one.
+func exampleMarker(data []byte) ([]byte, int) {
+	if len(data) < 3 || data[0] != '(' || data[1] != '@' {
+		return nil, 0
+	}
+	i := 2
+	for i < len(data) && (isalnum(data[i]) || data[i] == '_' || data[i] == '-') {
+		i++
+	}
+	if i == len(data) || data[i] != ')' {
+		return nil, 0
+	}
+	return data[2:i], i + 1
+}
+
+// numberExample gives the next number to the example whose list item
+// starts data. The examples are numbered throughout the document, whatever
+// list they are in; their labels are collected while the document is
+// indexed, so that they can be referred to before they come.
+func (p *parser) numberExample(data []byte) {
+	p.exampleCount++
+	label, _ := exampleMarker(bytes.TrimLeft(data, " "))
+	if len(label) == 0 {
+		return
+	}
+	n, ok := p.examples[string(label)]
+	switch {
+	case !ok && p.indexing:
+		p.examples[string(label)] = p.exampleCount
+	case ok && n != p.exampleCount && !p.indexing:
+		p.diagnose(DIAGNOSTIC_WARNING, data, "example %q is defined more than once", label)
+	}
+}
+
+// '(': a reference to a labelled example, (@label), which is replaced
+// with the number of the example in parentheses
+func exampleRef(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	label, end := exampleMarker(data[offset:])
+	if len(label) == 0 {
+		return 0
+	}
+	n, ok := p.examples[string(label)]
+	if !ok {
+		p.diagnose(DIAGNOSTIC_WARNING, data[offset:], "undefined example %q", label)
+		return 0
+	}
+	p.r.NormalText(out, []byte("("+strconv.Itoa(n)+")"))
+	return end
+}
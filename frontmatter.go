@@ -0,0 +1,98 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Front matter with EXTENSION_FRONT_MATTER
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// The fences of YAML and TOML front matter when Options.FrontMatterDelimiters
+// is not set.
+var defaultFrontMatterDelimiters = [2]string{"---", "+++"}
+
+// FrontMatter is the metadata block at the start of a document, as used by
+// static site generators such as Jekyll and Hugo.
+type FrontMatter struct {
+	Format int    // one of the FRONT_MATTER_* values
+	Data   []byte // the metadata, without the fences; JSON keeps its braces
+}
+
+// SplitFrontMatter returns the front matter at the start of input, in the
+// format of the delimiters given in opts, and the rest of the document. If
+// there is none, the format is FRONT_MATTER_NONE and input is returned as it
+// is. Decoding the metadata is left to the caller.
+func SplitFrontMatter(input []byte, opts Options) (FrontMatter, []byte) {
+	format, data, end := frontMatter(input, frontMatterDelimiters(opts))
+	return FrontMatter{Format: format, Data: data}, input[end:]
+}
+
+// frontMatterDelimiters returns the fences of opts, with the defaults for
+// those left empty
+func frontMatterDelimiters(opts Options) [2]string {
+	delims := defaultFrontMatterDelimiters
+	for i, delim := range opts.FrontMatterDelimiters {
+		if delim != "" {
+			delims[i] = delim
+		}
+	}
+	return delims
+}
+
+// frontMatter returns the format, the contents and the length of the front
+// matter at the start of data. The fences are lines of their own:
+//
+//	---             +++             {
+//	title: YAML     title = "TOML"    "title": "JSON"
+//	---             +++             }
+//
+// YAML front matter can also end with "...".
+func frontMatter(data []byte, delims [2]string) (int, []byte, int) {
+	first, beg := frontMatterLine(data, 0)
+	format, close := FRONT_MATTER_NONE, ""
+	switch string(first) {
+	case delims[0]:
+		format, close = FRONT_MATTER_YAML, delims[0]
+	case delims[1]:
+		format, close = FRONT_MATTER_TOML, delims[1]
+	case "{":
+		format, close = FRONT_MATTER_JSON, "}"
+	default:
+		return FRONT_MATTER_NONE, nil, 0
+	}
+
+	for i := beg; i < len(data); {
+		line, end := frontMatterLine(data, i)
+		if string(line) == close || format == FRONT_MATTER_YAML && string(line) == "..." {
+			if format == FRONT_MATTER_JSON {
+				return format, data[:i+1], end
+			}
+			return format, data[beg:i], end
+		}
+		i = end
+	}
+	return FRONT_MATTER_NONE, nil, 0
+}
+
+// frontMatterLine returns the line starting at beg, without trailing space,
+// and the start of the next one
+func frontMatterLine(data []byte, beg int) ([]byte, int) {
+	end := skipUntilChar(data, beg, '\n')
+	next := end
+	if next < len(data) {
+		next++
+	}
+	return bytes.TrimRight(data[beg:end], " \t\r"), next
+}
@@ -18,32 +18,64 @@ package blackfriday
 import (
 	"bytes"
 	"fmt"
+	"html"
+	"io"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
+// HtmlFlags is a set of HTML_* options. Like Extensions, it has 64 bits so
+// that there is room for all of them on 32-bit platforms.
+type HtmlFlags uint64
+
 // Html renderer configuration options.
 const (
-	HTML_SKIP_HTML                 = 1 << iota // skip preformatted HTML blocks
-	HTML_SKIP_STYLE                            // skip embedded <style> elements
-	HTML_SKIP_IMAGES                           // skip embedded images
-	HTML_SKIP_LINKS                            // skip all links
-	HTML_SAFELINK                              // only link to trusted protocols
-	HTML_NOFOLLOW_LINKS                        // only link with rel="nofollow"
-	HTML_NOREFERRER_LINKS                      // only link with rel="noreferrer"
-	HTML_HREF_TARGET_BLANK                     // add a blank target
-	HTML_TOC                                   // generate a table of contents
-	HTML_OMIT_CONTENTS                         // skip the main contents (for a standalone table of contents)
-	HTML_COMPLETE_PAGE                         // generate a complete HTML page
-	HTML_USE_XHTML                             // generate XHTML output instead of HTML
-	HTML_USE_SMARTYPANTS                       // enable smart punctuation substitutions
-	HTML_SMARTYPANTS_FRACTIONS                 // enable smart fractions (with HTML_USE_SMARTYPANTS)
-	HTML_SMARTYPANTS_DASHES                    // enable smart dashes (with HTML_USE_SMARTYPANTS)
-	HTML_SMARTYPANTS_LATEX_DASHES              // enable LaTeX-style dashes (with HTML_USE_SMARTYPANTS and HTML_SMARTYPANTS_DASHES)
-	HTML_SMARTYPANTS_ANGLED_QUOTES             // enable angled double quotes (with HTML_USE_SMARTYPANTS) for double quotes rendering
-	HTML_SMARTYPANTS_QUOTES_NBSP               // enable "French guillemets" (with HTML_USE_SMARTYPANTS)
-	HTML_FOOTNOTE_RETURN_LINKS                 // generate a link at the end of a footnote to return to the source
+	HTML_SKIP_HTML                 HtmlFlags = 1 << iota // skip preformatted HTML blocks
+	HTML_SKIP_STYLE                                      // skip embedded <style> elements
+	HTML_SKIP_IMAGES                                     // skip embedded images
+	HTML_SKIP_LINKS                                      // skip all links
+	HTML_SAFELINK                                        // only link to trusted protocols
+	HTML_NOFOLLOW_LINKS                                  // only link with rel="nofollow"
+	HTML_NOREFERRER_LINKS                                // only link with rel="noreferrer"
+	HTML_HREF_TARGET_BLANK                               // add a blank target
+	HTML_TOC                                             // generate a table of contents
+	HTML_OMIT_CONTENTS                                   // skip the main contents (for a standalone table of contents)
+	HTML_COMPLETE_PAGE                                   // generate a complete HTML page
+	HTML_USE_XHTML                                       // generate XHTML output instead of HTML
+	HTML_USE_SMARTYPANTS                                 // enable smart punctuation substitutions
+	HTML_SMARTYPANTS_FRACTIONS                           // enable smart fractions (with HTML_USE_SMARTYPANTS)
+	HTML_SMARTYPANTS_DASHES                              // enable smart dashes (with HTML_USE_SMARTYPANTS)
+	HTML_SMARTYPANTS_LATEX_DASHES                        // enable LaTeX-style dashes (with HTML_USE_SMARTYPANTS and HTML_SMARTYPANTS_DASHES)
+	HTML_SMARTYPANTS_ANGLED_QUOTES                       // enable angled double quotes (with HTML_USE_SMARTYPANTS) for double quotes rendering
+	HTML_SMARTYPANTS_QUOTES_NBSP                         // enable "French guillemets" (with HTML_USE_SMARTYPANTS)
+	HTML_FOOTNOTE_RETURN_LINKS                           // generate a link at the end of a footnote to return to the source
+	HTML_TAGFILTER                                       // escape raw HTML tags that GitHub filters, such as <script>
+	HTML_EPUB                                            // generate XHTML 1.1 for EPUB packaging (implies HTML_USE_XHTML)
+	HTML_NOOPENER_LINKS                                  // only link with rel="noopener"
+	HTML_SANITIZE                                        // only keep whitelisted raw HTML, and no javascript: URLs
+	HTML_ESCAPE_HTML                                     // escape raw HTML so that it shows up as text
+	HTML_SMARTYPANTS_LOW_QUOTES                          // enable German low quotes (with HTML_USE_SMARTYPANTS)
+	HTML_OMIT_FOOTNOTES                                  // leave out the footnotes section (to collect them with FootnoteCollector)
+	HTML_CRITIC_ACCEPT                                   // render CriticMarkup with all the changes accepted
+	HTML_CRITIC_REJECT                                   // render CriticMarkup with all the changes rejected
+	HTML_OBFUSCATE_EMAILS                                // write email autolinks as character references to deter scrapers
+	HTML_LAZY_IMAGES                                     // add loading="lazy" to images
+	HTML_ASYNC_IMAGES                                    // add decoding="async" to images
+	HTML_SKIP_COMMENTS                                   // skip HTML comments
+	HTML_KEEP_CONDITIONAL_COMMENTS                       // keep <!--[if mso]> conditional comments (with HTML_SKIP_COMMENTS)
+	HTML_NUMBER_HEADERS                                  // number headers as in 1, 1.1 and 1.1.1
+	HTML_SMARTYPANTS_FRENCH_SPACES                       // put narrow non-breaking spaces before : ; ? and ! (with HTML_USE_SMARTYPANTS)
+	HTML_SMARTYPANTS_ORDINALS                            // superscript the suffix of ordinals such as 1st (with HTML_USE_SMARTYPANTS)
+)
+
+// These are the possible values of HtmlRendererParameters.Entities, how
+// the character references of the input are written out.
+const (
+	ENTITY_PASS    = iota // write them as they are
+	ENTITY_UTF8           // write the characters they stand for
+	ENTITY_NUMERIC        // write named ones as numeric references, for feeds and XML
 )
 
 var (
@@ -55,6 +87,8 @@ var (
 
 	// TODO: improve this regexp to catch all possible entities:
 	htmlEntity = regexp.MustCompile(`&[a-z]{2,5};`)
+
+	namedEntity = regexp.MustCompile(`&[a-zA-Z][a-zA-Z0-9]*;`)
 )
 
 type HtmlRendererParameters struct {
@@ -71,18 +105,155 @@ type HtmlRendererParameters struct {
 	HeaderIDPrefix string
 	// If set, add this text to the back of each Header ID, to ensure uniqueness.
 	HeaderIDSuffix string
+	// If set, add this text to the front of each class name, other than
+	// those of code blocks, to avoid clashes with a page's own styles.
+	ClassPrefix string
+	// Format of the class given to a code block for each of its language
+	// names, with %s standing for the name. If blank, "language-%s" is used.
+	CodeClassFormat string
+	// Resolves the targets of @user mentions and #123 issue references
+	// found with EXTENSION_MENTIONS. If nil, or if it reports that a
+	// mention does not resolve, the mention is rendered as plain text.
+	MentionResolver MentionResolverFunc
+	// If set, called with the destination of every link, image and
+	// autolink, and the URL it returns is written in its place. The
+	// AbsolutePrefix and the HTML_* link options apply to the rewritten URL.
+	URLRewriter URLRewriterFunc
+	// If set, called with the destination of every image, after any
+	// URLRewriter, to look up its size or replace its URL or alt text.
+	ImageResolver ImageResolverFunc
+	// Hosts that the pages of providers found with EXTENSION_MEDIA_EMBED
+	// may be embedded from. Embeds of other hosts are written as links. If
+	// nil, DefaultEmbedHosts is used.
+	EmbedHosts []string
+	// If set, writes out fenced and indented code blocks in place of the
+	// escaped <pre><code> block.
+	CodeHighlighter CodeHighlighter
+	// If set, called with the code of blocks without a language, such as
+	// indented code blocks, to guess one. The language it returns is used
+	// as if the block had been given it.
+	LanguageGuesser LanguageGuesserFunc
+	// The levels of the headers numbered with HTML_NUMBER_HEADERS.
+	HeaderNumbering HeaderNumbering
+	// If set, called with each HTML comment outside of other HTML, such
+	// as <!-- TODO -->, to write it out in place of the comment. It takes
+	// precedence over HTML_SKIP_COMMENTS.
+	CommentHandler CommentFunc
+	// Writes out the code blocks of the given languages, such as "mermaid",
+	// in place of the <pre><code> block and before any CodeHighlighter.
+	CodeBlockRenderers map[string]CodeBlockFunc
+	// Tags that raw HTML may contain with HTML_SANITIZE, mapped to the
+	// attributes they may keep. If nil, DefaultAllowedTags is used.
+	AllowedTags map[string][]string
+	// If set, called with each footnote of the document, for example to
+	// gather the footnotes of several documents into one section at the
+	// end of a page. Use HTML_OMIT_FOOTNOTES to leave them out of the
+	// document itself, and FootnoteAnchorPrefix to keep their anchors
+	// apart.
+	FootnoteCollector FootnoteCollectorFunc
+	// How character references such as &copy; and &#169; are written out,
+	// one of the ENTITY_* constants.
+	Entities int
+	// The named entities that are recognized, without their & and ;,
+	// mapped to the text they stand for. Other names are escaped so that
+	// they show up as text. If nil, all the entities of HTML5 are
+	// recognized and unknown ones are written as they are.
+	EntityTable map[string]string
 }
 
+// FootnoteCollectorFunc is called with the number of a footnote, as shown
+// by its reference, and its <li> element as it is written in the footnotes
+// section. The item slice is not used by the renderer afterwards.
+type FootnoteCollectorFunc func(id int, item []byte)
+
+// MentionResolverFunc is called with the kind of a mention (one of the
+// MENTION_TYPE_* constants) and its token without the leading @ or #, and
+// returns the URL it should link to. If ok is false, no link is made.
+type MentionResolverFunc func(kind int, token string) (url string, ok bool)
+
+// CodeHighlighter writes out a code block with syntax highlighting.
+//
+// Highlight is called with the code and the first language name given for
+// it, which is empty if there was none, and writes the complete markup of
+// the block to w. If it returns an error, whatever it wrote is discarded
+// and the block is written as escaped text instead.
+type CodeHighlighter interface {
+	Highlight(w io.Writer, code []byte, lang string) error
+}
+
+// LanguageGuesserFunc is called with the code of a block without a
+// language and returns the language to use for it, or "" to leave it
+// without one.
+type LanguageGuesserFunc func(code []byte) string
+
+// CommentFunc writes out an HTML comment, including its <!-- and -->,
+// or anything else to take its place.
+type CommentFunc func(w io.Writer, comment []byte)
+
+// CodeBlockFunc writes out a code block of a language it was registered
+// for in HtmlRendererParameters.CodeBlockRenderers. It is called with the
+// code and the language name.
+type CodeBlockFunc func(w io.Writer, code []byte, lang string)
+
+// DiagramBlock returns a CodeBlockFunc that writes the code as the escaped
+// text of a <div> with the given class, as diagram libraries like Mermaid
+// expect:
+//
+//	<div class="mermaid">graph TD; A--&gt;B;</div>
+func DiagramBlock(class string) CodeBlockFunc {
+	return func(w io.Writer, code []byte, lang string) {
+		var out bytes.Buffer
+		out.WriteString("<div class=\"")
+		attrEscape(&out, []byte(class))
+		out.WriteString("\">")
+		attrEscape(&out, code)
+		out.WriteString("</div>\n")
+		w.Write(out.Bytes())
+	}
+}
+
+// These are the possible kind values passed to a URLRewriterFunc.
+const (
+	URL_TYPE_LINK     = iota // [text](url) and reference links
+	URL_TYPE_IMAGE           // ![alt](url)
+	URL_TYPE_AUTOLINK        // <url> and bare URLs or email addresses
+)
+
+// URLRewriterFunc is called with the kind of a destination (one of the
+// URL_TYPE_* constants) and the URL as written in the document, and
+// returns the URL to use instead. The url slice must not be modified.
+//
+// Email autolinks are passed with their mailto: scheme and www. autolinks
+// with their http:// scheme, as they would appear in the href attribute.
+type URLRewriterFunc func(kind int, url []byte) []byte
+
+// ImageInfo describes an image found by an ImageResolverFunc. Fields left
+// at their zero value don't change what the document says.
+type ImageInfo struct {
+	URL    string // replacement URL, for instance on a CDN or a data: URI
+	Width  int    // written out as the width attribute
+	Height int    // written out as the height attribute
+	Alt    string // replacement alt text
+	SrcSet string // written out as the srcset attribute, such as "a-2x.png 2x"
+	Sizes  string // written out as the sizes attribute
+}
+
+// ImageResolverFunc is called with the destination of an image and
+// returns what is known about it. If ok is false, the image is written as
+// it appears in the document.
+type ImageResolverFunc func(url []byte) (info ImageInfo, ok bool)
+
 // Html is a type that implements the Renderer interface for HTML output.
 //
 // Do not create this directly, instead use the HtmlRenderer function.
 type Html struct {
-	flags    int    // HTML_* options
-	closeTag string // how to end singleton tags: either " />" or ">"
-	title    string // document title
-	css      string // optional css file url (used with HTML_COMPLETE_PAGE)
+	flags    HtmlFlags // HTML_* options
+	closeTag string    // how to end singleton tags: either " />" or ">"
+	title    string    // document title
+	css      string    // optional css file url (used with HTML_COMPLETE_PAGE)
 
 	parameters HtmlRendererParameters
+	host       string // host of the AbsolutePrefix, if any
 
 	// table of contents data
 	tocMarker    int
@@ -93,6 +264,9 @@ type Html struct {
 	// Track header IDs to prevent ID collision in a single generation.
 	headerIDs map[string]int
 
+	// number of the last footnote written
+	footnoteCount int
+
 	smartypants *smartypantsRenderer
 }
 
@@ -108,12 +282,18 @@ const (
 // title is the title of the document, and css is a URL for the document's
 // stylesheet.
 // title and css are only used when HTML_COMPLETE_PAGE is selected.
-func HtmlRenderer(flags int, title string, css string) Renderer {
+func HtmlRenderer(flags HtmlFlags, title string, css string) Renderer {
 	return HtmlRendererWithParameters(flags, title, css, HtmlRendererParameters{})
 }
 
-func HtmlRendererWithParameters(flags int, title string,
+func HtmlRendererWithParameters(flags HtmlFlags, title string,
 	css string, renderParameters HtmlRendererParameters) Renderer {
+	// EPUB content documents are XHTML 1.1, which has no target attribute
+	if flags&HTML_EPUB != 0 {
+		flags |= HTML_USE_XHTML
+		flags &^= HTML_HREF_TARGET_BLANK
+	}
+
 	// configure the rendering engine
 	closeTag := htmlClose
 	if flags&HTML_USE_XHTML != 0 {
@@ -123,6 +303,23 @@ func HtmlRendererWithParameters(flags int, title string,
 	if renderParameters.FootnoteReturnLinkContents == "" {
 		renderParameters.FootnoteReturnLinkContents = `<sup>[return]</sup>`
 	}
+	if renderParameters.CodeClassFormat == "" {
+		renderParameters.CodeClassFormat = "language-%s"
+	}
+	if renderParameters.AllowedTags == nil {
+		renderParameters.AllowedTags = DefaultAllowedTags
+	}
+	if renderParameters.EmbedHosts == nil {
+		renderParameters.EmbedHosts = DefaultEmbedHosts
+	}
+
+	var host string
+	if u, err := url.Parse(renderParameters.AbsolutePrefix); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+
+	smarty := smartypants(smartypantsFlags(flags))
+	smarty.entities = renderParameters.Entities
 
 	return &Html{
 		flags:      flags,
@@ -130,6 +327,7 @@ func HtmlRendererWithParameters(flags int, title string,
 		title:      title,
 		css:        css,
 		parameters: renderParameters,
+		host:       host,
 
 		headerCount:  0,
 		currentLevel: 0,
@@ -137,10 +335,22 @@ func HtmlRendererWithParameters(flags int, title string,
 
 		headerIDs: make(map[string]int),
 
-		smartypants: smartypants(flags),
+		smartypants: smarty,
 	}
 }
 
+// newDocument returns a copy of the renderer without the table of contents,
+// header IDs, header numbers and footnotes of the documents it rendered.
+func (options *Html) newDocument() Renderer {
+	r := *options
+	r.tocMarker, r.headerCount, r.currentLevel = 0, 0, 0
+	r.toc = new(bytes.Buffer)
+	r.headerIDs = make(map[string]int)
+	r.parameters.HeaderNumbering.counts = [7]int{}
+	r.footnoteCount = 0
+	return &r
+}
+
 // Using if statements is a bit faster than a switch statement. As the compiler
 // improves, this should be unnecessary this is only worthwhile because
 // attrEscape is the single largest CPU user in normal use.
@@ -188,19 +398,49 @@ func entityEscapeWithSkip(out *bytes.Buffer, src []byte, skipRanges [][]int) {
 	attrEscape(out, src[end:])
 }
 
+// GetFlags returns the HTML_* options of the renderer that fit in an int.
+// On 32-bit platforms, the later ones are only returned by Flags.
 func (options *Html) GetFlags() int {
+	return int(options.flags)
+}
+
+// Flags returns the HTML_* options of the renderer.
+func (options *Html) Flags() HtmlFlags {
 	return options.flags
 }
 
 func (options *Html) TitleBlock(out *bytes.Buffer, text []byte) {
 	text = bytes.TrimPrefix(text, []byte("% "))
 	text = bytes.Replace(text, []byte("\n% "), []byte("\n"), -1)
-	out.WriteString("<h1 class=\"title\">")
+	out.WriteString("<h1 class=\"")
+	options.writeClass(out, "title")
+	out.WriteString("\">")
 	out.Write(text)
 	out.WriteString("\n</h1>")
 }
 
-func (options *Html) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+// The bibliography is left to a renderer embedding Html, which can look
+// the keys up in a database of its own.
+// Line blocks are written as a <div> with the lines split by line breaks,
+// as in Pandoc.
+func (options *Html) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	doubleSpace(out)
+	out.WriteString("<div class=\"")
+	options.writeClass(out, "line-block")
+	out.WriteString("\">")
+	for i, line := range lines {
+		if i > 0 {
+			options.LineBreak(out)
+		}
+		out.Write(line)
+	}
+	out.WriteString("</div>\n")
+}
+
+func (options *Html) Bibliography(out *bytes.Buffer, keys []string) {
+}
+
+func (options *Html) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
 	marker := out.Len()
 	doubleSpace(out)
 
@@ -219,12 +459,23 @@ func (options *Html) Header(out *bytes.Buffer, text func() bool, level int, id s
 			id = id + options.parameters.HeaderIDSuffix
 		}
 
-		out.WriteString(fmt.Sprintf("<h%d id=\"%s\">", level, id))
+		out.WriteString(fmt.Sprintf("<h%d id=\"%s\"", level, id))
 	} else {
-		out.WriteString(fmt.Sprintf("<h%d>", level))
+		out.WriteString(fmt.Sprintf("<h%d", level))
 	}
+	options.writeAttributes(out, attrs)
+	out.WriteByte('>')
 
 	tocMarker := out.Len()
+	if options.flags&HTML_NUMBER_HEADERS != 0 && !attrs.hasClass("unnumbered") {
+		if number := options.parameters.HeaderNumbering.Next(level); number != "" {
+			out.WriteString("<span class=\"")
+			options.writeClass(out, "header-number")
+			out.WriteString("\">")
+			out.WriteString(number)
+			out.WriteString("</span> ")
+		}
+	}
 	if !text() {
 		out.Truncate(marker)
 		return
@@ -243,8 +494,22 @@ func (options *Html) BlockHtml(out *bytes.Buffer, text []byte) {
 		return
 	}
 
+	if isHtmlComment(text) {
+		// leave no blank lines behind for dropped comments
+		marker := out.Len()
+		doubleSpace(out)
+		body := out.Len()
+		options.writeComment(out, text)
+		if out.Len() == body {
+			out.Truncate(marker)
+			return
+		}
+		out.WriteByte('\n')
+		return
+	}
+
 	doubleSpace(out)
-	out.Write(text)
+	options.writeRawHtml(out, text)
 	out.WriteByte('\n')
 }
 
@@ -255,35 +520,119 @@ func (options *Html) HRule(out *bytes.Buffer) {
 	out.WriteByte('\n')
 }
 
-func (options *Html) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+func (options *Html) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
 	doubleSpace(out)
 
-	// parse out the language names/classes
-	count := 0
+	// a title is written above the block, whatever writes the block itself
+	if attrs.has("title") {
+		out.WriteString("<div class=\"")
+		options.writeClass(out, "code-title")
+		out.WriteString("\">")
+		attrEscape(out, []byte(attrs.Get("title")))
+		out.WriteString("</div>\n")
+		attrs = attrs.without("title")
+	}
+
+	if strings.TrimSpace(lang) == "" && options.parameters.LanguageGuesser != nil {
+		lang = options.parameters.LanguageGuesser(text)
+	}
+
+	name := ""
 	for _, elt := range strings.Fields(lang) {
-		if elt[0] == '.' {
-			elt = elt[1:]
+		if elt = strings.TrimPrefix(elt, "."); elt != "" {
+			name = elt
+			break
 		}
-		if len(elt) == 0 {
-			continue
+	}
+	if render, ok := options.parameters.CodeBlockRenderers[name]; ok && name != "" {
+		render(out, text, name)
+		return
+	}
+
+	if options.parameters.CodeHighlighter != nil {
+		marker := out.Len()
+		if options.parameters.CodeHighlighter.Highlight(out, text, name) == nil {
+			return
 		}
-		if count == 0 {
-			out.WriteString("<pre><code class=\"language-")
-		} else {
-			out.WriteByte(' ')
+		out.Truncate(marker)
+	}
+
+	// parse out the language names/classes
+	var classes []string
+	for _, elt := range strings.Fields(lang) {
+		if elt = strings.TrimPrefix(elt, "."); elt != "" {
+			classes = append(classes, fmt.Sprintf(options.parameters.CodeClassFormat, elt))
 		}
-		attrEscape(out, []byte(elt))
-		count++
 	}
 
-	if count == 0 {
-		out.WriteString("<pre><code>")
+	linenos, hlLines := attrs.has("linenos"), attrs.Get("hl_lines")
+	attrs = attrs.without("linenos").without("hl_lines")
+
+	out.WriteString("<pre><code")
+	options.writeAttributes(out, attrs, classes...)
+	out.WriteByte('>')
+
+	if linenos || hlLines != "" {
+		options.writeCodeLines(out, text, linenos, hlLines)
 	} else {
+		attrEscape(out, text)
+	}
+	out.WriteString("</code></pre>\n")
+}
+
+// writeCodeLines writes each line of a code block as a span, with its
+// number in front of it if linenos is set, and with an extra class if it is
+// one of the lines in hlLines.
+func (options *Html) writeCodeLines(out *bytes.Buffer, text []byte, linenos bool, hlLines string) {
+	lines := bytes.SplitAfter(text, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	highlighted := lineRanges(hlLines, len(lines))
+	for i, line := range lines {
+		out.WriteString("<span class=\"")
+		options.writeClass(out, "line")
+		if highlighted[i+1] {
+			out.WriteByte(' ')
+			options.writeClass(out, "hl")
+		}
 		out.WriteString("\">")
+		if linenos {
+			out.WriteString("<span class=\"")
+			options.writeClass(out, "line-number")
+			out.WriteString("\">")
+			out.WriteString(strconv.Itoa(i + 1))
+			out.WriteString("</span>")
+		}
+		newline := bytes.HasSuffix(line, []byte("\n"))
+		attrEscape(out, bytes.TrimSuffix(line, []byte("\n")))
+		out.WriteString("</span>")
+		if newline {
+			out.WriteByte('\n')
+		}
 	}
+}
 
-	attrEscape(out, text)
-	out.WriteString("</code></pre>\n")
+// lineRanges parses line numbers and ranges of them, such as "2-4 6" or
+// "2-4,6", into the set of lines up to count that they cover. Anything else
+// is ignored.
+func lineRanges(s string, count int) map[int]bool {
+	lines := make(map[int]bool)
+	for _, r := range strings.FieldsFunc(s, func(c rune) bool { return c == ',' || c == ' ' }) {
+		from, to := r, r
+		if i := strings.IndexByte(r, '-'); i >= 0 {
+			from, to = r[:i], r[i+1:]
+		}
+		a, err1 := strconv.Atoi(from)
+		b, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		for n := a; n <= b && n <= count; n++ {
+			lines[n] = true
+		}
+	}
+	return lines
 }
 
 func (options *Html) BlockQuote(out *bytes.Buffer, text []byte) {
@@ -293,60 +642,190 @@ func (options *Html) BlockQuote(out *bytes.Buffer, text []byte) {
 	out.WriteString("</blockquote>\n")
 }
 
-func (options *Html) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+// A cited quotation is a figure with the citation as its caption, or in
+// EPUB, which has no <figure>, a <div> with a paragraph after the quote.
+func (options *Html) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
 	doubleSpace(out)
-	out.WriteString("<table>\n<thead>\n")
-	out.Write(header)
-	out.WriteString("</thead>\n\n<tbody>\n")
-	out.Write(body)
-	out.WriteString("</tbody>\n</table>\n")
+	if options.flags&HTML_EPUB != 0 {
+		out.WriteString("<div class=\"")
+		options.writeClass(out, "quotation")
+		out.WriteString("\">\n")
+	} else {
+		out.WriteString("<figure>\n")
+	}
+	out.WriteString("<blockquote>\n")
+	out.Write(text)
+	out.WriteString("</blockquote>\n")
+	if options.flags&HTML_EPUB != 0 {
+		out.WriteString("<p class=\"")
+		options.writeClass(out, "attribution")
+		out.WriteString("\">")
+		writeEntity(out, "mdash", options.parameters.Entities)
+		out.WriteByte(' ')
+		out.Write(citation)
+		out.WriteString("</p>\n</div>\n")
+	} else {
+		out.WriteString("<figcaption>")
+		writeEntity(out, "mdash", options.parameters.Entities)
+		out.WriteByte(' ')
+		out.Write(citation)
+		out.WriteString("</figcaption>\n</figure>\n")
+	}
 }
 
-func (options *Html) TableRow(out *bytes.Buffer, text []byte) {
+func (options *Html) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
 	doubleSpace(out)
-	out.WriteString("<tr>\n")
+	out.WriteString("<div class=\"")
+	options.writeClass(out, "admonition")
+	out.WriteByte(' ')
+	options.writeClass(out, kind)
+	out.WriteString("\">\n<p class=\"")
+	options.writeClass(out, "admonition-title")
+	out.WriteString("\">")
+	attrEscape(out, admonitionTitle(kind, title))
+	out.WriteString("</p>\n")
 	out.Write(text)
-	out.WriteString("\n</tr>\n")
+	out.WriteString("</div>\n")
 }
 
-func (options *Html) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
+// The info string of a container gives its classes, and optionally an id
+// written as #id, as in Pandoc.
+func (options *Html) Container(out *bytes.Buffer, info string, text []byte) {
 	doubleSpace(out)
-	switch align {
-	case TABLE_ALIGNMENT_LEFT:
-		out.WriteString("<th align=\"left\">")
-	case TABLE_ALIGNMENT_RIGHT:
-		out.WriteString("<th align=\"right\">")
-	case TABLE_ALIGNMENT_CENTER:
-		out.WriteString("<th align=\"center\">")
-	default:
-		out.WriteString("<th>")
+	out.WriteString("<div")
+	var classes []string
+	for _, field := range strings.Fields(strings.Trim(info, "{}")) {
+		switch {
+		case strings.HasPrefix(field, "#"):
+			out.WriteString(" id=\"")
+			attrEscape(out, []byte(field[1:]))
+			out.WriteString("\"")
+		case !strings.Contains(field, "="):
+			classes = append(classes, strings.TrimPrefix(field, "."))
+		}
+	}
+	if len(classes) > 0 {
+		out.WriteString(" class=\"")
+		attrEscape(out, []byte(strings.Join(classes, " ")))
+		out.WriteString("\"")
+	}
+	out.WriteString(">\n")
+	out.Write(text)
+	out.WriteString("</div>\n")
+}
+
+func (options *Html) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	doubleSpace(out)
+	out.WriteString("<details")
+	if flags&DETAILS_OPEN != 0 {
+		out.WriteString(" open")
+	}
+	out.WriteString(">\n")
+	if len(summary) > 0 {
+		out.WriteString("<summary>")
+		out.Write(summary)
+		out.WriteString("</summary>\n")
 	}
+	out.Write(text)
+	out.WriteString("</details>\n")
+}
 
+// Spoilers are collapsed, and can be styled further through their class.
+func (options *Html) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	doubleSpace(out)
+	out.WriteString("<details class=\"")
+	options.writeClass(out, "spoiler")
+	out.WriteString("\">\n")
 	out.Write(text)
-	out.WriteString("</th>")
+	out.WriteString("</details>\n")
 }
 
-func (options *Html) TableCell(out *bytes.Buffer, text []byte, align int) {
+// Shortcodes are written as they are, for a static site generator to
+// expand.
+func (options *Html) BlockShortcode(out *bytes.Buffer, text []byte) {
 	doubleSpace(out)
-	switch align {
+	out.Write(text)
+	out.WriteByte('\n')
+}
+
+func (options *Html) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	doubleSpace(out)
+	out.WriteString("<table>\n")
+	if len(caption) > 0 {
+		out.WriteString("<caption>")
+		out.Write(caption)
+		out.WriteString("</caption>\n")
+	}
+	if len(header) > 0 {
+		out.WriteString("<thead>\n")
+		out.Write(header)
+		out.WriteString("</thead>\n\n")
+	}
+	out.WriteString("<tbody>\n")
+	out.Write(body)
+	out.WriteString("</tbody>\n</table>\n")
+}
+
+func (options *Html) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	doubleSpace(out)
+	out.WriteString("<tr>\n")
+	out.Write(text)
+	out.WriteString("\n</tr>\n")
+}
+
+func (options *Html) TableHeaderCell(out *bytes.Buffer, text []byte, flags int, span int) {
+	options.TableCell(out, text, flags|TABLE_CELL_HEADER, span)
+}
+
+func (options *Html) TableCell(out *bytes.Buffer, text []byte, flags int, span int) {
+	doubleSpace(out)
+	tag := "td"
+	if flags&TABLE_CELL_HEADER != 0 {
+		tag = "th"
+	}
+	out.WriteString("<" + tag)
+	switch flags & TABLE_ALIGNMENT_MASK {
 	case TABLE_ALIGNMENT_LEFT:
-		out.WriteString("<td align=\"left\">")
+		out.WriteString(" align=\"left\"")
 	case TABLE_ALIGNMENT_RIGHT:
-		out.WriteString("<td align=\"right\">")
+		out.WriteString(" align=\"right\"")
 	case TABLE_ALIGNMENT_CENTER:
-		out.WriteString("<td align=\"center\">")
-	default:
-		out.WriteString("<td>")
+		out.WriteString(" align=\"center\"")
+	}
+	if span > 1 {
+		out.WriteString(" colspan=\"")
+		out.WriteString(strconv.Itoa(span))
+		out.WriteString("\"")
 	}
+	out.WriteString(">")
 
 	out.Write(text)
-	out.WriteString("</td>")
+	out.WriteString("</" + tag + ">")
+}
+
+// writeClass writes out a class name generated by the renderer.
+func (options *Html) writeClass(out *bytes.Buffer, name string) {
+	attrEscape(out, []byte(options.parameters.ClassPrefix))
+	out.WriteString(name)
 }
 
 func (options *Html) Footnotes(out *bytes.Buffer, text func() bool) {
-	out.WriteString("<div class=\"footnotes\">\n")
+	options.footnoteCount = 0
+	if options.flags&HTML_OMIT_FOOTNOTES != 0 {
+		// the items are still rendered for the FootnoteCollector
+		marker := out.Len()
+		text()
+		out.Truncate(marker)
+		return
+	}
+
+	out.WriteString("<div class=\"")
+	options.writeClass(out, "footnotes")
+	out.WriteString("\"")
+	options.writeEpubType(out, "footnotes")
+	out.WriteString(">\n")
 	options.HRule(out)
-	options.List(out, text, LIST_TYPE_ORDERED)
+	options.List(out, text, LIST_TYPE_ORDERED, 1)
 	out.WriteString("</div>\n")
 }
 
@@ -354,15 +833,20 @@ func (options *Html) FootnoteItem(out *bytes.Buffer, name, text []byte, flags in
 	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 || flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
 		doubleSpace(out)
 	}
+	marker := out.Len()
 	slug := slugify(name)
 	out.WriteString(`<li id="`)
 	out.WriteString(`fn:`)
 	out.WriteString(options.parameters.FootnoteAnchorPrefix)
 	out.Write(slug)
-	out.WriteString(`">`)
+	out.WriteString(`"`)
+	options.writeEpubType(out, "footnote")
+	out.WriteString(`>`)
 	out.Write(text)
 	if options.flags&HTML_FOOTNOTE_RETURN_LINKS != 0 {
-		out.WriteString(` <a class="footnote-return" href="#`)
+		out.WriteString(` <a class="`)
+		options.writeClass(out, "footnote-return")
+		out.WriteString(`" href="#`)
 		out.WriteString(`fnref:`)
 		out.WriteString(options.parameters.FootnoteAnchorPrefix)
 		out.Write(slug)
@@ -371,16 +855,40 @@ func (options *Html) FootnoteItem(out *bytes.Buffer, name, text []byte, flags in
 		out.WriteString(`</a>`)
 	}
 	out.WriteString("</li>\n")
+
+	options.footnoteCount++
+	if options.parameters.FootnoteCollector != nil {
+		item := append([]byte(nil), out.Bytes()[marker:]...)
+		options.parameters.FootnoteCollector(options.footnoteCount, item)
+	}
 }
 
-func (options *Html) List(out *bytes.Buffer, text func() bool, flags int) {
+func (options *Html) List(out *bytes.Buffer, text func() bool, flags int, start int) {
 	marker := out.Len()
 	doubleSpace(out)
 
 	if flags&LIST_TYPE_DEFINITION != 0 {
 		out.WriteString("<dl>")
 	} else if flags&LIST_TYPE_ORDERED != 0 {
-		out.WriteString("<ol>")
+		out.WriteString("<ol")
+		switch {
+		case flags&LIST_STYLE_LOWER_ALPHA != 0:
+			out.WriteString(" type=\"a\"")
+		case flags&LIST_STYLE_UPPER_ALPHA != 0:
+			out.WriteString(" type=\"A\"")
+		case flags&LIST_STYLE_LOWER_ROMAN != 0:
+			out.WriteString(" type=\"i\"")
+		case flags&LIST_STYLE_UPPER_ROMAN != 0:
+			out.WriteString(" type=\"I\"")
+		case flags&LIST_STYLE_EXAMPLE != 0:
+			out.WriteString(" class=\"")
+			options.writeClass(out, "example")
+			out.WriteString("\"")
+		}
+		if start != 1 {
+			out.WriteString(fmt.Sprintf(" start=\"%d\"", start))
+		}
+		out.WriteString(">")
 	} else {
 		out.WriteString("<ul>")
 	}
@@ -409,6 +917,24 @@ func (options *Html) ListItem(out *bytes.Buffer, text []byte, flags int) {
 	} else {
 		out.WriteString("<li>")
 	}
+	if flags&LIST_ITEM_TASK != 0 {
+		// XHTML 1.1 spells out the values of boolean attributes
+		out.WriteString("<input type=\"checkbox\"")
+		if flags&LIST_ITEM_CHECKED != 0 {
+			if options.flags&HTML_EPUB != 0 {
+				out.WriteString(" checked=\"checked\"")
+			} else {
+				out.WriteString(" checked=\"\"")
+			}
+		}
+		if options.flags&HTML_EPUB != 0 {
+			out.WriteString(" disabled=\"disabled\"")
+		} else {
+			out.WriteString(" disabled=\"\"")
+		}
+		out.WriteString(options.closeTag)
+		out.WriteByte(' ')
+	}
 	out.Write(text)
 	if flags&LIST_TYPE_TERM != 0 {
 		out.WriteString("</dt>\n")
@@ -433,7 +959,8 @@ func (options *Html) Paragraph(out *bytes.Buffer, text func() bool) {
 
 func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 	skipRanges := htmlEntity.FindAllIndex(link, -1)
-	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) && kind != LINK_TYPE_EMAIL {
+	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) && kind != LINK_TYPE_EMAIL && kind != LINK_TYPE_WWW ||
+		options.flags&HTML_SANITIZE != 0 && isUnsafeURL(link) {
 		// mark it but don't link it if it is not a safe link: no smartypants
 		out.WriteString("<tt>")
 		entityEscapeWithSkip(out, link, skipRanges)
@@ -441,37 +968,36 @@ func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 		return
 	}
 
-	out.WriteString("<a href=\"")
-	if kind == LINK_TYPE_EMAIL {
-		out.WriteString("mailto:")
-	} else {
-		options.maybeWriteAbsolutePrefix(out, link)
-	}
-
-	entityEscapeWithSkip(out, link, skipRanges)
-
-	var relAttrs []string
-	if options.flags&HTML_NOFOLLOW_LINKS != 0 && !isRelativeLink(link) {
-		relAttrs = append(relAttrs, "nofollow")
+	href := link
+	switch kind {
+	case LINK_TYPE_EMAIL:
+		href = append([]byte("mailto:"), link...)
+	case LINK_TYPE_WWW:
+		href = append([]byte("http://"), link...)
 	}
-	if options.flags&HTML_NOREFERRER_LINKS != 0 && !isRelativeLink(link) {
-		relAttrs = append(relAttrs, "noreferrer")
-	}
-	if len(relAttrs) > 0 {
-		out.WriteString(fmt.Sprintf("\" rel=\"%s", strings.Join(relAttrs, " ")))
+	if options.parameters.URLRewriter != nil {
+		href = options.parameters.URLRewriter(URL_TYPE_AUTOLINK, href)
 	}
 
-	// blank target only add to external link
-	if options.flags&HTML_HREF_TARGET_BLANK != 0 && !isRelativeLink(link) {
-		out.WriteString("\" target=\"_blank")
+	obfuscate := options.flags&HTML_OBFUSCATE_EMAILS != 0 && bytes.HasPrefix(href, []byte("mailto:"))
+
+	out.WriteString("<a href=\"")
+	if obfuscate {
+		obfuscateEmail(out, href)
+	} else {
+		options.maybeWriteAbsolutePrefix(out, href)
+		entityEscapeWithSkip(out, href, htmlEntity.FindAllIndex(href, -1))
 	}
 
+	options.writeLinkAttrs(out, href)
 	out.WriteString("\">")
 
 	// Pretty print: if we get an email address as
 	// an actual URI, e.g. `mailto:foo@bar.com`, we don't
 	// want to print the `mailto:` prefix
 	switch {
+	case obfuscate:
+		obfuscateEmail(out, bytes.TrimPrefix(bytes.TrimPrefix(link, []byte("mailto:")), []byte("//")))
 	case bytes.HasPrefix(link, []byte("mailto://")):
 		attrEscape(out, link[len("mailto://"):])
 	case bytes.HasPrefix(link, []byte("mailto:")):
@@ -483,6 +1009,20 @@ func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 	out.WriteString("</a>")
 }
 
+// obfuscateEmail writes an email address or mailto: link as character
+// references, mixing decimal and hexadecimal ones as Markdown.pl did,
+// though in a fixed order. It keeps simple address harvesters from reading
+// it while browsers show it as usual.
+func obfuscateEmail(out *bytes.Buffer, text []byte) {
+	for i, c := range text {
+		if i%2 == 0 {
+			out.WriteString("&#" + strconv.Itoa(int(c)) + ";")
+		} else {
+			out.WriteString("&#x" + strconv.FormatInt(int64(c), 16) + ";")
+		}
+	}
+}
+
 func (options *Html) CodeSpan(out *bytes.Buffer, text []byte) {
 	out.WriteString("<code>")
 	attrEscape(out, text)
@@ -505,7 +1045,7 @@ func (options *Html) Emphasis(out *bytes.Buffer, text []byte) {
 }
 
 func (options *Html) maybeWriteAbsolutePrefix(out *bytes.Buffer, link []byte) {
-	if options.parameters.AbsolutePrefix != "" && isRelativeLink(link) && link[0] != '.' {
+	if options.parameters.AbsolutePrefix != "" && len(link) > 0 && isRelativeLink(link) && link[0] != '.' {
 		out.WriteString(options.parameters.AbsolutePrefix)
 		if link[0] != '/' {
 			out.WriteByte('/')
@@ -513,10 +1053,29 @@ func (options *Html) maybeWriteAbsolutePrefix(out *bytes.Buffer, link []byte) {
 	}
 }
 
-func (options *Html) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+func (options *Html) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
 	if options.flags&HTML_SKIP_IMAGES != 0 {
 		return
 	}
+	if options.flags&HTML_SANITIZE != 0 && isUnsafeURL(link) {
+		attrEscape(out, alt)
+		return
+	}
+	if options.parameters.URLRewriter != nil {
+		link = options.parameters.URLRewriter(URL_TYPE_IMAGE, link)
+	}
+	var info ImageInfo
+	if options.parameters.ImageResolver != nil {
+		if resolved, ok := options.parameters.ImageResolver(link); ok {
+			info = resolved
+		}
+	}
+	if info.URL != "" {
+		link = []byte(info.URL)
+	}
+	if info.Alt != "" {
+		alt = []byte(info.Alt)
+	}
 
 	out.WriteString("<img src=\"")
 	options.maybeWriteAbsolutePrefix(out, link)
@@ -529,8 +1088,32 @@ func (options *Html) Image(out *bytes.Buffer, link []byte, title []byte, alt []b
 		out.WriteString("\" title=\"")
 		attrEscape(out, title)
 	}
+	// sizes given in the attribute list win over the resolved ones
+	if info.Width > 0 && attrs.Get("width") == "" {
+		out.WriteString("\" width=\"")
+		out.WriteString(strconv.Itoa(info.Width))
+	}
+	if info.Height > 0 && attrs.Get("height") == "" {
+		out.WriteString("\" height=\"")
+		out.WriteString(strconv.Itoa(info.Height))
+	}
+	if info.SrcSet != "" && attrs.Get("srcset") == "" {
+		out.WriteString("\" srcset=\"")
+		attrEscape(out, []byte(info.SrcSet))
+	}
+	if info.Sizes != "" && attrs.Get("sizes") == "" {
+		out.WriteString("\" sizes=\"")
+		attrEscape(out, []byte(info.Sizes))
+	}
+	if options.flags&HTML_LAZY_IMAGES != 0 && attrs.Get("loading") == "" {
+		out.WriteString("\" loading=\"lazy")
+	}
+	if options.flags&HTML_ASYNC_IMAGES != 0 && attrs.Get("decoding") == "" {
+		out.WriteString("\" decoding=\"async")
+	}
 
 	out.WriteByte('"')
+	options.writeAttributes(out, attrs)
 	out.WriteString(options.closeTag)
 }
 
@@ -540,7 +1123,7 @@ func (options *Html) LineBreak(out *bytes.Buffer) {
 	out.WriteByte('\n')
 }
 
-func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
 	if options.flags&HTML_SKIP_LINKS != 0 {
 		// write the link text out but don't link it, just mark it with typewriter font
 		out.WriteString("<tt>")
@@ -549,7 +1132,12 @@ func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content
 		return
 	}
 
-	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) {
+	if options.parameters.URLRewriter != nil {
+		link = options.parameters.URLRewriter(URL_TYPE_LINK, link)
+	}
+
+	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) ||
+		options.flags&HTML_SANITIZE != 0 && isUnsafeURL(link) {
 		// write the link text out but don't link it, just mark it with typewriter font
 		out.WriteString("<tt>")
 		attrEscape(out, content)
@@ -564,26 +1152,86 @@ func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content
 		out.WriteString("\" title=\"")
 		attrEscape(out, title)
 	}
+	options.writeLinkAttrs(out, link)
+	out.WriteByte('"')
+	options.writeAttributes(out, attrs)
+	out.WriteByte('>')
+	out.Write(content)
+	out.WriteString("</a>")
+	return
+}
+
+// writeAttributes writes out an attribute list. Its classes are joined
+// with the ones given into a single class attribute. Unless raw HTML is
+// let through as is, event handlers, styles and unsafe URLs are dropped.
+func (options *Html) writeAttributes(out *bytes.Buffer, attrs Attributes, classes ...string) {
+	classes = append(classes, attrs.Classes()...)
+	if len(classes) > 0 {
+		out.WriteString(" class=\"")
+		attrEscape(out, []byte(strings.Join(classes, " ")))
+		out.WriteByte('"')
+	}
+
+	safe := options.flags&(HTML_SKIP_HTML|HTML_SANITIZE|HTML_ESCAPE_HTML) != 0
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		if key == "class" {
+			continue
+		}
+		if safe && (strings.HasPrefix(key, "on") || key == "style" ||
+			urlAttributes[key] && isUnsafeURL([]byte(attr.Value))) {
+			continue
+		}
+		out.WriteByte(' ')
+		out.WriteString(attr.Key)
+		out.WriteString("=\"")
+		attrEscape(out, []byte(attr.Value))
+		out.WriteByte('"')
+	}
+}
+
+// writeLinkAttrs writes out the rel and target attributes asked for by the
+// HTML_* link options. They are only added to external links.
+func (options *Html) writeLinkAttrs(out *bytes.Buffer, link []byte) {
+	if !options.isExternalLink(link) {
+		return
+	}
+
 	var relAttrs []string
-	if options.flags&HTML_NOFOLLOW_LINKS != 0 && !isRelativeLink(link) {
+	if options.flags&HTML_NOFOLLOW_LINKS != 0 {
 		relAttrs = append(relAttrs, "nofollow")
 	}
-	if options.flags&HTML_NOREFERRER_LINKS != 0 && !isRelativeLink(link) {
+	if options.flags&HTML_NOREFERRER_LINKS != 0 {
 		relAttrs = append(relAttrs, "noreferrer")
 	}
+	if options.flags&HTML_NOOPENER_LINKS != 0 {
+		relAttrs = append(relAttrs, "noopener")
+	}
 	if len(relAttrs) > 0 {
 		out.WriteString(fmt.Sprintf("\" rel=\"%s", strings.Join(relAttrs, " ")))
 	}
 
-	// blank target only add to external link
-	if options.flags&HTML_HREF_TARGET_BLANK != 0 && !isRelativeLink(link) {
+	if options.flags&HTML_HREF_TARGET_BLANK != 0 {
 		out.WriteString("\" target=\"_blank")
 	}
+}
 
-	out.WriteString("\">")
-	out.Write(content)
-	out.WriteString("</a>")
-	return
+// isExternalLink reports whether a link leads away from the site: it is
+// neither relative nor to the host of the AbsolutePrefix.
+func (options *Html) isExternalLink(link []byte) bool {
+	if isRelativeLink(link) {
+		return false
+	}
+	u, err := url.Parse(string(link))
+	if err != nil {
+		return true
+	}
+
+	// a path without a scheme or host, such as page.html, is relative too
+	if u.Scheme == "" && u.Host == "" {
+		return false
+	}
+	return options.host == "" || strings.ToLower(u.Host) != options.host
 }
 
 func (options *Html) RawHtmlTag(out *bytes.Buffer, text []byte) {
@@ -599,7 +1247,83 @@ func (options *Html) RawHtmlTag(out *bytes.Buffer, text []byte) {
 	if options.flags&HTML_SKIP_IMAGES != 0 && isHtmlTag(text, "img") {
 		return
 	}
-	out.Write(text)
+	if isHtmlComment(text) {
+		options.writeComment(out, text)
+		return
+	}
+	options.writeRawHtml(out, text)
+}
+
+// writeComment writes out an HTML comment through the CommentHandler, or
+// as the HTML_SKIP_COMMENTS flags say.
+func (options *Html) writeComment(out *bytes.Buffer, comment []byte) {
+	switch {
+	case options.parameters.CommentHandler != nil:
+		options.parameters.CommentHandler(out, comment)
+	case options.flags&HTML_SKIP_COMMENTS == 0,
+		options.flags&HTML_KEEP_CONDITIONAL_COMMENTS != 0 && isConditionalComment(comment):
+		options.writeRawHtml(out, comment)
+	}
+}
+
+func isHtmlComment(text []byte) bool {
+	return bytes.HasPrefix(text, []byte("<!--")) && bytes.HasSuffix(text, []byte("-->"))
+}
+
+// isConditionalComment checks for the comments that older Internet
+// Explorer and Outlook versions read, such as <!--[if mso]>...<![endif]-->.
+func isConditionalComment(comment []byte) bool {
+	return bytes.HasPrefix(bytes.ToLower(comment), []byte("<!--[if ")) ||
+		bytes.HasSuffix(bytes.ToLower(comment), []byte("<![endif]-->"))
+}
+
+// tags whose opening '<' is escaped with HTML_TAGFILTER, as in GitHub
+// Flavored Markdown
+var tagfilterTags = []string{
+	"title", "textarea", "style", "xmp", "iframe",
+	"noembed", "noframes", "script", "plaintext",
+}
+
+func (options *Html) writeRawHtml(out *bytes.Buffer, html []byte) {
+	if options.flags&HTML_ESCAPE_HTML != 0 {
+		attrEscape(out, html)
+		return
+	}
+	if options.flags&HTML_SANITIZE != 0 {
+		sanitizeHtml(out, html, options.parameters.AllowedTags)
+		return
+	}
+	if options.flags&HTML_TAGFILTER == 0 {
+		out.Write(html)
+		return
+	}
+
+	mark := 0
+	for i := 0; i < len(html); i++ {
+		if html[i] == '<' && isFilteredTag(html[i+1:]) {
+			out.Write(html[mark:i])
+			out.WriteString("&lt;")
+			mark = i + 1
+		}
+	}
+	out.Write(html[mark:])
+}
+
+// isFilteredTag reports whether data, which follows a '<', opens or closes
+// one of the tagfilter tags
+func isFilteredTag(data []byte) bool {
+	if len(data) > 0 && data[0] == '/' {
+		data = data[1:]
+	}
+	for _, tag := range tagfilterTags {
+		if len(data) > len(tag) && bytes.EqualFold(data[:len(tag)], []byte(tag)) {
+			switch data[len(tag)] {
+			case '>', '/', ' ', '\t', '\n':
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (options *Html) TripleEmphasis(out *bytes.Buffer, text []byte) {
@@ -614,13 +1338,52 @@ func (options *Html) StrikeThrough(out *bytes.Buffer, text []byte) {
 	out.WriteString("</del>")
 }
 
+func (options *Html) Highlight(out *bytes.Buffer, text []byte) {
+	out.WriteString("<mark>")
+	out.Write(text)
+	out.WriteString("</mark>")
+}
+
+func (options *Html) Insert(out *bytes.Buffer, text []byte) {
+	out.WriteString("<ins>")
+	out.Write(text)
+	out.WriteString("</ins>")
+}
+
+// CriticMarkup shows the changes as tracked changes, or with
+// HTML_CRITIC_ACCEPT or HTML_CRITIC_REJECT, the text as it is with the
+// changes accepted or rejected. Comments are only shown with the changes.
+func (options *Html) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	accept := options.flags&HTML_CRITIC_ACCEPT != 0
+	reject := options.flags&HTML_CRITIC_REJECT != 0 && !accept
+	switch {
+	case !accept && !reject && kind == CRITIC_COMMENT:
+		out.WriteString("<span class=\"")
+		options.writeClass(out, "critic-comment")
+		out.WriteString("\">")
+		out.Write(text)
+		out.WriteString("</span>")
+	case !accept && !reject:
+		criticChanges(options, out, kind, text, replacement)
+	case kind == CRITIC_ADDITION && accept, kind == CRITIC_DELETION && reject,
+		kind == CRITIC_SUBSTITUTION && reject, kind == CRITIC_HIGHLIGHT:
+		out.Write(text)
+	case kind == CRITIC_SUBSTITUTION && accept:
+		out.Write(replacement)
+	}
+}
+
 func (options *Html) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
 	slug := slugify(ref)
-	out.WriteString(`<sup class="footnote-ref" id="`)
+	out.WriteString(`<sup class="`)
+	options.writeClass(out, "footnote-ref")
+	out.WriteString(`" id="`)
 	out.WriteString(`fnref:`)
 	out.WriteString(options.parameters.FootnoteAnchorPrefix)
 	out.Write(slug)
-	out.WriteString(`"><a rel="footnote" href="#`)
+	out.WriteString(`"><a rel="footnote"`)
+	options.writeEpubType(out, "noteref")
+	out.WriteString(` href="#`)
 	out.WriteString(`fn:`)
 	out.WriteString(options.parameters.FootnoteAnchorPrefix)
 	out.Write(slug)
@@ -629,8 +1392,186 @@ func (options *Html) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
 	out.WriteString(`</a></sup>`)
 }
 
+func (options *Html) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	if len(title) > 0 {
+		out.WriteString("<abbr title=\"")
+		attrEscape(out, title)
+		out.WriteString("\">")
+	} else {
+		out.WriteString("<abbr>")
+	}
+	attrEscape(out, abbr)
+	out.WriteString("</abbr>")
+}
+
+func (options *Html) Mention(out *bytes.Buffer, kind int, token []byte) {
+	sigil := "@"
+	if kind == MENTION_TYPE_ISSUE {
+		sigil = "#"
+	}
+
+	url, ok := "", false
+	if options.parameters.MentionResolver != nil {
+		url, ok = options.parameters.MentionResolver(kind, string(token))
+	}
+	if !ok || url == "" {
+		out.WriteString(sigil)
+		attrEscape(out, token)
+		return
+	}
+
+	out.WriteString("<a href=\"")
+	attrEscape(out, []byte(url))
+	out.WriteString("\">")
+	out.WriteString(sigil)
+	attrEscape(out, token)
+	out.WriteString("</a>")
+}
+
+// Citations keep their source text, marked with the cited keys for a tool
+// such as citeproc to fill in.
+func (options *Html) Citation(out *bytes.Buffer, items []CitationItem) {
+	out.WriteString("<span class=\"")
+	options.writeClass(out, "citation")
+	out.WriteString("\" data-cites=\"")
+	for i, item := range items {
+		if i > 0 {
+			out.WriteByte(' ')
+		}
+		attrEscape(out, []byte(item.Key))
+	}
+	out.WriteString("\">")
+	attrEscape(out, citationText(items))
+	out.WriteString("</span>")
+}
+
+// Video and audio files are written as <video> and <audio> elements with
+// the alt text as their fallback content, and the pages of providers as the
+// <iframe> of their player, if its host is in EmbedHosts.
+func (options *Html) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	if options.flags&HTML_SANITIZE != 0 && isUnsafeURL(link) {
+		attrEscape(out, alt)
+		return
+	}
+
+	if kind == MEDIA_EMBED {
+		src := embedURL(link)
+		u, err := url.Parse(src)
+		if err != nil || !options.isEmbedHost(u.Host) {
+			if len(alt) == 0 {
+				alt = link
+			}
+			var content bytes.Buffer
+			attrEscape(&content, alt)
+			options.Link(out, link, title, content.Bytes(), nil)
+			return
+		}
+		out.WriteString("<iframe src=\"")
+		attrEscape(out, []byte(src))
+		if len(title) == 0 {
+			title = alt
+		}
+		if len(title) > 0 {
+			out.WriteString("\" title=\"")
+			attrEscape(out, title)
+		}
+		if options.flags&HTML_LAZY_IMAGES != 0 {
+			out.WriteString("\" loading=\"lazy")
+		}
+		out.WriteString("\" allowfullscreen></iframe>")
+		return
+	}
+
+	if options.parameters.URLRewriter != nil {
+		link = options.parameters.URLRewriter(URL_TYPE_IMAGE, link)
+	}
+	tag := "video"
+	if kind == MEDIA_AUDIO {
+		tag = "audio"
+	}
+	out.WriteString("<" + tag + " src=\"")
+	options.maybeWriteAbsolutePrefix(out, link)
+	attrEscape(out, link)
+	if len(title) > 0 {
+		out.WriteString("\" title=\"")
+		attrEscape(out, title)
+	}
+	out.WriteString("\" controls>")
+	attrEscape(out, alt)
+	out.WriteString("</" + tag + ">")
+}
+
+func (options *Html) Kbd(out *bytes.Buffer, key []byte) {
+	out.WriteString("<kbd>")
+	attrEscape(out, key)
+	out.WriteString("</kbd>")
+}
+
+// The readings are also given in parentheses, for browsers without ruby
+// support.
+func (options *Html) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	out.WriteString("<ruby>")
+	out.Write(text)
+	out.WriteString("<rp>(</rp><rt>")
+	out.Write(annotation)
+	out.WriteString("</rt><rp>)</rp></ruby>")
+}
+
+func (options *Html) Spoiler(out *bytes.Buffer, text []byte) {
+	out.WriteString("<span class=\"")
+	options.writeClass(out, "spoiler")
+	out.WriteString("\">")
+	out.Write(text)
+	out.WriteString("</span>")
+}
+
+func (options *Html) Shortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Html) isEmbedHost(host string) bool {
+	for _, h := range options.parameters.EmbedHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
 func (options *Html) Entity(out *bytes.Buffer, entity []byte) {
-	out.Write(entity)
+	text, ok := options.lookupEntity(entity)
+	if !ok {
+		if options.parameters.EntityTable != nil {
+			out.WriteString("&amp;")
+			out.Write(entity[1:])
+		} else {
+			out.Write(entity)
+		}
+		return
+	}
+	switch {
+	case options.parameters.Entities == ENTITY_UTF8:
+		attrEscape(out, []byte(text))
+	case options.parameters.Entities == ENTITY_NUMERIC && entity[1] != '#':
+		out.Write(numericReferences(entity, text))
+	default:
+		out.Write(entity)
+	}
+}
+
+// lookupEntity returns the text that a character reference stands for, and
+// whether it is one that the renderer recognizes
+func (options *Html) lookupEntity(entity []byte) (string, bool) {
+	if entity[1] == '#' {
+		text := html.UnescapeString(string(entity))
+		return text, text != string(entity)
+	}
+	if table := options.parameters.EntityTable; table != nil {
+		text, ok := table[string(entity[1:len(entity)-1])]
+		return text, ok
+	}
+	text := html.UnescapeString(string(entity))
+	return text, text != string(entity)
 }
 
 func (options *Html) NormalText(out *bytes.Buffer, text []byte) {
@@ -642,32 +1583,28 @@ func (options *Html) NormalText(out *bytes.Buffer, text []byte) {
 }
 
 func (options *Html) Smartypants(out *bytes.Buffer, text []byte) {
-	smrt := smartypantsData{false, false}
-
-	// first do normal entity escaping
-	var escaped bytes.Buffer
-	attrEscape(&escaped, text)
-	text = escaped.Bytes()
-
-	mark := 0
-	for i := 0; i < len(text); i++ {
-		if action := options.smartypants[text[i]]; action != nil {
-			if i > mark {
-				out.Write(text[mark:i])
-			}
+	options.smartypants.process(out, text)
+}
 
-			previousChar := byte(0)
-			if i > 0 {
-				previousChar = text[i-1]
-			}
-			i += action(out, &smrt, previousChar, text[i:])
-			mark = i + 1
+// smartypantsFlags translates the HTML_SMARTYPANTS_* options into the
+// SMARTYPANTS_* ones.
+func smartypantsFlags(flags HtmlFlags) int {
+	sflags := SMARTYPANTS_ELLIPSIS
+	for html, smart := range map[HtmlFlags]int{
+		HTML_SMARTYPANTS_FRACTIONS:     SMARTYPANTS_FRACTIONS,
+		HTML_SMARTYPANTS_DASHES:        SMARTYPANTS_DASHES,
+		HTML_SMARTYPANTS_LATEX_DASHES:  SMARTYPANTS_LATEX_DASHES,
+		HTML_SMARTYPANTS_ANGLED_QUOTES: SMARTYPANTS_ANGLED_QUOTES,
+		HTML_SMARTYPANTS_QUOTES_NBSP:   SMARTYPANTS_QUOTES_NBSP,
+		HTML_SMARTYPANTS_LOW_QUOTES:    SMARTYPANTS_LOW_QUOTES,
+		HTML_SMARTYPANTS_FRENCH_SPACES: SMARTYPANTS_FRENCH_SPACES,
+		HTML_SMARTYPANTS_ORDINALS:      SMARTYPANTS_ORDINALS,
+	} {
+		if flags&html != 0 {
+			sflags |= smart
 		}
 	}
-
-	if mark < len(text) {
-		out.Write(text[mark:])
-	}
+	return sflags
 }
 
 func (options *Html) DocumentHeader(out *bytes.Buffer) {
@@ -676,7 +1613,13 @@ func (options *Html) DocumentHeader(out *bytes.Buffer) {
 	}
 
 	ending := ""
-	if options.flags&HTML_USE_XHTML != 0 {
+	if options.flags&HTML_EPUB != 0 {
+		out.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+		out.WriteString("<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.1//EN\" ")
+		out.WriteString("\"http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd\">\n")
+		out.WriteString("<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\">\n")
+		ending = " /"
+	} else if options.flags&HTML_USE_XHTML != 0 {
 		out.WriteString("<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" ")
 		out.WriteString("\"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\">\n")
 		out.WriteString("<html xmlns=\"http://www.w3.org/1999/xhtml\">\n")
@@ -694,7 +1637,11 @@ func (options *Html) DocumentHeader(out *bytes.Buffer) {
 	out.WriteString("\"")
 	out.WriteString(ending)
 	out.WriteString(">\n")
-	out.WriteString("  <meta charset=\"utf-8\"")
+	if options.flags&HTML_EPUB != 0 {
+		out.WriteString("  <meta http-equiv=\"Content-Type\" content=\"text/html; charset=utf-8\"")
+	} else {
+		out.WriteString("  <meta charset=\"utf-8\"")
+	}
 	out.WriteString(ending)
 	out.WriteString(">\n")
 	if options.css != "" {
@@ -729,10 +1676,18 @@ func (options *Html) DocumentFooter(out *bytes.Buffer) {
 			out.WriteByte('\n')
 		}
 
-		// insert the table of contents
-		out.WriteString("<nav>\n")
-		out.Write(options.toc.Bytes())
-		out.WriteString("</nav>\n")
+		// insert the table of contents; <nav> is not part of XHTML 1.1
+		if options.flags&HTML_EPUB != 0 {
+			out.WriteString("<div class=\"")
+			options.writeClass(out, "toc")
+			out.WriteString("\" epub:type=\"toc\">\n")
+			out.Write(options.toc.Bytes())
+			out.WriteString("</div>\n")
+		} else {
+			out.WriteString("<nav>\n")
+			out.Write(options.toc.Bytes())
+			out.WriteString("</nav>\n")
+		}
 
 		// corner case spacing issue
 		if options.flags&HTML_COMPLETE_PAGE == 0 && options.flags&HTML_OMIT_CONTENTS == 0 {
@@ -750,6 +1705,104 @@ func (options *Html) DocumentFooter(out *bytes.Buffer) {
 		out.WriteString("</html>\n")
 	}
 
+	// XML only predefines a few named entities, so EPUB readers need the
+	// rest written as numeric character references
+	if options.flags&HTML_EPUB != 0 {
+		doc := namedEntity.ReplaceAllFunc(out.Bytes(), numericEntity)
+		out.Reset()
+		out.Write(doc)
+	}
+}
+
+// numericEntity turns a named HTML entity into numeric character
+// references, leaving the entities predefined by XML alone
+func numericEntity(entity []byte) []byte {
+	text := html.UnescapeString(string(entity))
+	if text == string(entity) {
+		// unknown entity, leave it to the reader
+		return entity
+	}
+	return numericReferences(entity, text)
+}
+
+// writeEntity writes the HTML entity with the given name, one the renderer
+// writes itself, as the ENTITY_* policy has it
+func writeEntity(out *bytes.Buffer, name string, policy int) {
+	entity := "&" + name + ";"
+	switch policy {
+	case ENTITY_UTF8:
+		attrEscape(out, []byte(html.UnescapeString(entity)))
+	case ENTITY_NUMERIC:
+		out.Write(numericEntity([]byte(entity)))
+	default:
+		out.WriteString(entity)
+	}
+}
+
+// numericReferences writes text, which entity stands for, as numeric
+// character references, leaving the entities predefined by XML alone
+func numericReferences(entity []byte, text string) []byte {
+	switch string(entity) {
+	case "&amp;", "&lt;", "&gt;", "&quot;", "&apos;":
+		return entity
+	}
+	var out bytes.Buffer
+	for _, r := range text {
+		out.WriteString("&#")
+		out.WriteString(strconv.Itoa(int(r)))
+		out.WriteByte(';')
+	}
+	return out.Bytes()
+}
+
+// writeEpubType adds an epub:type attribute when rendering for EPUB
+func (options *Html) writeEpubType(out *bytes.Buffer, kind string) {
+	if options.flags&HTML_EPUB != 0 {
+		out.WriteString(` epub:type="`)
+		out.WriteString(kind)
+		out.WriteString(`"`)
+	}
+}
+
+// HeaderNumbering numbers headers hierarchically, as in 1, 1.1 and 1.1.1.
+// Renderers can use it in their Header callback; the Html renderer does
+// with HTML_NUMBER_HEADERS. The zero value numbers the headers of all
+// levels.
+type HeaderNumbering struct {
+	MinLevel int // level of the headers numbered with a single number; 1 if zero
+	MaxLevel int // deepest level numbered; 6 if zero
+
+	counts [7]int
+}
+
+// Next counts a header of the given level and returns its number, or "" if
+// the headers of that level aren't numbered. Every header restarts the
+// numbering of the levels below its own, even if it isn't numbered itself;
+// headers that shouldn't count at all are not passed to Next.
+func (n *HeaderNumbering) Next(level int) string {
+	if level < 1 || level > 6 {
+		return ""
+	}
+	n.counts[level]++
+	for l := level + 1; l < len(n.counts); l++ {
+		n.counts[l] = 0
+	}
+
+	first, last := n.MinLevel, n.MaxLevel
+	if first == 0 {
+		first = 1
+	}
+	if last == 0 {
+		last = 6
+	}
+	if level < first || level > last {
+		return ""
+	}
+	parts := make([]string, 0, level-first+1)
+	for l := first; l <= level; l++ {
+		parts = append(parts, strconv.Itoa(n.counts[l]))
+	}
+	return strings.Join(parts, ".")
 }
 
 func (options *Html) TocHeaderWithAnchor(text []byte, level int, anchor string) {
@@ -902,6 +1955,11 @@ func doubleSpace(out *bytes.Buffer) {
 }
 
 func isRelativeLink(link []byte) (yes bool) {
+	// an empty link refers to the current document
+	if len(link) == 0 {
+		return true
+	}
+
 	// a tag begin with '#'
 	if link[0] == '#' {
 		return true
@@ -931,19 +1989,25 @@ func isRelativeLink(link []byte) (yes bool) {
 }
 
 func (options *Html) ensureUniqueHeaderID(id string) string {
-	for count, found := options.headerIDs[id]; found; count, found = options.headerIDs[id] {
+	return uniqueID(options.headerIDs, id)
+}
+
+// uniqueID records id in ids and returns it, adding a numeric suffix if
+// it has already been recorded. ids maps each id to the last suffix used.
+func uniqueID(ids map[string]int, id string) string {
+	for count, found := ids[id]; found; count, found = ids[id] {
 		tmp := fmt.Sprintf("%s-%d", id, count+1)
 
-		if _, tmpFound := options.headerIDs[tmp]; !tmpFound {
-			options.headerIDs[id] = count + 1
+		if _, tmpFound := ids[tmp]; !tmpFound {
+			ids[id] = count + 1
 			id = tmp
 		} else {
 			id = id + "-1"
 		}
 	}
 
-	if _, found := options.headerIDs[id]; !found {
-		options.headerIDs[id] = 0
+	if _, found := ids[id]; !found {
+		ids[id] = 0
 	}
 
 	return id
@@ -0,0 +1,123 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Including other documents with EXTENSION_INCLUDE
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// How deeply included documents can include others.
+const maxIncludeDepth = 8
+
+// {{include "path.md"}} or <!--#include file="path.md"--> on a line of its own
+var includeRe = regexp.MustCompile(`^ {0,3}(?:\{\{\s*include\s+"([^"]+)"\s*\}\}|<!--\s*#include\s+file="([^"]+)"\s*-->)[ \t]*\r?$`)
+
+// includeDirective returns the path named by an include directive, or ""
+// if line isn't one.
+func includeDirective(line []byte) string {
+	m := includeRe.FindSubmatch(line)
+	if m == nil {
+		return ""
+	}
+	if m[1] != nil {
+		return string(m[1])
+	}
+	return string(m[2])
+}
+
+// expandIncludes replaces the include directives of the input, and of the
+// documents it includes, with the documents they name. The included
+// documents become part of the input, so that their references and
+// footnotes can be used throughout. The input line of each of the lines is
+// kept in p.includeLines; included lines are given the line of the
+// directive that included them.
+func (p *parser) expandIncludes(input []byte) []byte {
+	var out bytes.Buffer
+	p.includeLines = p.includeLines[:0]
+	p.include(&out, input, nil, 0)
+	return out.Bytes()
+}
+
+// include copies data to out, including the documents named by its
+// directives. The paths of the documents data was included through are in
+// stack. If line is 0, data is the input itself; otherwise line is where it
+// was included.
+func (p *parser) include(out *bytes.Buffer, data []byte, stack []string, line int) {
+	marker := ""
+	for n, beg := 0, 0; beg < len(data); n++ {
+		end := skipUntilChar(data, beg, '\n')
+		if end < len(data) {
+			end++
+		}
+		current := data[beg:end]
+		beg = end
+
+		srcLine := line
+		if srcLine == 0 {
+			srcLine = n + 1
+		}
+
+		// directives in fenced code are left alone
+		if p.flags&EXTENSION_FENCED_CODE != 0 {
+			if marker != "" {
+				if fenceEnd, _ := isFenceLine(current, nil, nil, marker, true); fenceEnd > 0 {
+					marker = ""
+				}
+			} else {
+				var syntax, info string
+				if fenceEnd, m := isFenceLine(current, &syntax, &info, "", true); fenceEnd > 0 {
+					marker = m
+				}
+			}
+		}
+
+		if path := includeDirective(bytes.TrimRight(current, "\n")); path != "" && marker == "" {
+			if included, ok := p.resolveInclude(path, stack, srcLine); ok {
+				p.include(out, included, append(stack, path), srcLine)
+				if len(included) > 0 && included[len(included)-1] != '\n' {
+					out.WriteByte('\n')
+					p.includeLines = append(p.includeLines, srcLine)
+				}
+				continue
+			}
+		}
+
+		out.Write(current)
+		p.includeLines = append(p.includeLines, srcLine)
+	}
+}
+
+// resolveInclude looks up the document at path, unless including it would
+// nest too deeply or include a document within itself.
+func (p *parser) resolveInclude(path string, stack []string, line int) ([]byte, bool) {
+	if len(stack) >= maxIncludeDepth {
+		p.diagnoseLine(DIAGNOSTIC_ERROR, line, "includes nested deeper than %d, %q not included", maxIncludeDepth, path)
+		return nil, false
+	}
+	for _, seen := range stack {
+		if seen == path {
+			p.diagnoseLine(DIAGNOSTIC_ERROR, line, "%q includes itself", path)
+			return nil, false
+		}
+	}
+	included, err := p.includeResolver(path)
+	if err != nil {
+		p.diagnoseLine(DIAGNOSTIC_ERROR, line, "cannot include %q: %v", path, err)
+		return nil, false
+	}
+	return included, true
+}
@@ -0,0 +1,340 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Rendering a document again after an edit, block by block
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// The extensions that number things throughout the document, so that an
+// edit anywhere can change the output of any block.
+const documentWideExtensions = EXTENSION_FOOTNOTES | EXTENSION_CITATIONS |
+	EXTENSION_EXAMPLE_LISTS | EXTENSION_CROSS_REFERENCES
+
+// Snapshot is a document rendered one top-level block at a time, for live
+// previews. After an edit, Update parses only the blocks the edit affects
+// and reports the part of the output that changed.
+//
+// The output is that of the blocks, footnotes and bibliography; the
+// renderer's DocumentHeader and DocumentFooter are left out. The renderer
+// has to render each block the same whatever blocks come before it, as
+// the HTML renderer does without HTML_TOC and HTML_NUMBER_HEADERS.
+type Snapshot struct {
+	newRenderer func() Renderer
+	opts        Options
+	input       []byte
+	doc         []byte // output of the first pass
+	blocks      []snapshotBlock
+	notes       []byte // footnotes and bibliography
+
+	// what the first pass collected, which all the blocks depend on
+	refs          map[string]*reference
+	abbreviations []*abbreviation
+}
+
+// snapshotBlock is the output of a top-level block, or of blank lines
+// between blocks, at doc[start:end].
+type snapshotBlock struct {
+	start, end int
+	output     []byte
+
+	// how far past end the block was looked at, if it was: an unclosed
+	// fence or HTML block is looked for up to the end of the document
+	reach int
+
+	// the header ids used up to and including this block, for the
+	// suffixes that keep them unique; shared by the blocks after it
+	// until one of them adds an id
+	ids map[string]int
+}
+
+// Change is a part of the output of a Snapshot replaced by Update.
+type Change struct {
+	Start, End int    // the replaced part of the old output
+	Output     []byte // what replaced it
+}
+
+// RenderSnapshot renders a block of markdown-encoded text with the given
+// options into a Snapshot, for Update. newRenderer returns the renderer,
+// and is called again for each update, as renderers such as the HTML one
+// keep track of what they have rendered.
+func RenderSnapshot(input []byte, newRenderer func() Renderer, opts Options) (*Snapshot, error) {
+	s := &Snapshot{newRenderer: newRenderer, opts: opts, input: input}
+	if err := s.render(input); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Input returns the document as of the last update.
+func (s *Snapshot) Input() []byte {
+	return s.input
+}
+
+// Output returns the output of the whole document.
+func (s *Snapshot) Output() []byte {
+	var out bytes.Buffer
+	for _, b := range s.blocks {
+		out.Write(b.output)
+	}
+	out.Write(s.notes)
+	return out.Bytes()
+}
+
+// Update replaces input[start:end] of the document with text and renders
+// it again, and returns the part of the output that changed.
+//
+// Only the blocks from the one before the edit on are parsed again, up to
+// where the parser falls back in step with the blocks of the old input,
+// along with any block whose parse looked as far as the edit, such as a
+// fence that was not closed before it. The whole document is parsed again
+// if the edit changes link references or abbreviations, or if footnotes,
+// citations, example lists or cross-references are enabled.
+func (s *Snapshot) Update(start, end int, text []byte) (Change, error) {
+	if start < 0 || start > end || end > len(s.input) {
+		return Change{}, errors.New("blackfriday: edit out of range")
+	}
+	input := make([]byte, 0, len(s.input)-(end-start)+len(text))
+	input = append(input, s.input[:start]...)
+	input = append(input, text...)
+	input = append(input, s.input[end:]...)
+
+	old := *s
+	if err := s.render(input); err != nil {
+		return Change{}, err
+	}
+
+	// the blocks that are the same at either end
+	first := 0
+	for first < len(old.blocks) && first < len(s.blocks) &&
+		bytes.Equal(old.blocks[first].output, s.blocks[first].output) {
+		first++
+	}
+	oldLast, last := len(old.blocks), len(s.blocks)
+	for oldLast > first && last > first &&
+		bytes.Equal(old.blocks[oldLast-1].output, s.blocks[last-1].output) {
+		oldLast--
+		last--
+	}
+
+	change := Change{Start: outputLength(old.blocks[:first])}
+	change.End = change.Start + outputLength(old.blocks[first:oldLast])
+	var out bytes.Buffer
+	for _, b := range s.blocks[first:last] {
+		out.Write(b.output)
+	}
+	if !bytes.Equal(old.notes, s.notes) {
+		change.End = outputLength(old.blocks) + len(old.notes)
+		for _, b := range s.blocks[last:] {
+			out.Write(b.output)
+		}
+		out.Write(s.notes)
+	}
+	change.Output = out.Bytes()
+	return change, nil
+}
+
+// outputLength returns the length of the output of blocks
+func outputLength(blocks []snapshotBlock) int {
+	length := 0
+	for _, b := range blocks {
+		length += len(b.output)
+	}
+	return length
+}
+
+// render parses input, reusing what it can of the blocks of the old
+// input. It updates the snapshot unless it fails.
+func (s *Snapshot) render(input []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("blackfriday: %v", r)
+		}
+	}()
+
+	p := newParser(s.newRenderer(), s.opts)
+	if err := p.checkUTF8(input); err != nil {
+		return err
+	}
+	doc := firstPass(p, input)
+	p.doc = doc
+
+	// blocks that can be reused from the start and from the end, in
+	// terms of the first pass output
+	var prefix, suffix int
+	full := s.doc == nil || p.flags&documentWideExtensions != 0 ||
+		!reflect.DeepEqual(p.refs, s.refs) || !reflect.DeepEqual(p.abbreviations, s.abbreviations)
+	if !full {
+		prefix = commonPrefix(s.doc, doc)
+		suffix = commonSuffix(s.doc[prefix:], doc[prefix:])
+	}
+
+	if p.flags&(EXTENSION_CROSS_REFERENCES|EXTENSION_EXAMPLE_LISTS) != 0 {
+		p.index(doc)
+	}
+
+	// Blocks look ahead as far as the first line of the next block, so
+	// the blocks are kept up to the last one whose first line is
+	// unchanged, and parsing starts again there. A block that looked for
+	// its end further than that, up to the edit, is parsed again too.
+	keep := 0
+	if !full {
+		for i, b := range s.blocks {
+			if b.start >= prefix {
+				break
+			}
+			if len(b.output) > 0 && lineEnd(s.doc, b.start) <= prefix {
+				keep = i
+			}
+		}
+		for i, b := range s.blocks[:keep] {
+			if b.reach >= prefix {
+				keep = i
+				break
+			}
+		}
+	}
+	blocks := append([]snapshotBlock(nil), s.blocks[:keep]...)
+	pos := 0
+	if keep > 0 {
+		pos = blocks[keep-1].end
+		p.headerIDs = copyIDs(blocks[keep-1].ids)
+	}
+
+	delta := len(doc) - len(s.doc)
+	next := keep // the old block to fall back in step with
+	for pos < len(doc) {
+		if !full && pos >= len(doc)-suffix {
+			// in step again if an old block starts here, with the same
+			// header ids before it
+			for next < len(s.blocks) && s.blocks[next].start < pos-delta {
+				next++
+			}
+			if next < len(s.blocks) && s.blocks[next].start == pos-delta &&
+				reflect.DeepEqual(p.headerIDs, previousIDs(s.blocks, next)) &&
+				lastOutputByte(blocks) == lastOutputByte(s.blocks[:next]) {
+				for _, b := range s.blocks[next:] {
+					b.start += delta
+					b.end += delta
+					if b.reach > 0 {
+						b.reach += delta
+					}
+					blocks = append(blocks, b)
+				}
+				break
+			}
+		}
+
+		// as in block, with the end of the output so far in front, which
+		// renderers look at to space out blocks
+		var out bytes.Buffer
+		last := lastOutputByte(blocks)
+		if last != 0 {
+			out.WriteByte(last)
+		}
+		ids := len(p.headerIDs)
+		p.reach = 0
+		p.nesting++
+		n := p.nextBlock(&out, doc[pos:])
+		p.nesting--
+		output := out.Bytes()
+		if last != 0 {
+			output = output[1:]
+		}
+		b := snapshotBlock{start: pos, end: pos + n, output: output, reach: p.reach}
+		switch {
+		case len(p.headerIDs) != ids:
+			b.ids = copyIDs(p.headerIDs)
+		case len(blocks) > 0:
+			b.ids = blocks[len(blocks)-1].ids
+		}
+		blocks = append(blocks, b)
+		pos += n
+	}
+
+	var notes bytes.Buffer
+	p.endNotes(&notes)
+
+	s.input, s.doc, s.blocks, s.notes = input, doc, blocks, notes.Bytes()
+	s.refs, s.abbreviations = p.refs, p.abbreviations
+	return nil
+}
+
+// lookedAhead records that a block parser looked at data up to end in a
+// search for the end of a block that it did not find, which a Snapshot
+// has to parse again after an edit there
+func (p *parser) lookedAhead(data []byte, end int) {
+	if off, ok := sliceOffset(p.doc, data); ok && off+end > p.reach {
+		p.reach = off + end
+	}
+}
+
+// lastOutputByte returns the last byte of the output of blocks, or 0 if
+// there is none
+func lastOutputByte(blocks []snapshotBlock) byte {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if output := blocks[i].output; len(output) > 0 {
+			return output[len(output)-1]
+		}
+	}
+	return 0
+}
+
+// previousIDs returns the header ids used before blocks[i]
+func previousIDs(blocks []snapshotBlock, i int) map[string]int {
+	if i == 0 || blocks[i-1].ids == nil {
+		return map[string]int{}
+	}
+	return blocks[i-1].ids
+}
+
+func copyIDs(ids map[string]int) map[string]int {
+	c := make(map[string]int, len(ids))
+	for id, n := range ids {
+		c[id] = n
+	}
+	return c
+}
+
+// lineEnd returns the end of the line starting at beg, past its newline
+func lineEnd(data []byte, beg int) int {
+	end := skipUntilChar(data, beg, '\n')
+	if end < len(data) {
+		end++
+	}
+	return end
+}
+
+// commonPrefix returns the length of the common prefix of a and b
+func commonPrefix(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// commonSuffix returns the length of the common suffix of a and b
+func commonSuffix(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[len(a)-1-i] == b[len(b)-1-i] {
+		i++
+	}
+	return i
+}
@@ -17,6 +17,9 @@ import (
 	"bytes"
 	"regexp"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
@@ -32,9 +35,12 @@ var (
 func (p *parser) inline(out *bytes.Buffer, data []byte) {
 	// this is called recursively: enforce a maximum depth
 	if p.nesting >= p.maxNesting {
+		p.diagnose(DIAGNOSTIC_ERROR, data, "spans nested more than %d deep left out", p.maxNesting)
 		return
 	}
 	p.nesting++
+	span, index, emphases := p.span, p.spanIndex, p.emphases
+	p.span, p.spanIndex = data, nil
 
 	i, end := 0, 0
 	for i < len(data) {
@@ -43,7 +49,7 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 			end++
 		}
 
-		p.r.NormalText(out, data[i:end])
+		p.normalText(out, data[i:end])
 
 		if end >= len(data) {
 			break
@@ -52,29 +58,76 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 
 		// call the trigger
 		handler := p.inlineCallback[data[end]]
-		if consumed := handler(p, out, data, i); consumed == 0 {
+		mark := p.mark()
+		consumed := handler(p, out, data, i)
+		rewound := p.rewound
+		p.rewound = 0
+		if consumed == 0 {
 			// no action from the callback; buffer the byte for later
 			end = i + 1
 		} else {
+			p.place(mark, data[i-rewound:], consumed+rewound)
 			// skip past whatever the callback used
 			i += consumed
 			end = i
 		}
 	}
 
+	p.span, p.spanIndex, p.emphases = span, index, emphases
 	p.nesting--
 }
 
+// normalText renders a run of text that contains no inline markup,
+// picking out any abbreviations it contains.
+func (p *parser) normalText(out *bytes.Buffer, text []byte) {
+	if len(p.abbreviations) == 0 {
+		p.r.NormalText(out, text)
+		return
+	}
+
+	mark := 0
+	for i := 0; i < len(text); i++ {
+		// abbreviations only start at a word boundary
+		if isalnumBefore(text, i) {
+			continue
+		}
+		for _, abbr := range p.abbreviations {
+			end := i + len(abbr.term)
+			if !bytes.HasPrefix(text[i:], abbr.term) || isalnumAt(text, end) {
+				continue
+			}
+			if i > mark {
+				p.r.NormalText(out, text[mark:i])
+			}
+			node := p.mark()
+			p.r.Abbreviation(out, abbr.term, abbr.title)
+			p.place(node, text[i:], len(abbr.term))
+			mark = end
+			i = end - 1
+			break
+		}
+	}
+	if mark < len(text) {
+		p.r.NormalText(out, text[mark:])
+	}
+}
+
 // single and double emphasis parsing
 func emphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	// CommonMark pairs up the delimiters of the whole span
+	if p.flags&EXTENSION_COMMONMARK != 0 && (data[offset] == '*' || data[offset] == '_') {
+		return commonmarkEmphasis(p, out, data, offset)
+	}
+
 	data = data[offset:]
 	c := data[0]
 	ret := 0
 
 	if len(data) > 2 && data[1] != c {
 		// whitespace cannot follow an opening emphasis;
-		// strikethrough only takes two characters '~~'
-		if c == '~' || isspace(data[1]) {
+		// strikethrough, highlight and insert only take two characters,
+		// unless single tildes are enabled
+		if isDoubleOnly(c) && (c != '~' || !p.strikethroughTildes(1)) || isspace(data[1]) {
 			return 0
 		}
 		if ret = helperEmphasis(p, out, data[1:], c); ret == 0 {
@@ -85,7 +138,7 @@ func emphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	}
 
 	if len(data) > 3 && data[1] == c && data[2] != c {
-		if isspace(data[2]) {
+		if isspace(data[2]) || c == '~' && !p.strikethroughTildes(2) {
 			return 0
 		}
 		if ret = helperDoubleEmphasis(p, out, data[2:], c); ret == 0 {
@@ -96,7 +149,7 @@ func emphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	}
 
 	if len(data) > 4 && data[1] == c && data[2] == c && data[3] != c {
-		if c == '~' || isspace(data[3]) {
+		if isDoubleOnly(c) || isspace(data[3]) {
 			return 0
 		}
 		if ret = helperTripleEmphasis(p, out, data, 3, c); ret == 0 {
@@ -109,28 +162,33 @@ func emphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	return 0
 }
 
+// isDoubleOnly reports whether c is the delimiter of a span that only
+// comes in the doubled form, such as ~~strikethrough~~.
+func isDoubleOnly(c byte) bool {
+	return c == '~' || c == '=' || c == '+'
+}
+
+// strikethroughTildes reports whether runs of n tildes mark strikethrough,
+// according to Options.Strikethrough.
+func (p *parser) strikethroughTildes(n int) bool {
+	switch p.strikethrough {
+	case STRIKETHROUGH_SINGLE:
+		return n == 1
+	case STRIKETHROUGH_BOTH:
+		return n == 1 || n == 2
+	}
+	return n == 2
+}
+
 func codeSpan(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	data = data[offset:]
 
-	nb := 0
-
 	// count the number of backticks in the delimiter
-	for nb < len(data) && data[nb] == '`' {
-		nb++
-	}
+	nb := p.skipBytes(data, 0, "`")
 
 	// find the next delimiter
-	i, end := 0, 0
-	for end = nb; end < len(data) && i < nb; end++ {
-		if data[end] == '`' {
-			i++
-		} else {
-			i = 0
-		}
-	}
-
-	// no matching delimiter?
-	if i < nb && end >= len(data) {
+	end := p.closingBackticks(data, nb)
+	if end == 0 {
 		return 0
 	}
 
@@ -174,8 +232,21 @@ func lineBreak(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		return 1
 	}
 
+	// CommonMark leaves out the spaces that start the next line
+	next := 1
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		next = p.skipBytes(data, offset+1, " ") - offset
+	}
+
 	// should there be a hard line break here?
 	if p.flags&EXTENSION_HARD_LINE_BREAK == 0 && !precededByTwoSpaces && !precededByBackslash {
+		if p.flags&EXTENSION_EAST_ASIAN_LINE_BREAKS != 0 {
+			return eastAsianLineBreak(data, offset)
+		}
+		if next > 1 {
+			p.r.NormalText(out, data[offset:offset+1])
+			return next
+		}
 		return 0
 	}
 
@@ -183,7 +254,32 @@ func lineBreak(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		out.Truncate(eol - 1)
 	}
 	p.r.LineBreak(out)
-	return 1
+	return next
+}
+
+// eastAsianLineBreak returns how much of data to skip at the newline at
+// offset to join the lines around it without a space, or 0 to keep the
+// newline. Chinese and Japanese are written without spaces between words,
+// so lines are joined like that when they end and start with a character
+// of either.
+func eastAsianLineBreak(data []byte, offset int) int {
+	before, _ := utf8.DecodeLastRune(bytes.TrimRight(data[:offset], " "))
+	next := bytes.TrimLeft(data[offset+1:], " ")
+	after, _ := utf8.DecodeRune(next)
+	if !isEastAsianWide(before) || !isEastAsianWide(after) {
+		return 0
+	}
+	return len(data) - offset - len(next)
+}
+
+// isEastAsianWide reports whether r is a Chinese or Japanese character,
+// or punctuation used with them. Korean is written with spaces between
+// words, so Hangul doesn't count.
+func isEastAsianWide(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		r >= 0x3000 && r <= 0x303f || // CJK symbols and punctuation
+		r >= 0xff01 && r <= 0xff60 || // fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6
 }
 
 type linkType int
@@ -195,6 +291,39 @@ const (
 	linkInlineFootnote
 )
 
+// imageSize parses the size at the end of an image destination, such as
+// "image.png =640x480". The width or the height can be left out, as in
+// "=640x" or "=x480". It returns the width and height attributes and the
+// length of the size, or 0 if the destination doesn't end with one.
+func imageSize(link []byte) (Attributes, int) {
+	i := len(link)
+	for i > 0 && isdigit(link[i-1]) {
+		i--
+	}
+	height := link[i:]
+	if i == 0 || link[i-1] != 'x' {
+		return nil, 0
+	}
+	i--
+	j := i
+	for i > 0 && isdigit(link[i-1]) {
+		i--
+	}
+	width := link[i:j]
+	if len(width) == 0 && len(height) == 0 || i < 2 || link[i-1] != '=' || !isspace(link[i-2]) {
+		return nil, 0
+	}
+
+	var size Attributes
+	if len(width) > 0 {
+		size = append(size, Attribute{Key: "width", Value: string(width)})
+	}
+	if len(height) > 0 {
+		size = append(size, Attribute{Key: "height", Value: string(height)})
+	}
+	return size, len(link) - i + 1
+}
+
 func isReferenceStyleLink(data []byte, pos int, t linkType) bool {
 	if t == linkDeferredFootnote {
 		return false
@@ -209,6 +338,27 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		return 0
 	}
 
+	if p.flags&EXTENSION_CITATIONS != 0 && !(offset > 0 && data[offset-1] == '!') {
+		if items, consumed := citation(data[offset:]); consumed > 0 {
+			p.cite(items)
+			p.r.Citation(out, items)
+			return consumed
+		}
+	}
+
+	// keys go before wiki links, so that [[Home]] is a key with both
+	if p.flags&EXTENSION_KBD != 0 {
+		if consumed := kbd(p, out, data, offset); consumed > 0 {
+			return consumed
+		}
+	}
+
+	if p.flags&EXTENSION_WIKI_LINKS != 0 && !p.insideLink {
+		if consumed := wikiLink(p, out, data, offset); consumed > 0 {
+			return consumed
+		}
+	}
+
 	var t linkType
 	switch {
 	// special case: ![^text] == deferred footnote (that follows something with
@@ -238,37 +388,19 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		noteId                  int
 		title, link, altContent []byte
 		textHasNl               = false
+		size                    Attributes
 	)
 
 	if t == linkDeferredFootnote {
 		i++
 	}
 
-	brace := 0
-
 	// look for the matching closing bracket
-	for level := 1; level > 0 && i < len(data); i++ {
-		switch {
-		case data[i] == '\n':
-			textHasNl = true
-
-		case data[i-1] == '\\':
-			continue
-
-		case data[i] == '[':
-			level++
-
-		case data[i] == ']':
-			level--
-			if level <= 0 {
-				i-- // compensate for extra i++ in for loop
-			}
-		}
-	}
-
+	i = p.closingBracket(data, i)
 	if i >= len(data) {
 		return 0
 	}
+	textHasNl = p.nextByte(data, 1, '\n') < i
 
 	txtE := i
 	i++
@@ -294,31 +426,7 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		// look for link end: ' " ), check for new opening braces and take this
 		// into account, this may lead for overshooting and probably will require
 		// some fine-tuning.
-	findlinkend:
-		for i < len(data) {
-			switch {
-			case data[i] == '\\':
-				i += 2
-
-			case data[i] == '(':
-				brace++
-				i++
-
-			case data[i] == ')':
-				if brace <= 0 {
-					break findlinkend
-				}
-				brace--
-				i++
-
-			case data[i] == '\'' || data[i] == '"':
-				break findlinkend
-
-			default:
-				i++
-			}
-		}
-
+		i = p.linkDestinationEnd(data, i)
 		if i >= len(data) {
 			return 0
 		}
@@ -330,20 +438,7 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			i++
 			titleB = i
 
-		findtitleend:
-			for i < len(data) {
-				switch {
-				case data[i] == '\\':
-					i += 2
-
-				case data[i] == ')':
-					break findtitleend
-
-				default:
-					i++
-				}
-			}
-
+			i = p.nextUnescaped(data, i, ")")
 			if i >= len(data) {
 				return 0
 			}
@@ -366,6 +461,17 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			linkE--
 		}
 
+		// an image can end with its size
+		if t == linkImg && p.flags&EXTENSION_IMAGE_SIZE != 0 {
+			var n int
+			if size, n = imageSize(data[linkB:linkE]); n > 0 {
+				linkE -= n
+				for linkE > linkB && isspace(data[linkE-1]) {
+					linkE--
+				}
+			}
+		}
+
 		// remove optional angle brackets around the link
 		if data[linkB] == '<' {
 			linkB++
@@ -393,13 +499,14 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		// look for the id
 		i++
 		linkB := i
-		for i < len(data) && data[i] != ']' {
-			i++
-		}
+		i = p.nextByte(data, i, ']')
 		if i >= len(data) {
 			return 0
 		}
 		linkE := i
+		if linkE-linkB > maxReferenceLength || linkB == linkE && txtE-1 > maxReferenceLength {
+			return 0
+		}
 
 		// find the reference
 		if linkB == linkE {
@@ -425,8 +532,12 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		}
 
 		// find the reference with matching id
-		lr, ok := p.getRef(string(id))
+		lr, ok := p.getRef(id)
+		if !ok {
+			lr, ok = p.resolveRef(string(id))
+		}
 		if !ok {
+			p.diagnose(DIAGNOSTIC_WARNING, data, "link to undefined reference %q", id)
 			return 0
 		}
 
@@ -441,6 +552,9 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	// shortcut reference style link or reference or inline footnote
 	default:
 		var id []byte
+		if t != linkInlineFootnote && txtE-1 > maxReferenceLength {
+			return 0
+		}
 
 		// craft the id
 		if textHasNl {
@@ -480,11 +594,13 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 				fragment = append([]byte("footnote-"), []byte(strconv.Itoa(noteId))...)
 			}
 
+			// the footnote is rendered after the blocks, when the text it
+			// is a part of may have been let go of
 			ref := &reference{
 				noteId:   noteId,
 				hasBlock: false,
 				link:     fragment,
-				title:    id,
+				title:    copyBytes(id),
 			}
 
 			p.notes = append(p.notes, ref)
@@ -494,8 +610,11 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			title = ref.title
 		} else {
 			// find the reference with matching id
-			lr, ok := p.getRef(string(id))
+			lr, ok := p.getRef(id)
 			if !ok {
+				if t == linkDeferredFootnote {
+					p.diagnose(DIAGNOSTIC_WARNING, data, "undefined footnote %q", id)
+				}
 				return 0
 			}
 
@@ -538,19 +657,66 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			uLink = uLinkBuf.Bytes()
 		}
 
-		// links need something to click on and somewhere to go
-		if len(uLink) == 0 || (t == linkNormal && content.Len() == 0) {
+		// [](#id) takes the title of the header it links to
+		if t == linkNormal && content.Len() == 0 && len(uLink) > 1 && uLink[0] == '#' &&
+			p.flags&EXTENSION_CROSS_REFERENCES != 0 {
+			if title, ok := p.headerTitles[string(uLink[1:])]; ok {
+				insideLink := p.insideLink
+				p.insideLink = true
+				p.inline(&content, title)
+				p.insideLink = insideLink
+			} else if !p.indexing {
+				p.diagnose(DIAGNOSTIC_WARNING, data, "link to undefined header %q", uLink)
+			}
+		}
+
+		// links need something to click on and somewhere to go, which
+		// CommonMark lets be empty
+		if (len(uLink) == 0 && p.flags&EXTENSION_COMMONMARK == 0) || (t == linkNormal && content.Len() == 0) {
 			return 0
 		}
 	}
 
+	// an attribute list can follow links and images
+	var attrs Attributes
+	if (t == linkNormal || t == linkImg) && p.flags&EXTENSION_ATTRIBUTES != 0 && i < len(data) {
+		if list, n := attributeList(data[i:]); n > 0 {
+			attrs = list
+			i += n
+		}
+	}
+
+	// sizes given in the attribute list win over the inline ones
+	for _, attr := range size {
+		if attrs.Get(attr.Key) == "" {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	// links to disallowed schemes are left as their text
+	if (t == linkNormal || t == linkImg) && !p.isAllowedLink(uLink) {
+		p.diagnose(DIAGNOSTIC_WARNING, data, "link to %q not allowed", uLink)
+		switch {
+		case t == linkImg:
+			if out.Len() > 0 && out.Bytes()[out.Len()-1] == '!' {
+				out.Truncate(out.Len() - 1)
+			}
+			p.r.NormalText(out, content.Bytes())
+		case len(altContent) > 0:
+			out.Write(altContent)
+		default:
+			out.Write(content.Bytes())
+		}
+		return i
+	}
+
 	// call the relevant rendering function
 	switch t {
 	case linkNormal:
 		if len(altContent) > 0 {
-			p.r.Link(out, uLink, title, altContent)
+			p.r.Link(out, uLink, title, altContent, attrs)
 		} else {
-			p.r.Link(out, uLink, title, content.Bytes())
+			p.r.Link(out, uLink, title, content.Bytes(), attrs)
 		}
 
 	case linkImg:
@@ -560,7 +726,13 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			out.Truncate(outSize - 1)
 		}
 
-		p.r.Image(out, uLink, title, content.Bytes())
+		if p.flags&EXTENSION_MEDIA_EMBED != 0 {
+			if kind := mediaKind(uLink); kind != 0 {
+				p.r.MediaEmbed(out, kind, uLink, title, content.Bytes())
+				break
+			}
+		}
+		p.r.Image(out, uLink, title, content.Bytes(), attrs)
 
 	case linkInlineFootnote:
 		outSize := out.Len()
@@ -581,6 +753,108 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	return i
 }
 
+// The names of the keys that [[Key]] marks up, in lower case, on top of
+// single characters and function keys.
+var kbdKeys = map[string]bool{
+	"alt": true, "altgr": true, "backspace": true, "break": true,
+	"capslock": true, "cmd": true, "command": true, "control": true,
+	"ctrl": true, "del": true, "delete": true, "down": true, "end": true,
+	"enter": true, "esc": true, "escape": true, "fn": true, "home": true,
+	"ins": true, "insert": true, "left": true, "menu": true, "meta": true,
+	"numlock": true, "option": true, "opt": true, "pagedown": true,
+	"pageup": true, "pause": true, "pgdn": true, "pgup": true,
+	"printscreen": true, "prtsc": true, "return": true, "right": true,
+	"scrolllock": true, "shift": true, "space": true, "super": true,
+	"tab": true, "up": true, "win": true, "windows": true,
+}
+
+// isKbdKey reports whether key names a key: a single character, a
+// function key such as F5, or one of kbdKeys.
+func isKbdKey(key []byte) bool {
+	if utf8.RuneCount(key) == 1 {
+		return !isspace(key[0])
+	}
+	name := strings.ToLower(string(key))
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "f")); err == nil && name[0] == 'f' && name[1] >= '1' && name[1] <= '9' {
+		return n >= 1 && n <= 24
+	}
+	return kbdKeys[name]
+}
+
+// [[Key]] keyboard input, such as [[Ctrl]]+[[C]]
+func kbd(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	if offset > 0 && data[offset-1] == '!' {
+		return 0
+	}
+	data = data[offset:]
+	if len(data) < 5 || data[1] != '[' {
+		return 0
+	}
+	end := bytes.Index(data, []byte("]]"))
+	if end < 3 || !isKbdKey(data[2:end]) {
+		return 0
+	}
+	p.r.Kbd(out, data[2:end])
+	return end + 2
+}
+
+// [[Page Name]] or [[Page Name|link text]] wiki link
+func wikiLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	if offset > 0 && data[offset-1] == '!' {
+		return 0
+	}
+	data = data[offset:]
+	if len(data) < 5 || data[1] != '[' {
+		return 0
+	}
+
+	// the link must be closed on the same line
+	i := 2
+	textStart := 0
+	for i+1 < len(data) && !(data[i] == ']' && data[i+1] == ']') {
+		if data[i] == '\n' || data[i] == '[' {
+			return 0
+		}
+		if data[i] == '|' && textStart == 0 {
+			textStart = i + 1
+		}
+		i++
+	}
+	if i+1 >= len(data) {
+		return 0
+	}
+
+	pageEnd := i
+	if textStart > 0 {
+		pageEnd = textStart - 1
+	}
+	page := bytes.TrimSpace(data[2:pageEnd])
+	if len(page) == 0 {
+		return 0
+	}
+
+	url := string(page)
+	if p.wikiResolver != nil {
+		var ok bool
+		if url, ok = p.wikiResolver(url); !ok || url == "" {
+			return 0
+		}
+	}
+
+	var content bytes.Buffer
+	if text := bytes.TrimSpace(data[textStart:i]); textStart > 0 && len(text) > 0 {
+		insideLink := p.insideLink
+		p.insideLink = true
+		p.inline(&content, text)
+		p.insideLink = insideLink
+	} else {
+		p.r.NormalText(&content, page)
+	}
+
+	p.r.Link(out, []byte(url), nil, content.Bytes(), nil)
+	return i + 2
+}
+
 func (p *parser) inlineHTMLComment(out *bytes.Buffer, data []byte) int {
 	if len(data) < 5 {
 		return 0
@@ -588,11 +862,8 @@ func (p *parser) inlineHTMLComment(out *bytes.Buffer, data []byte) int {
 	if data[0] != '<' || data[1] != '!' || data[2] != '-' || data[3] != '-' {
 		return 0
 	}
-	i := 5
 	// scan for an end-of-comment marker, across lines if necessary
-	for i < len(data) && !(data[i-2] == '-' && data[i-1] == '-' && data[i] == '>') {
-		i++
-	}
+	i := p.commentEnd(data, 5)
 	// no end-of-comment marker
 	if i >= len(data) {
 		return 0
@@ -600,18 +871,43 @@ func (p *parser) inlineHTMLComment(out *bytes.Buffer, data []byte) int {
 	return i + 1
 }
 
+// isSourceComment checks whether an HTML comment is written with three
+// dashes, <!--- like this --->, which EXTENSION_COMMENTS leaves out of the
+// output.
+func (p *parser) isSourceComment(comment []byte) bool {
+	return p.flags&EXTENSION_COMMENTS != 0 && len(comment) >= 9 &&
+		bytes.HasPrefix(comment, []byte("<!---")) && bytes.HasSuffix(comment, []byte("--->"))
+}
+
+// isCommentLine checks for a line starting with %%, which EXTENSION_COMMENTS
+// leaves out of the output.
+func isCommentLine(line []byte) bool {
+	i := 0
+	for i < 3 && i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return bytes.HasPrefix(line[i:], []byte("%%"))
+}
+
 // '<' when tags or autolinks are allowed
 func leftAngle(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	data = data[offset:]
 	altype := LINK_TYPE_NOT_AUTOLINK
-	end := tagLength(data, &altype)
+	end := tagLength(p, data, &altype)
 	if size := p.inlineHTMLComment(out, data); size > 0 {
+		if p.isSourceComment(data[:size]) {
+			return size
+		}
 		end = size
 	}
 	if end > 2 {
 		if altype != LINK_TYPE_NOT_AUTOLINK {
 			var uLink bytes.Buffer
 			unescapeText(&uLink, data[1:end+1-2])
+			if altype == LINK_TYPE_EMAIL && !p.isAllowedLink([]byte("mailto:")) ||
+				altype == LINK_TYPE_NORMAL && !p.isAllowedLink(uLink.Bytes()) {
+				return 0
+			}
 			if uLink.Len() > 0 {
 				p.r.AutoLink(out, uLink.Bytes(), altype)
 			}
@@ -624,20 +920,27 @@ func leftAngle(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 }
 
 // '\\' backslash escape
-var escapeChars = []byte("\\`*_{}[]()#+-.!:|&<>~")
+var escapeChars = []byte("\\`*_{}[]()#+-.!:|&<>~=")
 
 func escape(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	data = data[offset:]
 
 	if len(data) > 1 {
-		if bytes.IndexByte(escapeChars, data[1]) < 0 {
+		if data[1] == ' ' && p.flags&EXTENSION_ESCAPED_NBSP != 0 {
+			p.r.NormalText(out, nbsp)
+			return 2
+		}
+		// CommonMark escapes any ASCII punctuation
+		if bytes.IndexByte(escapeChars, data[1]) < 0 && (p.flags&EXTENSION_COMMONMARK == 0 || !ispunct(data[1])) {
 			return 0
 		}
 
 		p.r.NormalText(out, data[1:2])
+		return 2
 	}
 
-	return 2
+	// a backslash that ends the text is kept
+	return 0
 }
 
 func unescapeText(ob *bytes.Buffer, src []byte) {
@@ -656,8 +959,11 @@ func unescapeText(ob *bytes.Buffer, src []byte) {
 			break
 		}
 
-		ob.WriteByte(src[i+1])
-		i += 2
+		if ispunct(src[i+1]) {
+			i++
+		}
+		ob.WriteByte(src[i])
+		i++
 	}
 }
 
@@ -708,8 +1014,12 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 
 	anchorStr := anchorRe.Find(data[anchorStart:])
 	if anchorStr != nil {
-		out.Write(anchorStr[offsetFromAnchor:])
-		return len(anchorStr) - offsetFromAnchor
+		// the rest of the text of the anchor, and its end tag
+		rest := anchorStr[offsetFromAnchor:]
+		end := len(rest) - len("</a>")
+		p.r.NormalText(out, rest[:end])
+		p.r.RawHtmlTag(out, rest[end:])
+		return len(rest)
 	}
 
 	// scan backward for a word boundary
@@ -724,7 +1034,7 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	origData := data
 	data = data[offset-rewind:]
 
-	if !isSafeLink(data) {
+	if !isSafeLink(data) || !p.isAllowedLink(data) {
 		return 0
 	}
 
@@ -806,6 +1116,7 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	if out.Len() >= rewind {
 		out.Truncate(len(out.Bytes()) - rewind)
 	}
+	p.rewound = rewind
 
 	var uLink bytes.Buffer
 	unescapeText(&uLink, data[:linkEnd])
@@ -817,10 +1128,284 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	return linkEnd - rewind
 }
 
+// 'w': a www. link without a scheme
+func wwwAutoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	// the link must start a word, possibly inside emphasis or parentheses
+	if p.insideLink || offset > 0 && !isspace(data[offset-1]) &&
+		bytes.IndexByte([]byte("*_~("), data[offset-1]) < 0 {
+		return 0
+	}
+
+	data = data[offset:]
+	if !bytes.HasPrefix(data, []byte("www.")) || len(data) < 5 || !isalnum(data[4]) {
+		return 0
+	}
+	if !p.isAllowedLink([]byte("http:")) {
+		return 0
+	}
+
+	end := 4
+	for end < len(data) && !isEndOfLink(data[end]) {
+		end++
+	}
+	end = extendedAutoLinkEnd(data, end)
+
+	p.r.AutoLink(out, data[:end], LINK_TYPE_WWW)
+	return end
+}
+
+// extendedAutoLinkEnd trims trailing punctuation off the link data[:end],
+// keeping closing parentheses that balance an opening one.
+func extendedAutoLinkEnd(data []byte, end int) int {
+	for end > 0 {
+		switch c := data[end-1]; {
+		case bytes.IndexByte([]byte("?!.,:*_~'\""), c) >= 0:
+			end--
+		case c == ')' && bytes.Count(data[:end], []byte("(")) < bytes.Count(data[:end], []byte(")")):
+			end--
+		case c == ';' && linkEndsWithEntity(data, end):
+			end = bytes.LastIndexByte(data[:end], '&')
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+// '.': a bare domain such as example.com/path, found when the part after
+// the dot turns out to be a known top-level domain
+func domainAutoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	if p.insideLink {
+		return 0
+	}
+
+	// scan backward for the start of the domain, which must start a word.
+	// Only the first dot of a run of domain characters is looked at: the
+	// later ones would find the same start, so each run is scanned once.
+	start := offset
+	for start > 0 && isDomainChar(data[start-1]) {
+		if data[start-1] == '.' {
+			return 0
+		}
+		start--
+	}
+	if start == offset || start > 0 && !isspace(data[start-1]) &&
+		bytes.IndexByte([]byte("*_~("), data[start-1]) < 0 {
+		return 0
+	}
+	if !bytes.HasSuffix(out.Bytes(), data[start:offset]) || !p.isAllowedLink([]byte("http:")) {
+		return 0
+	}
+
+	end := scanDomain(data, start)
+	if end <= offset || end < len(data) && data[end] == '@' {
+		return 0
+	}
+	if end < len(data) && data[end] == '/' {
+		for end < len(data) && !isEndOfLink(data[end]) {
+			end++
+		}
+		end = start + extendedAutoLinkEnd(data[start:], end-start)
+	}
+
+	// we were triggered on the '.', so we need to rewind the output a bit
+	out.Truncate(out.Len() - (offset - start))
+	p.rewound = offset - start
+	p.r.AutoLink(out, data[start:end], LINK_TYPE_WWW)
+	return end - offset
+}
+
+// '@': an email address without mailto:, or failing that a mention
+func emailAutoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	start := offset
+	for start > 0 && (isalnum(data[start-1]) || bytes.IndexByte([]byte(".+-_"), data[start-1]) >= 0) {
+		start--
+	}
+	if !p.insideLink && start < offset && (start == 0 || data[start-1] != '@') &&
+		bytes.HasSuffix(out.Bytes(), data[start:offset]) &&
+		p.isAllowedLink([]byte("mailto:")) {
+		if end := scanDomain(data, offset+1); end > 0 && (end == len(data) || data[end] != '@') {
+			out.Truncate(out.Len() - (offset - start))
+			p.rewound = offset - start
+			p.r.AutoLink(out, data[start:end], LINK_TYPE_EMAIL)
+			return end - offset
+		}
+	}
+
+	if p.flags&EXTENSION_MENTIONS != 0 {
+		return mention(p, out, data, offset)
+	}
+	return 0
+}
+
+func isDomainChar(c byte) bool {
+	return isalnum(c) || c == '-' || c == '_' || c == '.'
+}
+
+// scanDomain scans a domain name of two or more labels starting at
+// data[i], ending in a known top-level domain. It returns the index just
+// past the domain, or 0 if there isn't one. A final dot is left out, as it
+// most likely ends a sentence.
+func scanDomain(data []byte, i int) int {
+	end := i
+	for end < len(data) && isDomainChar(data[end]) {
+		end++
+	}
+	for end > i && data[end-1] == '.' {
+		end--
+	}
+
+	labels := bytes.Split(data[i:end], []byte("."))
+	if len(labels) < 2 {
+		return 0
+	}
+	for j, label := range labels {
+		if len(label) == 0 || j >= len(labels)-2 && bytes.IndexByte(label, '_') >= 0 {
+			return 0
+		}
+	}
+	if _, ok := autoLinkTLDs[strings.ToLower(string(labels[len(labels)-1]))]; !ok {
+		return 0
+	}
+	return end
+}
+
+// autoLinkTLDs are the top-level domains of bare domain links. Country
+// codes that are also common file extensions, such as .md, .py and .sh,
+// are left out.
+var autoLinkTLDs = map[string]struct{}{
+	"com": {}, "org": {}, "net": {}, "edu": {}, "gov": {}, "mil": {},
+	"int": {}, "info": {}, "biz": {}, "io": {}, "dev": {}, "app": {},
+	"co": {}, "me": {}, "us": {}, "uk": {}, "eu": {}, "de": {}, "fr": {},
+	"nl": {}, "be": {}, "ch": {}, "at": {}, "se": {}, "no": {}, "dk": {},
+	"fi": {}, "es": {}, "it": {}, "pt": {}, "pl": {}, "cz": {}, "ru": {},
+	"ca": {}, "au": {}, "nz": {}, "jp": {}, "cn": {}, "kr": {}, "br": {},
+	"mx": {}, "ar": {}, "za": {},
+}
+
+// '{': a {{name}} placeholder, replaced with the value of the variable.
+// Anything else is left to CriticMarkup, if it is enabled.
+func variable(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	data = data[offset:]
+	if !bytes.HasPrefix(data, []byte("{{")) {
+		if p.flags&EXTENSION_CRITIC_MARKUP != 0 {
+			return criticMarkup(p, out, data, 0)
+		}
+		return 0
+	}
+
+	end := p.nextString(data, 2, "}}")
+	if end == len(data) {
+		return 0
+	}
+	name := bytes.TrimSpace(data[2:end])
+	if len(name) == 0 || !isletter(name[0]) && name[0] != '_' {
+		return 0
+	}
+	for _, c := range name {
+		if !isalnum(c) && c != '_' && c != '.' && c != '-' {
+			return 0
+		}
+	}
+
+	value, ok := p.variables[string(name)]
+	if !ok && p.varResolver != nil {
+		value, ok = p.varResolver(string(name))
+	}
+	if !ok {
+		p.diagnose(DIAGNOSTIC_WARNING, data, "undefined variable %q", name)
+		return 0
+	}
+	p.r.NormalText(out, []byte(value))
+	return end + 2
+}
+
+// '@' or '#': a user mention or an issue reference
+func mention(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	// mentions are not recognized inside links or in the middle of a word,
+	// which also rules out email addresses
+	if p.insideLink || (offset > 0 && (isalnum(data[offset-1]) || data[offset-1] == '_' || data[offset-1] == '.')) {
+		return 0
+	}
+
+	data = data[offset:]
+	kind := MENTION_TYPE_USER
+	end := 1
+	if data[0] == '#' {
+		kind = MENTION_TYPE_ISSUE
+		for end < len(data) && isdigit(data[end]) {
+			end++
+		}
+	} else {
+		// user names are alphanumeric with inner dashes,
+		// optionally followed by a /team name
+		end = scanMentionName(data, end)
+		if end > 1 && end+1 < len(data) && data[end] == '/' && isalnum(data[end+1]) {
+			end = scanMentionName(data, end+1)
+		}
+	}
+
+	if end == 1 || (end < len(data) && (isalnum(data[end]) || data[end] == '_')) {
+		return 0
+	}
+
+	p.r.Mention(out, kind, data[1:end])
+	return end
+}
+
+func scanMentionName(data []byte, i int) int {
+	if i >= len(data) || !isalnum(data[i]) {
+		return i
+	}
+	for i < len(data) && (isalnum(data[i]) || data[i] == '-') {
+		i++
+	}
+	for data[i-1] == '-' {
+		i--
+	}
+	return i
+}
+
 func isEndOfLink(char byte) bool {
 	return isspace(char) || char == '<'
 }
 
+// isAllowedLink reports whether link has no scheme or one of the
+// allowed schemes.
+func (p *parser) isAllowedLink(link []byte) bool {
+	if p.allowedSchemes == nil {
+		return true
+	}
+	scheme := linkScheme(link)
+	if scheme == "" {
+		return true
+	}
+	for _, allowed := range p.allowedSchemes {
+		if scheme == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// linkScheme returns the scheme of a URL in lower case, or "" if it has
+// none.
+func linkScheme(link []byte) string {
+	for i, c := range link {
+		switch {
+		case c == ':':
+			if i == 0 {
+				return ""
+			}
+			return strings.ToLower(string(link[:i]))
+		case isletter(c), i > 0 && (isdigit(c) || c == '+' || c == '-' || c == '.'):
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
 var validUris = [][]byte{[]byte("http://"), []byte("https://"), []byte("ftp://"), []byte("mailto://")}
 var validPaths = [][]byte{[]byte("/"), []byte("./"), []byte("../")}
 
@@ -847,7 +1432,7 @@ func isSafeLink(link []byte) bool {
 }
 
 // return the length of the given tag, or 0 is it's not valid
-func tagLength(data []byte, autolink *int) int {
+func tagLength(p *parser, data []byte, autolink *int) int {
 	var i, j int
 
 	// a valid tag can't be shorter than 3 chars
@@ -895,18 +1480,7 @@ func tagLength(data []byte, autolink *int) int {
 		*autolink = LINK_TYPE_NOT_AUTOLINK
 	case *autolink != 0:
 		j = i
-
-		for i < len(data) {
-			if data[i] == '\\' {
-				i += 2
-			} else if data[i] == '>' || data[i] == '\'' || data[i] == '"' || isspace(data[i]) {
-				break
-			} else {
-				i++
-			}
-
-		}
-
+		i = p.nextUnescaped(data, i, ">'\" \t\n\r\f\v")
 		if i >= len(data) {
 			return 0
 		}
@@ -919,9 +1493,7 @@ func tagLength(data []byte, autolink *int) int {
 	}
 
 	// look for something looking like a tag end
-	for i < len(data) && data[i] != '>' {
-		i++
-	}
+	i = p.nextByte(data, i, '>')
 	if i >= len(data) {
 		return 0
 	}
@@ -961,13 +1533,18 @@ func isMailtoAutoLink(data []byte) int {
 }
 
 // look for the next emph char, skipping other constructs
-func helperFindEmphChar(data []byte, c byte) int {
+func helperFindEmphChar(p *parser, data []byte, c byte) int {
+	// the next c, looked up again once i passes it
+	nextC := -1
 	i := 0
 
 	for i < len(data) {
-		for i < len(data) && data[i] != c && data[i] != '`' && data[i] != '[' {
-			i++
+		if nextC < i {
+			nextC = p.nextByte(data, i, c)
 		}
+		// a code span or a link before it
+		code := p.nextByte(data[:nextC], i, '`')
+		i = p.nextByte(data[:code], i, '[')
 		if i >= len(data) {
 			return 0
 		}
@@ -984,12 +1561,14 @@ func helperFindEmphChar(data []byte, c byte) int {
 			// skip a code span
 			tmpI := 0
 			i++
-			for i < len(data) && data[i] != '`' {
-				if tmpI == 0 && data[i] == c {
-					tmpI = i
-				}
-				i++
+			end := p.nextByte(data, i, '`')
+			if nextC < i {
+				nextC = p.nextByte(data, i, c)
 			}
+			if nextC < end {
+				tmpI = nextC
+			}
+			i = end
 			if i >= len(data) {
 				return tmpI
 			}
@@ -998,16 +1577,14 @@ func helperFindEmphChar(data []byte, c byte) int {
 			// skip a link
 			tmpI := 0
 			i++
-			for i < len(data) && data[i] != ']' {
-				if tmpI == 0 && data[i] == c {
-					tmpI = i
-				}
-				i++
+			end := p.nextByte(data, i, ']')
+			if nextC < i {
+				nextC = p.nextByte(data, i, c)
 			}
-			i++
-			for i < len(data) && (data[i] == ' ' || data[i] == '\n') {
-				i++
+			if nextC < end {
+				tmpI = nextC
 			}
+			i = p.skipBytes(data, end+1, " \n")
 			if i >= len(data) {
 				return tmpI
 			}
@@ -1020,12 +1597,14 @@ func helperFindEmphChar(data []byte, c byte) int {
 			}
 			cc := data[i]
 			i++
-			for i < len(data) && data[i] != cc {
-				if tmpI == 0 && data[i] == c {
-					return i
-				}
-				i++
+			end = p.nextByte(data, i, cc)
+			if nextC < i {
+				nextC = p.nextByte(data, i, c)
+			}
+			if tmpI == 0 && nextC < end {
+				return nextC
 			}
+			i = end
 			if i >= len(data) {
 				return tmpI
 			}
@@ -1044,7 +1623,7 @@ func helperEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int {
 	}
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(p, data[i:], c)
 		if length == 0 {
 			return 0
 		}
@@ -1060,15 +1639,19 @@ func helperEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int {
 
 		if data[i] == c && !isspace(data[i-1]) {
 
-			if p.flags&EXTENSION_NO_INTRA_EMPHASIS != 0 {
-				if !(i+1 == len(data) || isspace(data[i+1]) || ispunct(data[i+1])) {
+			if p.flags&EXTENSION_NO_INTRA_EMPHASIS != 0 || p.flags&EXTENSION_COMMONMARK != 0 && c == '_' {
+				if isalnumAt(data, i+1) {
 					continue
 				}
 			}
 
 			var work bytes.Buffer
 			p.inline(&work, data[:i])
-			p.r.Emphasis(out, work.Bytes())
+			if c == '~' {
+				p.r.StrikeThrough(out, work.Bytes())
+			} else {
+				p.r.Emphasis(out, work.Bytes())
+			}
 			return i + 1
 		}
 	}
@@ -1080,7 +1663,7 @@ func helperDoubleEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int
 	i := 0
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(p, data[i:], c)
 		if length == 0 {
 			return 0
 		}
@@ -1092,9 +1675,14 @@ func helperDoubleEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int
 
 			if work.Len() > 0 {
 				// pick the right renderer
-				if c == '~' {
+				switch c {
+				case '~':
 					p.r.StrikeThrough(out, work.Bytes())
-				} else {
+				case '=':
+					p.r.Highlight(out, work.Bytes())
+				case '+':
+					p.r.Insert(out, work.Bytes())
+				default:
 					p.r.DoubleEmphasis(out, work.Bytes())
 				}
 			}
@@ -1111,7 +1699,7 @@ func helperTripleEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int,
 	data = data[offset:]
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(p, data[i:], c)
 		if length == 0 {
 			return 0
 		}
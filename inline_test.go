@@ -14,12 +14,16 @@
 package blackfriday
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
 )
 
-func runMarkdownInline(input string, opts Options, htmlFlags int, params HtmlRendererParameters) string {
+func runMarkdownInline(input string, opts Options, htmlFlags HtmlFlags, params HtmlRendererParameters) string {
 	opts.Extensions |= EXTENSION_AUTOLINK
 	opts.Extensions |= EXTENSION_STRIKETHROUGH
 
@@ -55,7 +59,7 @@ func doSafeTestsInline(t *testing.T, tests []string) {
 	doTestsInlineParam(t, transformTests, Options{}, HTML_SAFELINK, params)
 }
 
-func doTestsInlineParam(t *testing.T, tests []string, opts Options, htmlFlags int,
+func doTestsInlineParam(t *testing.T, tests []string, opts Options, htmlFlags HtmlFlags,
 	params HtmlRendererParameters) {
 	// catch and report panics
 	var candidate string
@@ -74,6 +78,11 @@ func doTestsInlineParam(t *testing.T, tests []string, opts Options, htmlFlags in
 			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
 				candidate, expected, actual)
 		}
+		treeOpts := opts
+		treeOpts.Extensions |= EXTENSION_AUTOLINK | EXTENSION_STRIKETHROUGH
+		checkRoundTrip(t, input, treeOpts, func() Renderer {
+			return HtmlRendererWithParameters(htmlFlags|HTML_USE_XHTML, "", "", params)
+		})
 
 		// now test every substring to stress test bounds checking
 		if !testing.Short() {
@@ -176,10 +185,47 @@ func TestNoIntraEmphasis(t *testing.T) {
 
 		"un*frigging*believable\n",
 		"<p>un*frigging*believable</p>\n",
+
+		// words in any script
+		"un*frigging*élan\n",
+		"<p>un*frigging*élan</p>\n",
+
+		"これは*強調*です\n",
+		"<p>これは*強調*です</p>\n",
+
+		"*強調*。\n",
+		"<p><em>強調</em>。</p>\n",
+
+		"_emphasis_\u00a0and\n",
+		"<p><em>emphasis</em>\u00a0and</p>\n",
 	}
 	doTestsInlineParam(t, tests, Options{
 		Extensions: EXTENSION_NO_INTRA_EMPHASIS},
 		0, HtmlRendererParameters{})
+
+	// CommonMark doesn't open underscore emphasis inside a word either
+	tests = []string{
+		"café_crème_.\n",
+		"<p>café_crème_.</p>\n",
+
+		"日本_語_です\n",
+		"<p>日本_語_です</p>\n",
+
+		"_強調_。\n",
+		"<p><em>強調</em>。</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{
+		Extensions: EXTENSION_COMMONMARK},
+		0, HtmlRendererParameters{})
+}
+
+func BenchmarkNoIntraEmphasis(b *testing.B) {
+	input := strings.Repeat("snake_case_name and *emphasis* in naïve_café_text, 日本_語_です and _強調_。 ", 100) + "\n"
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runMarkdownInline(input, Options{Extensions: EXTENSION_NO_INTRA_EMPHASIS}, 0, HtmlRendererParameters{})
+	}
 }
 
 func TestReferenceOverride(t *testing.T) {
@@ -239,6 +285,87 @@ func TestReferenceOverride(t *testing.T) {
 		}}, 0, HtmlRendererParameters{})
 }
 
+func TestExternalReferences(t *testing.T) {
+	var tests = []string{
+		"See [docs] and [the FAQ][faq].\n",
+		"<p>See <a href=\"/docs/\" title=\"Documentation\">docs</a> and <a href=\"/faq/\">the FAQ</a>.</p>\n",
+
+		// the document's own definitions win
+		"See [docs].\n\n[docs]: /local/\n",
+		"<p>See <a href=\"/local/\">docs</a>.</p>\n",
+
+		"[Missing] stays\n",
+		"<p>[Missing] stays</p>\n",
+	}
+	opts := Options{References: map[string]Reference{
+		"Docs": {Link: "/docs/", Title: "Documentation"},
+		"FAQ":  {Link: "/faq/"},
+	}}
+	doTestsInlineParam(t, tests, opts, 0, HtmlRendererParameters{})
+}
+
+func TestShortcodeSmartypants(t *testing.T) {
+	var tests = []string{
+		"\"Quoted\" {{< ref \"page.md\" >}} -- {% link 'a.md' %}\n",
+		"<p>&ldquo;Quoted&rdquo; {{< ref \"page.md\" >}} &mdash; {% link 'a.md' %}</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_SHORTCODES},
+		HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_DASHES, HtmlRendererParameters{})
+
+	tests = []string{
+		"A {{< year >}} and {{< other >}}\n",
+		"<p>A 2026 and {{< other >}}</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{
+		Extensions: EXTENSION_SHORTCODES | EXTENSION_CROSS_REFERENCES,
+		ShortcodeResolver: func(shortcode string) (string, bool) {
+			if shortcode == "{{< year >}}" {
+				return "2026", true
+			}
+			return "", false
+		},
+	}, 0, HtmlRendererParameters{})
+}
+
+func TestUnresolvedReference(t *testing.T) {
+	var unresolved []string
+	opts := Options{
+		Extensions: EXTENSION_CROSS_REFERENCES,
+		UnresolvedReference: func(reference string) (Reference, bool) {
+			unresolved = append(unresolved, reference)
+			if reference == "issue 12" {
+				return Reference{Link: "https://example.com/issues/12"}, true
+			}
+			return Reference{}, false
+		},
+	}
+	input := "See [the fix][issue 12], [this][defined], [that][missing] and [plain].\n\n[defined]: /defined\n"
+	expected := "<p>See <a href=\"https://example.com/issues/12\">the fix</a>, <a href=\"/defined\">this</a>, [that][missing] and [plain].</p>\n"
+
+	output, diagnostics, err := MarkdownDiagnostics([]byte(input), HtmlRenderer(0, "", ""), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", expected, output)
+	}
+	if !reflect.DeepEqual(unresolved, []string{"issue 12", "missing"}) {
+		t.Errorf("unexpected calls for %q", unresolved)
+	}
+	if len(diagnostics) != 1 || diagnostics[0].String() != "line 1: warning: link to undefined reference \"missing\"" {
+		t.Errorf("unexpected diagnostics %v", diagnostics)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	input := "[Docs]: /docs/ \"Documentation\"\n[^1]: A note.\n\n```\n[code]: /not-a-ref/\n```\n"
+	refs := References([]byte(input), Options{Extensions: EXTENSION_FOOTNOTES | EXTENSION_FENCED_CODE})
+	expected := map[string]Reference{"docs": {Link: "/docs/", Title: "Documentation"}}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, refs)
+	}
+}
+
 func TestStrong(t *testing.T) {
 	var tests = []string{
 		"nothing inline\n",
@@ -344,6 +471,41 @@ func TestEmphasisLink(t *testing.T) {
 	doTestsInline(t, tests)
 }
 
+func TestEmphasisCommonMark(t *testing.T) {
+	var tests = []string{
+		"***triple emphasis***\n",
+		"<p><em><strong>triple emphasis</strong></em></p>\n",
+
+		"*foo**bar*\n",
+		"<p><em>foo**bar</em></p>\n",
+
+		"**foo*\n",
+		"<p>*<em>foo</em></p>\n",
+
+		"*foo *bar**\n",
+		"<p><em>foo <em>bar</em></em></p>\n",
+
+		"_foo_bar_baz_\n",
+		"<p><em>foo_bar_baz</em></p>\n",
+
+		"a*\"foo\"*\n",
+		"<p>a*&quot;foo&quot;*</p>\n",
+
+		"*(*foo*)*\n",
+		"<p><em>(<em>foo</em>)</em></p>\n",
+
+		"*foo [*bar*](/url)*\n",
+		"<p><em>foo <a href=\"/url\"><em>bar</em></a></em></p>\n",
+
+		"*[bar*](/url)\n",
+		"<p>*<a href=\"/url\">bar*</a></p>\n",
+
+		"*a `*`*\n",
+		"<p><em>a <code>*</code></em></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_COMMONMARK}, 0, HtmlRendererParameters{})
+}
+
 func TestStrikeThrough(t *testing.T) {
 	var tests = []string{
 		"nothing inline\n",
@@ -373,6 +535,144 @@ func TestStrikeThrough(t *testing.T) {
 	doTestsInline(t, tests)
 }
 
+func TestStrikeThroughTildes(t *testing.T) {
+	var single = []string{
+		"one ~tilde~ and ~~two~~\n",
+		"<p>one <del>tilde</del> and ~~two~~</p>\n",
+
+		"~ not struck~\n",
+		"<p>~ not struck~</p>\n",
+	}
+	doTestsInlineParam(t, single, Options{
+		Extensions:    EXTENSION_STRIKETHROUGH,
+		Strikethrough: STRIKETHROUGH_SINGLE,
+	}, 0, HtmlRendererParameters{})
+
+	var both = []string{
+		"one ~tilde~ and ~~two~~\n",
+		"<p>one <del>tilde</del> and <del>two</del></p>\n",
+
+		"~mismatched~~ tildes\n",
+		"<p>~mismatched~~ tildes</p>\n",
+	}
+	doTestsInlineParam(t, both, Options{
+		Extensions:    EXTENSION_STRIKETHROUGH,
+		Strikethrough: STRIKETHROUGH_BOTH,
+	}, 0, HtmlRendererParameters{})
+
+	var double = []string{
+		"one ~tilde~ and ~~two~~\n",
+		"<p>one ~tilde~ and <del>two</del></p>\n",
+	}
+	doTestsInlineParam(t, double, Options{Extensions: EXTENSION_STRIKETHROUGH}, 0, HtmlRendererParameters{})
+}
+
+func TestKbd(t *testing.T) {
+	var tests = []string{
+		"Press [[Ctrl]]+[[C]] to copy.\n",
+		"<p>Press <kbd>Ctrl</kbd>+<kbd>C</kbd> to copy.</p>\n",
+
+		"[[shift]]+[[F5]], [[Esc]] or [[<]]\n",
+		"<p><kbd>shift</kbd>+<kbd>F5</kbd>, <kbd>Esc</kbd> or <kbd>&lt;</kbd></p>\n",
+
+		"[[Not a key]] and [[F25]] and [[F+1]]\n",
+		"<p>[[Not a key]] and [[F25]] and [[F+1]]</p>\n",
+
+		"![[C]] and [[C]](/url)\n",
+		"<p>![[C]] and <kbd>C</kbd>(/url)</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_KBD}, 0, HtmlRendererParameters{})
+
+	// keys go before wiki links
+	tests = []string{
+		"[[Home]] and [[Main Page]]\n",
+		"<p><kbd>Home</kbd> and <a href=\"Main Page\">Main Page</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_KBD | EXTENSION_WIKI_LINKS}, 0, HtmlRendererParameters{})
+}
+
+func TestRuby(t *testing.T) {
+	var tests = []string{
+		"{漢字|かんじ}を読む\n",
+		"<p><ruby>漢字<rp>(</rp><rt>かんじ</rt><rp>)</rp></ruby>を読む</p>\n",
+
+		"{漢字|かん|じ}\n",
+		"<p><ruby>漢<rp>(</rp><rt>かん</rt><rp>)</rp></ruby><ruby>字<rp>(</rp><rt>じ</rt><rp>)</rp></ruby></p>\n",
+
+		"{*強調*|きょうちょう}\n",
+		"<p><ruby><em>強調</em><rp>(</rp><rt>きょうちょう</rt><rp>)</rp></ruby></p>\n",
+
+		"{漢字|か|ん|じ} {no reading|} {|} {a\n|b}\n",
+		"<p>{漢字|か|ん|じ} {no reading|} {|} {a\n|b}</p>\n",
+
+		"{++added++} and {>>a | comment<<}\n",
+		"<p><ins>added</ins> and <span class=\"critic-comment\">a | comment</span></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_RUBY | EXTENSION_CRITIC_MARKUP}, 0, HtmlRendererParameters{})
+
+	tests = []string{
+		"｜漢字《かんじ》と{漢字|かんじ}\n",
+		"<p><ruby>漢字<rp>(</rp><rt>かんじ</rt><rp>)</rp></ruby>と{漢字|かんじ}</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{
+		Extensions:     EXTENSION_RUBY,
+		RubyDelimiters: [3]string{"｜", "《", "》"},
+	}, 0, HtmlRendererParameters{})
+}
+
+func TestHighlightAndInsert(t *testing.T) {
+	var tests = []string{
+		"simple ==marked== and ++inserted++ text\n",
+		"<p>simple <mark>marked</mark> and <ins>inserted</ins> text</p>\n",
+
+		"==*nested* ++spans++==\n",
+		"<p><mark><em>nested</em> <ins>spans</ins></mark></p>\n",
+
+		"over ==two\nlines== test\n",
+		"<p>over <mark>two\nlines</mark> test</p>\n",
+
+		"not spans: a == b, C++ and C++, =single=, and +single+\n",
+		"<p>not spans: a == b, C++ and C++, =single=, and +single+</p>\n",
+
+		"escaped \\==marks== and \\++marks++\n",
+		"<p>escaped ==marks== and ++marks++</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_HIGHLIGHT | EXTENSION_INSERT}, 0, HtmlRendererParameters{})
+
+	// without the extensions the markers are left alone
+	doTestsInline(t, []string{
+		"==marked== and ++inserted++\n",
+		"<p>==marked== and ++inserted++</p>\n",
+	})
+}
+
+func TestCriticMarkup(t *testing.T) {
+	input := "a {++new *word*++}, {--old--}, {~~this~>that~~}{>>why?<<} and {==this==}\n"
+	opts := Options{Extensions: EXTENSION_CRITIC_MARKUP}
+
+	doTestsInlineParam(t, []string{
+		input,
+		"<p>a <ins>new <em>word</em></ins>, <del>old</del>, <del>this</del><ins>that</ins>" +
+			"<span class=\"critic-comment\">why?</span> and <mark>this</mark></p>\n",
+
+		"not closed: {++open\n",
+		"<p>not closed: {++open</p>\n",
+
+		"not annotations: {+-x-+}, {~~no arrow~~} and \\{++escaped++}\n",
+		"<p>not annotations: {+-x-+}, {<del>no arrow</del>} and {++escaped++}</p>\n",
+	}, opts, 0, HtmlRendererParameters{})
+
+	doTestsInlineParam(t, []string{
+		input,
+		"<p>a new <em>word</em>, , that and this</p>\n",
+	}, opts, HTML_CRITIC_ACCEPT, HtmlRendererParameters{})
+
+	doTestsInlineParam(t, []string{
+		input,
+		"<p>a , old, this and this</p>\n",
+	}, opts, HTML_CRITIC_REJECT, HtmlRendererParameters{})
+}
+
 func TestCodeSpan(t *testing.T) {
 	var tests = []string{
 		"`source code`\n",
@@ -411,6 +711,26 @@ func TestCodeSpan(t *testing.T) {
 	doTestsInline(t, tests)
 }
 
+func TestCodeSpanFarCloser(t *testing.T) {
+	// past spanScanLimit, the closing backticks are found in the index
+	long := strings.Repeat("a`", 40)
+	ticks := strings.Repeat("`", 70)
+	var tests = []string{
+		"``" + long + "``` and `b`\n",
+		"<p><code>" + long[:len(long)-1] + "</code>`<code>and</code>b`</p>\n",
+
+		"``" + long + "\n",
+		"<p>`" + strings.Repeat("<code>a</code>a", 20) + "`</p>\n",
+
+		ticks + "a" + ticks + "\n",
+		"<p><code>a</code></p>\n",
+
+		ticks + long + "\n",
+		"<p>" + ticks[1:] + strings.Repeat("<code>a</code>a", 20) + "`</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
 func TestLineBreak(t *testing.T) {
 	var tests = []string{
 		"this line  \nhas a break\n",
@@ -653,6 +973,97 @@ func TestHrefTargetBlank(t *testing.T) {
 	doTestsInlineParam(t, tests, Options{}, HTML_SAFELINK|HTML_HREF_TARGET_BLANK, HtmlRendererParameters{})
 }
 
+func TestExternalLinks(t *testing.T) {
+	var tests = []string{
+		"[foo](http://example.com/foo)\n",
+		"<p><a href=\"http://example.com/foo\" rel=\"nofollow noopener\" target=\"_blank\">foo</a></p>\n",
+
+		"[foo](//example.com/foo)\n",
+		"<p><a href=\"//example.com/foo\" rel=\"nofollow noopener\" target=\"_blank\">foo</a></p>\n",
+
+		"[foo](mailto:alice@example.com)\n",
+		"<p><a href=\"mailto:alice@example.com\" rel=\"nofollow noopener\" target=\"_blank\">foo</a></p>\n",
+
+		"<http://example.com/foo>\n",
+		"<p><a href=\"http://example.com/foo\" rel=\"nofollow noopener\" target=\"_blank\">http://example.com/foo</a></p>\n",
+
+		// relative links are never external
+		"[foo](page.html) and [bar](docs/guide.md#intro)\n",
+		"<p><a href=\"page.html\">foo</a> and <a href=\"docs/guide.md#intro\">bar</a></p>\n",
+
+		"[foo](/page.html)\n",
+		"<p><a href=\"/page.html\">foo</a></p>\n",
+	}
+	flags := HTML_NOFOLLOW_LINKS | HTML_NOOPENER_LINKS | HTML_HREF_TARGET_BLANK
+	doTestsInlineParam(t, tests, Options{}, flags, HtmlRendererParameters{})
+
+	// links to the host of the absolute prefix are on the same site
+	tests = []string{
+		"[foo](https://Blog.Example.com/foo)\n",
+		"<p><a href=\"https://Blog.Example.com/foo\">foo</a></p>\n",
+
+		"[foo](http://example.com/foo)\n",
+		"<p><a href=\"http://example.com/foo\" rel=\"nofollow noopener\" target=\"_blank\">foo</a></p>\n",
+
+		"[foo](/foo)\n",
+		"<p><a href=\"https://blog.example.com/foo\">foo</a></p>\n",
+	}
+	params := HtmlRendererParameters{AbsolutePrefix: "https://blog.example.com"}
+	doTestsInlineParam(t, tests, Options{}, flags, params)
+}
+
+func TestRawHtmlFlags(t *testing.T) {
+	var input = "<div>\nblock\n</div>\n\n" +
+		"some <em>inline</em> html, a [link](/x) and ![an image](/y.png)\n"
+
+	doTestsInlineParam(t, []string{
+		input,
+		"<p>some inline html, a <a href=\"/x\">link</a> and <img src=\"/y.png\" alt=\"an image\" /></p>\n",
+	}, Options{}, HTML_SKIP_HTML, HtmlRendererParameters{})
+
+	doTestsInlineParam(t, []string{
+		input,
+		"&lt;div&gt;\nblock\n&lt;/div&gt;\n\n" +
+			"<p>some &lt;em&gt;inline&lt;/em&gt; html, a <a href=\"/x\">link</a> and <img src=\"/y.png\" alt=\"an image\" /></p>\n",
+	}, Options{}, HTML_ESCAPE_HTML, HtmlRendererParameters{})
+
+	doTestsInlineParam(t, []string{
+		input,
+		"<div>\nblock\n</div>\n\n" +
+			"<p>some <em>inline</em> html, a <tt>link</tt> and </p>\n",
+	}, Options{}, HTML_SKIP_LINKS|HTML_SKIP_IMAGES, HtmlRendererParameters{})
+}
+
+func TestAllowedSchemes(t *testing.T) {
+	var tests = []string{
+		"[ok](https://example.com) [rel](page.html) [abs](/x:y)\n",
+		"<p><a href=\"https://example.com\">ok</a> <a href=\"page.html\">rel</a> <a href=\"/x:y\">abs</a></p>\n",
+
+		"[click *me*](javascript:alert(1)) and [feed](FEED://example.com/rss)\n",
+		"<p>click <em>me</em> and feed</p>\n",
+
+		"![pixel](data:image/gif;base64,R0lGOD) ![ok](http://example.com/a.png)\n",
+		"<p>pixel <img src=\"http://example.com/a.png\" alt=\"ok\" /></p>\n",
+
+		"[ref]\n\n[ref]: irc://example.com/channel\n",
+		"<p>ref</p>\n",
+
+		"<ssh://example.com> <mailto:alice@example.com> <bob@example.com>\n",
+		"<p>&lt;ssh://example.com&gt; <a href=\"mailto:alice@example.com\">alice@example.com</a> <a href=\"mailto:bob@example.com\">bob@example.com</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{AllowedSchemes: DefaultAllowedSchemes}, 0, HtmlRendererParameters{})
+
+	tests = []string{
+		"see http://example.com, www.example.com and <bob@example.com>\n",
+		"<p>see http://example.com, www.example.com and &lt;bob@example.com&gt;</p>\n",
+
+		"[ftp](FTP://example.com/file)\n",
+		"<p><a href=\"FTP://example.com/file\">ftp</a></p>\n",
+	}
+	opts := Options{AllowedSchemes: []string{"FTP"}, Extensions: EXTENSION_EXTENDED_AUTOLINK}
+	doTestsInlineParam(t, tests, opts, 0, HtmlRendererParameters{})
+}
+
 func TestSafeInlineLink(t *testing.T) {
 	var tests = []string{
 		"[foo](/bar/)\n",
@@ -724,6 +1135,23 @@ func TestReferenceLink(t *testing.T) {
 	doLinkTestsInline(t, tests)
 }
 
+func TestLongReferenceId(t *testing.T) {
+	// too long to run on every substring
+	id := strings.Repeat("a", maxReferenceLength)
+	input := "[" + id + "]\n\n[" + id + "]: /url/\n"
+	expected := "<p><a href=\"/url/\">" + id + "</a></p>\n"
+	if actual := runMarkdownInline(input, Options{}, 0, HtmlRendererParameters{}); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+
+	id += "a"
+	input = "[" + id + "]\n\n[" + id + "]: /url/\n"
+	expected = "<p>[" + id + "]</p>\n\n<p>[" + id + "]: /url/</p>\n"
+	if actual := runMarkdownInline(input, Options{}, 0, HtmlRendererParameters{}); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
 func TestTags(t *testing.T) {
 	var tests = []string{
 		"a <span>tag</span>\n",
@@ -741,6 +1169,171 @@ func TestTags(t *testing.T) {
 	doTestsInline(t, tests)
 }
 
+func TestExtendedAutoLink(t *testing.T) {
+	var tests = []string{
+		"www.example.com\n",
+		"<p><a href=\"http://www.example.com\">www.example.com</a></p>\n",
+
+		"Visit www.commonmark.org/help for more information.\n",
+		"<p>Visit <a href=\"http://www.commonmark.org/help\">www.commonmark.org/help</a> for more information.</p>\n",
+
+		"Visit www.commonmark.org/a.b.\n",
+		"<p>Visit <a href=\"http://www.commonmark.org/a.b\">www.commonmark.org/a.b</a>.</p>\n",
+
+		"(www.google.com/search?q=Markup+(business))\n",
+		"<p>(<a href=\"http://www.google.com/search?q=Markup+(business)\">www.google.com/search?q=Markup+(business)</a>)</p>\n",
+
+		"www.google.com/search?q=commonmark&hl;\n",
+		"<p><a href=\"http://www.google.com/search?q=commonmark\">www.google.com/search?q=commonmark</a>&hl;</p>\n",
+
+		"*www.example.com*\n",
+		"<p><em><a href=\"http://www.example.com\">www.example.com</a></em></p>\n",
+
+		"awww.example.test and www. and www.\n",
+		"<p>awww.example.test and www. and www.</p>\n",
+
+		"[www.example.com](/x) and http://www.example.com\n",
+		"<p><a href=\"/x\">www.example.com</a> and <a href=\"http://www.example.com\">http://www.example.com</a></p>\n",
+
+		"see example.com/path?q=1, or docs.Example.IO.\n",
+		"<p>see <a href=\"http://example.com/path?q=1\">example.com/path?q=1</a>, or <a href=\"http://docs.Example.IO\">docs.Example.IO</a>.</p>\n",
+
+		"(go.dev/doc) and *sub.go.dev*\n",
+		"<p>(<a href=\"http://go.dev/doc\">go.dev/doc</a>) and <em><a href=\"http://sub.go.dev\">sub.go.dev</a></em></p>\n",
+
+		"not domains: README.md, main.go, e.g. this, a..com, x.foo_bar.com and ...\n",
+		"<p>not domains: README.md, main.go, e.g. this, a..com, x.foo_bar.com and ...</p>\n",
+
+		"mail foo.bar+baz@example.co.uk, or a-b_c@mail.example.org.\n",
+		"<p>mail <a href=\"mailto:foo.bar+baz@example.co.uk\">foo.bar+baz@example.co.uk</a>, or <a href=\"mailto:a-b_c@mail.example.org\">a-b_c@mail.example.org</a>.</p>\n",
+
+		"not addresses: a@b, a@example.test, @example.com and a@b@example.com\n",
+		"<p>not addresses: a@b, a@example.test, @example.com and a@b@example.com</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_EXTENDED_AUTOLINK}, HTML_SAFELINK,
+		HtmlRendererParameters{})
+}
+
+func TestExtendedAutoLinkTrailingDots(t *testing.T) {
+	// the Markdown renderer writes bare domains back as plain text, and the
+	// cross-reference pass renders plain text first, so these used to find
+	// the same domain over and over again
+	inputs := []string{"a.com..\n", "see www.a.com..\n", "See a.com.. now\n", "a.com.b..c.com...\n"}
+	renderers := []Renderer{
+		HtmlRenderer(0, "", ""),
+		LatexRenderer(0),
+		DocBookRenderer(0),
+		MarkdownRenderer(0),
+		ConfluenceRenderer(0),
+		SlackRenderer(0),
+	}
+	extensions := []Extensions{
+		EXTENSION_EXTENDED_AUTOLINK,
+		githubExtensions | EXTENSION_CROSS_REFERENCES,
+		githubExtensions | EXTENSION_EXAMPLE_LISTS,
+	}
+	for _, input := range inputs {
+		for _, ext := range extensions {
+			for _, renderer := range renderers {
+				output := MarkdownOptions([]byte(input), renderer, Options{Extensions: ext})
+				if !bytes.Contains(output, []byte("a.com")) {
+					t.Errorf("%T lost the domain of %q: %q", renderer, input, output)
+				}
+			}
+			Parse([]byte(input), Options{Extensions: ext})
+		}
+	}
+
+	var tests = []string{
+		"a.com..\n",
+		"<p><a href=\"http://a.com\">a.com</a>..</p>\n",
+
+		"a.com.b..c.com...\n",
+		"<p>a.com.b..c.com...</p>\n",
+
+		"a.com.. and b.org...\n",
+		"<p><a href=\"http://a.com\">a.com</a>.. and <a href=\"http://b.org\">b.org</a>...</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_EXTENDED_AUTOLINK}, 0,
+		HtmlRendererParameters{})
+}
+
+func TestObfuscateEmails(t *testing.T) {
+	var tests = []string{
+		"<a@b.io>\n",
+		"<p><a href=\"&#109;&#x61;&#105;&#x6c;&#116;&#x6f;&#58;&#x61;&#64;&#x62;&#46;&#x69;&#111;\">" +
+			"&#97;&#x40;&#98;&#x2e;&#105;&#x6f;</a></p>\n",
+
+		"<mailto:a@b.io> and a@b.io\n",
+		"<p><a href=\"&#109;&#x61;&#105;&#x6c;&#116;&#x6f;&#58;&#x61;&#64;&#x62;&#46;&#x69;&#111;\">" +
+			"&#97;&#x40;&#98;&#x2e;&#105;&#x6f;</a> and " +
+			"<a href=\"&#109;&#x61;&#105;&#x6c;&#116;&#x6f;&#58;&#x61;&#64;&#x62;&#46;&#x69;&#111;\">" +
+			"&#97;&#x40;&#98;&#x2e;&#105;&#x6f;</a></p>\n",
+
+		"other links are left alone: <http://b.io>\n",
+		"<p>other links are left alone: <a href=\"http://b.io\">http://b.io</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_AUTOLINK | EXTENSION_EXTENDED_AUTOLINK},
+		HTML_OBFUSCATE_EMAILS, HtmlRendererParameters{})
+}
+
+func TestSkipComments(t *testing.T) {
+	var tests = []string{
+		"a <!-- note --> b\n\n<!-- block\nnote -->\n\nc\n",
+		"<p>a  b</p>\n\n<p>c</p>\n",
+
+		"<!--[if mso]>\n<table><tr><td>\n<![endif]-->\n\nText <!--[if !mso]><!--><!--<![endif]-->\n",
+		"<!--[if mso]>\n<table><tr><td>\n<![endif]-->\n\n<p>Text <!--[if !mso]><!--><!--<![endif]--></p>\n",
+
+		"<div><!-- inside other HTML --></div>\n",
+		"<div><!-- inside other HTML --></div>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, HTML_SKIP_COMMENTS|HTML_KEEP_CONDITIONAL_COMMENTS, HtmlRendererParameters{})
+
+	doTestsInlineParam(t, []string{
+		"<!--[if mso]>x<![endif]-->\n",
+		"",
+	}, Options{}, HTML_SKIP_COMMENTS, HtmlRendererParameters{})
+
+	// the handler takes precedence
+	params := HtmlRendererParameters{CommentHandler: func(w io.Writer, comment []byte) {
+		if bytes.Contains(comment, []byte("TODO")) {
+			return
+		}
+		w.Write(bytes.ToUpper(comment))
+	}}
+	doTestsInlineParam(t, []string{
+		"<!-- TODO: fix -->\n\na <!-- keep --> b\n",
+		"<p>a <!-- KEEP --> b</p>\n",
+	}, Options{}, HTML_SKIP_COMMENTS, params)
+
+	// the flags past the 32nd bit are kept on every platform
+	flags := HTML_USE_XHTML | HTML_SKIP_COMMENTS | HTML_SMARTYPANTS_ORDINALS
+	if actual := HtmlRenderer(flags, "", "").(*Html).Flags(); actual != flags {
+		t.Errorf("flags %#x, want %#x", actual, flags)
+	}
+}
+
+func TestTagfilter(t *testing.T) {
+	var tests = []string{
+		"<strong> <title> <style> <em>\n",
+		"<p><strong> &lt;title> &lt;style> <em></p>\n",
+
+		"a <script>alert(1)</script> b\n",
+		"<p>a &lt;script>alert(1)&lt;/script> b</p>\n",
+
+		"<IFRAME src=\"x\"></iframe>\n",
+		"<p>&lt;IFRAME src=\"x\">&lt;/iframe></p>\n",
+
+		"<div>\n<xmp>\n</div>\n",
+		"<div>\n&lt;xmp>\n</div>\n",
+
+		"<scripts> and <titles> are kept\n",
+		"<p><scripts> and <titles> are kept</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, HTML_TAGFILTER, HtmlRendererParameters{})
+}
+
 func TestAutoLink(t *testing.T) {
 	var tests = []string{
 		"http://foo.com/\n",
@@ -1045,6 +1638,25 @@ test footnotes the second.[^b]
 </li>
 </ol>
 </div>
+`,
+	"> quoted^[the note]\n\ntext\n\n> another quote, longer than the first\n",
+	`<blockquote>
+<p>quoted<sup class="footnote-ref" id="fnref:the-note"><a rel="footnote" href="#fn:the-note">1</a></sup></p>
+</blockquote>
+
+<p>text</p>
+
+<blockquote>
+<p>another quote, longer than the first</p>
+</blockquote>
+<div class="footnotes">
+
+<hr />
+
+<ol>
+<li id="fn:the-note">the note</li>
+</ol>
+</div>
 `,
 }
 
@@ -1077,6 +1689,52 @@ func TestFootnotesWithParameters(t *testing.T) {
 	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_FOOTNOTES}, HTML_FOOTNOTE_RETURN_LINKS, params)
 }
 
+func TestFootnoteCollector(t *testing.T) {
+	var items []string
+	params := HtmlRendererParameters{
+		FootnoteAnchorPrefix: "doc1-",
+		FootnoteCollector: func(id int, item []byte) {
+			items = append(items, fmt.Sprintf("%d %s", id, item))
+		},
+	}
+
+	input := "One[^a] and two[^b].\n\n[^a]: First.\n[^b]: Second.\n"
+	expected := "<p>One<sup class=\"footnote-ref\" id=\"fnref:doc1-a\"><a rel=\"footnote\" href=\"#fn:doc1-a\">1</a></sup> and " +
+		"two<sup class=\"footnote-ref\" id=\"fnref:doc1-b\"><a rel=\"footnote\" href=\"#fn:doc1-b\">2</a></sup>.</p>\n"
+	actual := runMarkdownInline(input, Options{Extensions: EXTENSION_FOOTNOTES}, HTML_OMIT_FOOTNOTES, params)
+	if actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+
+	collected := []string{
+		"1 <li id=\"fn:doc1-a\">First.\n</li>\n",
+		"2 <li id=\"fn:doc1-b\">Second.\n</li>\n",
+	}
+	if !reflect.DeepEqual(items, collected) {
+		t.Errorf("\nExpected%q\nActual  %q", collected, items)
+	}
+
+	// the section is kept without HTML_OMIT_FOOTNOTES
+	items = nil
+	actual = runMarkdownInline("x[^a]\n\n[^a]: Note.\n", Options{Extensions: EXTENSION_FOOTNOTES}, 0, params)
+	if !strings.Contains(actual, `<div class="footnotes">`) || len(items) != 1 {
+		t.Errorf("unexpected output %q and items %q", actual, items)
+	}
+}
+
+func TestClassPrefix(t *testing.T) {
+	tests := make([]string, len(footnoteTests))
+	for i, test := range footnoteTests {
+		if i%2 == 1 {
+			test = strings.Replace(test, `class="`, `class="md-`, -1)
+		}
+		tests[i] = test
+	}
+
+	params := HtmlRendererParameters{ClassPrefix: "md-"}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_FOOTNOTES}, 0, params)
+}
+
 func TestNestedFootnotes(t *testing.T) {
 	var tests = []string{
 		`Paragraph.[^fn1]
@@ -1209,6 +1867,14 @@ func TestSmartAngledDoubleQuotesNbsp(t *testing.T) {
 	doTestsInlineParam(t, tests, Options{}, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_ANGLED_QUOTES|HTML_SMARTYPANTS_QUOTES_NBSP, HtmlRendererParameters{})
 }
 
+func TestSmartLowQuotes(t *testing.T) {
+	var tests = []string{
+		"Er sagte: \"Das ist 'gut'.\"\n",
+		"<p>Er sagte: &bdquo;Das ist &sbquo;gut&lsquo;.&ldquo;</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_LOW_QUOTES, HtmlRendererParameters{})
+}
+
 func TestSmartFractions(t *testing.T) {
 	var tests = []string{
 		"1/2, 1/4 and 3/4; 1/4th and 3/4ths\n",
@@ -1227,6 +1893,20 @@ func TestSmartFractions(t *testing.T) {
 	doTestsInlineParam(t, tests, Options{}, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_FRACTIONS, HtmlRendererParameters{})
 }
 
+func TestSmartOrdinals(t *testing.T) {
+	var tests = []string{
+		"The 1st, 22nd and *103rd* 1/4th\n",
+		"<p>The 1st, 22nd and <em>103rd</em> &frac14;th</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, HTML_USE_SMARTYPANTS, HtmlRendererParameters{})
+
+	tests = []string{
+		"The 1st, 22nd and *103rd* 1/4th\n",
+		"<p>The 1<sup>st</sup>, 22<sup>nd</sup> and <em>103<sup>rd</sup></em> &frac14;th</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_ORDINALS, HtmlRendererParameters{})
+}
+
 func TestDisableSmartDashes(t *testing.T) {
 	doTestsInlineParam(t, []string{
 		"foo - bar\n",
@@ -1270,3 +1950,643 @@ func BenchmarkSmartDoubleQuotes(b *testing.B) {
 		runMarkdownInline("this should be normal \"quoted\" text.\n", Options{}, HTML_USE_SMARTYPANTS, HtmlRendererParameters{})
 	}
 }
+
+func TestAbbreviations(t *testing.T) {
+	var tests = []string{
+		"The HTML specification\n\n*[HTML]: HyperText Markup Language\n",
+		"<p>The <abbr title=\"HyperText Markup Language\">HTML</abbr> specification</p>\n",
+
+		"*[HTML]: HyperText Markup Language\n\nHTML and HTML5 and xHTML, but *HTML*.\n",
+		"<p><abbr title=\"HyperText Markup Language\">HTML</abbr> and HTML5 and xHTML, but <em><abbr title=\"HyperText Markup Language\">HTML</abbr></em>.</p>\n",
+
+		"W3C and the W3C HTML WG\n\n*[W3C]: World Wide Web Consortium\n*[HTML WG]: HTML Working Group\n*[HTML]: HyperText Markup Language\n",
+		"<p><abbr title=\"World Wide Web Consortium\">W3C</abbr> and the <abbr title=\"World Wide Web Consortium\">W3C</abbr> <abbr title=\"HTML Working Group\">HTML WG</abbr></p>\n",
+
+		"A \"quoted\" <abbr>\n\n*[A]: a & \"b\"\n",
+		"<p><abbr title=\"a &amp; &quot;b&quot;\">A</abbr> &quot;quoted&quot; <abbr></p>\n",
+
+		"Redefined ABC\n\n*[ABC]: first\n*[ABC]: second\n",
+		"<p>Redefined <abbr title=\"second\">ABC</abbr></p>\n",
+
+		"No title for ABC\n\n*[ABC]:\n",
+		"<p>No title for <abbr>ABC</abbr></p>\n",
+
+		"`HTML` in code\n\n*[HTML]: HyperText Markup Language\n",
+		"<p><code>HTML</code> in code</p>\n",
+
+		"*[]: empty\n",
+		"<p>*[]: empty</p>\n",
+
+		"HTMLé and éHTML and HTML.\n\n*[HTML]: HyperText Markup Language\n",
+		"<p>HTMLé and éHTML and <abbr title=\"HyperText Markup Language\">HTML</abbr>.</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_ABBREVIATIONS}, 0, HtmlRendererParameters{})
+
+	// without the extension the definitions are plain text
+	doTestsInline(t, []string{
+		"HTML\n\n*[HTML]: HyperText Markup Language\n",
+		"<p>HTML</p>\n\n<p>*[HTML]: HyperText Markup Language</p>\n",
+	})
+}
+
+func TestMentions(t *testing.T) {
+	resolver := func(kind int, token string) (string, bool) {
+		switch kind {
+		case MENTION_TYPE_USER:
+			if token == "nobody" {
+				return "", false
+			}
+			return "https://example.com/users/" + token, true
+		case MENTION_TYPE_ISSUE:
+			return "https://example.com/issues/" + token, true
+		}
+		return "", false
+	}
+	params := HtmlRendererParameters{MentionResolver: resolver}
+
+	var tests = []string{
+		"thanks @alice for fixing #42\n",
+		"<p>thanks <a href=\"https://example.com/users/alice\">@alice</a> for fixing <a href=\"https://example.com/issues/42\">#42</a></p>\n",
+
+		"cc @my-org/core-team, @bob. Not @bob_smith or @carol-\n",
+		"<p>cc <a href=\"https://example.com/users/my-org/core-team\">@my-org/core-team</a>, <a href=\"https://example.com/users/bob\">@bob</a>. Not @bob_smith or <a href=\"https://example.com/users/carol\">@carol</a>-</p>\n",
+
+		"@nobody is not a user\n",
+		"<p>@nobody is not a user</p>\n",
+
+		"mail alice@example.com or see page#42 and #42a\n",
+		"<p>mail alice@example.com or see page#42 and #42a</p>\n",
+
+		"a lone @ and # sign, @-dash\n",
+		"<p>a lone @ and # sign, @-dash</p>\n",
+
+		"[@alice in a link](/x) and &#42;\n",
+		"<p><a href=\"/x\">@alice in a link</a> and &#42;</p>\n",
+
+		"`@alice` in code\n",
+		"<p><code>@alice</code> in code</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_MENTIONS}, 0, params)
+
+	// with no resolver mentions are left as text
+	doTestsInlineParam(t, []string{
+		"thanks @alice for fixing #42\n",
+		"<p>thanks @alice for fixing #42</p>\n",
+	}, Options{Extensions: EXTENSION_MENTIONS}, 0, HtmlRendererParameters{})
+
+	// email addresses are still found with extended autolinks
+	doTestsInlineParam(t, []string{
+		"@alice or alice@example.com\n",
+		"<p><a href=\"https://example.com/users/alice\">@alice</a> or <a href=\"mailto:alice@example.com\">alice@example.com</a></p>\n",
+	}, Options{Extensions: EXTENSION_MENTIONS | EXTENSION_EXTENDED_AUTOLINK}, 0, params)
+}
+
+func TestCitations(t *testing.T) {
+	var tests = []string{
+		"as shown [@doe2019]\n",
+		"<p>as shown <span class=\"citation\" data-cites=\"doe2019\">[@doe2019]</span></p>\n",
+
+		"see [@doe2019, p. 30; @roe:2020,  ch.   2] for more\n",
+		"<p>see <span class=\"citation\" data-cites=\"doe2019 roe:2020\">[@doe2019, p. 30; @roe:2020, ch. 2]</span> for more</p>\n",
+
+		"not citations: [@], [@ doe], [@doe p. 3], [@doe; roe], [@doe.] and [see @doe]\n",
+		"<p>not citations: [@], [@ doe], [@doe p. 3], [@doe; roe], [@doe.] and [see @doe]</p>\n",
+
+		"a link [@doe](/x) and an image ![@doe](/y.png)\n",
+		"<p>a link <span class=\"citation\" data-cites=\"doe\">[@doe]</span>(/x) and an image <img src=\"/y.png\" alt=\"@doe\" /></p>\n",
+
+		"escaped [@a<b]\n",
+		"<p>escaped <span class=\"citation\" data-cites=\"a&lt;b\">[@a&lt;b]</span></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_CITATIONS}, 0, HtmlRendererParameters{})
+
+	// without the extension a citation is a link label like any other
+	doTestsInline(t, []string{
+		"[@doe2019]\n\n[@doe2019]: /doe\n",
+		"<p><a href=\"/doe\">@doe2019</a></p>\n",
+	})
+}
+
+func TestURLRewriter(t *testing.T) {
+	rewriter := func(kind int, url []byte) []byte {
+		switch kind {
+		case URL_TYPE_LINK:
+			if bytes.HasSuffix(url, []byte(".md")) {
+				return append(url[:len(url)-len(".md"):len(url)-len(".md")], ".html"...)
+			}
+			return append([]byte("/out?to="), url...)
+		case URL_TYPE_IMAGE:
+			return append([]byte("https://cdn.example.com"), url...)
+		case URL_TYPE_AUTOLINK:
+			return append([]byte("/out?to="), url...)
+		}
+		return url
+	}
+	params := HtmlRendererParameters{URLRewriter: rewriter}
+
+	var tests = []string{
+		"[guide](docs/guide.md) and [site](http://example.com)\n",
+		"<p><a href=\"docs/guide.html\">guide</a> and <a href=\"/out?to=http://example.com\">site</a></p>\n",
+
+		"[ref][1]\n\n[1]: intro.md \"Intro\"\n",
+		"<p><a href=\"intro.html\" title=\"Intro\">ref</a></p>\n",
+
+		"![logo](/img/logo.png)\n",
+		"<p><img src=\"https://cdn.example.com/img/logo.png\" alt=\"logo\" /></p>\n",
+
+		"<http://example.com/?a=1&b=2>\n",
+		"<p><a href=\"/out?to=http://example.com/?a=1&amp;b=2\">http://example.com/?a=1&amp;b=2</a></p>\n",
+
+		"<alice@example.com>\n",
+		"<p><a href=\"/out?to=mailto:alice@example.com\">alice@example.com</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, 0, params)
+
+	// the absolute prefix and link options see the rewritten URL
+	params.AbsolutePrefix = "http://localhost"
+	doTestsInlineParam(t, []string{
+		"[site](http://example.com)\n",
+		"<p><a href=\"http://localhost/out?to=http://example.com\">site</a></p>\n",
+	}, Options{}, HTML_NOFOLLOW_LINKS, params)
+}
+
+func TestImageResolver(t *testing.T) {
+	resolver := func(url []byte) (ImageInfo, bool) {
+		switch string(url) {
+		case "/img/logo.png":
+			return ImageInfo{URL: "https://cdn.example.com/logo.png", Width: 120, Height: 40}, true
+		case "dot.gif":
+			return ImageInfo{URL: "data:image/gif;base64,R0lGODlhAQABAAAAACw=", Alt: "dot"}, true
+		}
+		return ImageInfo{}, false
+	}
+	params := HtmlRendererParameters{ImageResolver: resolver}
+
+	var tests = []string{
+		"![logo](/img/logo.png \"Logo\")\n",
+		"<p><img src=\"https://cdn.example.com/logo.png\" alt=\"logo\" title=\"Logo\" width=\"120\" height=\"40\" /></p>\n",
+
+		"![](dot.gif)\n",
+		"<p><img src=\"data:image/gif;base64,R0lGODlhAQABAAAAACw=\" alt=\"dot\" /></p>\n",
+
+		"![unknown](other.png)\n",
+		"<p><img src=\"other.png\" alt=\"unknown\" /></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, 0, params)
+
+	// the resolver sees the rewritten URL
+	params.URLRewriter = func(kind int, url []byte) []byte {
+		return append([]byte("/img/"), url...)
+	}
+	doTestsInlineParam(t, []string{
+		"![logo](logo.png)\n",
+		"<p><img src=\"https://cdn.example.com/logo.png\" alt=\"logo\" width=\"120\" height=\"40\" /></p>\n",
+	}, Options{}, 0, params)
+}
+
+func TestImageLoading(t *testing.T) {
+	var tests = []string{
+		"![a](a.png)\n",
+		"<p><img src=\"a.png\" alt=\"a\" loading=\"lazy\" decoding=\"async\" /></p>\n",
+
+		"![a](a.png){loading=eager}\n",
+		"<p><img src=\"a.png\" alt=\"a\" decoding=\"async\" loading=\"eager\" /></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_ATTRIBUTES},
+		HTML_USE_XHTML|HTML_LAZY_IMAGES|HTML_ASYNC_IMAGES, HtmlRendererParameters{})
+
+	doTestsInlineParam(t, []string{
+		"![a](a.png)\n",
+		"<p><img src=\"a.png\" alt=\"a\" loading=\"lazy\" /></p>\n",
+	}, Options{}, HTML_LAZY_IMAGES, HtmlRendererParameters{})
+
+	// the resolver can add a srcset and sizes
+	params := HtmlRendererParameters{ImageResolver: func(url []byte) (ImageInfo, bool) {
+		return ImageInfo{SrcSet: "a-480.png 480w, a-960.png 960w", Sizes: "(max-width: 600px) 480px, 960px"}, true
+	}}
+	doTestsInlineParam(t, []string{
+		"![a](a.png)\n",
+		"<p><img src=\"a.png\" alt=\"a\" srcset=\"a-480.png 480w, a-960.png 960w\" " +
+			"sizes=\"(max-width: 600px) 480px, 960px\" decoding=\"async\" /></p>\n",
+	}, Options{}, HTML_USE_XHTML|HTML_ASYNC_IMAGES, params)
+}
+
+func TestMediaEmbed(t *testing.T) {
+	var tests = []string{
+		"![A talk](talk.mp4 \"The talk\")\n",
+		"<p><video src=\"talk.mp4\" title=\"The talk\" controls>A talk</video></p>\n",
+
+		"Listen: ![](/audio/Episode%201.MP3?dl=1)\n",
+		"<p>Listen: <audio src=\"/audio/Episode%201.MP3?dl=1\" controls></audio></p>\n",
+
+		"![Demo](https://www.youtube.com/watch?t=10&v=dQw4w9WgXcQ)\n",
+		"<p><iframe src=\"https://www.youtube.com/embed/dQw4w9WgXcQ\" title=\"Demo\" allowfullscreen></iframe></p>\n",
+
+		"https://youtu.be/dQw4w9WgXcQ\n\nhttps://vimeo.com/76979871\n",
+		"<p><iframe src=\"https://www.youtube.com/embed/dQw4w9WgXcQ\" allowfullscreen></iframe></p>\n\n" +
+			"<p><iframe src=\"https://player.vimeo.com/video/76979871\" allowfullscreen></iframe></p>\n",
+
+		// not media
+		"![logo](logo.png) [talk](talk.mp4)\n",
+		"<p><img src=\"logo.png\" alt=\"logo\" /> <a href=\"talk.mp4\">talk</a></p>\n",
+
+		"See https://youtu.be/dQw4w9WgXcQ\n",
+		"<p>See <a href=\"https://youtu.be/dQw4w9WgXcQ\">https://youtu.be/dQw4w9WgXcQ</a></p>\n",
+
+		"https://vimeo.com/channels/staffpicks\n",
+		"<p><a href=\"https://vimeo.com/channels/staffpicks\">https://vimeo.com/channels/staffpicks</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_MEDIA_EMBED}, 0, HtmlRendererParameters{})
+
+	// providers whose hosts aren't allowed are linked to
+	doTestsInlineParam(t, []string{
+		"https://youtu.be/dQw4w9WgXcQ\n\n![Demo](https://vimeo.com/76979871)\n",
+		"<p><a href=\"https://youtu.be/dQw4w9WgXcQ\">https://youtu.be/dQw4w9WgXcQ</a></p>\n\n" +
+			"<p><iframe src=\"https://player.vimeo.com/video/76979871\" title=\"Demo\" loading=\"lazy\" allowfullscreen></iframe></p>\n",
+	}, Options{Extensions: EXTENSION_MEDIA_EMBED}, HTML_LAZY_IMAGES,
+		HtmlRendererParameters{EmbedHosts: []string{"player.vimeo.com"}})
+
+	// without the extension they are images
+	doTestsInlineParam(t, []string{
+		"![A talk](talk.mp4)\n",
+		"<p><img src=\"talk.mp4\" alt=\"A talk\" /></p>\n",
+	}, Options{}, 0, HtmlRendererParameters{})
+}
+
+func TestLinkAttributes(t *testing.T) {
+	var tests = []string{
+		"![logo](logo.png){width=300 .rounded}\n",
+		"<p><img src=\"logo.png\" alt=\"logo\" class=\"rounded\" width=\"300\" /></p>\n",
+
+		"[home](/ \"Home\"){#home .nav data-x='a \"b\"'} next\n",
+		"<p><a href=\"/\" title=\"Home\" class=\"nav\" id=\"home\" data-x=\"a &quot;b&quot;\">home</a> next</p>\n",
+
+		"[ref]{.x}\n\n[ref]: /r\n",
+		"<p><a href=\"/r\" class=\"x\">ref</a></p>\n",
+
+		// not attribute lists
+		"[a](/a){} [b](/b){x} [c](/c) {.y}\n",
+		"<p><a href=\"/a\">a</a>{} <a href=\"/b\">b</a>{x} <a href=\"/c\">c</a> {.y}</p>\n",
+
+		"[a](/a){.unclosed\n",
+		"<p><a href=\"/a\">a</a>{.unclosed</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_ATTRIBUTES}, HTML_USE_XHTML, HtmlRendererParameters{})
+
+	// sizes in the list win over resolved ones
+	params := HtmlRendererParameters{ImageResolver: func(url []byte) (ImageInfo, bool) {
+		return ImageInfo{Width: 120, Height: 40}, true
+	}}
+	doTestsInlineParam(t, []string{
+		"![a](a.png){width=60}\n",
+		"<p><img src=\"a.png\" alt=\"a\" height=\"40\" width=\"60\" /></p>\n",
+	}, Options{Extensions: EXTENSION_ATTRIBUTES}, HTML_USE_XHTML, params)
+
+	// event handlers and unsafe URLs are dropped with HTML_SANITIZE
+	doTestsInlineParam(t, []string{
+		"[a](/a){onclick=x() style=\"color:red\" cite=javascript:x .c}\n",
+		"<p><a href=\"/a\" class=\"c\">a</a></p>\n",
+	}, Options{Extensions: EXTENSION_ATTRIBUTES}, HTML_SANITIZE, HtmlRendererParameters{})
+
+	// without the extension the list stays text
+	doTestsInlineParam(t, []string{
+		"[a](/a){.c}\n",
+		"<p><a href=\"/a\">a</a>{.c}</p>\n",
+	}, Options{}, 0, HtmlRendererParameters{})
+}
+
+func TestImageSize(t *testing.T) {
+	var tests = []string{
+		"![photo](photo.jpg =640x480)\n",
+		"<p><img src=\"photo.jpg\" alt=\"photo\" width=\"640\" height=\"480\" /></p>\n",
+
+		"![photo](photo.jpg =640x \"Title\")\n",
+		"<p><img src=\"photo.jpg\" alt=\"photo\" title=\"Title\" width=\"640\" /></p>\n",
+
+		"![photo](<photo.jpg> =x480)\n",
+		"<p><img src=\"photo.jpg\" alt=\"photo\" height=\"480\" /></p>\n",
+
+		// not sizes
+		"![photo](photo=640x480.jpg)\n",
+		"<p><img src=\"photo=640x480.jpg\" alt=\"photo\" /></p>\n",
+
+		"![photo](photo.jpg =640)\n",
+		"<p><img src=\"photo.jpg =640\" alt=\"photo\" /></p>\n",
+
+		"![photo](photo.jpg =x)\n",
+		"<p><img src=\"photo.jpg =x\" alt=\"photo\" /></p>\n",
+
+		"[link](page =640x480)\n",
+		"<p><a href=\"page =640x480\">link</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_IMAGE_SIZE}, HTML_USE_XHTML, HtmlRendererParameters{})
+
+	// sizes in an attribute list win over the inline ones
+	doTestsInlineParam(t, []string{
+		"![a](a.png =640x480){width=60}\n",
+		"<p><img src=\"a.png\" alt=\"a\" width=\"60\" height=\"480\" /></p>\n",
+	}, Options{Extensions: EXTENSION_IMAGE_SIZE | EXTENSION_ATTRIBUTES}, HTML_USE_XHTML, HtmlRendererParameters{})
+
+	// without the extension the size is part of the URL
+	doTestsInlineParam(t, []string{
+		"![a](a.png =640x480)\n",
+		"<p><img src=\"a.png =640x480\" alt=\"a\" /></p>\n",
+	}, Options{}, HTML_USE_XHTML, HtmlRendererParameters{})
+}
+
+func TestVariables(t *testing.T) {
+	opts := Options{
+		Extensions: EXTENSION_VARIABLES,
+		Variables:  map[string]string{"product": "Black*friday*", "version": "1.5", "empty": ""},
+		VariableResolver: func(name string) (string, bool) {
+			if name == "user.name" {
+				return "<Ann>", true
+			}
+			return "", false
+		},
+	}
+
+	var tests = []string{
+		"{{product}} {{ version }} by {{user.name}}{{empty}}.\n",
+		"<p>Black*friday* 1.5 by &lt;Ann&gt;.</p>\n",
+
+		"*{{product}}* [{{version}}](/v)\n",
+		"<p><em>Black*friday*</em> <a href=\"/v\">1.5</a></p>\n",
+
+		// not placeholders
+		"{{missing}} \\{{product}} {{2x}} {{a b}} {{product} {product}\n",
+		"<p>{{missing}} {{product}} {{2x}} {{a b}} {{product} {product}</p>\n",
+
+		"`{{product}}`\n\n    {{product}}\n",
+		"<p><code>{{product}}</code></p>\n\n<pre><code>{{product}}\n</code></pre>\n",
+	}
+	doTestsInlineParam(t, tests, opts, 0, HtmlRendererParameters{})
+
+	// CriticMarkup still works alongside
+	opts.Extensions |= EXTENSION_CRITIC_MARKUP
+	doTestsInlineParam(t, []string{
+		"{++{{version}}++}\n",
+		"<p><ins>1.5</ins></p>\n",
+	}, opts, 0, HtmlRendererParameters{})
+}
+
+func TestCrossReferences(t *testing.T) {
+	var tests = []string{
+		"See [Getting started] and [the API][api reference], [](#the-api).\n\n" +
+			"## Getting  Started\n\nThe *API* {#the-api}\n---\n\n## API Reference\n",
+		"<p>See <a href=\"#getting-started\">Getting started</a> and " +
+			"<a href=\"#api-reference\">the API</a>, <a href=\"#the-api\">The <em>API</em></a>.</p>\n\n" +
+			"<h2 id=\"getting-started\">Getting  Started</h2>\n\n<h2 id=\"the-api\">The <em>API</em></h2>\n\n" +
+			"<h2 id=\"api-reference\">API Reference</h2>\n",
+
+		// references defined in the document come first
+		"# Intro\n\n# Intro\n\n# Other\n\n[Intro], [](#intro-1), [Other]\n\n[other]: /other\n",
+		"<h1 id=\"intro\">Intro</h1>\n\n<h1 id=\"intro-1\">Intro</h1>\n\n<h1 id=\"other\">Other</h1>\n\n" +
+			"<p><a href=\"#intro\">Intro</a>, <a href=\"#intro-1\">Intro</a>, <a href=\"/other\">Other</a></p>\n",
+
+		"> # Quoted\n\n[Quoted], [Missing], [](#missing)\n",
+		"<blockquote>\n<h1 id=\"quoted\">Quoted</h1>\n</blockquote>\n\n" +
+			"<p><a href=\"#quoted\">Quoted</a>, [Missing], [](#missing)</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_CROSS_REFERENCES | EXTENSION_HEADER_IDS}, 0, HtmlRendererParameters{})
+
+	doTestsInlineParam(t, []string{
+		"# Intro\n\n[Intro]\n",
+		"<h1>Intro</h1>\n\n<p>[Intro]</p>\n",
+	}, Options{}, 0, HtmlRendererParameters{})
+}
+
+func TestWikiLinks(t *testing.T) {
+	resolver := func(page string) (string, bool) {
+		if page == "Missing Page" {
+			return "", false
+		}
+		return "/wiki/" + strings.Replace(page, " ", "_", -1), true
+	}
+	opts := Options{Extensions: EXTENSION_WIKI_LINKS, WikiLinkResolver: resolver}
+
+	var tests = []string{
+		"See [[Main Page]] for details.\n",
+		"<p>See <a href=\"/wiki/Main_Page\">Main Page</a> for details.</p>\n",
+
+		"See [[Main Page|the *front* page]].\n",
+		"<p>See <a href=\"/wiki/Main_Page\">the <em>front</em> page</a>.</p>\n",
+
+		"[[ Spaced | text ]] and [[Page|]]\n",
+		"<p><a href=\"/wiki/Spaced\">text</a> and <a href=\"/wiki/Page\">Page</a></p>\n",
+
+		"[[Missing Page]] stays\n",
+		"<p>[[Missing Page]] stays</p>\n",
+
+		"[[]] and [[|text]] and [[unclosed\n",
+		"<p>[[]] and [[|text]] and [[unclosed</p>\n",
+
+		"[[Split\nline]]\n",
+		"<p>[[Split\nline]]</p>\n",
+
+		"`[[Code]]` is untouched\n",
+		"<p><code>[[Code]]</code> is untouched</p>\n",
+
+		"[a [[Nested]] link](/x)\n",
+		"<p><a href=\"/x\">a [[Nested]] link</a></p>\n",
+
+		"[regular](/link) still works\n",
+		"<p><a href=\"/link\">regular</a> still works</p>\n",
+	}
+	doTestsInlineParam(t, tests, opts, 0, HtmlRendererParameters{})
+
+	// without a resolver the page name is the link target
+	doTestsInlineParam(t, []string{
+		"[[Page]]\n",
+		"<p><a href=\"Page\">Page</a></p>\n",
+	}, Options{Extensions: EXTENSION_WIKI_LINKS}, 0, HtmlRendererParameters{})
+}
+
+func TestInlineParsers(t *testing.T) {
+	vars := map[string]string{"name": "<World>"}
+	variable := func(r Renderer, out *bytes.Buffer, data []byte, offset int) int {
+		data = data[offset:]
+		if !bytes.HasPrefix(data, []byte("{{")) {
+			return 0
+		}
+		end := bytes.Index(data, []byte("}}"))
+		if end < 0 {
+			return 0
+		}
+		value, ok := vars[string(data[2:end])]
+		if !ok {
+			return 0
+		}
+		r.NormalText(out, []byte(value))
+		return end + 2
+	}
+	// a custom parser for '[' that only handles [!]
+	bang := func(r Renderer, out *bytes.Buffer, data []byte, offset int) int {
+		if !bytes.HasPrefix(data[offset:], []byte("[!]")) {
+			return 0
+		}
+		r.DoubleEmphasis(out, []byte("!"))
+		return 3
+	}
+	opts := Options{}
+	WithInlineParser('{', variable)(&opts)
+	WithInlineParser('[', bang)(&opts)
+
+	var tests = []string{
+		"Hello, {{name}}!\n",
+		"<p>Hello, &lt;World&gt;!</p>\n",
+
+		"*{{name}}* and {{unknown}} and {{name\n",
+		"<p><em>&lt;World&gt;</em> and {{unknown}} and {{name</p>\n",
+
+		"`{{name}}` [!] [link](/url)\n",
+		"<p><code>{{name}}</code> <strong>!</strong> <a href=\"/url\">link</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, opts, 0, HtmlRendererParameters{})
+
+	md := New()
+	md.RegisterInline('{', variable)
+	out, err := md.Render([]byte("{{name}}\n"), HtmlRenderer(0, "", ""))
+	if err != nil || string(out) != "<p>&lt;World&gt;</p>\n" {
+		t.Errorf("unexpected output %q, %v", out, err)
+	}
+}
+
+func TestEpub(t *testing.T) {
+	var tests = []string{
+		"Text[^1] -- \"quoted\" &copy; &amp;\n\n[^1]: The note.\n",
+		"<p>Text<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" epub:type=\"noteref\" href=\"#fn:1\">1</a></sup> &#8212; &#8220;quoted&#8221; &#169; &amp;</p>\n" +
+			"<div class=\"footnotes\" epub:type=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:1\" epub:type=\"footnote\">The note.\n</li>\n</ol>\n</div>\n",
+
+		"[link](http://example.com) and a\nbreak  \nhere\n",
+		"<p><a href=\"http://example.com\">link</a> and a\nbreak<br />\nhere</p>\n",
+
+		"```\n&copy;\n```\n",
+		"<pre><code>&amp;copy;\n</code></pre>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_FOOTNOTES | EXTENSION_FENCED_CODE},
+		HTML_EPUB|HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_DASHES|HTML_HREF_TARGET_BLANK,
+		HtmlRendererParameters{})
+
+	tests = []string{
+		"- [x] done\n",
+		"<ul>\n<li><input type=\"checkbox\" checked=\"checked\" disabled=\"disabled\" /> done</li>\n</ul>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_TASK_LISTS}, HTML_EPUB,
+		HtmlRendererParameters{})
+}
+
+func TestEntities(t *testing.T) {
+	var tests = []string{
+		"&copy; &#169; &#xA9; &amp; &lt; &bogus;\n",
+		"<p>&copy; &#169; &#xA9; &amp; &lt; &bogus;</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, 0, HtmlRendererParameters{})
+
+	tests = []string{
+		"&copy; &#169; &#xA9; &amp; &lt; &bogus;\n",
+		"<p>\u00a9 \u00a9 \u00a9 &amp; &lt; &bogus;</p>\n",
+
+		"&quot;&ngE;\n",
+		"<p>&quot;\u2267\u0338</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, 0, HtmlRendererParameters{
+		Entities: ENTITY_UTF8,
+	})
+
+	tests = []string{
+		"&copy; &#169; &#xA9; &amp; &lt; &bogus;\n",
+		"<p>&#169; &#169; &#xA9; &amp; &lt; &bogus;</p>\n",
+
+		"&ngE;\n",
+		"<p>&#8807;&#824;</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, 0, HtmlRendererParameters{
+		Entities: ENTITY_NUMERIC,
+	})
+
+	table := map[string]string{"amp": "&", "copy": "\u00a9", "smile": "\u263a"}
+	tests = []string{
+		"&copy; &smile; &#169; &amp; &lt; &nbsp;\n",
+		"<p>&copy; &smile; &#169; &amp; &amp;lt; &amp;nbsp;</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, 0, HtmlRendererParameters{
+		EntityTable: table,
+	})
+
+	tests = []string{
+		"&copy; &smile; &#169; &amp; &lt;\n",
+		"<p>&#169; &#9786; &#169; &amp; &amp;lt;</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, 0, HtmlRendererParameters{
+		Entities:    ENTITY_NUMERIC,
+		EntityTable: table,
+	})
+
+	// the references written by smartypants and the renderer itself
+	smarty := HTML_USE_SMARTYPANTS | HTML_SMARTYPANTS_DASHES | HTML_SMARTYPANTS_FRACTIONS
+	tests = []string{
+		"\"x\" -- y... 1/2 (c) <&>\n",
+		"<p>&#8220;x&#8221; &#8212; y&#8230; <sup>1</sup>&#8260;<sub>2</sub> &#169; &lt;&amp;&gt;</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, smarty, HtmlRendererParameters{
+		Entities: ENTITY_NUMERIC,
+	})
+
+	tests = []string{
+		"\"x\" -- y... 1/2 (c) <&>\n",
+		"<p>\u201cx\u201d \u2014 y\u2026 <sup>1</sup>\u2044<sub>2</sub> \u00a9 &lt;&amp;&gt;</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, smarty, HtmlRendererParameters{
+		Entities: ENTITY_UTF8,
+	})
+
+	named := regexp.MustCompile(`&(?:[a-zA-Z][a-zA-Z0-9]*);`)
+	input := []byte("\"x\" -- y... 1/2 'z'\n\n> Quote\n> -- Author\n")
+	for _, flags := range []HtmlFlags{smarty, smarty | HTML_SMARTYPANTS_ANGLED_QUOTES | HTML_SMARTYPANTS_QUOTES_NBSP, smarty | HTML_EPUB} {
+		renderer := HtmlRendererWithParameters(flags, "", "", HtmlRendererParameters{Entities: ENTITY_NUMERIC})
+		output := MarkdownOptions(input, renderer, Options{Extensions: EXTENSION_QUOTE_CITATIONS})
+		for _, ref := range named.FindAll(output, -1) {
+			switch string(ref) {
+			case "&amp;", "&lt;", "&gt;", "&quot;", "&apos;":
+			default:
+				t.Errorf("named reference %s in %q", ref, output)
+			}
+		}
+	}
+}
+
+func TestEscapedNbsp(t *testing.T) {
+	var tests = []string{
+		"a\\ b\\\\ c\n",
+		"<p>a\u00a0b\\ c</p>\n",
+
+		"\\ \"quoted\"\n",
+		"<p>\u00a0&ldquo;quoted&rdquo;</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{Extensions: EXTENSION_ESCAPED_NBSP},
+		HTML_USE_SMARTYPANTS, HtmlRendererParameters{})
+
+	tests = []string{
+		"a\\ b\n",
+		"<p>a\\ b</p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, 0, HtmlRendererParameters{})
+}
+
+func TestEpubCompletePage(t *testing.T) {
+	renderer := HtmlRenderer(HTML_EPUB|HTML_COMPLETE_PAGE|HTML_TOC, "Title", "")
+	actual := string(Markdown([]byte("# One\n"), renderer, 0))
+	expected := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		"<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.1//EN\" \"http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd\">\n" +
+		"<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\">\n" +
+		"<head>\n  <title>Title</title>\n" +
+		"  <meta name=\"GENERATOR\" content=\"Blackfriday Markdown Processor v" + VERSION + "\" />\n" +
+		"  <meta http-equiv=\"Content-Type\" content=\"text/html; charset=utf-8\" />\n" +
+		"</head>\n<body>\n\n" +
+		"<div class=\"toc\" epub:type=\"toc\">\n<ul>\n<li><a href=\"#toc_0\">One</a></li>\n</ul>\n</div>\n\n" +
+		"<h1 id=\"toc_0\">One</h1>\n\n</body>\n</html>\n"
+	if actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
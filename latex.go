@@ -17,12 +17,14 @@ package blackfriday
 
 import (
 	"bytes"
+	"strconv"
 )
 
 // Latex is a type that implements the Renderer interface for LaTeX output.
 //
 // Do not create this directly, instead use the LatexRenderer function.
 type Latex struct {
+	footnoteCount int // number of footnote texts emitted so far
 }
 
 // LatexRenderer creates and configures a Latex object, which
@@ -38,8 +40,12 @@ func (options *Latex) GetFlags() int {
 	return 0
 }
 
+func (options *Latex) newDocument() Renderer {
+	return &Latex{}
+}
+
 // render code chunks using verbatim, or listings if we have a language
-func (options *Latex) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+func (options *Latex) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
 	if lang == "" {
 		out.WriteString("\n\\begin{verbatim}\n")
 	} else {
@@ -59,12 +65,71 @@ func (options *Latex) TitleBlock(out *bytes.Buffer, text []byte) {
 
 }
 
+// The \bibliography command names the database, which only the document
+// preamble knows.
+// An empty line needs something in it for \\ to end.
+func (options *Latex) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	out.WriteString("\n")
+	for i, line := range lines {
+		if i > 0 {
+			options.LineBreak(out)
+		}
+		if len(line) == 0 {
+			out.WriteString("\\mbox{}")
+		}
+		out.Write(line)
+	}
+	out.WriteString("\n")
+}
+
+func (options *Latex) Bibliography(out *bytes.Buffer, keys []string) {
+}
+
 func (options *Latex) BlockQuote(out *bytes.Buffer, text []byte) {
 	out.WriteString("\n\\begin{quotation}\n")
 	out.Write(text)
 	out.WriteString("\n\\end{quotation}\n")
 }
 
+func (options *Latex) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
+	out.WriteString("\n\\begin{quotation}\n")
+	out.Write(text)
+	out.WriteString("\n\\hfill---")
+	out.Write(citation)
+	out.WriteString("\n\\end{quotation}\n")
+}
+
+func (options *Latex) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
+	out.WriteString("\n\\begin{quotation}\n\\textbf{")
+	escapeSpecialChars(out, admonitionTitle(kind, title))
+	out.WriteString("}\n\n")
+	out.Write(text)
+	out.WriteString("\n\\end{quotation}\n")
+}
+
+func (options *Latex) Container(out *bytes.Buffer, info string, text []byte) {
+	out.Write(text)
+}
+
+func (options *Latex) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	if len(summary) > 0 {
+		out.WriteString("\n\\textbf{")
+		out.Write(summary)
+		out.WriteString("}\n\n")
+	}
+	out.Write(text)
+}
+
+func (options *Latex) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Latex) BlockShortcode(out *bytes.Buffer, text []byte) {
+	out.WriteString("\n")
+	out.Write(text)
+	out.WriteString("\n")
+}
+
 func (options *Latex) BlockHtml(out *bytes.Buffer, text []byte) {
 	// a pretty lame thing to do...
 	out.WriteString("\n\\begin{verbatim}\n")
@@ -72,7 +137,7 @@ func (options *Latex) BlockHtml(out *bytes.Buffer, text []byte) {
 	out.WriteString("\n\\end{verbatim}\n")
 }
 
-func (options *Latex) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+func (options *Latex) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
 	marker := out.Len()
 
 	switch level {
@@ -93,17 +158,26 @@ func (options *Latex) Header(out *bytes.Buffer, text func() bool, level int, id
 		out.Truncate(marker)
 		return
 	}
-	out.WriteString("}\n")
+	out.WriteString("}")
+	if id != "" {
+		out.WriteString("\\label{")
+		out.WriteString(id)
+		out.WriteString("}")
+	}
+	out.WriteString("\n")
 }
 
 func (options *Latex) HRule(out *bytes.Buffer) {
 	out.WriteString("\n\\HRule\n")
 }
 
-func (options *Latex) List(out *bytes.Buffer, text func() bool, flags int) {
+func (options *Latex) List(out *bytes.Buffer, text func() bool, flags int, start int) {
 	marker := out.Len()
 	if flags&LIST_TYPE_ORDERED != 0 {
 		out.WriteString("\n\\begin{enumerate}\n")
+		if start != 1 {
+			out.WriteString("\\setcounter{enumi}{" + strconv.Itoa(start-1) + "}\n")
+		}
 	} else {
 		out.WriteString("\n\\begin{itemize}\n")
 	}
@@ -119,7 +193,14 @@ func (options *Latex) List(out *bytes.Buffer, text func() bool, flags int) {
 }
 
 func (options *Latex) ListItem(out *bytes.Buffer, text []byte, flags int) {
-	out.WriteString("\n\\item ")
+	switch {
+	case flags&LIST_ITEM_CHECKED != 0:
+		out.WriteString("\n\\item[{[x]}] ")
+	case flags&LIST_ITEM_TASK != 0:
+		out.WriteString("\n\\item[{[ ]}] ")
+	default:
+		out.WriteString("\n\\item ")
+	}
 	out.Write(text)
 }
 
@@ -133,59 +214,92 @@ func (options *Latex) Paragraph(out *bytes.Buffer, text func() bool) {
 	out.WriteString("\n")
 }
 
-func (options *Latex) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+func (options *Latex) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	// only a floating table can have a caption
+	if len(caption) > 0 {
+		out.WriteString("\n\\begin{table}[h]\n\\centering")
+	}
 	out.WriteString("\n\\begin{tabular}{")
 	for _, elt := range columnData {
-		switch elt {
-		case TABLE_ALIGNMENT_LEFT:
-			out.WriteByte('l')
-		case TABLE_ALIGNMENT_RIGHT:
-			out.WriteByte('r')
-		default:
-			out.WriteByte('c')
-		}
+		out.WriteByte(latexAlignment(elt))
 	}
 	out.WriteString("}\n")
-	out.Write(header)
-	out.WriteString(" \\\\\n\\hline\n")
+	if len(header) > 0 {
+		out.Write(header)
+		out.WriteString(" \\\\\n\\hline\n")
+	}
 	out.Write(body)
 	out.WriteString("\n\\end{tabular}\n")
+	if len(caption) > 0 {
+		out.WriteString("\\caption{")
+		out.Write(caption)
+		out.WriteString("}\n\\end{table}\n")
+	}
 }
 
-func (options *Latex) TableRow(out *bytes.Buffer, text []byte) {
-	if out.Len() > 0 {
-		out.WriteString(" \\\\\n")
+func latexAlignment(flags int) byte {
+	switch flags & TABLE_ALIGNMENT_MASK {
+	case TABLE_ALIGNMENT_LEFT:
+		return 'l'
+	case TABLE_ALIGNMENT_RIGHT:
+		return 'r'
 	}
-	out.Write(text)
+	return 'c'
 }
 
-func (options *Latex) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
+func (options *Latex) TableRow(out *bytes.Buffer, text []byte, flags int) {
 	if out.Len() > 0 {
-		out.WriteString(" & ")
+		out.WriteString(" \\\\\n")
 	}
 	out.Write(text)
 }
 
-func (options *Latex) TableCell(out *bytes.Buffer, text []byte, align int) {
+func (options *Latex) TableHeaderCell(out *bytes.Buffer, text []byte, align int, span int) {
+	options.TableCell(out, text, align, span)
+}
+
+func (options *Latex) TableCell(out *bytes.Buffer, text []byte, align int, span int) {
 	if out.Len() > 0 {
 		out.WriteString(" & ")
 	}
+	if span > 1 {
+		out.WriteString("\\multicolumn{")
+		out.WriteString(strconv.Itoa(span))
+		out.WriteString("}{")
+		out.WriteByte(latexAlignment(align))
+		out.WriteString("}{")
+		out.Write(text)
+		out.WriteString("}")
+		return
+	}
 	out.Write(text)
 }
 
-// TODO: this
+// Footnote texts are emitted at the end of the document with \footnotetext,
+// matched up with the \footnotemark written by FootnoteRef.
 func (options *Latex) Footnotes(out *bytes.Buffer, text func() bool) {
-
+	text()
 }
 
 func (options *Latex) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
-
+	if flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
+		options.footnoteCount = 0
+	}
+	options.footnoteCount++
+	out.WriteString("\n\\footnotetext[")
+	out.WriteString(strconv.Itoa(options.footnoteCount))
+	out.WriteString("]{")
+	out.Write(bytes.TrimRight(text, "\n"))
+	out.WriteString("}\n")
 }
 
 func (options *Latex) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 	out.WriteString("\\href{")
-	if kind == LINK_TYPE_EMAIL {
+	switch kind {
+	case LINK_TYPE_EMAIL:
 		out.WriteString("mailto:")
+	case LINK_TYPE_WWW:
+		out.WriteString("http://")
 	}
 	out.Write(link)
 	out.WriteString("}{")
@@ -211,7 +325,7 @@ func (options *Latex) Emphasis(out *bytes.Buffer, text []byte) {
 	out.WriteString("}")
 }
 
-func (options *Latex) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+func (options *Latex) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
 	if bytes.HasPrefix(link, []byte("http://")) || bytes.HasPrefix(link, []byte("https://")) {
 		// treat it like a link
 		out.WriteString("\\href{")
@@ -230,7 +344,7 @@ func (options *Latex) LineBreak(out *bytes.Buffer) {
 	out.WriteString(" \\\\\n")
 }
 
-func (options *Latex) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+func (options *Latex) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
 	out.WriteString("\\href{")
 	out.Write(link)
 	out.WriteString("}{")
@@ -253,9 +367,91 @@ func (options *Latex) StrikeThrough(out *bytes.Buffer, text []byte) {
 	out.WriteString("}")
 }
 
-// TODO: this
+func (options *Latex) Highlight(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\colorbox{yellow}{")
+	out.Write(text)
+	out.WriteString("}")
+}
+
+func (options *Latex) Insert(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\uline{")
+	out.Write(text)
+	out.WriteString("}")
+}
+
+// Comments go in the margin.
+func (options *Latex) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	if kind == CRITIC_COMMENT {
+		out.WriteString("\\marginpar{")
+		out.Write(text)
+		out.WriteString("}")
+		return
+	}
+	criticChanges(options, out, kind, text, replacement)
+}
+
 func (options *Latex) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("\\footnotemark[")
+	out.WriteString(strconv.Itoa(id))
+	out.WriteString("]")
+}
 
+func (options *Latex) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	escapeSpecialChars(out, abbr)
+}
+
+func (options *Latex) Mention(out *bytes.Buffer, kind int, token []byte) {
+	if kind == MENTION_TYPE_ISSUE {
+		out.WriteString("\\#")
+	} else {
+		out.WriteByte('@')
+	}
+	escapeSpecialChars(out, token)
+}
+
+func (options *Latex) Citation(out *bytes.Buffer, items []CitationItem) {
+	for i, item := range items {
+		if i > 0 {
+			out.WriteString("; ")
+		}
+		out.WriteString("\\cite")
+		if item.Locator != "" {
+			out.WriteByte('[')
+			escapeSpecialChars(out, []byte(item.Locator))
+			out.WriteByte(']')
+		}
+		out.WriteString("{" + item.Key + "}")
+	}
+}
+
+func (options *Latex) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	if len(alt) == 0 {
+		alt = link
+	}
+	options.Link(out, link, title, alt, nil)
+}
+
+func (options *Latex) Kbd(out *bytes.Buffer, key []byte) {
+	out.WriteString("\\fbox{\\texttt{")
+	escapeSpecialChars(out, key)
+	out.WriteString("}}")
+}
+
+// \\ruby is provided by the pxrubrica and luatexja-ruby packages.
+func (options *Latex) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	out.WriteString("\\ruby{")
+	out.Write(text)
+	out.WriteString("}{")
+	out.Write(annotation)
+	out.WriteString("}")
+}
+
+func (options *Latex) Spoiler(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Latex) Shortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
 }
 
 func needsBackslash(c byte) bool {
@@ -307,6 +503,7 @@ func (options *Latex) DocumentHeader(out *bytes.Buffer) {
 	out.WriteString("\\usepackage[utf8]{inputenc}\n")
 	out.WriteString("\\usepackage{verbatim}\n")
 	out.WriteString("\\usepackage[normalem]{ulem}\n")
+	out.WriteString("\\usepackage{xcolor}\n")
 	out.WriteString("\\usepackage{hyperref}\n")
 	out.WriteString("\n")
 	out.WriteString("\\hypersetup{colorlinks,%\n")
@@ -0,0 +1,73 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for the LaTeX renderer
+//
+
+package blackfriday
+
+import (
+	"strings"
+	"testing"
+)
+
+func runMarkdownLatex(input string, extensions Extensions) string {
+	out := string(Markdown([]byte(input), LatexRenderer(0), extensions))
+
+	// strip the document preamble and trailer, they are the same for every test
+	begin := "\\begin{document}\n"
+	if i := strings.Index(out, begin); i >= 0 {
+		out = out[i+len(begin):]
+	}
+	return strings.TrimSuffix(out, "\n\\end{document}\n")
+}
+
+func doTestsLatex(t *testing.T, tests []string, extensions Extensions) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runMarkdownLatex(input, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestLatexFootnotes(t *testing.T) {
+	var tests = []string{
+		"Some text.[^a]\n\n[^a]: The note.\n",
+		"\nSome text.\\footnotemark[1]\n\n\\footnotetext[1]{The note.}\n",
+
+		"One[^1] and two[^2].\n\n[^1]: first\n[^2]: second\n",
+		"\nOne\\footnotemark[1] and two\\footnotemark[2].\n\n\\footnotetext[1]{first}\n\n\\footnotetext[2]{second}\n",
+	}
+	doTestsLatex(t, tests, EXTENSION_FOOTNOTES)
+}
+
+func TestLatexTableExtras(t *testing.T) {
+	var tests = []string{
+		"a | b\n:--|--:\nc ||\n\nTable: Sums\n",
+		"\n\\begin{table}[h]\n\\centering\n\\begin{tabular}{lr}\na & b \\\\\n\\hline\n" +
+			"\\multicolumn{2}{l}{c}\n\\end{tabular}\n\\caption{Sums}\n\\end{table}\n",
+	}
+	doTestsLatex(t, tests, EXTENSION_TABLES|EXTENSION_TABLE_EXTRAS)
+}
+
+func TestLatexHeaderLabels(t *testing.T) {
+	var tests = []string{
+		"# Header\n\n# Header\n\nOther\n-----\n",
+		"\n\\section{Header}\\label{header}\n\n\\section{Header}\\label{header-1}\n\n\\subsection{Other}\\label{other}\n",
+
+		"# Header {#custom}\n\n# Header\n",
+		"\n\\section{Header}\\label{custom}\n\n\\section{Header}\\label{header}\n",
+	}
+	doTestsLatex(t, tests, EXTENSION_AUTO_HEADER_IDS|EXTENSION_HEADER_IDS)
+}
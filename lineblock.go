@@ -0,0 +1,86 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Pandoc line blocks
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// returns the length of the "| " prefix of a line block line, or 0 if
+// there is none. A bar alone on its line is an empty line of the block.
+func lineBlockPrefix(data []byte) int {
+	if len(data) < 2 || data[0] != '|' {
+		return 0
+	}
+	switch data[1] {
+	case ' ':
+		return 2
+	case '\n':
+		return 1
+	}
+	return 0
+}
+
+// A line block keeps the line breaks and the indentation of its lines,
+// for verse and addresses:
+//
+//	| The limerick packs laughs anatomical
+//	|     Into space that is quite economical.
+//
+// A line starting with a space continues the line before it.
+func (p *parser) lineBlock(out *bytes.Buffer, data []byte) int {
+	var lines [][]byte
+	end := 0
+	for end < len(data) {
+		eol := skipUntilChar(data, end, '\n')
+		line := data[end:eol]
+		if eol < len(data) {
+			eol++
+		}
+		if pre := lineBlockPrefix(data[end:]); pre > 0 {
+			lines = append(lines, indentLine(line[pre:]))
+		} else if len(lines) > 0 && len(line) > 0 && line[0] == ' ' && p.isEmpty(data[end:]) == 0 {
+			last := len(lines) - 1
+			lines[last] = append(append(lines[last], ' '), bytes.TrimLeft(line, " ")...)
+		} else {
+			break
+		}
+		end = eol
+	}
+	if len(lines) == 0 {
+		return 0
+	}
+
+	rendered := make([][]byte, len(lines))
+	for i, line := range lines {
+		var work bytes.Buffer
+		p.inline(&work, line)
+		rendered[i] = work.Bytes()
+	}
+	p.r.LineBlock(out, rendered)
+	return end
+}
+
+// indentLine returns a copy of a line of a line block with its leading
+// spaces made non-breaking, so that they are kept
+func indentLine(line []byte) []byte {
+	i := 0
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+	indented := bytes.Repeat([]byte("\u00a0"), i)
+	return append(indented, line[i:]...)
+}
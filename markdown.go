@@ -17,33 +17,75 @@ package blackfriday
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
 const VERSION = "1.5"
 
+// Extensions is a set of EXTENSION_* flags. It has 64 bits, so that there
+// is room for all of them on 32-bit platforms too.
+type Extensions uint64
+
 // These are the supported markdown parsing extensions.
 // OR these values together to select multiple extensions.
 const (
-	EXTENSION_NO_INTRA_EMPHASIS          = 1 << iota // ignore emphasis markers inside words
-	EXTENSION_TABLES                                 // render tables
-	EXTENSION_FENCED_CODE                            // render fenced code blocks
-	EXTENSION_AUTOLINK                               // detect embedded URLs that are not explicitly marked
-	EXTENSION_STRIKETHROUGH                          // strikethrough text using ~~test~~
-	EXTENSION_LAX_HTML_BLOCKS                        // loosen up HTML block parsing rules
-	EXTENSION_SPACE_HEADERS                          // be strict about prefix header rules
-	EXTENSION_HARD_LINE_BREAK                        // translate newlines into line breaks
-	EXTENSION_TAB_SIZE_EIGHT                         // expand tabs to eight spaces instead of four
-	EXTENSION_FOOTNOTES                              // Pandoc-style footnotes
-	EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK             // No need to insert an empty line to start a (code, quote, ordered list, unordered list) block
-	EXTENSION_HEADER_IDS                             // specify header IDs  with {#id}
-	EXTENSION_TITLEBLOCK                             // Titleblock ala pandoc
-	EXTENSION_AUTO_HEADER_IDS                        // Create the header ID from the text
-	EXTENSION_BACKSLASH_LINE_BREAK                   // translate trailing backslashes into line breaks
-	EXTENSION_DEFINITION_LISTS                       // render definition lists
-	EXTENSION_JOIN_LINES                             // delete newline and join lines
+	EXTENSION_NO_INTRA_EMPHASIS          Extensions = 1 << iota // ignore emphasis markers inside words
+	EXTENSION_TABLES                                            // render tables
+	EXTENSION_FENCED_CODE                                       // render fenced code blocks
+	EXTENSION_AUTOLINK                                          // detect embedded URLs that are not explicitly marked
+	EXTENSION_STRIKETHROUGH                                     // strikethrough text using ~~test~~
+	EXTENSION_LAX_HTML_BLOCKS                                   // loosen up HTML block parsing rules
+	EXTENSION_SPACE_HEADERS                                     // be strict about prefix header rules
+	EXTENSION_HARD_LINE_BREAK                                   // translate newlines into line breaks
+	EXTENSION_TAB_SIZE_EIGHT                                    // expand tabs to eight spaces instead of four
+	EXTENSION_FOOTNOTES                                         // Pandoc-style footnotes
+	EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK                        // No need to insert an empty line to start a (code, quote, ordered list, unordered list) block
+	EXTENSION_HEADER_IDS                                        // specify header IDs  with {#id}
+	EXTENSION_TITLEBLOCK                                        // Titleblock ala pandoc
+	EXTENSION_AUTO_HEADER_IDS                                   // Create the header ID from the text
+	EXTENSION_BACKSLASH_LINE_BREAK                              // translate trailing backslashes into line breaks
+	EXTENSION_DEFINITION_LISTS                                  // render definition lists
+	EXTENSION_JOIN_LINES                                        // delete newline and join lines
+	EXTENSION_TASK_LISTS                                        // render list items starting with [ ] or [x] as task list items
+	EXTENSION_ABBREVIATIONS                                     // mark up abbreviations defined with *[abbr]: title
+	EXTENSION_MENTIONS                                          // detect @user mentions and #123 issue references
+	EXTENSION_WIKI_LINKS                                        // render [[Page Name]] and [[Page Name|text]] as links
+	EXTENSION_COMMONMARK                                        // follow CommonMark's block structure and emphasis rules
+	EXTENSION_EXTENDED_AUTOLINK                                 // detect www. links, bare domains and email addresses without a scheme
+	EXTENSION_EAST_ASIAN_LINE_BREAKS                            // join lines without a space between Chinese or Japanese characters
+	EXTENSION_ADMONITIONS                                       // render block quotes starting with [!NOTE] and the like as admonitions
+	EXTENSION_FENCED_DIVS                                       // render ::: fenced divs as containers
+	EXTENSION_ATTRIBUTES                                        // parse {.class #id key=val} after headers, code fences, images and links
+	EXTENSION_TABLE_EXTRAS                                      // table captions and cells spanning columns with ||
+	EXTENSION_GRID_TABLES                                       // parse Pandoc grid tables, whose cells can hold blocks
+	EXTENSION_CITATIONS                                         // parse [@key, p. 30] citations and collect them for a bibliography
+	EXTENSION_HIGHLIGHT                                         // highlighted text using ==text==
+	EXTENSION_INSERT                                            // inserted text using ++text++
+	EXTENSION_CRITIC_MARKUP                                     // parse CriticMarkup {++additions++}, {--deletions--} and comments
+	EXTENSION_DETAILS                                           // parse the contents of <details> elements as Markdown
+	EXTENSION_MARKDOWN_ATTRIBUTE                                // parse the contents of HTML blocks with markdown="1" as Markdown
+	EXTENSION_IMAGE_SIZE                                        // image sizes using ![alt](image.png =640x480)
+	EXTENSION_MEDIA_EMBED                                       // embed ![](video.mp4), audio and YouTube or Vimeo links on their own line
+	EXTENSION_CODE_METADATA                                     // parse title="main.go", hl_lines="2-4" and linenos after the language of fenced code
+	EXTENSION_INCLUDE                                           // include documents with {{include "path.md"}} through Options.IncludeResolver
+	EXTENSION_VARIABLES                                         // replace {{name}} with the values of Options.Variables
+	EXTENSION_COMMENTS                                          // leave %% lines and <!--- comments ---> out of the output
+	EXTENSION_CROSS_REFERENCES                                  // link [Header Title] and [](#header-id) to the headers of the document
+	EXTENSION_FANCY_LISTS                                       // number ordered lists with letters and roman numerals, as in a. and iv)
+	EXTENSION_EXAMPLE_LISTS                                     // number (@label) example lists throughout the document and replace references to them
+	EXTENSION_KBD                                               // mark up keys such as [[Ctrl]]+[[C]] as keyboard input
+	EXTENSION_RUBY                                              // ruby annotations such as {漢字|かんじ}
+	EXTENSION_SPOILERS                                          // hide >! spoiler blocks and ||inline spoilers||
+	EXTENSION_FRONT_MATTER                                      // leave YAML, TOML or JSON front matter out of the output
+	EXTENSION_SHORTCODES                                        // pass {{< shortcodes >}} and {% liquid tags %} through untouched
+	EXTENSION_QUOTE_CITATIONS                                   // pass a last "-- Author, Source" line of block quotes to BlockQuoteCitation
+	EXTENSION_LINE_BLOCKS                                       // keep the line breaks and indentation of Pandoc "| " line blocks
+	EXTENSION_ESCAPED_NBSP                                      // turn a backslash before a space into a non-breaking space
 
 	commonHtmlFlags = 0 |
 		HTML_USE_XHTML |
@@ -62,6 +104,73 @@ const (
 		EXTENSION_HEADER_IDS |
 		EXTENSION_BACKSLASH_LINE_BREAK |
 		EXTENSION_DEFINITION_LISTS
+
+	githubHtmlFlags = 0 |
+		HTML_USE_XHTML |
+		HTML_TAGFILTER
+
+	githubExtensions = 0 |
+		EXTENSION_COMMONMARK |
+		EXTENSION_TABLES |
+		EXTENSION_FENCED_CODE |
+		EXTENSION_AUTOLINK |
+		EXTENSION_EXTENDED_AUTOLINK |
+		EXTENSION_STRIKETHROUGH |
+		EXTENSION_TASK_LISTS |
+		EXTENSION_BACKSLASH_LINE_BREAK
+
+	pandocHtmlFlags = 0 |
+		HTML_USE_XHTML |
+		HTML_USE_SMARTYPANTS |
+		HTML_SMARTYPANTS_DASHES |
+		HTML_SMARTYPANTS_LATEX_DASHES |
+		HTML_FOOTNOTE_RETURN_LINKS
+
+	pandocExtensions = 0 |
+		EXTENSION_TABLES |
+		EXTENSION_TABLE_EXTRAS |
+		EXTENSION_GRID_TABLES |
+		EXTENSION_FENCED_CODE |
+		EXTENSION_STRIKETHROUGH |
+		EXTENSION_SPACE_HEADERS |
+		EXTENSION_FOOTNOTES |
+		EXTENSION_HEADER_IDS |
+		EXTENSION_AUTO_HEADER_IDS |
+		EXTENSION_TITLEBLOCK |
+		EXTENSION_BACKSLASH_LINE_BREAK |
+		EXTENSION_DEFINITION_LISTS |
+		EXTENSION_FANCY_LISTS |
+		EXTENSION_EXAMPLE_LISTS |
+		EXTENSION_FENCED_DIVS |
+		EXTENSION_ATTRIBUTES |
+		EXTENSION_CITATIONS |
+		EXTENSION_LINE_BLOCKS
+)
+
+// These are the possible values of Options.Strikethrough, the runs of
+// tildes that EXTENSION_STRIKETHROUGH takes for strikethrough.
+const (
+	STRIKETHROUGH_DOUBLE = iota // ~~text~~ only
+	STRIKETHROUGH_SINGLE        // ~text~ only
+	STRIKETHROUGH_BOTH          // ~text~ and ~~text~~, as on GitHub
+)
+
+// These are the possible values of Options.InvalidUTF8, what becomes of
+// input that isn't valid UTF-8.
+const (
+	INVALID_UTF8_KEEP         = iota // pass the bytes through as they are
+	INVALID_UTF8_REPLACE             // replace each byte that is out of place with U+FFFD
+	INVALID_UTF8_REJECT              // fail with ErrInvalidUTF8
+	INVALID_UTF8_WINDOWS_1252        // decode the whole input as Windows-1252, as legacy documents from Word are
+)
+
+// These are the formats of the front matter found by SplitFrontMatter and
+// left out with EXTENSION_FRONT_MATTER.
+const (
+	FRONT_MATTER_NONE = iota
+	FRONT_MATTER_YAML // between --- lines
+	FRONT_MATTER_TOML // between +++ lines
+	FRONT_MATTER_JSON // a JSON object between { and } lines
 )
 
 // These are the possible flag values for the link renderer.
@@ -71,6 +180,37 @@ const (
 	LINK_TYPE_NOT_AUTOLINK = iota
 	LINK_TYPE_NORMAL
 	LINK_TYPE_EMAIL
+	LINK_TYPE_WWW
+)
+
+// These are the possible kind values for the Mention renderer.
+// Only a single one of these values will be used; they are not ORed together.
+const (
+	MENTION_TYPE_USER  = iota // @username or @org/team
+	MENTION_TYPE_ISSUE        // #123
+)
+
+// These are the possible kind values for the MediaEmbed renderer.
+// Only a single one of these values will be used; they are not ORed together.
+const (
+	MEDIA_VIDEO = iota + 1 // a video file
+	MEDIA_AUDIO            // an audio file
+	MEDIA_EMBED            // the page of a provider such as YouTube
+)
+
+// These are the possible flag values for the Details renderer.
+const (
+	DETAILS_OPEN = 1 << iota // the section is expanded to begin with
+)
+
+// These are the possible kind values for the CriticMarkup renderer.
+// Only a single one of these values will be used; they are not ORed together.
+const (
+	CRITIC_ADDITION     = iota // {++text++}
+	CRITIC_DELETION            // {--text--}
+	CRITIC_SUBSTITUTION        // {~~text~>replacement~~}
+	CRITIC_COMMENT             // {>>text<<}
+	CRITIC_HIGHLIGHT           // {==text==}
 )
 
 // These are the possible flag values for the ListItem renderer.
@@ -83,15 +223,35 @@ const (
 	LIST_ITEM_CONTAINS_BLOCK
 	LIST_ITEM_BEGINNING_OF_LIST
 	LIST_ITEM_END_OF_LIST
+	LIST_ITEM_TASK
+	LIST_ITEM_CHECKED
+	LIST_DELIMITER_PAREN   // the items of an ordered list are numbered 1), 2), ...
+	LIST_LOOSE             // the items of the list are separated by blank lines
+	LIST_STYLE_LOWER_ALPHA // the items of an ordered list are lettered a, b, c, ...
+	LIST_STYLE_UPPER_ALPHA // A, B, C, ...
+	LIST_STYLE_LOWER_ROMAN // i, ii, iii, ...
+	LIST_STYLE_UPPER_ROMAN // I, II, III, ...
+	LIST_STYLE_EXAMPLE     // the items are examples, (@), numbered throughout the document
 )
 
 // These are the possible flag values for the table cell renderer.
-// Only a single one of these values will be used; they are not ORed together.
+// Only a single one of the alignment values will be used; they are not ORed
+// together. TABLE_CELL_HEADER is ORed in for the cells of the header row.
 // These are mostly of interest if you are writing a new output format.
 const (
 	TABLE_ALIGNMENT_LEFT = 1 << iota
 	TABLE_ALIGNMENT_RIGHT
+	TABLE_CELL_HEADER
 	TABLE_ALIGNMENT_CENTER = (TABLE_ALIGNMENT_LEFT | TABLE_ALIGNMENT_RIGHT)
+	TABLE_ALIGNMENT_MASK   = TABLE_ALIGNMENT_CENTER
+)
+
+// These are the possible flag values for the table row renderer. Rows of
+// the body are numbered from one, so the first is odd.
+const (
+	TABLE_ROW_HEADER = 1 << iota
+	TABLE_ROW_ODD
+	TABLE_ROW_EVEN
 )
 
 // The size of a tab stop.
@@ -102,6 +262,7 @@ const (
 
 // blockTags is a set of tags that are recognized as HTML block tags.
 // Any of these can be included in markdown text without special escaping.
+// Options.BlockTags adds to it for a single parser; it is never modified.
 var blockTags = map[string]struct{}{
 	"blockquote": {},
 	"del":        {},
@@ -149,44 +310,66 @@ var blockTags = map[string]struct{}{
 // This is mostly of interest if you are implementing a new rendering format.
 //
 // When a byte slice is provided, it contains the (rendered) contents of the
-// element.
+// element. It may be a scratch buffer that the parser reuses, so renderers
+// that keep it past the call need a copy.
 //
 // When a callback is provided instead, it will write the contents of the
 // respective element directly to the output buffer and return true on success.
 // If the callback returns false, the rendering function should reset the
 // output buffer as though it had never been called.
 //
-// Currently Html and Latex implementations are provided
+// Currently Html and Latex implementations are provided, along with
+// DocBook, Markdown, Confluence and Slack ones. New renderers can embed
+// BaseRenderer and define only the callbacks they need.
 type Renderer interface {
 	// block-level callbacks
-	BlockCode(out *bytes.Buffer, text []byte, lang string)
+	BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes)
 	BlockQuote(out *bytes.Buffer, text []byte)
+	BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte)
+	Admonition(out *bytes.Buffer, kind string, title []byte, text []byte)
+	Container(out *bytes.Buffer, info string, text []byte)
+	Details(out *bytes.Buffer, summary []byte, text []byte, flags int)
+	BlockSpoiler(out *bytes.Buffer, text []byte)
+	BlockShortcode(out *bytes.Buffer, text []byte)
 	BlockHtml(out *bytes.Buffer, text []byte)
-	Header(out *bytes.Buffer, text func() bool, level int, id string)
+	Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes)
 	HRule(out *bytes.Buffer)
-	List(out *bytes.Buffer, text func() bool, flags int)
+	List(out *bytes.Buffer, text func() bool, flags int, start int)
 	ListItem(out *bytes.Buffer, text []byte, flags int)
 	Paragraph(out *bytes.Buffer, text func() bool)
-	Table(out *bytes.Buffer, header []byte, body []byte, columnData []int)
-	TableRow(out *bytes.Buffer, text []byte)
-	TableHeaderCell(out *bytes.Buffer, text []byte, flags int)
-	TableCell(out *bytes.Buffer, text []byte, flags int)
+	Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte)
+	TableRow(out *bytes.Buffer, text []byte, flags int)
+	TableHeaderCell(out *bytes.Buffer, text []byte, flags int, span int)
+	TableCell(out *bytes.Buffer, text []byte, flags int, span int)
 	Footnotes(out *bytes.Buffer, text func() bool)
 	FootnoteItem(out *bytes.Buffer, name, text []byte, flags int)
 	TitleBlock(out *bytes.Buffer, text []byte)
+	Bibliography(out *bytes.Buffer, keys []string)
+	LineBlock(out *bytes.Buffer, lines [][]byte)
 
 	// Span-level callbacks
 	AutoLink(out *bytes.Buffer, link []byte, kind int)
 	CodeSpan(out *bytes.Buffer, text []byte)
 	DoubleEmphasis(out *bytes.Buffer, text []byte)
 	Emphasis(out *bytes.Buffer, text []byte)
-	Image(out *bytes.Buffer, link []byte, title []byte, alt []byte)
+	Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes)
 	LineBreak(out *bytes.Buffer)
-	Link(out *bytes.Buffer, link []byte, title []byte, content []byte)
+	Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes)
 	RawHtmlTag(out *bytes.Buffer, tag []byte)
 	TripleEmphasis(out *bytes.Buffer, text []byte)
 	StrikeThrough(out *bytes.Buffer, text []byte)
+	Highlight(out *bytes.Buffer, text []byte)
+	Insert(out *bytes.Buffer, text []byte)
+	CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte)
 	FootnoteRef(out *bytes.Buffer, ref []byte, id int)
+	Abbreviation(out *bytes.Buffer, abbr []byte, title []byte)
+	Mention(out *bytes.Buffer, kind int, token []byte)
+	Citation(out *bytes.Buffer, items []CitationItem)
+	MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte)
+	Kbd(out *bytes.Buffer, key []byte)
+	Ruby(out *bytes.Buffer, text []byte, annotation []byte)
+	Spoiler(out *bytes.Buffer, text []byte)
+	Shortcode(out *bytes.Buffer, text []byte)
 
 	// Low-level callbacks
 	Entity(out *bytes.Buffer, entity []byte)
@@ -199,6 +382,55 @@ type Renderer interface {
 	GetFlags() int
 }
 
+// A DocumentRenderer is a Renderer that keeps state while it renders a
+// document, such as the footnotes or the table of contents so far. The
+// parser renders each document with a renderer of its own from NewDocument,
+// so that one DocumentRenderer can be used by any number of goroutines at
+// once.
+//
+// The renderers of this package are DocumentRenderers in effect, without
+// the method. A type that wraps one of them by embedding it should
+// implement NewDocument itself, with ForDocument:
+//
+//	func (r wrapper) NewDocument() blackfriday.Renderer {
+//	    return wrapper{blackfriday.ForDocument(r.Renderer)}
+//	}
+//
+// Otherwise the documents share the state of the wrapped renderer, and the
+// wrapper can only render one document at a time.
+type DocumentRenderer interface {
+	Renderer
+
+	// NewDocument returns a renderer with the same configuration and none
+	// of the state, for one document.
+	NewDocument() Renderer
+}
+
+// ForDocument returns the renderer to render one document with: a new one
+// for the renderers of this package and for DocumentRenderers, and r
+// itself for others. The renderers of this package are matched by their
+// type, so that a type embedding one of them is never replaced by a copy
+// of what it embeds.
+func ForDocument(r Renderer) Renderer {
+	switch r := r.(type) {
+	case *Html:
+		return r.newDocument()
+	case *Latex:
+		return r.newDocument()
+	case *DocBook:
+		return r.newDocument()
+	case *MarkdownFormatter:
+		return r.newDocument()
+	case *Confluence:
+		return r.newDocument()
+	case *Slack:
+		return r.newDocument()
+	case DocumentRenderer:
+		return r.NewDocument()
+	}
+	return r
+}
+
 // Callback functions for inline parsing. One such function is defined
 // for each character that triggers a response when parsing inline data.
 type inlineParser func(p *parser, out *bytes.Buffer, data []byte, offset int) int
@@ -206,25 +438,124 @@ type inlineParser func(p *parser, out *bytes.Buffer, data []byte, offset int) in
 // Parser holds runtime state used by the parser.
 // This is constructed by the Markdown function.
 type parser struct {
-	r              Renderer
-	refOverride    ReferenceOverrideFunc
-	refs           map[string]*reference
-	inlineCallback [256]inlineParser
-	flags          int
-	nesting        int
-	maxNesting     int
-	insideLink     bool
+	r                 Renderer
+	refOverride       ReferenceOverrideFunc
+	refUnresolved     UnresolvedReferenceFunc
+	wikiResolver      WikiLinkResolverFunc
+	includeResolver   IncludeResolverFunc
+	variables         map[string]string
+	varResolver       VariableResolverFunc
+	shortcodeResolver ShortcodeResolverFunc
+	refs              map[string]*reference
+	externalRefs      map[string]*reference
+	refScratch        []byte
+	allowedSchemes    []string
+	inlineCallback    [256]inlineParser
+	flags             Extensions
+	nesting           int
+	maxNesting        int
+	workers           int
+	invalidUTF8       int
+	strikethrough     int
+	rubyDelimiters    [3]string
+	frontMatter       [2]string
+	blockTags         map[string]struct{}
+	insideLink        bool
 
 	// Footnotes need to be ordered as well as available to quickly check for
 	// presence. If a ref is also a footnote, it's stored both in refs and here
 	// in notes. Slice is nil if footnotes not enabled.
 	notes       []*reference
 	notesRecord map[string]struct{}
+
+	// Header IDs already handed to the renderer, to keep them unique.
+	headerIDs map[string]int
+
+	// Abbreviation definitions, longest first so that the longest
+	// matching term wins.
+	abbreviations []*abbreviation
+
+	// The id of each header by its lowercased title, and the title by
+	// the id, for cross-references. indexing is set while they are
+	// collected.
+	headerRefs   map[string]string
+	headerTitles map[string][]byte
+	indexing     bool
+
+	// The number of each labelled example, and the number of examples so
+	// far.
+	examples     map[string]int
+	exampleCount int
+
+	// Citation keys in the order they are first cited, for the
+	// bibliography.
+	citations []string
+	cited     map[string]struct{}
+
+	// The output of the first pass, and the input line of each of its
+	// lines, to report the positions of diagnostics.
+	doc   []byte
+	lines []int
+
+	// The furthest position of doc that a block parser looked at in a
+	// search for the end of a block that it did not find, for Snapshot,
+	// and the last search for each closer of a block, for nextCloser.
+	reach   int
+	closers map[string]closerSearch
+
+	// The renderer building a tree, if it is one, which is told where in
+	// the document each node comes from. The copies of the document that
+	// nested blocks are being parsed from, and how far before its trigger
+	// the last span parser started its span.
+	ast     *astRecorder
+	copies  []*sourceCopy
+	rewound int
+
+	// The input as the first pass read it, with the length of the byte
+	// order mark left out of it, where the lines of it and of doc start,
+	// and where the columns of the lines of doc that the first pass
+	// changed come from, to turn positions in doc into ones in the input.
+	source      []byte
+	sourceBase  int
+	sourceLines []int
+	docLines    []int
+	columns     map[int][]int
+
+	// The input line of each line of the input with its includes
+	// expanded.
+	includeLines []int
+
+	// The problems found in the input, if diagnosing, and the ones of
+	// them seen already.
+	diagnostics []Diagnostic
+	diagnosed   map[Diagnostic]bool
+	diagnosing  bool
+
+	// The headers found for Outline, which sets outlining.
+	outline   []Heading
+	outlining bool
+
+	// The text inline is parsing, and its index once a span parser needs
+	// one.
+	span      []byte
+	spanIndex *spanIndex
+
+	// In CommonMark mode, how the emphasis of the span inline is parsing
+	// pairs up, once emphasis needs it.
+	emphases *emphasisIndex
+
+	// The nesting of the blocks of a tight CommonMark list item, whose
+	// paragraphs are rendered as their text, or 0.
+	tight int
+
+	// Closed once the caller of MarkdownContext gives up on the document,
+	// which stops the parser at the next block. nil otherwise.
+	done <-chan struct{}
 }
 
-func (p *parser) getRef(refid string) (ref *reference, found bool) {
+func (p *parser) getRef(refid []byte) (ref *reference, found bool) {
 	if p.refOverride != nil {
-		r, overridden := p.refOverride(refid)
+		r, overridden := p.refOverride(string(refid))
 		if overridden {
 			if r == nil {
 				return nil, false
@@ -237,11 +568,54 @@ func (p *parser) getRef(refid string) (ref *reference, found bool) {
 				text:     []byte(r.Text)}, true
 		}
 	}
-	// refs are case insensitive
-	ref, found = p.refs[strings.ToLower(refid)]
+	// refs are case insensitive, and in CommonMark blind to runs of space
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		refid = commonmarkLabel(refid)
+	}
+	key := p.refKey(refid)
+	ref, found = p.refs[string(key)]
+	if !found {
+		ref, found = p.externalRefs[string(key)]
+	}
+	if !found {
+		ref, found = p.headerRef(string(refid))
+	}
 	return ref, found
 }
 
+// refKey returns refid in lower case, as the refs are keyed. ASCII ids are
+// lowered into a scratch buffer, so that looking them up allocates nothing.
+func (p *parser) refKey(refid []byte) []byte {
+	key := p.refScratch[:0]
+	for _, c := range refid {
+		if c >= utf8.RuneSelf {
+			return bytes.ToLower(refid)
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		key = append(key, c)
+	}
+	p.refScratch = key
+	return key
+}
+
+// resolveRef asks the UnresolvedReference callback for a reference that
+// getRef didn't find
+func (p *parser) resolveRef(refid string) (*reference, bool) {
+	if p.refUnresolved == nil || p.indexing {
+		return nil, false
+	}
+	r, ok := p.refUnresolved(refid)
+	if !ok {
+		return nil, false
+	}
+	return &reference{
+		link:  []byte(r.Link),
+		title: []byte(r.Title),
+		text:  []byte(r.Text)}, true
+}
+
 func (p *parser) isFootnote(ref *reference) bool {
 	_, ok := p.notesRecord[string(ref.link)]
 	return ok
@@ -271,12 +645,50 @@ type Reference struct {
 // See the documentation in Options for more details on use-case.
 type ReferenceOverrideFunc func(reference string) (ref *Reference, overridden bool)
 
+// UnresolvedReferenceFunc is called with the id of a [text][id] link whose
+// reference is not defined, and returns the reference to use instead. If ok
+// is false, the link is left as plain text.
+// See the documentation in Options for more details on use-case.
+type UnresolvedReferenceFunc func(reference string) (ref Reference, ok bool)
+
+// WikiLinkResolverFunc is called with the page name of a [[Page Name]] wiki
+// link and returns the URL the link should point to. If ok is false, the
+// text is not turned into a link.
+// See the documentation in Options for more details on use-case.
+type WikiLinkResolverFunc func(page string) (url string, ok bool)
+
+// VariableResolverFunc is called with the name of a {{name}} placeholder
+// and returns its value. If ok is false, the placeholder is left as it is.
+type VariableResolverFunc func(name string) (value string, ok bool)
+
+// ShortcodeResolverFunc is called with a shortcode found with
+// EXTENSION_SHORTCODES, delimiters included, and returns the output to
+// write in its place. If ok is false, the shortcode is written as it is.
+type ShortcodeResolverFunc func(shortcode string) (output string, ok bool)
+
+// IncludeResolverFunc is called with the path given by an include
+// directive, as it is written, and returns the document it names.
+// See the documentation in Options for more details on use-case.
+type IncludeResolverFunc func(path string) ([]byte, error)
+
+// InlineParserFunc parses a custom span in inline text. It is called when
+// the parser meets the trigger character it was registered for. data holds
+// the text of the whole block being parsed, and data[offset] is the trigger
+// character; everything before it has been written to out already.
+//
+// The function writes its output to out, using r to render any text or
+// elements, and returns the number of bytes it consumed from data[offset:].
+// It returns 0 if there is no span at offset, in which case out must be
+// left as it was. The trigger character is then handled by the built-in
+// parser for it, if any, or written out as text.
+type InlineParserFunc func(r Renderer, out *bytes.Buffer, data []byte, offset int) int
+
 // Options represents configurable overrides and callbacks (in addition to the
 // extension flag set) for configuring a Markdown parse.
 type Options struct {
 	// Extensions is a flag set of bit-wise ORed extension bits. See the
 	// EXTENSION_* flags defined in this package.
-	Extensions int
+	Extensions Extensions
 
 	// ReferenceOverride is an optional function callback that is called every
 	// time a reference is resolved.
@@ -293,6 +705,309 @@ type Options struct {
 	// the override function indicates an override did not occur, the refids at
 	// the bottom will be used to fill in the link details.
 	ReferenceOverride ReferenceOverrideFunc
+
+	// UnresolvedReference is an optional function callback that is called
+	// for the reference links that resolve neither through
+	// ReferenceOverride nor to a reference of the document or References.
+	// It can resolve them on the fly, say from a link database, or record
+	// them. The links it leaves unresolved are left as plain text and
+	// reported by MarkdownDiagnostics, along with their line, so that a
+	// documentation build can fail on them.
+	UnresolvedReference UnresolvedReferenceFunc
+
+	// WikiLinkResolver is an optional function callback that maps the page
+	// names of wiki links to URLs when EXTENSION_WIKI_LINKS is enabled. Wiki
+	// links take one of the following forms:
+	//
+	//  * [[Page Name]]
+	//  * [[Page Name|link text]]
+	//
+	// If no resolver is provided, the page name itself is used as the URL.
+	WikiLinkResolver WikiLinkResolverFunc
+
+	// MaxNesting limits how deeply blocks and spans can be nested inside
+	// each other. Deeper elements are left out of the output. Zero means
+	// the default of 16.
+	MaxNesting int
+
+	// InlineParsers are custom span parsers, by trigger character. They
+	// are tried before any built-in parser for the same character.
+	InlineParsers map[byte]InlineParserFunc
+
+	// References are link references defined outside of the document, such
+	// as a site-wide list of links, by reference id. They are used for the
+	// ids that the document doesn't define itself. Like the ids defined in
+	// the document, they are matched case-insensitively.
+	References map[string]Reference
+
+	// AllowedSchemes are the URL schemes that link, image and autolink
+	// destinations may use, in lower case; DefaultAllowedSchemes is a
+	// common choice. Links with any other scheme are left as plain text,
+	// for every renderer. Links without a scheme, such as relative ones,
+	// are always allowed. If nil, any scheme is allowed.
+	AllowedSchemes []string
+
+	// BlockTags are tag names to recognize as HTML blocks on top of the
+	// built-in ones, such as "details" or custom elements like
+	// "my-widget". Like the built-in ones, they are matched
+	// case-sensitively.
+	BlockTags []string
+
+	// IncludeResolver is an optional function callback that returns the
+	// documents included with EXTENSION_INCLUDE. An include directive is a
+	// line of its own, in one of the following forms:
+	//
+	//  * {{include "path.md"}}
+	//  * <!--#include file="path.md"-->
+	//
+	// The directive is replaced with the document, which can include
+	// others in turn. If the resolver returns an error, or the document
+	// would include itself, the directive is left as it is.
+	IncludeResolver IncludeResolverFunc
+
+	// Variables are the values of the {{name}} placeholders replaced with
+	// EXTENSION_VARIABLES, by name. Names not found in it are passed to
+	// VariableResolver, if there is one. The values are written as plain
+	// text, not parsed as Markdown. Placeholders in code are left alone.
+	Variables        map[string]string
+	VariableResolver VariableResolverFunc
+
+	// ShortcodeResolver is an optional function callback that expands the
+	// shortcodes passed through with EXTENSION_SHORTCODES, for renderers
+	// that don't leave them to a static site generator. Its output is
+	// written as it is, like the shortcodes it doesn't expand.
+	ShortcodeResolver ShortcodeResolverFunc
+
+	// Strikethrough is the STRIKETHROUGH_* value choosing whether
+	// EXTENSION_STRIKETHROUGH takes one tilde, two or either around the
+	// struck text. The default is two.
+	Strikethrough int
+
+	// RubyDelimiters are the opening, separating and closing delimiters
+	// of the ruby annotations of EXTENSION_RUBY. The default is {"{", "|",
+	// "}"}, as in {漢字|かんじ}; {"｜", "《", "》"} gives the ｜漢字《かんじ》
+	// of Japanese e-texts. Any of them left empty takes its default.
+	RubyDelimiters [3]string
+
+	// FrontMatterDelimiters are the fences around YAML and TOML front
+	// matter, for SplitFrontMatter and EXTENSION_FRONT_MATTER. The default
+	// is {"---", "+++"}; either of them left empty takes its default. JSON
+	// front matter is always between braces.
+	FrontMatterDelimiters [2]string
+
+	// Workers is the number of goroutines that render the top-level blocks
+	// of large documents at the same time, for less latency on documents
+	// of megabytes. The output is the same as with a single one. Zero or
+	// one renders the blocks one after the other, as do footnotes,
+	// citations, example lists and cross-references, which number things
+	// throughout the document, and renderers that number headers or
+	// collect a table of contents. Custom renderers have to be
+	// DocumentRenderers, and the callbacks in the options and renderer
+	// parameters may be called from several goroutines at once.
+	Workers int
+
+	// InvalidUTF8 is the INVALID_UTF8_* value choosing what becomes of
+	// input that isn't valid UTF-8, which would otherwise end up in the
+	// output and trip up XML or JSON encoders after the renderer. All but
+	// INVALID_UTF8_KEEP leave out a byte order mark at the start of the
+	// input too. The default is to keep the input as it is.
+	InvalidUTF8 int
+}
+
+// DefaultAllowedSchemes are URL schemes that are safe to link to.
+var DefaultAllowedSchemes = []string{"http", "https", "mailto", "ftp"}
+
+// Option sets one of the fields of Options. Options are passed to New.
+type Option func(*Options)
+
+// WithExtensions enables the EXTENSION_* flags in extensions, on top of
+// the ones enabled already.
+func WithExtensions(extensions Extensions) Option {
+	return func(opts *Options) {
+		opts.Extensions |= extensions
+	}
+}
+
+// WithReferenceOverride sets Options.ReferenceOverride.
+func WithReferenceOverride(override ReferenceOverrideFunc) Option {
+	return func(opts *Options) {
+		opts.ReferenceOverride = override
+	}
+}
+
+// WithUnresolvedReference sets Options.UnresolvedReference.
+func WithUnresolvedReference(resolver UnresolvedReferenceFunc) Option {
+	return func(opts *Options) {
+		opts.UnresolvedReference = resolver
+	}
+}
+
+// WithWikiLinkResolver sets Options.WikiLinkResolver.
+func WithWikiLinkResolver(resolver WikiLinkResolverFunc) Option {
+	return func(opts *Options) {
+		opts.WikiLinkResolver = resolver
+	}
+}
+
+// WithIncludeResolver sets Options.IncludeResolver.
+func WithIncludeResolver(resolver IncludeResolverFunc) Option {
+	return func(opts *Options) {
+		opts.IncludeResolver = resolver
+	}
+}
+
+// WithVariables sets Options.Variables.
+func WithVariables(vars map[string]string) Option {
+	return func(opts *Options) {
+		opts.Variables = vars
+	}
+}
+
+// WithVariableResolver sets Options.VariableResolver.
+func WithVariableResolver(resolver VariableResolverFunc) Option {
+	return func(opts *Options) {
+		opts.VariableResolver = resolver
+	}
+}
+
+// WithShortcodeResolver sets Options.ShortcodeResolver.
+func WithShortcodeResolver(resolver ShortcodeResolverFunc) Option {
+	return func(opts *Options) {
+		opts.ShortcodeResolver = resolver
+	}
+}
+
+// WithWorkers sets Options.Workers.
+func WithWorkers(workers int) Option {
+	return func(opts *Options) {
+		opts.Workers = workers
+	}
+}
+
+// WithMaxNesting sets Options.MaxNesting.
+func WithMaxNesting(depth int) Option {
+	return func(opts *Options) {
+		opts.MaxNesting = depth
+	}
+}
+
+// WithStrikethrough sets Options.Strikethrough.
+func WithStrikethrough(tildes int) Option {
+	return func(opts *Options) {
+		opts.Strikethrough = tildes
+	}
+}
+
+// WithRubyDelimiters sets Options.RubyDelimiters.
+func WithRubyDelimiters(open, sep, close string) Option {
+	return func(opts *Options) {
+		opts.RubyDelimiters = [3]string{open, sep, close}
+	}
+}
+
+// WithFrontMatterDelimiters sets Options.FrontMatterDelimiters.
+func WithFrontMatterDelimiters(yaml, toml string) Option {
+	return func(opts *Options) {
+		opts.FrontMatterDelimiters = [2]string{yaml, toml}
+	}
+}
+
+// WithInvalidUTF8 sets Options.InvalidUTF8.
+func WithInvalidUTF8(mode int) Option {
+	return func(opts *Options) {
+		opts.InvalidUTF8 = mode
+	}
+}
+
+// WithReferences sets Options.References.
+func WithReferences(refs map[string]Reference) Option {
+	return func(opts *Options) {
+		opts.References = refs
+	}
+}
+
+// WithAllowedSchemes sets Options.AllowedSchemes.
+func WithAllowedSchemes(schemes ...string) Option {
+	return func(opts *Options) {
+		opts.AllowedSchemes = schemes
+	}
+}
+
+// WithBlockTags adds tags to Options.BlockTags.
+func WithBlockTags(tags ...string) Option {
+	return func(opts *Options) {
+		opts.BlockTags = append(append([]string(nil), opts.BlockTags...), tags...)
+	}
+}
+
+// WithInlineParser adds fn to Options.InlineParsers for the trigger
+// character c, replacing any parser added for it before.
+func WithInlineParser(c byte, fn InlineParserFunc) Option {
+	return func(opts *Options) {
+		parsers := make(map[byte]InlineParserFunc, len(opts.InlineParsers)+1)
+		for k, v := range opts.InlineParsers {
+			parsers[k] = v
+		}
+		parsers[c] = fn
+		opts.InlineParsers = parsers
+	}
+}
+
+// Processor parses markdown input with a fixed set of options. It holds no
+// state between calls, so one Processor can be used for any number of
+// documents, also concurrently. So can the renderers of this package and
+// other DocumentRenderers, which Render gives a renderer of their own for
+// each document:
+//
+//	md := blackfriday.New(blackfriday.WithExtensions(blackfriday.EXTENSION_FOOTNOTES))
+//	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_TOC, "", "")
+//	// in any number of goroutines
+//	output, err := md.Render(input, renderer)
+type Processor struct {
+	opts Options
+}
+
+// New creates a Processor with the given options applied in order:
+//
+//	md := blackfriday.New(
+//	    blackfriday.WithExtensions(blackfriday.EXTENSION_TABLES),
+//	    blackfriday.WithMaxNesting(32),
+//	)
+//	output, err := md.Render(input, blackfriday.HtmlRenderer(0, "", ""))
+func New(opts ...Option) *Processor {
+	p := new(Processor)
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+	return p
+}
+
+// RegisterInline registers fn as the custom span parser for the trigger
+// character c, like WithInlineParser. It must not be called while the
+// Processor is in use.
+func (p *Processor) RegisterInline(c byte, fn InlineParserFunc) {
+	WithInlineParser(c, fn)(&p.opts)
+}
+
+// Options returns the options the Processor was created with.
+func (p *Processor) Options() Options {
+	return p.opts
+}
+
+// Render parses and renders a block of markdown-encoded text with the
+// given renderer. If the parser or the renderer fails, it returns an error
+// rather than panicking.
+func (p *Processor) Render(input []byte, renderer Renderer) ([]byte, error) {
+	if renderer == nil {
+		return nil, nil
+	}
+	return newParser(renderer, p.opts).render(input)
+}
+
+// Parse parses a block of markdown-encoded text into a document tree. If
+// the parser fails, it returns an error rather than panicking.
+func (p *Processor) Parse(input []byte) (*Node, error) {
+	return parse(input, p.opts)
 }
 
 // MarkdownBasic is a convenience function for simple rendering.
@@ -332,6 +1047,29 @@ func MarkdownCommon(input []byte) []byte {
 		Extensions: commonExtensions})
 }
 
+// MarkdownGitHub is a convenience function for rendering the way GitHub
+// does. It processes markdown input with the GitHub Flavored Markdown
+// extensions enabled on top of CommonMark mode:
+//
+// * Tables
+//
+// * Fenced code blocks
+//
+// * Strikethrough support, with one tilde or two
+//
+// * Task list items
+//
+// * Autolinking, including www. links without a scheme
+//
+// * Filtering of raw HTML tags such as <script> and <iframe>
+func MarkdownGitHub(input []byte) []byte {
+	// set up the HTML renderer
+	renderer := HtmlRenderer(githubHtmlFlags, "", "")
+	return MarkdownOptions(input, renderer, Options{
+		Extensions:    githubExtensions,
+		Strikethrough: STRIKETHROUGH_BOTH})
+}
+
 // Markdown is the main rendering function.
 // It parses and renders a block of markdown-encoded text.
 // The supplied Renderer is used to format the output, and extensions dictates
@@ -339,29 +1077,125 @@ func MarkdownCommon(input []byte) []byte {
 //
 // To use the supplied Html or LaTeX renderers, see HtmlRenderer and
 // LatexRenderer, respectively.
-func Markdown(input []byte, renderer Renderer, extensions int) []byte {
+//
+// Deprecated: Use New(WithExtensions(extensions)).Render(input, renderer),
+// which can be given further options.
+func Markdown(input []byte, renderer Renderer, extensions Extensions) []byte {
 	return MarkdownOptions(input, renderer, Options{
 		Extensions: extensions})
 }
 
 // MarkdownOptions is just like Markdown but takes additional options through
 // the Options struct.
+//
+// It panics if the parser or the renderer fails. Processor.Render returns
+// an error instead.
 func MarkdownOptions(input []byte, renderer Renderer, opts Options) []byte {
 	// no point in parsing if we can't render
 	if renderer == nil {
 		return nil
 	}
 
+	output, err := newParser(renderer, opts).render(input)
+	if err != nil {
+		panic(err)
+	}
+	return output
+}
+
+// MarkdownToWriter is like Markdown, but reads the input from r and writes
+// the output to w. It returns the first error encountered while reading,
+// rendering or writing.
+//
+// The parser still needs the whole document in memory, but it works on the
+// input as it was read where it can, and the caller needs no copies of its
+// own.
+func MarkdownToWriter(w io.Writer, r io.Reader, renderer Renderer, extensions Extensions) error {
+	if renderer == nil {
+		return nil
+	}
+
+	var input bytes.Buffer
+	if _, err := input.ReadFrom(r); err != nil {
+		return err
+	}
+	output, err := newParser(renderer, Options{Extensions: extensions}).render(input.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(output)
+	return err
+}
+
+// References returns the link references defined in a block of
+// markdown-encoded text, parsed with the extensions given in opts. The
+// reference ids are lowercased, as they are matched case-insensitively.
+// Footnotes are not included.
+func References(input []byte, opts Options) map[string]Reference {
+	p := newParser(BaseRenderer{}, opts)
+	firstPass(p, input)
+
+	refs := make(map[string]Reference, len(p.refs))
+	for id, ref := range p.refs {
+		if ref.noteId == 0 {
+			refs[id] = Reference{Link: string(ref.link), Title: string(ref.title)}
+		}
+	}
+	return refs
+}
+
+// newParser creates a parser with the given renderer and options
+func newParser(renderer Renderer, opts Options) *parser {
 	extensions := opts.Extensions
 
 	// fill in the render structure
 	p := new(parser)
-	p.r = renderer
+	p.r = ForDocument(renderer)
+	p.ast, _ = p.r.(*astRecorder)
 	p.flags = extensions
 	p.refOverride = opts.ReferenceOverride
+	p.refUnresolved = opts.UnresolvedReference
+	p.wikiResolver = opts.WikiLinkResolver
+	p.includeResolver = opts.IncludeResolver
+	p.variables = opts.Variables
+	p.varResolver = opts.VariableResolver
+	p.shortcodeResolver = opts.ShortcodeResolver
 	p.refs = make(map[string]*reference)
+	if len(opts.References) > 0 {
+		p.externalRefs = make(map[string]*reference, len(opts.References))
+		for id, r := range opts.References {
+			p.externalRefs[strings.ToLower(id)] = &reference{
+				link:  []byte(r.Link),
+				title: []byte(r.Title),
+				text:  []byte(r.Text),
+			}
+		}
+	}
+	if opts.AllowedSchemes != nil {
+		p.allowedSchemes = make([]string, len(opts.AllowedSchemes))
+		for i, scheme := range opts.AllowedSchemes {
+			p.allowedSchemes[i] = strings.ToLower(scheme)
+		}
+	}
 	p.maxNesting = 16
+	if opts.MaxNesting > 0 {
+		p.maxNesting = opts.MaxNesting
+	}
+	p.strikethrough = opts.Strikethrough
+	p.workers = opts.Workers
+	p.invalidUTF8 = opts.InvalidUTF8
 	p.insideLink = false
+	p.headerIDs = make(map[string]int)
+	p.blockTags = blockTags
+	if len(opts.BlockTags) > 0 {
+		p.blockTags = make(map[string]struct{}, len(blockTags)+len(opts.BlockTags))
+		for tag := range blockTags {
+			p.blockTags[tag] = struct{}{}
+		}
+		for _, tag := range opts.BlockTags {
+			p.blockTags[tag] = struct{}{}
+		}
+	}
 
 	// register inline parsers
 	p.inlineCallback['*'] = emphasis
@@ -369,6 +1203,30 @@ func MarkdownOptions(input []byte, renderer Renderer, opts Options) []byte {
 	if extensions&EXTENSION_STRIKETHROUGH != 0 {
 		p.inlineCallback['~'] = emphasis
 	}
+	if extensions&EXTENSION_HIGHLIGHT != 0 {
+		p.inlineCallback['='] = emphasis
+	}
+	if extensions&EXTENSION_INSERT != 0 {
+		p.inlineCallback['+'] = emphasis
+	}
+	if extensions&EXTENSION_CRITIC_MARKUP != 0 {
+		p.inlineCallback['{'] = criticMarkup
+	}
+	if extensions&EXTENSION_VARIABLES != 0 {
+		p.inlineCallback['{'] = variable
+	}
+	if extensions&EXTENSION_SHORTCODES != 0 {
+		builtin := p.inlineCallback['{']
+		p.inlineCallback['{'] = func(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+			if consumed := shortcode(p, out, data, offset); consumed > 0 {
+				return consumed
+			}
+			if builtin != nil {
+				return builtin(p, out, data, offset)
+			}
+			return 0
+		}
+	}
 	p.inlineCallback['`'] = codeSpan
 	p.inlineCallback['\n'] = lineBreak
 	p.inlineCallback['['] = link
@@ -380,30 +1238,143 @@ func MarkdownOptions(input []byte, renderer Renderer, opts Options) []byte {
 		p.inlineCallback[':'] = autoLink
 	}
 
+	if extensions&EXTENSION_MENTIONS != 0 {
+		p.inlineCallback['@'] = mention
+		p.inlineCallback['#'] = mention
+	}
+
+	if extensions&EXTENSION_EXTENDED_AUTOLINK != 0 {
+		p.inlineCallback['w'] = wwwAutoLink
+		p.inlineCallback['.'] = domainAutoLink
+		p.inlineCallback['@'] = emailAutoLink
+	}
+
+	if extensions&EXTENSION_EXAMPLE_LISTS != 0 {
+		p.inlineCallback['('] = exampleRef
+	}
+
+	if extensions&EXTENSION_SPOILERS != 0 {
+		p.inlineCallback['|'] = spoilerSpan
+	}
+
+	if extensions&EXTENSION_FRONT_MATTER != 0 {
+		p.frontMatter = frontMatterDelimiters(opts)
+	}
+
 	if extensions&EXTENSION_FOOTNOTES != 0 {
 		p.notes = make([]*reference, 0)
 		p.notesRecord = make(map[string]struct{})
 	}
 
+	if extensions&EXTENSION_RUBY != 0 {
+		p.rubyDelimiters = defaultRubyDelimiters
+		for i, delim := range opts.RubyDelimiters {
+			if delim != "" {
+				p.rubyDelimiters[i] = delim
+			}
+		}
+		// CriticMarkup, variables and shortcodes, which also start with
+		// a brace, take precedence
+		c := p.rubyDelimiters[0][0]
+		builtin := p.inlineCallback[c]
+		p.inlineCallback[c] = func(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+			if builtin != nil {
+				if consumed := builtin(p, out, data, offset); consumed > 0 {
+					return consumed
+				}
+			}
+			return ruby(p, out, data, offset)
+		}
+	}
+
+	// custom parsers go first, falling back on the built-in ones
+	for c, fn := range opts.InlineParsers {
+		builtin := p.inlineCallback[c]
+		fn := fn
+		p.inlineCallback[c] = func(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+			if consumed := fn(p.r, out, data, offset); consumed > 0 {
+				return consumed
+			}
+			if builtin != nil {
+				return builtin(p, out, data, offset)
+			}
+			return 0
+		}
+	}
+
+	return p
+}
+
+// render runs both passes over input. A panic within the parser or the
+// renderer is returned as an error.
+func (p *parser) render(input []byte) (output []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			output, err = nil, fmt.Errorf("blackfriday: %v", r)
+		}
+	}()
+
+	if err := p.checkUTF8(input); err != nil {
+		return nil, err
+	}
 	first := firstPass(p, input)
-	second := secondPass(p, first)
-	return second
+	return secondPass(p, first)
 }
 
 // first pass:
+// - include other documents
+// - drop comment lines (outside of fenced code blocks)
 // - normalize newlines
 // - extract references (outside of fenced code blocks)
 // - expand tabs (outside of fenced code blocks)
 // - copy everything else
+//
+// If there is nothing to change, the input is returned as it is, without
+// the front matter, rather than copied.
 func firstPass(p *parser, input []byte) []byte {
+	p.source = p.cleanUTF8(input)
+	if len(p.source) < len(input) && bytes.HasPrefix(input, byteOrderMark) {
+		p.sourceBase = len(byteOrderMark)
+	}
+	input = p.source
+	if p.flags&EXTENSION_INCLUDE != 0 && p.includeResolver != nil {
+		input = p.expandIncludes(input)
+	}
+
+	beg := 0
+	if p.flags&EXTENSION_FRONT_MATTER != 0 {
+		_, _, beg = frontMatter(input, p.frontMatter)
+	}
+	if p.includeLines == nil && p.isPlainText(input[beg:]) {
+		line, n := 1+bytes.Count(input[:beg], []byte("\n")), bytes.Count(input[beg:], []byte("\n"))
+		if p.lines == nil {
+			p.lines = make([]int, 0, n)
+		}
+		for i := 0; i < n; i++ {
+			p.lines = append(p.lines, line+i)
+		}
+		// capped, so that nothing appended to the document ends up in
+		// the caller's buffer
+		return input[beg:len(input):len(input)]
+	}
+
+	// the output is about as long as the input
 	var out bytes.Buffer
+	out.Grow(len(input) + 1)
+	if p.lines == nil {
+		p.lines = make([]int, 0, bytes.Count(input, []byte("\n"))+1)
+	}
 	tabSize := TAB_SIZE_DEFAULT
 	if p.flags&EXTENSION_TAB_SIZE_EIGHT != 0 {
 		tabSize = TAB_SIZE_EIGHT
 	}
-	beg := 0
 	lastFencedCodeBlockEnd := 0
+	line, lineBeg := 1, 0
+	paragraph := false // CommonMark's references can't interrupt paragraphs
 	for beg < len(input) {
+		line += bytes.Count(input[lineBeg:beg], []byte("\n"))
+		lineBeg = beg
+
 		// Find end of this line, then process the line.
 		end := beg
 		for end < len(input) && input[end] != '\n' && input[end] != '\r' {
@@ -420,18 +1391,47 @@ func firstPass(p *parser, input []byte) []byte {
 			}
 		}
 
+		// drop %% comment lines altogether
+		if p.flags&EXTENSION_COMMENTS != 0 && end >= lastFencedCodeBlockEnd && isCommentLine(input[beg:end]) {
+			if end < len(input) && input[end] == '\r' {
+				end++
+			}
+			if end < len(input) && input[end] == '\n' {
+				end++
+			}
+			beg = end
+			continue
+		}
+
 		// add the line body if present
 		if end > beg {
+			refEnd := 0
+			if end >= lastFencedCodeBlockEnd && !paragraph {
+				refEnd = isReference(p, input[beg:], tabSize)
+			}
 			if end < lastFencedCodeBlockEnd { // Do not expand tabs while inside fenced code blocks.
 				out.Write(input[beg:end])
-			} else if refEnd := isReference(p, input[beg:], tabSize); refEnd > 0 {
+			} else if refEnd > 0 {
 				beg += refEnd
 				continue
+			} else if abbrEnd := isAbbreviation(p, input[beg:]); abbrEnd > 0 {
+				beg += abbrEnd
+				continue
 			} else {
 				expandTabs(&out, input[beg:end], tabSize)
 			}
 		}
 
+		if p.flags&EXTENSION_COMMONMARK != 0 {
+			// the block checks expect the line to end in a newline
+			body := input[beg:]
+			if end == len(input) {
+				body = append(body[:len(body):len(body)], '\n')
+			}
+			paragraph = end >= lastFencedCodeBlockEnd &&
+				(p.paragraphLine(body) || paragraph && p.continuesParagraph(body))
+		}
+
 		if end < len(input) && input[end] == '\r' {
 			end++
 		}
@@ -439,6 +1439,11 @@ func firstPass(p *parser, input []byte) []byte {
 			end++
 		}
 		out.WriteByte('\n')
+		if p.includeLines != nil && line <= len(p.includeLines) {
+			p.lines = append(p.lines, p.includeLines[line-1])
+		} else {
+			p.lines = append(p.lines, line)
+		}
 
 		beg = end
 	}
@@ -451,15 +1456,59 @@ func firstPass(p *parser, input []byte) []byte {
 	return out.Bytes()
 }
 
+// isPlainText reports whether the first pass would copy data as it is:
+// it ends with a newline, has no tabs or carriage returns, and none of its
+// lines could start a reference, an abbreviation or a comment.
+func (p *parser) isPlainText(data []byte) bool {
+	if len(data) == 0 || data[len(data)-1] != '\n' ||
+		bytes.IndexByte(data, '\t') >= 0 || bytes.IndexByte(data, '\r') >= 0 {
+		return false
+	}
+	for beg := 0; beg < len(data); beg = skipUntilChar(data, beg, '\n') + 1 {
+		i := beg
+		for i < beg+3 && data[i] == ' ' {
+			i++
+		}
+		switch {
+		case data[i] == '[':
+			return false
+		case data[i] == '*' && data[i+1] == '[' && p.flags&EXTENSION_ABBREVIATIONS != 0:
+			return false
+		case data[i] == '%' && data[i+1] == '%' && p.flags&EXTENSION_COMMENTS != 0:
+			return false
+		}
+	}
+	return true
+}
+
 // second pass: actual rendering
-func secondPass(p *parser, input []byte) []byte {
+func secondPass(p *parser, input []byte) ([]byte, error) {
 	var output bytes.Buffer
+	output.Grow(len(input) + len(input)/2)
 
+	p.doc = input
+	if p.flags&(EXTENSION_CROSS_REFERENCES|EXTENSION_EXAMPLE_LISTS) != 0 {
+		p.index(input)
+	}
 	p.r.DocumentHeader(&output)
-	p.block(&output, input)
+	if !p.parallelBlocks(&output, input) {
+		p.block(&output, input)
+	}
+	p.endNotes(&output)
+	p.r.DocumentFooter(&output)
+
+	if p.nesting != 0 {
+		return nil, errors.New("blackfriday: nesting level did not end at zero")
+	}
 
+	return output.Bytes(), nil
+}
+
+// endNotes renders the footnotes and the bibliography that follow the
+// blocks of the document
+func (p *parser) endNotes(output *bytes.Buffer) {
 	if p.flags&EXTENSION_FOOTNOTES != 0 && len(p.notes) > 0 {
-		p.r.Footnotes(&output, func() bool {
+		p.r.Footnotes(output, func() bool {
 			flags := LIST_ITEM_BEGINNING_OF_LIST
 			for i := 0; i < len(p.notes); i += 1 {
 				ref := p.notes[i]
@@ -470,7 +1519,7 @@ func secondPass(p *parser, input []byte) []byte {
 				} else {
 					p.inline(&buf, ref.title)
 				}
-				p.r.FootnoteItem(&output, ref.link, buf.Bytes(), flags)
+				p.r.FootnoteItem(output, ref.link, buf.Bytes(), flags)
 				flags &^= LIST_ITEM_BEGINNING_OF_LIST | LIST_ITEM_CONTAINS_BLOCK
 			}
 
@@ -478,13 +1527,11 @@ func secondPass(p *parser, input []byte) []byte {
 		})
 	}
 
-	p.r.DocumentFooter(&output)
-
-	if p.nesting != 0 {
-		panic("Nesting level did not end at zero")
+	// the bibliography comes last, so that it includes the works cited in
+	// footnotes
+	if p.flags&EXTENSION_CITATIONS != 0 && len(p.citations) > 0 {
+		p.r.Bibliography(output, p.citations)
 	}
-
-	return output.Bytes()
 }
 
 //
@@ -516,6 +1563,11 @@ func secondPass(p *parser, input []byte) []byte {
 //
 // are not yet supported.
 
+// Reference ids longer than this, as in CommonMark, are taken as text.
+// Looking up ever longer ids would make text full of brackets take
+// quadratic time.
+const maxReferenceLength = 999
+
 // References are parsed and stored in this struct.
 type reference struct {
 	link     []byte
@@ -560,11 +1612,14 @@ func isReference(p *parser, data []byte, tabSize int) int {
 			i++
 		}
 	}
+	if p.flags&EXTENSION_COMMONMARK != 0 && noteId == 0 {
+		return commonmarkReference(p, data, i)
+	}
 	idOffset := i
 	for i < len(data) && data[i] != '\n' && data[i] != '\r' && data[i] != ']' {
 		i++
 	}
-	if i >= len(data) || data[i] != ']' {
+	if i >= len(data) || data[i] != ']' || i-idOffset > maxReferenceLength {
 		return 0
 	}
 	idEnd := i
@@ -629,6 +1684,12 @@ func isReference(p *parser, data []byte, tabSize int) int {
 	// id matches are case-insensitive
 	id := string(bytes.ToLower(data[idOffset:idEnd]))
 
+	// CommonMark gives precedence to the first matching definition
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		if _, found := p.refs[id]; found {
+			return lineEnd
+		}
+	}
 	p.refs[id] = ref
 
 	return lineEnd
@@ -705,6 +1766,149 @@ func scanLinkRef(p *parser, data []byte, i int) (linkOffset, linkEnd, titleOffse
 	return
 }
 
+// commonmarkReference is isReference by the rules of CommonMark, for the
+// label that starts at data[i]. The label and the title can run over lines
+// that aren't blank, and so can the space before the destination and the
+// title. Anything but space after the title drops it, if it is on a line
+// of its own, or the whole definition otherwise.
+func commonmarkReference(p *parser, data []byte, i int) int {
+	idOffset := i
+	for ; i < len(data) && data[i] != ']'; i++ {
+		switch {
+		case data[i] == '[':
+			return 0
+		case data[i] == '\\' && i+1 < len(data) && ispunct(data[i+1]):
+			i++
+		case data[i] == '\n' && p.isEmpty(data[i+1:]) > 0:
+			return 0
+		}
+	}
+	if i >= len(data) || i-idOffset > maxReferenceLength || len(bytes.TrimSpace(data[idOffset:i])) == 0 {
+		return 0
+	}
+	idEnd := i
+	i++
+	if i >= len(data) || data[i] != ':' {
+		return 0
+	}
+	i = skipReferenceSpace(p, data, i+1)
+	if i >= len(data) || data[i] == '\n' {
+		return 0
+	}
+
+	// the destination, between angle brackets or without spaces
+	var linkOffset, linkEnd int
+	if data[i] == '<' {
+		linkOffset = i + 1
+		for i++; i < len(data) && data[i] != '>'; i++ {
+			if data[i] == '\n' || data[i] == '<' {
+				return 0
+			}
+			if data[i] == '\\' && i+1 < len(data) && ispunct(data[i+1]) {
+				i++
+			}
+		}
+		if i >= len(data) {
+			return 0
+		}
+		linkEnd = i
+		i++
+		if i < len(data) && !isspace(data[i]) {
+			return 0
+		}
+	} else {
+		linkOffset = i
+		for depth := 0; i < len(data) && data[i] > ' ' && data[i] != 0x7f; i++ {
+			switch data[i] {
+			case '\\':
+				if i+1 < len(data) && ispunct(data[i+1]) {
+					i++
+				}
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+		}
+		linkEnd = i
+		if linkEnd == linkOffset {
+			return 0
+		}
+	}
+	end := referenceLineEnd(data, i)
+
+	// the title, after some space
+	var title []byte
+	if j := skipReferenceSpace(p, data, i); j > i && j < len(data) && (data[j] == '"' || data[j] == '\'' || data[j] == '(') {
+		closer := data[j]
+		if closer == '(' {
+			closer = ')'
+		}
+		k := j + 1
+		for ; k < len(data) && data[k] != closer; k++ {
+			if data[k] == '\\' && k+1 < len(data) && ispunct(data[k+1]) {
+				k++
+			} else if closer == ')' && data[k] == '(' || data[k] == '\n' && p.isEmpty(data[k+1:]) > 0 {
+				k = len(data)
+			}
+		}
+		if k < len(data) {
+			if titleEnd := referenceLineEnd(data, k+1); titleEnd >= 0 {
+				var b bytes.Buffer
+				unescapeText(&b, data[j+1:k])
+				title, end = b.Bytes(), titleEnd
+			}
+		}
+	}
+	if end < 0 {
+		return 0
+	}
+
+	// the first definition of a label is the one that counts
+	id := string(bytes.ToLower(commonmarkLabel(data[idOffset:idEnd])))
+	if _, found := p.refs[id]; !found {
+		p.refs[id] = &reference{link: data[linkOffset:linkEnd], title: title}
+	}
+	return end
+}
+
+// skipReferenceSpace skips the spaces and tabs at data[i], and at most one
+// newline among them, unless the line after it is blank.
+func skipReferenceSpace(p *parser, data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
+		i++
+	}
+	if i < len(data) && data[i] == '\n' && p.isEmpty(data[i+1:]) == 0 {
+		i++
+		for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
+			i++
+		}
+	}
+	return i
+}
+
+// referenceLineEnd returns where the line ends if there is nothing but
+// space from data[i] to the end of it, or -1.
+func referenceLineEnd(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r') {
+		i++
+	}
+	if i < len(data) && data[i] != '\n' {
+		return -1
+	}
+	return i
+}
+
+// commonmarkLabel returns the label with its runs of whitespace made single
+// spaces and trimmed off its ends, as CommonMark matches them.
+func commonmarkLabel(label []byte) []byte {
+	fields := bytes.Fields(label)
+	if len(fields) == 1 && len(fields[0]) == len(label) {
+		return label
+	}
+	return bytes.Join(fields, []byte(" "))
+}
+
 // The first bit of this logic is the same as (*parser).listItem, but the rest
 // is much simpler. This function simply finds the entire block and shifts it
 // over by one tab if it is indeed a block (just returns the line if it's not).
@@ -785,6 +1989,88 @@ gatherLines:
 	return
 }
 
+//
+// Abbreviations
+//
+// Abbreviations are defined anywhere in the document, one per line:
+//
+//    *[HTML]: HyperText Markup Language
+//
+// and every occurrence of the abbreviated term as a whole word in the
+// document text is then passed to the Abbreviation renderer callback.
+
+type abbreviation struct {
+	term  []byte
+	title []byte
+}
+
+// Check whether or not data starts with an abbreviation definition.
+// If so, it is parsed and stored in the list of abbreviations.
+// Returns the number of bytes to skip to move past it,
+// or zero if the first line is not an abbreviation definition.
+func isAbbreviation(p *parser, data []byte) int {
+	if p.flags&EXTENSION_ABBREVIATIONS == 0 {
+		return 0
+	}
+
+	// up to 3 optional leading spaces
+	i := 0
+	for i < 3 && i < len(data) && data[i] == ' ' {
+		i++
+	}
+	if i+2 >= len(data) || data[i] != '*' || data[i+1] != '[' {
+		return 0
+	}
+	i += 2
+
+	// term: anything but a newline or closing bracket
+	termStart := i
+	for i < len(data) && data[i] != '\n' && data[i] != '\r' && data[i] != ']' {
+		i++
+	}
+	termEnd := i
+	if termEnd == termStart || i+1 >= len(data) || data[i] != ']' || data[i+1] != ':' {
+		return 0
+	}
+	i += 2
+
+	// title: the rest of the line
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
+		i++
+	}
+	titleStart := i
+	for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+		i++
+	}
+	titleEnd := i
+	for titleEnd > titleStart && (data[titleEnd-1] == ' ' || data[titleEnd-1] == '\t') {
+		titleEnd--
+	}
+
+	abbr := &abbreviation{
+		term:  data[termStart:termEnd],
+		title: data[titleStart:titleEnd],
+	}
+
+	// a later definition of the same term replaces the earlier one;
+	// otherwise keep the list sorted by decreasing term length
+	pos := len(p.abbreviations)
+	for j, a := range p.abbreviations {
+		if bytes.Equal(a.term, abbr.term) {
+			p.abbreviations[j] = abbr
+			return i
+		}
+		if len(a.term) < len(abbr.term) && pos == len(p.abbreviations) {
+			pos = j
+		}
+	}
+	p.abbreviations = append(p.abbreviations, nil)
+	copy(p.abbreviations[pos+1:], p.abbreviations[pos:])
+	p.abbreviations[pos] = abbr
+
+	return i
+}
+
 //
 //
 // Miscellaneous helper functions
@@ -812,12 +2098,43 @@ func isletter(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
 }
 
+// Test if a character is an uppercase letter.
+func isupper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
 // Test if a character is a letter or a digit.
 // TODO: check when this is looking for ASCII alnum and when it should use unicode
 func isalnum(c byte) bool {
 	return (c >= '0' && c <= '9') || isletter(c)
 }
 
+// Test if the character that ends data[:i] is a letter or a digit, in any
+// script, to tell whether i is inside a word.
+func isalnumBefore(data []byte, i int) bool {
+	if i == 0 {
+		return false
+	}
+	if c := data[i-1]; c < utf8.RuneSelf {
+		return isalnum(c)
+	}
+	r, _ := utf8.DecodeLastRune(data[:i])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Test if the character that starts data[i:] is a letter or a digit, in
+// any script.
+func isalnumAt(data []byte, i int) bool {
+	if i >= len(data) {
+		return false
+	}
+	if c := data[i]; c < utf8.RuneSelf {
+		return isalnum(c)
+	}
+	r, _ := utf8.DecodeRune(data[i:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
 // Replace tab characters with spaces, aligning to the next TAB_SIZE column.
 // always ends output with a newline
 func expandTabs(out *bytes.Buffer, line []byte, tabSize int) {
@@ -0,0 +1,661 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Markdown rendering backend
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Markdown renderer configuration options.
+const (
+	MARKDOWN_REFERENCE_LINKS = 1 << iota // write links as numbered references collected at the end
+)
+
+// MarkdownFormatter is a type that implements the Renderer interface for
+// Markdown output. It writes the document back in one consistent style:
+// ATX headers, '-' bullets, '*' emphasis and fenced code blocks.
+//
+// Do not create this directly, instead use the MarkdownRenderer function.
+type MarkdownFormatter struct {
+	flags int // MARKDOWN_* options
+
+	// item counters of the lists being rendered, innermost last
+	listCounters []int
+
+	// link references for MARKDOWN_REFERENCE_LINKS, in order of appearance
+	refs     [][]byte
+	refIndex map[string]int
+
+	// abbreviation definitions, in order of appearance
+	abbrs     [][]byte
+	abbrIndex map[string]bool
+}
+
+// MarkdownRenderer creates and configures a MarkdownFormatter object, which
+// satisfies the Renderer interface.
+//
+// flags is a set of MARKDOWN_* options ORed together.
+//
+// Parsing the output again gives the same document as the input, as long
+// as the same extensions are enabled, which makes this renderer useful for
+// formatting Markdown files and for testing the parser.
+func MarkdownRenderer(flags int) Renderer {
+	return &MarkdownFormatter{flags: flags}
+}
+
+func (options *MarkdownFormatter) GetFlags() int {
+	return options.flags
+}
+
+func (options *MarkdownFormatter) newDocument() Renderer {
+	return &MarkdownFormatter{flags: options.flags}
+}
+
+// Every block ends with a blank line. The parser strips it from the end of
+// list items, which keeps tight lists tight.
+
+func (options *MarkdownFormatter) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
+	// the fence has to be longer than any run of backticks in the code
+	fence := "```"
+	for bytes.Contains(text, []byte(fence)) {
+		fence += "`"
+	}
+	out.WriteString(fence)
+	if len(attrs) > 0 {
+		if lang != "" {
+			attrs = append(Attributes{{"class", lang}}, attrs...)
+		}
+		out.WriteString(attrs.String())
+	} else {
+		out.WriteString(lang)
+	}
+	out.WriteByte('\n')
+	out.Write(text)
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString(fence)
+	out.WriteString("\n\n")
+}
+
+func (options *MarkdownFormatter) TitleBlock(out *bytes.Buffer, text []byte) {
+	out.Write(bytes.TrimRight(text, "\n"))
+	out.WriteString("\n\n")
+}
+
+// The citations are written back as they were, so there is nothing to add
+// for the bibliography.
+func (options *MarkdownFormatter) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	for _, line := range lines {
+		out.WriteString("|")
+		if len(line) > 0 {
+			out.WriteString(" ")
+			out.Write(line)
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString("\n")
+}
+
+func (options *MarkdownFormatter) Bibliography(out *bytes.Buffer, keys []string) {
+}
+
+func (options *MarkdownFormatter) BlockQuote(out *bytes.Buffer, text []byte) {
+	writeIndented(out, bytes.TrimRight(text, "\n"), "> ", "> ")
+	out.WriteString("\n")
+}
+
+func (options *MarkdownFormatter) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
+	var quote bytes.Buffer
+	quote.Write(bytes.TrimRight(text, "\n"))
+	quote.WriteString("\n-- ")
+	quote.Write(citation)
+	writeIndented(out, quote.Bytes(), "> ", "> ")
+	out.WriteString("\n")
+}
+
+func (options *MarkdownFormatter) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
+	out.WriteString("> [!" + strings.ToUpper(kind) + "]")
+	if len(title) > 0 {
+		out.WriteString(" ")
+		out.Write(title)
+	}
+	out.WriteString("\n")
+	if text = bytes.TrimRight(text, "\n"); len(text) > 0 {
+		writeIndented(out, text, "> ", "> ")
+	}
+	out.WriteString("\n")
+}
+
+func (options *MarkdownFormatter) Container(out *bytes.Buffer, info string, text []byte) {
+	out.WriteString("::: " + info + "\n")
+	out.Write(text)
+	out.WriteString(":::\n\n")
+}
+
+func (options *MarkdownFormatter) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	out.WriteString("<details")
+	if flags&DETAILS_OPEN != 0 {
+		out.WriteString(" open")
+	}
+	out.WriteString(">\n")
+	if len(summary) > 0 {
+		out.WriteString("<summary>")
+		out.Write(summary)
+		out.WriteString("</summary>\n")
+	}
+	out.WriteString("\n")
+	out.Write(text)
+	out.WriteString("</details>\n\n")
+}
+
+func (options *MarkdownFormatter) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	writeIndented(out, bytes.TrimRight(text, "\n"), ">! ", ">! ")
+	out.WriteString("\n")
+}
+
+func (options *MarkdownFormatter) BlockShortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteString("\n\n")
+}
+
+func (options *MarkdownFormatter) BlockHtml(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteString("\n\n")
+}
+
+func (options *MarkdownFormatter) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	marker := out.Len()
+	out.WriteString(strings.Repeat("#", level))
+	out.WriteByte(' ')
+	start := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+
+	// generated ids come back by themselves, others need to be spelled out
+	if id != "" && id != SanitizedAnchorName(string(out.Bytes()[start:])) {
+		attrs = append(Attributes{{"id", id}}, attrs...)
+	}
+	if len(attrs) > 0 {
+		out.WriteString(" ")
+		out.WriteString(attrs.String())
+	}
+	out.WriteString("\n\n")
+}
+
+func (options *MarkdownFormatter) HRule(out *bytes.Buffer) {
+	out.WriteString("---\n\n")
+}
+
+func (options *MarkdownFormatter) List(out *bytes.Buffer, text func() bool, flags int, start int) {
+	marker := out.Len()
+
+	// a sublist of a tight list item follows its text on the next line
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+		out.WriteByte('\n')
+	}
+
+	options.listCounters = append(options.listCounters, start-1)
+	ok := text()
+	options.listCounters = options.listCounters[:len(options.listCounters)-1]
+	if !ok {
+		out.Truncate(marker)
+		return
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("\n\n")) {
+		out.WriteByte('\n')
+	}
+}
+
+// List item contents are indented by four spaces, which puts their blocks
+// inside the item whatever the width of the marker.
+func (options *MarkdownFormatter) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	if flags&LIST_TYPE_TERM != 0 {
+		out.Write(text)
+		out.WriteByte('\n')
+		return
+	}
+
+	var bullet string
+	switch {
+	case flags&LIST_TYPE_DEFINITION != 0:
+		bullet = ":   "
+	case flags&LIST_TYPE_ORDERED != 0:
+		n := 1
+		if len(options.listCounters) > 0 {
+			options.listCounters[len(options.listCounters)-1]++
+			n = options.listCounters[len(options.listCounters)-1]
+		}
+		bullet = listMarker(n, flags) + "."
+		switch {
+		case flags&LIST_STYLE_EXAMPLE != 0:
+			bullet = "(@)"
+		case flags&LIST_DELIMITER_PAREN != 0:
+			bullet = listMarker(n, flags) + ")"
+		}
+		bullet += strings.Repeat(" ", 4-len(bullet)%4)
+	default:
+		bullet = "-   "
+	}
+	switch {
+	case flags&LIST_ITEM_CHECKED != 0:
+		bullet += "[x] "
+	case flags&LIST_ITEM_TASK != 0:
+		bullet += "[ ] "
+	}
+
+	writeIndented(out, text, bullet, "    ")
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 {
+		out.WriteByte('\n')
+	}
+}
+
+func (options *MarkdownFormatter) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n\n")
+}
+
+func (options *MarkdownFormatter) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	// pipe tables can't do without a header, so grid tables get an empty one
+	if len(header) == 0 {
+		out.WriteString(strings.Repeat("|   ", len(columnData)))
+		out.WriteString("|\n")
+	}
+	out.Write(header)
+	for _, align := range columnData {
+		switch align {
+		case TABLE_ALIGNMENT_LEFT:
+			out.WriteString("| :-- ")
+		case TABLE_ALIGNMENT_RIGHT:
+			out.WriteString("| --: ")
+		case TABLE_ALIGNMENT_CENTER:
+			out.WriteString("| :-: ")
+		default:
+			out.WriteString("| --- ")
+		}
+	}
+	out.WriteString("|\n")
+	out.Write(body)
+	if len(caption) > 0 {
+		out.WriteString("Table: ")
+		out.Write(caption)
+		out.WriteByte('\n')
+	}
+	out.WriteByte('\n')
+}
+
+func (options *MarkdownFormatter) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	out.Write(text)
+	out.WriteString("|\n")
+}
+
+func (options *MarkdownFormatter) TableHeaderCell(out *bytes.Buffer, text []byte, align int, span int) {
+	options.TableCell(out, text, align, span)
+}
+
+func (options *MarkdownFormatter) TableCell(out *bytes.Buffer, text []byte, align int, span int) {
+	// the cells of grid tables can have several lines, pipe tables can't
+	text = bytes.Replace(bytes.TrimRight(text, "\n"), []byte("\n"), []byte(" "), -1)
+	out.WriteString("| ")
+	out.Write(text)
+	out.WriteByte(' ')
+	if span > 1 {
+		out.WriteString(strings.Repeat("|", span-1))
+	}
+}
+
+func (options *MarkdownFormatter) Footnotes(out *bytes.Buffer, text func() bool) {
+	text()
+}
+
+func (options *MarkdownFormatter) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	writeIndented(out, bytes.TrimRight(text, "\n"), "[^"+string(name)+"]: ", "    ")
+	out.WriteString("\n")
+}
+
+func (options *MarkdownFormatter) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	// www. links have no scheme, so they only work with the extension
+	if kind == LINK_TYPE_WWW {
+		out.Write(link)
+		return
+	}
+	out.WriteByte('<')
+	out.Write(link)
+	out.WriteByte('>')
+}
+
+func (options *MarkdownFormatter) CodeSpan(out *bytes.Buffer, text []byte) {
+	// the delimiter has to be longer than any run of backticks in the code
+	delim := "`"
+	for bytes.Contains(text, []byte(delim)) {
+		delim += "`"
+	}
+	pad := ""
+	if bytes.HasPrefix(text, []byte("`")) || bytes.HasSuffix(text, []byte("`")) {
+		pad = " "
+	}
+	out.WriteString(delim + pad)
+	out.Write(text)
+	out.WriteString(pad + delim)
+}
+
+func (options *MarkdownFormatter) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("**")
+	out.Write(text)
+	out.WriteString("**")
+}
+
+func (options *MarkdownFormatter) Emphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("*")
+	out.Write(text)
+	out.WriteString("*")
+}
+
+func (options *MarkdownFormatter) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
+	out.WriteString("![")
+	out.Write(alt)
+	out.WriteString("]")
+	writeLinkTarget(out, link, title)
+	if len(attrs) > 0 {
+		out.WriteString(attrs.String())
+	}
+}
+
+func (options *MarkdownFormatter) LineBreak(out *bytes.Buffer) {
+	out.WriteString("  \n")
+}
+
+func (options *MarkdownFormatter) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
+	out.WriteString("[")
+	out.Write(content)
+	out.WriteString("]")
+	if len(attrs) > 0 {
+		defer out.WriteString(attrs.String())
+	}
+	if options.flags&MARKDOWN_REFERENCE_LINKS == 0 {
+		writeLinkTarget(out, link, title)
+		return
+	}
+
+	var target bytes.Buffer
+	writeLinkTarget(&target, link, title)
+	key := target.String()
+	n, found := options.refIndex[key]
+	if !found {
+		options.refs = append(options.refs, target.Bytes())
+		n = len(options.refs)
+		options.refIndex[key] = n
+	}
+	out.WriteString("[")
+	out.WriteString(strconv.Itoa(n))
+	out.WriteString("]")
+}
+
+// writeLinkTarget writes the (link "title") part of an inline link
+func writeLinkTarget(out *bytes.Buffer, link []byte, title []byte) {
+	out.WriteByte('(')
+	if bytes.IndexAny(link, " ()") >= 0 {
+		out.WriteByte('<')
+		out.Write(link)
+		out.WriteByte('>')
+	} else {
+		out.Write(link)
+	}
+	if len(title) > 0 {
+		out.WriteString(" \"")
+		// the parser takes the title up to the last quote, so quotes
+		// inside it need no escaping
+		out.Write(title)
+		out.WriteString("\"")
+	}
+	out.WriteByte(')')
+}
+
+func (options *MarkdownFormatter) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	out.Write(tag)
+}
+
+func (options *MarkdownFormatter) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("***")
+	out.Write(text)
+	out.WriteString("***")
+}
+
+func (options *MarkdownFormatter) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString("~~")
+	out.Write(text)
+	out.WriteString("~~")
+}
+
+func (options *MarkdownFormatter) Highlight(out *bytes.Buffer, text []byte) {
+	out.WriteString("==")
+	out.Write(text)
+	out.WriteString("==")
+}
+
+func (options *MarkdownFormatter) Insert(out *bytes.Buffer, text []byte) {
+	out.WriteString("++")
+	out.Write(text)
+	out.WriteString("++")
+}
+
+func (options *MarkdownFormatter) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	open, close := criticDelimiter(kind)
+	out.WriteString(open)
+	out.Write(text)
+	if kind == CRITIC_SUBSTITUTION {
+		out.WriteString("~>")
+		out.Write(replacement)
+	}
+	out.WriteString(close)
+}
+
+func (options *MarkdownFormatter) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("[^")
+	out.Write(ref)
+	out.WriteString("]")
+}
+
+func (options *MarkdownFormatter) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	out.Write(abbr)
+	if !options.abbrIndex[string(abbr)] {
+		options.abbrIndex[string(abbr)] = true
+		options.abbrs = append(options.abbrs, []byte("*["+string(abbr)+"]: "+string(title)))
+	}
+}
+
+func (options *MarkdownFormatter) Mention(out *bytes.Buffer, kind int, token []byte) {
+	if kind == MENTION_TYPE_ISSUE {
+		out.WriteByte('#')
+	} else {
+		out.WriteByte('@')
+	}
+	out.Write(token)
+}
+
+func (options *MarkdownFormatter) Citation(out *bytes.Buffer, items []CitationItem) {
+	out.Write(citationText(items))
+}
+
+func (options *MarkdownFormatter) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	options.Image(out, link, title, alt, nil)
+}
+
+func (options *MarkdownFormatter) Kbd(out *bytes.Buffer, key []byte) {
+	out.WriteString("[[")
+	out.Write(key)
+	out.WriteString("]]")
+}
+
+func (options *MarkdownFormatter) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	out.WriteString("{")
+	out.Write(text)
+	out.WriteString("|")
+	out.Write(annotation)
+	out.WriteString("}")
+}
+
+func (options *MarkdownFormatter) Spoiler(out *bytes.Buffer, text []byte) {
+	out.WriteString("||")
+	out.Write(text)
+	out.WriteString("||")
+}
+
+func (options *MarkdownFormatter) Shortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *MarkdownFormatter) Entity(out *bytes.Buffer, entity []byte) {
+	out.Write(entity)
+}
+
+// NormalText escapes the characters that would otherwise start inline
+// markup, and those that would start a block at the beginning of a line.
+func (options *MarkdownFormatter) NormalText(out *bytes.Buffer, text []byte) {
+	lineStart := out.Len() == 0 || out.Bytes()[out.Len()-1] == '\n'
+	for i := 0; i < len(text); i++ {
+		if lineStart && text[i] != ' ' {
+			if isBlockStart(text[i:]) {
+				out.WriteByte('\\')
+			}
+		}
+		c := text[i]
+		switch {
+		case c == '\\' || c == '`' || c == '*' || c == '_' || c == '[' || c == ']' || c == '<':
+			out.WriteByte('\\')
+		case c == '{' && isCriticStart(text[i:]):
+			out.WriteByte('\\')
+		case i == 0 && afterByte(out, '{') && isCriticStart(append([]byte{'{'}, text...)):
+			// the brace was written by the previous call
+			out.WriteByte('\\')
+		case c == '~' && i+1 < len(text) && text[i+1] == '~':
+			out.WriteByte('\\')
+		case (c == '=' || c == '+') && i+2 < len(text) && text[i+1] == c && !isspace(text[i+2]):
+			// only an opening == or ++ needs it
+			out.WriteByte('\\')
+		case (c == '=' || c == '+') && i == 0 && i+1 < len(text) && !isspace(text[i+1]) && afterByte(out, c):
+			// the first half was written by the previous call
+			out.WriteByte('\\')
+		case c == '&' && htmlEntity.Match(text[i:]):
+			out.WriteByte('\\')
+		case c == '.' && (i+1 == len(text) || text[i+1] == ' ') && afterLineNumber(out):
+			// ordered list items: escaping the period is what stops them
+			out.WriteByte('\\')
+		}
+		out.WriteByte(c)
+		lineStart = c == '\n' || (lineStart && c == ' ')
+	}
+}
+
+// afterByte checks whether the last byte written to out is c.
+func afterByte(out *bytes.Buffer, c byte) bool {
+	return out.Len() > 0 && out.Bytes()[out.Len()-1] == c
+}
+
+// isBlockStart checks whether text at the beginning of a line would be
+// read as a header, block quote, list item or horizontal rule.
+func isBlockStart(text []byte) bool {
+	switch text[0] {
+	case '#', '>':
+		return true
+	case '-', '+':
+		return len(text) == 1 || text[1] == ' ' || text[1] == text[0]
+	}
+	return false
+}
+
+// afterLineNumber checks whether the last line of out is a bare number,
+// which a following period would turn into an ordered list item.
+func afterLineNumber(out *bytes.Buffer) bool {
+	line := out.Bytes()[bytes.LastIndexByte(out.Bytes(), '\n')+1:]
+	line = bytes.TrimLeft(line, " ")
+	if len(line) == 0 {
+		return false
+	}
+	for _, c := range line {
+		if !isdigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeIndented writes text with first in front of its first line and
+// indent in front of the others. Blank lines are left without trailing
+// spaces.
+func writeIndented(out *bytes.Buffer, text []byte, first, indent string) {
+	prefix := first
+	for len(text) > 0 {
+		end := bytes.IndexByte(text, '\n') + 1
+		if end == 0 {
+			end = len(text)
+		}
+		line := text[:end]
+		if len(bytes.TrimSpace(line)) > 0 {
+			out.WriteString(prefix)
+		} else {
+			out.WriteString(strings.TrimRight(prefix, " "))
+		}
+		out.Write(line)
+		text = text[end:]
+		prefix = indent
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.WriteByte('\n')
+	}
+}
+
+// header and footer
+func (options *MarkdownFormatter) DocumentHeader(out *bytes.Buffer) {
+	options.listCounters = nil
+	options.refs = nil
+	options.refIndex = make(map[string]int)
+	options.abbrs = nil
+	options.abbrIndex = make(map[string]bool)
+}
+
+func (options *MarkdownFormatter) DocumentFooter(out *bytes.Buffer) {
+	for i, ref := range options.refs {
+		// drop the parentheses of the inline form
+		out.WriteString("[")
+		out.WriteString(strconv.Itoa(i + 1))
+		out.WriteString("]: ")
+		out.Write(ref[1 : len(ref)-1])
+		out.WriteString("\n")
+	}
+	if len(options.refs) > 0 {
+		out.WriteString("\n")
+	}
+	for _, abbr := range options.abbrs {
+		out.Write(abbr)
+		out.WriteString("\n")
+	}
+
+	// end the document with exactly one newline
+	doc := bytes.TrimRight(out.Bytes(), "\n")
+	out.Truncate(len(doc))
+	if len(doc) > 0 {
+		out.WriteByte('\n')
+	}
+}
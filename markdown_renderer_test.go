@@ -0,0 +1,165 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for Markdown rendering
+//
+
+package blackfriday
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func runMarkdownMarkdown(input string, flags int, extensions Extensions) string {
+	return string(Markdown([]byte(input), MarkdownRenderer(flags), extensions))
+}
+
+func doTestsMarkdown(t *testing.T, tests []string, flags int, extensions Extensions) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runMarkdownMarkdown(input, flags, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestMarkdownRendererBlocks(t *testing.T) {
+	var tests = []string{
+		"Header\n======\n\nSub\n---\n",
+		"# Header\n\n## Sub\n",
+
+		"* a\n* b\n\n+ c\n\n    + d\n",
+		"-   a\n-   b\n\n-   c\n\n    -   d\n",
+
+		"3. one\n7. two\n",
+		"3.  one\n4.  two\n",
+
+		"- a\n    - b\n- c\n",
+		"-   a\n    -   b\n-   c\n",
+
+		"> quote\n>\n> > nested\n",
+		"> quote\n>\n> > nested\n",
+
+		"    code\n\n~~~ go\nx := \"```\"\n~~~\n",
+		"```\ncode\n```\n\n````go\nx := \"```\"\n````\n",
+
+		"a | b\n:--|--:\n1 | 2\n",
+		"| a | b |\n| :-- | --: |\n| 1 | 2 |\n",
+
+		"***\n",
+		"---\n",
+
+		"> [!note]   A *title*\n> Some\n> text.\n",
+		"> [!NOTE] A *title*\n> Some\n> text.\n",
+
+		":::: {.aside} ::::\nText\n::::\n",
+		"::: {.aside}\nText\n\n:::\n",
+
+		"# Title {#top .main}\n\n```{.go startFrom=\"1 0\"}\nx\n```\n",
+		"# Title {#top .main}\n\n```{.go startFrom=\"1 0\"}\nx\n```\n",
+
+		"![a](a.png){width=10} [b](/b){.c}\n",
+		"![a](a.png){width=10} [b](/b){.c}\n",
+
+		"a | b\n---|---\nc ||\n\nTable: A *caption*\n",
+		"| a | b |\n| --- | --- |\n| c ||\nTable: A *caption*\n",
+
+		"+---+-----+\n| a | two |\n|   | *l* |\n+---+-----+\n",
+		"|   |   |\n| --- | --- |\n| a | two *l* |\n",
+
+		"see [@doe2019,  p. 30;@roe]\n",
+		"see [@doe2019, p. 30; @roe]\n",
+
+		"==marked== ++new++ and \\==not== a == b\n",
+		"==marked== ++new++ and =\\=not== a == b\n",
+
+		"{++a++} {--*b*--} {~~c~>d~~}{>>e<<} \\{>>f<<}\n",
+		"{++a++} {--*b*--} {~~c~>d~~}{>>e<<} {\\>>f\\<\\<}\n",
+
+		"<details open><summary>*More*</summary>\nText\n</details>\n",
+		"<details open>\n<summary>*More*</summary>\n\nText\n\n</details>\n",
+
+		"```go title=\"main.go\" linenos\nx\n```\n",
+		"```{.go title=main.go linenos=\"\"}\nx\n```\n",
+
+		"b) x\nc) y\n",
+		"b)  x\nc)  y\n",
+
+		"IX. x\n",
+		"IX. x\n",
+
+		"> quote\n>\n> \u2014 *Author*\n",
+		"> quote\n> -- *Author*\n",
+
+		"| a\n|\n|   *b*\n  c\n",
+		"| a\n|\n| \u00a0\u00a0*b* c\n",
+	}
+	doTestsMarkdown(t, tests, 0, EXTENSION_FENCED_CODE|EXTENSION_TABLES|EXTENSION_ADMONITIONS|EXTENSION_FENCED_DIVS|EXTENSION_ATTRIBUTES|EXTENSION_TABLE_EXTRAS|EXTENSION_GRID_TABLES|EXTENSION_CITATIONS|EXTENSION_HIGHLIGHT|EXTENSION_INSERT|EXTENSION_CRITIC_MARKUP|EXTENSION_DETAILS|EXTENSION_CODE_METADATA|EXTENSION_FANCY_LISTS|EXTENSION_QUOTE_CITATIONS|EXTENSION_LINE_BLOCKS)
+}
+
+func TestMarkdownRendererInline(t *testing.T) {
+	var tests = []string{
+		"_a_ __b__ ~~c~~ `` `d` ``\n",
+		"*a* **b** ~~c~~ `` `d` ``\n",
+
+		"[a](/b 'c') ![d](/e f.png)\n",
+		"[a](/b \"c\") ![d](</e f.png>)\n",
+
+		"Use \\*stars\\* and snake\\_case.\n",
+		"Use \\*stars\\* and snake\\_case.\n",
+
+		"1\\. not a list\n\\# not a header\n",
+		"1\\. not a list\n\\# not a header\n",
+
+		"http://example.com/ and <b>raw</b>\n",
+		"<http://example.com/> and <b>raw</b>\n",
+
+		"Text[^note].\n\n[^note]: The note.\n",
+		"Text[^note].\n\n[^note]: The note.\n",
+	}
+	doTestsMarkdown(t, tests, 0, EXTENSION_STRIKETHROUGH|EXTENSION_AUTOLINK|EXTENSION_FOOTNOTES)
+}
+
+func TestMarkdownRendererReferenceLinks(t *testing.T) {
+	var tests = []string{
+		"[one](/a) and [two][x] and [three](/a)\n\n[x]: /b \"B\"\n",
+		"[one][1] and [two][2] and [three][1]\n\n[1]: /a\n[2]: /b \"B\"\n",
+	}
+	doTestsMarkdown(t, tests, MARKDOWN_REFERENCE_LINKS, 0)
+}
+
+// Rendering the Markdown output again must give the same HTML as the
+// original input.
+func TestMarkdownRendererRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	extensions := commonExtensions | EXTENSION_FOOTNOTES
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+		formatted := Markdown(input, MarkdownRenderer(0), extensions)
+		expected := string(Markdown(input, HtmlRenderer(0, "", ""), extensions))
+		actual := string(Markdown(formatted, HtmlRenderer(0, "", ""), extensions))
+		if actual != expected {
+			t.Errorf("%s: round trip differs\nMarkdown[%s]\nExpected[%s]\nActual  [%s]",
+				filename, formatted, expected, actual)
+		}
+	}
+}
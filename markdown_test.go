@@ -14,7 +14,16 @@
 package blackfriday
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func runMarkdown(input string) string {
@@ -73,3 +82,617 @@ func TestDocument(t *testing.T) {
 	}
 	doTests(t, tests)
 }
+
+func TestMarkdownGitHub(t *testing.T) {
+	var tests = []string{
+		"- [x] done\n- [ ] todo\n",
+		"<ul>\n<li><input type=\"checkbox\" checked=\"\" disabled=\"\" /> done</li>\n<li><input type=\"checkbox\" disabled=\"\" /> todo</li>\n</ul>\n",
+
+		"See www.example.com and ~~this~~ \"quote\"\n",
+		"<p>See <a href=\"http://www.example.com\">www.example.com</a> and <del>this</del> &quot;quote&quot;</p>\n",
+
+		"~one~ and ~~two~~\n",
+		"<p><del>one</del> and <del>two</del></p>\n",
+
+		"snake_case_name and *intra*word\n",
+		"<p>snake_case_name and <em>intra</em>word</p>\n",
+
+		"a | b\n---|---\n1 | 2\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n",
+
+		"<script>alert(1)</script>\n",
+		"&lt;script>alert(1)&lt;/script>\n",
+	}
+
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := string(MarkdownGitHub([]byte(tests[i])))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	input := []byte("a | b\n---|---\n~~1~~ | 2\n")
+	md := New(WithExtensions(EXTENSION_TABLES), WithExtensions(EXTENSION_STRIKETHROUGH))
+	if md.Options().Extensions != EXTENSION_TABLES|EXTENSION_STRIKETHROUGH {
+		t.Errorf("unexpected extensions %#x", md.Options().Extensions)
+	}
+	renderer := HtmlRenderer(0, "", "")
+	expected := string(Markdown(input, renderer, EXTENSION_TABLES|EXTENSION_STRIKETHROUGH))
+	actual, err := md.Render(input, renderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
+// numberedHeaders wraps a renderer, numbering its headers
+type numberedHeaders struct {
+	Renderer
+	count *int
+}
+
+func (r numberedHeaders) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	*r.count++
+	r.Renderer.Header(out, text, level, fmt.Sprintf("h%d", *r.count), attrs)
+}
+
+func (r numberedHeaders) NewDocument() Renderer {
+	return numberedHeaders{ForDocument(r.Renderer), new(int)}
+}
+
+func TestRendererReuse(t *testing.T) {
+	input := []byte("# One\n\nText[^a].\n\n# One\n\n1. a\n2. b\n\n[^a]: A note.\n")
+	md := New(WithExtensions(EXTENSION_FOOTNOTES | EXTENSION_AUTO_HEADER_IDS))
+	renderers := []Renderer{
+		HtmlRenderer(HTML_TOC|HTML_NUMBER_HEADERS, "", ""),
+		LatexRenderer(0),
+		DocBookRenderer(0),
+		MarkdownRenderer(MARKDOWN_REFERENCE_LINKS),
+		ConfluenceRenderer(0),
+		SlackRenderer(0),
+		numberedHeaders{HtmlRenderer(0, "", ""), new(int)},
+		ComposeRenderer(HtmlRenderer(HTML_TOC|HTML_NUMBER_HEADERS, "", ""), RendererFuncs{}),
+	}
+	for _, renderer := range renderers {
+		expected, err := md.Render(input, renderer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		outputs := make([][]byte, 8)
+		for i := range outputs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				outputs[i], _ = md.Render(input, renderer)
+			}(i)
+		}
+		wg.Wait()
+		for _, output := range outputs {
+			if !bytes.Equal(output, expected) {
+				t.Errorf("%T: document rendered again\nExpected[%q]\nActual  [%q]", renderer, expected, output)
+				break
+			}
+		}
+	}
+}
+
+func TestComposeRenderer(t *testing.T) {
+	renderer := ComposeRenderer(HtmlRenderer(HTML_TOC, "", ""), RendererFuncs{
+		Image: func(base Renderer, out *bytes.Buffer, link, title, alt []byte, attrs Attributes) {
+			out.WriteString("<figure>")
+			base.Image(out, link, title, alt, attrs)
+			out.WriteString("</figure>")
+		},
+		Header: func(base Renderer, out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+			base.Header(out, text, level+1, id, attrs)
+		},
+	})
+	input := []byte("# One\n\n![alt](/a.png) and [link](/b)\n")
+	expected := "<nav>\n<ul>\n<li>\n<ul>\n<li><a href=\"#toc_0\">One</a></li>\n</ul></li>\n</ul>\n</nav>\n\n" +
+		"<h2 id=\"toc_0\">One</h2>\n\n" +
+		"<p><figure><img src=\"/a.png\" alt=\"alt\"></figure> and <a href=\"/b\">link</a></p>\n"
+	for i := 0; i < 2; i++ {
+		if actual := string(Markdown(input, renderer, 0)); actual != expected {
+			t.Errorf("render %d:\nExpected[%q]\nActual  [%q]", i, expected, actual)
+		}
+	}
+	if renderer.GetFlags() != int(HTML_TOC) {
+		t.Errorf("flags %#x, want those of the base renderer", renderer.GetFlags())
+	}
+}
+
+func TestCaptureText(t *testing.T) {
+	var headers []string
+	renderer := ComposeRenderer(HtmlRenderer(0, "", ""), RendererFuncs{
+		Header: func(base Renderer, out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+			content, ok := CaptureText(out, text)
+			if ok {
+				headers = append(headers, string(content))
+				base.Header(out, TextFunc(out, content), level, id, attrs)
+			}
+		},
+		Paragraph: func(base Renderer, out *bytes.Buffer, text func() bool) {
+			content, ok := CaptureText(out, text)
+			if ok {
+				base.Paragraph(out, TextFunc(out, bytes.ToUpper(content)))
+			}
+		},
+	})
+	input := []byte("# The *first*\n\nSome text.\n\n## Second\n")
+	expected := "<h1>The <em>first</em></h1>\n\n<p>SOME TEXT.</p>\n\n<h2>Second</h2>\n"
+	if actual := string(Markdown(input, renderer, 0)); actual != expected {
+		t.Errorf("\nExpected[%q]\nActual  [%q]", expected, actual)
+	}
+	if len(headers) != 2 || headers[0] != "The <em>first</em>" || headers[1] != "Second" {
+		t.Errorf("captured headers %q", headers)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	input := []byte("# Title {#top}\n\nSome \"text\" -- with http://example.com, ~~this~~ and ~that~.\n\n| a |\n|---|\n| b |\n")
+	for _, c := range []struct {
+		preset   Preset
+		markdown func([]byte) []byte
+	}{
+		{PresetBasic(), MarkdownBasic},
+		{PresetCommon(), MarkdownCommon},
+		{PresetGitHub(), MarkdownGitHub},
+	} {
+		expected := c.markdown(input)
+		actual, err := New(WithPreset(c.preset)).Render(input, c.preset.Renderer())
+		if err != nil || !bytes.Equal(actual, expected) {
+			t.Errorf("preset %#x:\nExpected[%q]\nActual  [%q], %v", c.preset.Extensions, expected, actual, err)
+		}
+	}
+
+	preset := PresetCommon().With(EXTENSION_FOOTNOTES).Without(EXTENSION_AUTOLINK | EXTENSION_TABLES)
+	if preset.Extensions != (commonExtensions|EXTENSION_FOOTNOTES)&^(EXTENSION_AUTOLINK|EXTENSION_TABLES) {
+		t.Errorf("extensions %#x", preset.Extensions)
+	}
+	preset = preset.WithHtmlFlags(HTML_SKIP_HTML).WithoutHtmlFlags(HTML_USE_SMARTYPANTS)
+	if preset.HtmlFlags != (commonHtmlFlags|HTML_SKIP_HTML)&^HTML_USE_SMARTYPANTS {
+		t.Errorf("HTML flags %#x", preset.HtmlFlags)
+	}
+	if PresetCommon().Extensions != commonExtensions {
+		t.Errorf("PresetCommon changed")
+	}
+
+	md := New(WithExtensions(EXTENSION_MENTIONS), WithPreset(PresetPandoc()), WithExtensions(EXTENSION_KBD))
+	if ext := md.Options().Extensions; ext != pandocExtensions|EXTENSION_KBD {
+		t.Errorf("WithPreset: extensions %#x", ext)
+	}
+	output, err := md.Render([]byte("Term\n:   Definition[^1]\n\n[^1]: Note.\n"), PresetPandoc().Renderer())
+	if err != nil || !bytes.Contains(output, []byte("<dl>")) || !bytes.Contains(output, []byte("class=\"footnotes\"")) {
+		t.Errorf("pandoc preset output %q, %v", output, err)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	// sections with blank lines inside code blocks and list items, where
+	// the document can't be split, and repeated header ids
+	section := "# Usage\n\nSome *text* and a [link][ref].\n\n```\ncode\n\nmore code\n```\n\n" +
+		"- item\n\n  continued\n\n- item\n\n<div>\n\nraw\n</div>\n\nA | B\n--|--\n1 | 2\n\n"
+	input := []byte(strings.Repeat(section, 2000) + "[ref]: /url\n")
+	for _, extensions := range []Extensions{commonExtensions, commonExtensions | EXTENSION_AUTO_HEADER_IDS} {
+		for _, renderer := range []Renderer{HtmlRenderer(commonHtmlFlags, "", ""), LatexRenderer(0)} {
+			expected := MarkdownOptions(input, renderer, Options{Extensions: extensions})
+			actual := MarkdownOptions(input, renderer, Options{Extensions: extensions, Workers: 4})
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("%T, extensions %#x: output differs with workers", renderer, extensions)
+			}
+		}
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	renderer := HtmlRenderer(0, "", "")
+	var tests = []struct {
+		input    string
+		mode     int
+		expected string
+	}{
+		{"a\xffb \xe2\x82 *c*\n", INVALID_UTF8_KEEP, "<p>a\xffb \xe2\x82 <em>c</em></p>\n"},
+		{"a\xffb \xe2\x82 *c*\n", INVALID_UTF8_REPLACE, "<p>a\uFFFDb \uFFFD\uFFFD <em>c</em></p>\n"},
+		{"\xef\xbb\xbf# Title\n", INVALID_UTF8_KEEP, "<p>\xef\xbb\xbf# Title</p>\n"},
+		{"\xef\xbb\xbf# Title\n", INVALID_UTF8_REPLACE, "<h1>Title</h1>\n"},
+		{"\xef\xbb\xbf# Title\n", INVALID_UTF8_REJECT, "<h1>Title</h1>\n"},
+		{"日本語\n", INVALID_UTF8_REJECT, "<p>日本語</p>\n"},
+		{"\x93Smart\x94 caf\xe9 \x96 \x80\x81\n", INVALID_UTF8_WINDOWS_1252, "<p>“Smart” café – €\u0081</p>\n"},
+		{"“Smart” café\n", INVALID_UTF8_WINDOWS_1252, "<p>“Smart” café</p>\n"},
+	}
+	for _, test := range tests {
+		output, err := New(WithInvalidUTF8(test.mode)).Render([]byte(test.input), renderer)
+		if err != nil || string(output) != test.expected {
+			t.Errorf("\nInput   [%q], mode %d\nExpected[%q]\nActual  [%q], %v",
+				test.input, test.mode, test.expected, output, err)
+		}
+	}
+
+	input := []byte("a\xffb\n")
+	if output, err := New(WithInvalidUTF8(INVALID_UTF8_REJECT)).Render(input, renderer); err != ErrInvalidUTF8 || output != nil {
+		t.Errorf("invalid input rendered: %q, %v", output, err)
+	}
+	it := NewIterator(input, Options{InvalidUTF8: INVALID_UTF8_REJECT})
+	if _, ok := it.Next(); ok || it.Err() != ErrInvalidUTF8 {
+		t.Errorf("invalid input parsed: %v", it.Err())
+	}
+}
+
+func TestMaxNesting(t *testing.T) {
+	input := []byte(strings.Repeat("> ", 20) + "deep\n")
+	renderer := HtmlRenderer(0, "", "")
+
+	if out, _ := New().Render(input, renderer); bytes.Contains(out, []byte("deep")) {
+		t.Errorf("text nested 20 deep rendered with the default limit:\n%s", out)
+	}
+	if out, _ := New(WithMaxNesting(32)).Render(input, renderer); !bytes.Contains(out, []byte("deep")) {
+		t.Errorf("text nested 20 deep not rendered with a limit of 32:\n%s", out)
+	}
+}
+
+func TestBlockTags(t *testing.T) {
+	input := []byte("<my-widget>\n*raw*\n</my-widget>\n\n<details>\n*raw*\n</details>\n")
+	renderer := HtmlRenderer(0, "", "")
+
+	out, _ := New(WithBlockTags("my-widget"), WithBlockTags("details")).Render(input, renderer)
+	if string(out) != string(input) {
+		t.Errorf("custom block tags not kept as HTML blocks:\n%s", out)
+	}
+
+	// the tags are only added for that parser
+	out, _ = New().Render(input, renderer)
+	if !bytes.Contains(out, []byte("<em>raw</em>")) {
+		t.Errorf("custom block tags kept as HTML blocks by another parser:\n%s", out)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	docs := map[string]string{
+		"intro.md":    "# Intro\n\nSee [the spec][spec].[^1]\n\n{{include \"notes.md\"}}\n",
+		"notes.md":    "[^1]: A note.",
+		"links.md":    "[spec]: /spec\n",
+		"loop.md":     "<!--#include file=\"loop.md\"-->\n",
+		"code.md":     "```\n{{include \"intro.md\"}}\n```\n",
+		"deep.md":     "{{include \"deep.md \"}}\n",
+		"deep.md ":    "{{include \"deep.md  \"}}\n",
+		"deep.md  ":   "{{include \"deep.md   \"}}\n",
+		"deep.md   ":  "{{include \"deep.md    \"}}\n",
+		"deep.md    ": "deep\n",
+	}
+	resolver := func(path string) ([]byte, error) {
+		doc, ok := docs[path]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return []byte(doc), nil
+	}
+	renderer := HtmlRenderer(0, "", "")
+	parse := func(input string, opts ...Option) (string, string) {
+		md := New(append([]Option{WithExtensions(EXTENSION_INCLUDE | EXTENSION_FENCED_CODE | EXTENSION_FOOTNOTES),
+			WithIncludeResolver(resolver)}, opts...)...)
+		out, diagnostics, err := MarkdownDiagnostics([]byte(input), renderer, md.Options())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, d := range diagnostics {
+			lines = append(lines, d.String())
+		}
+		return string(out), strings.Join(lines, "\n")
+	}
+
+	// references and footnotes work across the included documents
+	out, diagnostics := parse("{{include \"intro.md\"}}\n\n  <!--#include file=\"links.md\"-->\n")
+	expected := "<h1>Intro</h1>\n\n<p>See <a href=\"/spec\">the spec</a>." +
+		"<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">1</a></sup></p>\n" +
+		"<div class=\"footnotes\">\n\n<hr>\n\n<ol>\n<li id=\"fn:1\">A note.\n</li>\n</ol>\n</div>\n"
+	if out != expected || diagnostics != "" {
+		t.Errorf("\nExpected[%s]\nActual  [%s]\nDiagnostics[%s]", expected, out, diagnostics)
+	}
+
+	// directives in code are left alone
+	out, _ = parse("{{include \"code.md\"}}\n")
+	if expected := "<pre><code>{{include &quot;intro.md&quot;}}\n</code></pre>\n"; out != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", expected, out)
+	}
+
+	var tests = []string{
+		"text\n\n{{include \"missing.md\"}}\n",
+		"line 3: error: cannot include \"missing.md\": not found",
+
+		"{{include \"loop.md\"}}\n",
+		"line 1: error: \"loop.md\" includes itself",
+
+		"{{include \"deep.md\"}}\n",
+		"",
+
+		"{{include \"deep.md\"}}\n{{include \"deep.md\"}}\n",
+		"",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		if _, diagnostics := parse(tests[i]); diagnostics != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%s]\nActual  [%s]", tests[i], tests[i+1], diagnostics)
+		}
+	}
+	if _, diagnostics := parse("{{include \"deep.md\"}}\n", WithIncludeResolver(func(path string) ([]byte, error) {
+		return []byte("{{include \"" + path + "x\"}}\n"), nil
+	})); diagnostics != "line 1: error: includes nested deeper than 8, \"deep.mdxxxxxxxx\" not included" {
+		t.Errorf("unexpected diagnostics for deep includes: %s", diagnostics)
+	}
+
+	// without the extension the directives are text
+	if out, _ := New(WithIncludeResolver(resolver)).Render([]byte("{{include \"notes.md\"}}\n"), renderer); string(out) != "<p>{{include &quot;notes.md&quot;}}</p>\n" {
+		t.Errorf("directive included without the extension:\n%s", out)
+	}
+}
+
+type errReadWriter struct{}
+
+func (errReadWriter) Read(p []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func (errReadWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestMarkdownToWriter(t *testing.T) {
+	input := "# Title\n\nSome *text* with a [link][1].\n\n[1]: /url\n"
+	renderer := HtmlRenderer(0, "", "")
+	expected := string(Markdown([]byte(input), renderer, commonExtensions))
+
+	var out bytes.Buffer
+	if err := MarkdownToWriter(&out, strings.NewReader(input), renderer, commonExtensions); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, out.String())
+	}
+
+	if err := MarkdownToWriter(&out, errReadWriter{}, renderer, 0); err == nil || err.Error() != "read failed" {
+		t.Errorf("expected the read error, got %v", err)
+	}
+	if err := MarkdownToWriter(errReadWriter{}, strings.NewReader(input), renderer, 0); err == nil || err.Error() != "write failed" {
+		t.Errorf("expected the write error, got %v", err)
+	}
+}
+
+type panickingRenderer struct {
+	BaseRenderer
+}
+
+func (panickingRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
+	panic("paragraph")
+}
+
+func TestRenderError(t *testing.T) {
+	input := []byte("# Title\n\ntext\n")
+
+	output, err := New().Render(input, panickingRenderer{})
+	if err == nil || err.Error() != "blackfriday: paragraph" || output != nil {
+		t.Errorf("expected an error and no output, got %q, %v", output, err)
+	}
+	if _, err := New().Render(input, HtmlRenderer(0, "", "")); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := MarkdownToWriter(ioutil.Discard, bytes.NewReader(input), panickingRenderer{}, 0); err == nil {
+		t.Errorf("expected an error from MarkdownToWriter")
+	}
+
+	// the legacy functions keep panicking
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected Markdown to panic")
+		}
+	}()
+	Markdown(input, panickingRenderer{}, 0)
+}
+
+func TestFirstPassPlainText(t *testing.T) {
+	var tests = []struct {
+		input      string
+		extensions Extensions
+		output     string
+		copied     bool
+		lines      []int
+	}{
+		{"# Title\n\ntext\n", 0, "# Title\n\ntext\n", false, []int{1, 2, 3}},
+		{"---\na: b\n---\ntext\n", EXTENSION_FRONT_MATTER, "text\n", false, []int{4}},
+		{"text\twith a tab\n", 0, "text    with a tab\n", true, []int{1}},
+		{"text\r\n", 0, "text\n", true, []int{1}},
+		{"[a]: /url\n\n[a]\n", 0, "\n\n[a]\n", true, []int{1, 2, 3}},
+		{"%% comment\ntext\n", EXTENSION_COMMENTS, "text\n", true, []int{2}},
+		{"%% not a comment\n", 0, "%% not a comment\n", false, []int{1}},
+		{"no newline", 0, "no newline\n", true, []int{1}},
+	}
+	for _, test := range tests {
+		input := []byte(test.input)
+		p := newParser(BaseRenderer{}, Options{Extensions: test.extensions})
+		doc := firstPass(p, input)
+		if string(doc) != test.output {
+			t.Errorf("%q: expected %q, got %q", test.input, test.output, doc)
+		}
+		copied := len(doc) > len(input) || &doc[0] != &input[len(input)-len(doc)]
+		if copied != test.copied {
+			t.Errorf("%q: expected copied to be %v", test.input, test.copied)
+		}
+		if !copied && cap(doc) != len(doc) {
+			t.Errorf("%q: expected the document to be capped at its length", test.input)
+		}
+		if !reflect.DeepEqual(p.lines, test.lines) {
+			t.Errorf("%q: expected lines %v, got %v", test.input, test.lines, p.lines)
+		}
+	}
+}
+
+func TestExtractMetadata(t *testing.T) {
+	input := "---\ntitle: ignored\n---\nIntro *before* the title.\n\n# The `Title` &amp; more\n\n" +
+		"> Quoted text\n\nSecond paragraph.\n"
+	meta, err := ExtractMetadata([]byte(input), Options{Extensions: EXTENSION_FRONT_MATTER})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Metadata{
+		Title:       "The Title & more",
+		Summary:     "Intro before the title.",
+		Description: "Intro before the title.",
+		WordCount:   12,
+		ReadingTime: time.Minute,
+	}
+	if meta != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, meta)
+	}
+
+	meta, err = ExtractMetadata([]byte(strings.Repeat("word ", 401)), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.WordCount != 401 || meta.ReadingTime != 3*time.Minute || meta.Title != "" {
+		t.Errorf("unexpected metadata %#v", meta)
+	}
+	if d := meta.Description; utf8.RuneCountInString(d) > 160 || !strings.HasSuffix(d, "word…") {
+		t.Errorf("unexpected description %q", d)
+	}
+
+	if meta, err := ExtractMetadata(nil, Options{}); err != nil || meta != (Metadata{}) {
+		t.Errorf("unexpected metadata %#v, %v", meta, err)
+	}
+}
+
+func TestOutline(t *testing.T) {
+	input := "---\ntitle: x\n---\n# Intro *to* it\n\nText\n\nUsage {#use}\n-----\n\n> ## Quoted\n\n### Intro to it\n"
+	opts := Options{Extensions: EXTENSION_FRONT_MATTER | EXTENSION_HEADER_IDS | EXTENSION_AUTO_HEADER_IDS}
+	expected := []Heading{
+		{Level: 1, Text: "Intro to it", ID: "intro-to-it", Line: 4, Offset: 17},
+		{Level: 2, Text: "Usage", ID: "use", Line: 8, Offset: 40},
+		{Level: 2, Text: "Quoted", ID: "quoted", Line: 0, Offset: -1},
+		{Level: 3, Text: "Intro to it", ID: "intro-to-it-1", Line: 13, Offset: 73},
+	}
+	if outline := Outline([]byte(input), opts); !reflect.DeepEqual(outline, expected) {
+		t.Errorf("\nExpected[%+v]\nActual  [%+v]", expected, outline)
+	}
+
+	if outline := Outline([]byte("Text\n"), Options{}); outline != nil {
+		t.Errorf("unexpected outline %+v", outline)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	input := "# Intro\n\nFirst *para*.\n\n- a\n- b\n\n# Intro\n\nLast [link][].\n\n[link]: /link\n"
+	opts := Options{Extensions: commonExtensions}
+	renderer := func() Renderer { return HtmlRenderer(0, "", "") }
+
+	s, err := RenderSnapshot([]byte(input), renderer, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var edits = []struct {
+		start, end int
+		text       string
+	}{
+		{16, 21, "bold"},               // within a paragraph
+		{25, 25, "\n"},                 // a blank line between the paragraph and the list
+		{0, 0, "Title\n=====\n\n"},     // a setext header in front
+		{45, 45, "    continued\n\n"},  // a lazy line into the list
+		{15, 15, "---\n"},              // a paragraph into a setext header
+		{0, 9, ""},                     // the first header and its id
+		{70, 70, "\n[link]: /other\n"}, // a changed reference
+	}
+	for _, edit := range edits {
+		old := s.Output()
+		if edit.end > len(s.Input()) {
+			edit.start, edit.end = len(s.Input()), len(s.Input())
+		}
+		change, err := s.Update(edit.start, edit.end, []byte(edit.text))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := string(MarkdownOptions(s.Input(), HtmlRenderer(0, "", ""), opts))
+		if actual := string(s.Output()); actual != expected {
+			t.Errorf("Input [%q]:\nExpected[%q]\nActual  [%q]", s.Input(), expected, actual)
+		}
+		patched := string(old[:change.Start]) + string(change.Output) + string(old[change.End:])
+		if patched != expected {
+			t.Errorf("Input [%q]:\nExpected[%q]\nPatched [%q]", s.Input(), expected, patched)
+		}
+	}
+
+	if _, err := s.Update(0, len(s.Input())+1, nil); err == nil {
+		t.Errorf("expected an error for an edit out of range")
+	}
+}
+
+func TestSnapshotLookAhead(t *testing.T) {
+	// blocks before the edit that looked for their end as far as it
+	var tests = []struct {
+		input, before, text string
+		extensions          Extensions
+	}{
+		{"```\ncode\n\npara one\n\npara two\n\nlast\n", "last", "```\n", EXTENSION_FENCED_CODE},
+		{"```\ncode\n\npara one\n\npara two\n", "", "```\n", EXTENSION_FENCED_CODE},
+		{"<div>\n\npara one\n\npara two\n\nlast\n", "last", "</div>\n\n", 0},
+		{"<!-- note\n\npara one\n\npara two\n\nlast\n", "last", "-->\n\n", 0},
+		{"::: warning\n\npara one\n\npara two\n\nlast\n", "last", ":::\n", EXTENSION_FENCED_DIVS},
+		{"para\n```\ncode\n\npara two\n\nlast\n", "last", "```\n", EXTENSION_FENCED_CODE},
+	}
+	for _, test := range tests {
+		opts := Options{Extensions: test.extensions}
+		s, err := RenderSnapshot([]byte(test.input), func() Renderer { return HtmlRenderer(0, "", "") }, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := s.Output()
+		at := len(test.input)
+		if test.before != "" {
+			at = strings.LastIndex(test.input, test.before)
+		}
+		change, err := s.Update(at, at, []byte(test.text))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := string(MarkdownOptions(s.Input(), HtmlRenderer(0, "", ""), opts))
+		if actual := string(s.Output()); actual != expected {
+			t.Errorf("Input [%q]:\nExpected[%q]\nActual  [%q]", s.Input(), expected, actual)
+		}
+		patched := string(old[:change.Start]) + string(change.Output) + string(old[change.End:])
+		if patched != expected {
+			t.Errorf("Input [%q]:\nExpected[%q]\nPatched [%q]", s.Input(), expected, patched)
+		}
+	}
+}
+
+func TestSnapshotReparse(t *testing.T) {
+	parsed := 0
+	opts := Options{InlineParsers: map[byte]InlineParserFunc{
+		'!': func(r Renderer, out *bytes.Buffer, data []byte, offset int) int {
+			parsed++
+			return 0
+		},
+	}}
+	input := strings.Repeat("A paragraph!\n\n", 100)
+	s, err := RenderSnapshot([]byte(input), func() Renderer { return HtmlRenderer(0, "", "") }, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed = 0
+	change, err := s.Update(14*50+2, 14*50+11, []byte("sentence"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed > 3 {
+		t.Errorf("parsed %d paragraphs for an edit of one", parsed)
+	}
+	if string(change.Output) != "\n<p>A sentence!</p>\n" {
+		t.Errorf("unexpected change %q", change.Output)
+	}
+}
@@ -0,0 +1,88 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Video, audio and provider embeds with EXTENSION_MEDIA_EMBED
+//
+//
+
+package blackfriday
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultEmbedHosts are the hosts the Html renderer loads provider embeds
+// from when HtmlRendererParameters.EmbedHosts is nil.
+var DefaultEmbedHosts = []string{"www.youtube.com", "player.vimeo.com"}
+
+var mediaExtensions = map[string]int{
+	".mp4":  MEDIA_VIDEO,
+	".m4v":  MEDIA_VIDEO,
+	".webm": MEDIA_VIDEO,
+	".ogv":  MEDIA_VIDEO,
+	".mov":  MEDIA_VIDEO,
+	".mp3":  MEDIA_AUDIO,
+	".m4a":  MEDIA_AUDIO,
+	".ogg":  MEDIA_AUDIO,
+	".oga":  MEDIA_AUDIO,
+	".opus": MEDIA_AUDIO,
+	".wav":  MEDIA_AUDIO,
+	".flac": MEDIA_AUDIO,
+}
+
+// The pages of the known providers, and the URL of the player that embeds
+// them, with %s standing for the id of the video.
+var mediaProviders = []struct {
+	re    *regexp.Regexp
+	embed string
+}{
+	{regexp.MustCompile(`^https?://(?:www\.|m\.)?youtube\.com/watch\?(?:[^#]*&)?v=([\w-]+)`), "https://www.youtube.com/embed/%s"},
+	{regexp.MustCompile(`^https?://youtu\.be/([\w-]+)`), "https://www.youtube.com/embed/%s"},
+	{regexp.MustCompile(`^https?://(?:www\.)?vimeo\.com/(\d+)(?:[/?#]|$)`), "https://player.vimeo.com/video/%s"},
+}
+
+// mediaKind returns the MEDIA_* kind of the media a link points at, or 0
+// if it is neither a video or audio file nor the page of a known provider.
+func mediaKind(link []byte) int {
+	if embedURL(link) != "" {
+		return MEDIA_EMBED
+	}
+	file := string(link)
+	if i := strings.IndexAny(file, "?#"); i >= 0 {
+		file = file[:i]
+	}
+	return mediaExtensions[strings.ToLower(path.Ext(file))]
+}
+
+// embedURL returns the URL of the player for the page of a known provider,
+// or "" if link isn't one.
+func embedURL(link []byte) string {
+	for _, provider := range mediaProviders {
+		if m := provider.re.FindSubmatch(link); m != nil {
+			return fmt.Sprintf(provider.embed, m[1])
+		}
+	}
+	return ""
+}
+
+// mediaLine checks whether a paragraph is only the link to a page of a
+// known provider, which is embedded like ![](link).
+func mediaLine(text []byte) bool {
+	for _, c := range text {
+		if isspace(c) {
+			return false
+		}
+	}
+	return embedURL(text) != ""
+}
@@ -0,0 +1,146 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Metadata of a document, for page titles and listings
+//
+//
+
+package blackfriday
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	wordsPerMinute       = 200 // reading speed for Metadata.ReadingTime
+	maxDescriptionLength = 160 // length of Metadata.Description, in characters
+)
+
+// Metadata sums up a document for the pages that list or link to it.
+type Metadata struct {
+	Title       string        // plain text of the first level 1 header
+	Summary     string        // plain text of the first paragraph
+	Description string        // the summary, cut short for a <meta name="description">
+	WordCount   int           // number of words in the plain text of the document
+	ReadingTime time.Duration // time it takes to read the document, in whole minutes
+}
+
+// ExtractMetadata parses a block of markdown-encoded text with the
+// extensions and other settings given in opts, and returns its metadata.
+// Only the paragraphs at the top level of the document are candidates for
+// the summary; those in lists or blockquotes are not.
+func ExtractMetadata(input []byte, opts Options) (Metadata, error) {
+	doc, err := parse(input, opts)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var meta Metadata
+	for _, n := range doc.Children {
+		if meta.Title == "" && n.Type == NODE_HEADER && n.Level == 1 {
+			meta.Title = plainText(n.Children)
+		}
+		if meta.Summary == "" && n.Type == NODE_PARAGRAPH {
+			meta.Summary = plainText(n.Children)
+		}
+	}
+	meta.Description = truncateWords(meta.Summary, maxDescriptionLength)
+	meta.WordCount = len(strings.Fields(string(Render(doc, BaseRenderer{}))))
+	minutes := (meta.WordCount + wordsPerMinute - 1) / wordsPerMinute
+	meta.ReadingTime = time.Duration(minutes) * time.Minute
+	return meta, nil
+}
+
+// plainText renders nodes as text on a single line
+func plainText(nodes []*Node) string {
+	text := Render(&Node{Type: NODE_DOCUMENT, Children: nodes}, BaseRenderer{})
+	return strings.Join(strings.Fields(string(text)), " ")
+}
+
+// truncateWords cuts text down to at most length characters, ellipsis
+// included, at a word boundary if there is one
+func truncateWords(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	// leave room for the ellipsis
+	cut, end, n := 0, 0, 0
+	for i, c := range text {
+		if n == length-1 {
+			end = i
+			break
+		}
+		if c == ' ' {
+			cut = i
+		}
+		n++
+	}
+	if cut == 0 {
+		// a single long word
+		cut = end
+	}
+	return strings.TrimRight(text[:cut], ",;:.") + "…"
+}
+
+// Heading is a header of a document, as listed by Outline.
+type Heading struct {
+	Level  int    // 1 to 6
+	Text   string // plain text of the header
+	ID     string // id of the header, or the anchor name of its text if it has none
+	Line   int    // input line of the header, or 0 if it is inside a block quote or list
+	Offset int    // byte offset of that line in the input, or -1
+}
+
+// Outline returns the headers of a block of markdown-encoded text, parsed
+// with the extensions and other settings given in opts, in document order.
+// The document is parsed without being rendered; the header ids are those
+// the HTML renderer would give the headers.
+func Outline(input []byte, opts Options) []Heading {
+	p := newParser(BaseRenderer{}, opts)
+	p.outlining = true
+	secondPass(p, firstPass(p, input))
+
+	// line starts, to turn lines into offsets
+	starts := []int{0}
+	for i, c := range input {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	for i := range p.outline {
+		h := &p.outline[i]
+		h.Offset = -1
+		if h.Line > 0 && h.Line <= len(starts) {
+			h.Offset = starts[h.Line-1]
+		}
+	}
+	return p.outline
+}
+
+// outlineHeader records a header for Outline. text is its output from
+// BaseRenderer, which is its plain text.
+func (p *parser) outlineHeader(data []byte, level int, id string, text []byte) {
+	if !p.outlining || p.indexing {
+		return
+	}
+	h := Heading{
+		Level: level,
+		Text:  strings.Join(strings.Fields(string(text)), " "),
+		ID:    id,
+		Line:  p.lineOf(data),
+	}
+	if h.ID == "" {
+		h.ID = SanitizedAnchorName(h.Text)
+	}
+	p.outline = append(p.outline, h)
+}
@@ -0,0 +1,208 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Rendering the blocks of large documents in parallel
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Documents are only split into parts at least this long, as smaller ones
+// take longer to hand to another goroutine than to render.
+const minParallelPart = 32 << 10
+
+// parallelPart is the output of the top-level blocks of a part of the
+// document, rendered by a parser of its own.
+type parallelPart struct {
+	start, end  int // where its blocks start and end in the document
+	output      []byte
+	headerIDs   map[string]int
+	diagnostics []Diagnostic
+	failed      bool // the parser panicked
+}
+
+// parallelBlocks renders the top-level blocks of data like block, with
+// the document split into parts that are rendered at the same time, one
+// per worker. It returns false, having rendered nothing, if the document
+// can't be split.
+//
+// Where a part starts is only a guess at where a block starts. The parts
+// are put together in order, each one after the blocks before it are
+// rendered: a part is used as it is if the block before it ends where the
+// part starts, the output so far ends the way the part assumed, and the
+// header ids it used are still free. Otherwise its blocks are parsed
+// again, one at a time, until one of them ends where the next part
+// starts. The output is the same as that of block either way.
+func (p *parser) parallelBlocks(out *bytes.Buffer, data []byte) bool {
+	if p.workers < 2 || p.flags&documentWideExtensions != 0 || !independentBlocks(p.r, p.flags) {
+		return false
+	}
+	starts := splitParts(data, p.workers)
+	if len(starts) < 2 {
+		return false
+	}
+
+	// the first part is rendered as it comes, the others by workers
+	parts := make([]parallelPart, len(starts))
+	var wg sync.WaitGroup
+	for i := 1; i < len(starts); i++ {
+		end := len(data)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		part := &parts[i]
+		part.start = starts[i]
+		w := p.worker()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.renderPart(part, data, end)
+		}()
+	}
+	defer wg.Wait()
+
+	p.nesting++
+	next, waited := 1, false
+	for pos := 0; pos < len(data) && !p.cancelled(); {
+		if next < len(parts) && pos >= parts[next].start {
+			if !waited {
+				wg.Wait()
+				waited = true
+			}
+			part := &parts[next]
+			next++
+			if part.start == pos && p.fits(part, out) {
+				out.Write(part.output)
+				for id, n := range part.headerIDs {
+					p.headerIDs[id] = n
+				}
+				for _, d := range part.diagnostics {
+					p.diagnoseLine(d.Severity, d.Line, "%s", d.Message)
+				}
+				pos = part.end
+			}
+			continue
+		}
+		pos += p.nextBlock(out, data[pos:])
+	}
+	p.nesting--
+	return true
+}
+
+// worker returns a parser for the blocks of a part of the document, with
+// the same options, references and abbreviations as p and a renderer of
+// its own
+func (p *parser) worker() *parser {
+	w := *p
+	w.r = ForDocument(p.r)
+	w.headerIDs = make(map[string]int)
+	w.diagnostics, w.diagnosed = nil, nil
+	w.closers = nil
+	w.refScratch = nil
+	w.span, w.spanIndex = nil, nil
+	return &w
+}
+
+// renderPart renders the blocks of data that start from part.start up to
+// end. Blocks may look ahead past end, and the last one may end past it.
+// The output is rendered after a newline, the way most blocks end, for the
+// renderers that space out blocks.
+func (p *parser) renderPart(part *parallelPart, data []byte, end int) {
+	defer func() {
+		if r := recover(); r != nil {
+			// the part is parsed again, and the panic reported, in order
+			part.failed = true
+		}
+	}()
+
+	var out bytes.Buffer
+	out.WriteByte('\n')
+	p.nesting++
+	pos := part.start
+	for pos < end && !p.cancelled() {
+		pos += p.nextBlock(&out, data[pos:])
+	}
+	p.nesting--
+	part.end = pos
+	part.output = out.Bytes()[1:]
+	part.headerIDs = p.headerIDs
+	part.diagnostics = p.diagnostics
+}
+
+// fits reports whether part can go after out as it was rendered: without
+// having panicked, after a newline, and with header ids that p hasn't used.
+// Every id that the part's parser looked up is in part.headerIDs, so none
+// of them being in p.headerIDs means they would have been given out the
+// same.
+func (p *parser) fits(part *parallelPart, out *bytes.Buffer) bool {
+	if part.failed || out.Len() == 0 || out.Bytes()[out.Len()-1] != '\n' {
+		return false
+	}
+	for id := range part.headerIDs {
+		if _, ok := p.headerIDs[id]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitParts returns where the parts of data rendered in parallel start:
+// at 0 and at the first paragraph after a blank line past each
+// len(data)/workers bytes. A line starting with a letter after a blank
+// line starts a paragraph unless it is inside a code block, an HTML block
+// or a list item, which parallelBlocks finds out.
+func splitParts(data []byte, workers int) []int {
+	if n := len(data) / minParallelPart; n < workers {
+		workers = n
+	}
+	if workers < 2 {
+		return nil
+	}
+	starts := []int{0}
+	for i := len(data) / workers; i < len(data); i++ {
+		if data[i-1] == '\n' && data[i-2] == '\n' && isletter(data[i]) {
+			starts = append(starts, i)
+			if next := len(starts) * len(data) / workers; next > i {
+				i = next - 1
+			}
+		}
+	}
+	return starts
+}
+
+// independentBlocks reports whether r renders each top-level block the same
+// whatever blocks come before it, and can render several documents at once:
+// the renderers of this package without the options that number or collect
+// things across blocks, those composed over one of them, and
+// DocumentRenderers, which are assumed to.
+func independentBlocks(r Renderer, extensions Extensions) bool {
+	switch r := r.(type) {
+	case *Html:
+		return r.flags&(HTML_TOC|HTML_OMIT_CONTENTS|HTML_NUMBER_HEADERS) == 0
+	case *Latex, *Confluence, *Slack:
+		return true
+	case *DocBook:
+		// headers open sections that later blocks close
+		return false
+	case *MarkdownFormatter:
+		return r.flags&MARKDOWN_REFERENCE_LINKS == 0 && extensions&EXTENSION_ABBREVIATIONS == 0
+	case *composedRenderer:
+		return independentBlocks(r.base, extensions)
+	case DocumentRenderer:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Sets of extensions and HTML flags that go together
+//
+//
+
+package blackfriday
+
+// A Preset is a set of parsing extensions with the HTML renderer flags that
+// go with them, such as those of MarkdownCommon. Start from one of the
+// Preset* functions and add or remove what differs:
+//
+//	preset := blackfriday.PresetCommon().With(blackfriday.EXTENSION_FOOTNOTES).Without(blackfriday.EXTENSION_AUTOLINK)
+//	output, err := blackfriday.New(blackfriday.WithPreset(preset)).Render(input, preset.Renderer())
+type Preset struct {
+	Extensions    Extensions // EXTENSION_* flags
+	HtmlFlags     HtmlFlags  // HTML_* flags
+	Strikethrough int        // STRIKETHROUGH_* value, for Options.Strikethrough
+}
+
+// PresetBasic enables no extensions, like MarkdownBasic.
+func PresetBasic() Preset {
+	return Preset{HtmlFlags: HTML_USE_XHTML}
+}
+
+// PresetCommon enables the extensions and smartypants options of
+// MarkdownCommon.
+func PresetCommon() Preset {
+	return Preset{Extensions: commonExtensions, HtmlFlags: commonHtmlFlags}
+}
+
+// PresetGitHub follows GitHub Flavored Markdown, like MarkdownGitHub,
+// striking through text between one tilde or two.
+func PresetGitHub() Preset {
+	return Preset{Extensions: githubExtensions, HtmlFlags: githubHtmlFlags, Strikethrough: STRIKETHROUGH_BOTH}
+}
+
+// PresetPandoc enables the extensions that come closest to Pandoc's
+// Markdown: footnotes, title blocks, definition, fancy and example lists,
+// grid tables, line blocks, fenced divs, attributes and citations, with
+// Pandoc's smart punctuation.
+func PresetPandoc() Preset {
+	return Preset{Extensions: pandocExtensions, HtmlFlags: pandocHtmlFlags}
+}
+
+// With returns the preset with the EXTENSION_* flags in extensions enabled
+// as well.
+func (p Preset) With(extensions Extensions) Preset {
+	p.Extensions |= extensions
+	return p
+}
+
+// Without returns the preset with the EXTENSION_* flags in extensions
+// disabled.
+func (p Preset) Without(extensions Extensions) Preset {
+	p.Extensions &^= extensions
+	return p
+}
+
+// WithHtmlFlags returns the preset with the HTML_* flags in flags set as
+// well.
+func (p Preset) WithHtmlFlags(flags HtmlFlags) Preset {
+	p.HtmlFlags |= flags
+	return p
+}
+
+// WithoutHtmlFlags returns the preset with the HTML_* flags in flags
+// cleared.
+func (p Preset) WithoutHtmlFlags(flags HtmlFlags) Preset {
+	p.HtmlFlags &^= flags
+	return p
+}
+
+// Renderer returns an HTML renderer with the flags of the preset.
+func (p Preset) Renderer() Renderer {
+	return HtmlRenderer(p.HtmlFlags, "", "")
+}
+
+// WithPreset sets Options.Extensions and Options.Strikethrough to those of
+// preset. Its HTML flags are left to the renderer, from preset.Renderer().
+func WithPreset(preset Preset) Option {
+	return func(opts *Options) {
+		opts.Extensions = preset.Extensions
+		opts.Strikethrough = preset.Strikethrough
+	}
+}
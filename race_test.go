@@ -0,0 +1,17 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//go:build race
+// +build race
+
+package blackfriday
+
+func init() {
+	raceEnabled = true
+}
@@ -14,17 +14,20 @@
 package blackfriday
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
-func runMarkdownReference(input string, flag int) string {
+func runMarkdownReference(input string, flag Extensions) string {
 	renderer := HtmlRenderer(0, "", "")
 	return string(Markdown([]byte(input), renderer, flag))
 }
 
-func doTestsReference(t *testing.T, files []string, flag int) {
+func doTestsReference(t *testing.T, files []string, flag Extensions) {
 	// catch and report panics
 	var candidate string
 	defer func() {
@@ -126,3 +129,142 @@ func TestReference_EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK(t *testing.T) {
 	}
 	doTestsReference(t, files, EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK)
 }
+
+// benchmarkDocument returns the reference test inputs joined together and
+// repeated into a large document
+func benchmarkDocument(b *testing.B) []byte {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.text"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	var doc []byte
+	for i := 0; i < 10; i++ {
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				b.Fatal(err)
+			}
+			doc = append(doc, data...)
+			doc = append(doc, '\n')
+		}
+	}
+	return doc
+}
+
+func BenchmarkReference(b *testing.B) {
+	input := benchmarkDocument(b)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MarkdownBasic(input)
+	}
+}
+
+func BenchmarkReferenceCommon(b *testing.B) {
+	input := benchmarkDocument(b)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MarkdownCommon(input)
+	}
+}
+
+func BenchmarkReferenceLinks(b *testing.B) {
+	var input []byte
+	for i := 0; i < 1000; i++ {
+		input = append(input, fmt.Sprintf("See [the docs][Ref%d] and [ref%d].\n\n", i%50, i%50)...)
+	}
+	for i := 0; i < 50; i++ {
+		input = append(input, fmt.Sprintf("[ref%d]: http://example.com/%d\n", i, i)...)
+	}
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MarkdownBasic(input)
+	}
+}
+
+func BenchmarkPlainText(b *testing.B) {
+	section := "## Section\n\nSome *text* with `code` and a [link](http://example.com/).\n\n- one\n- two\n\n"
+	input := []byte(strings.Repeat(section, 2000))
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MarkdownCommon(input)
+	}
+}
+
+// adversarialInputs used to take time quadratic in their length: open,
+// then unit, n times each.
+var adversarialInputs = []struct {
+	name       string
+	open, unit string
+	render     func([]byte) []byte
+}{
+	{"OpenBrackets", "", "[", MarkdownCommon},
+	{"BracketPairs", "", "[a]", MarkdownCommon},
+	{"EmptyBrackets", "", "[]", MarkdownCommon},
+	{"ReferenceBrackets", "", "[a][", MarkdownCommon},
+	{"OpenDestinations", "", "[a](", MarkdownCommon},
+	{"EmphasisLinks", "", "*[", MarkdownCommon},
+	{"EmphasisCode", "", "*`", MarkdownCommon},
+	{"StarsUnderscores", "", "*_", MarkdownCommon},
+	{"OpenTags", "", "<a ", MarkdownCommon},
+	{"OpenComments", "", "<!--", MarkdownCommon},
+	{"DomainDots", "", "a.b", MarkdownGitHub},
+	{"BacktickRuns", "`", "a`", MarkdownCommon},
+	{"Diagnostics", "", "[a][] ", func(input []byte) []byte {
+		output, _, _ := MarkdownDiagnostics(input, HtmlRenderer(0, "", ""), Options{})
+		return output
+	}},
+	{"OpenRuby", "", "{a", renderWith(EXTENSION_RUBY)},
+	{"OpenVariables", "", "{{a ", renderWith(EXTENSION_VARIABLES)},
+	{"OpenCriticMarkup", "", "{~~", renderWith(EXTENSION_CRITIC_MARKUP)},
+	{"OpenShortcodes", "", "{%", renderWith(EXTENSION_SHORTCODES)},
+	{"OpenShortcodeBlocks", "", "{{%\n\n", renderWith(EXTENSION_SHORTCODES)},
+	{"TreeSpans", "", "a\t*b* ", func(input []byte) []byte {
+		Parse(input, Options{})
+		return nil
+	}},
+}
+
+func renderWith(extensions Extensions) func([]byte) []byte {
+	return func(input []byte) []byte {
+		return MarkdownOptions(input, HtmlRenderer(0, "", ""), Options{Extensions: extensions})
+	}
+}
+
+// TestAdversarialTime renders each of adversarialInputs at two sizes, one
+// four times the other. The larger one takes about four times as long in
+// linear time, and sixteen times in quadratic time.
+func TestAdversarialTime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing is skipped in short mode")
+	}
+	// the fastest of a few runs, to leave out pauses
+	timing := func(render func([]byte) []byte, input []byte) time.Duration {
+		var fastest time.Duration
+		for i := 0; i < 3; i++ {
+			start := time.Now()
+			render(input)
+			if d := time.Since(start); i == 0 || d < fastest {
+				fastest = d
+			}
+		}
+		return fastest
+	}
+	for _, in := range adversarialInputs {
+		var times [2]time.Duration
+		for i, n := range []int{4000, 16000} {
+			input := []byte(strings.Repeat(in.open, n) + strings.Repeat(in.unit, n))
+			times[i] = timing(in.render, input)
+		}
+		if ratio := float64(times[1]) / float64(times[0]); ratio > 10 {
+			t.Errorf("%s: %v for 4 times the input that took %v", in.name, times[1], times[0])
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Ruby annotations with EXTENSION_RUBY
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// The delimiters of ruby annotations when Options.RubyDelimiters is not
+// set: {漢字|かんじ}.
+var defaultRubyDelimiters = [3]string{"{", "|", "}"}
+
+// {base|reading} ruby annotation. With more than one reading, as in
+// {漢字|かん|じ}, each character of the base gets one of its own.
+func ruby(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	open, sep, close := []byte(p.rubyDelimiters[0]), []byte(p.rubyDelimiters[1]), []byte(p.rubyDelimiters[2])
+	data = data[offset:]
+	if !bytes.HasPrefix(data, open) {
+		return 0
+	}
+	// through the span index, so that openers without a closer, or with
+	// a long way to it, don't make the time quadratic
+	closeAt := p.nextString(data, len(open), string(close))
+	if closeAt == len(data) {
+		return 0
+	}
+	if p.nextByte(data, len(open), '\n') < closeAt || p.nextString(data, len(open), string(open))+len(open) <= closeAt {
+		return 0
+	}
+	end := closeAt - len(open)
+	inner := data[len(open):closeAt]
+	parts := bytes.Split(inner, sep)
+	if len(parts) < 2 {
+		return 0
+	}
+	for _, part := range parts {
+		if len(bytes.TrimSpace(part)) == 0 {
+			return 0
+		}
+	}
+
+	base, readings := parts[0], parts[1:]
+	if len(readings) == 1 {
+		p.rubyPair(out, base, readings[0])
+	} else {
+		if utf8.RuneCount(base) != len(readings) {
+			return 0
+		}
+		for _, reading := range readings {
+			_, size := utf8.DecodeRune(base)
+			p.rubyPair(out, base[:size], reading)
+			base = base[size:]
+		}
+	}
+	return len(open) + end + len(close)
+}
+
+// rubyPair renders a base text annotated with its reading.
+func (p *parser) rubyPair(out *bytes.Buffer, base, reading []byte) {
+	var text, annotation bytes.Buffer
+	p.inline(&text, base)
+	p.r.NormalText(&annotation, reading)
+	p.r.Ruby(out, text.Bytes(), annotation.Bytes())
+}
@@ -0,0 +1,262 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Sanitizing raw HTML and URLs with HTML_SANITIZE
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// DefaultAllowedTags is the whitelist of raw HTML tags used with
+// HTML_SANITIZE when HtmlRendererParameters.AllowedTags is nil. It maps
+// each tag name to the attributes it may keep; both are lower case.
+//
+// It allows the tags that Markdown itself produces, and a few others for
+// text-level markup, but nothing that runs scripts, loads other documents
+// or changes the page's styles.
+var DefaultAllowedTags = map[string][]string{
+	"a":          {"href", "title", "name"},
+	"abbr":       {"title"},
+	"b":          nil,
+	"blockquote": {"cite"},
+	"br":         nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"details":    nil,
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        nil,
+	"kbd":        nil,
+	"li":         nil,
+	"ol":         {"start"},
+	"p":          nil,
+	"pre":        nil,
+	"q":          {"cite"},
+	"s":          nil,
+	"samp":       nil,
+	"small":      nil,
+	"span":       nil,
+	"strike":     nil,
+	"strong":     nil,
+	"sub":        nil,
+	"summary":    nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"align", "colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"align", "colspan", "rowspan"},
+	"thead":      nil,
+	"tr":         nil,
+	"tt":         nil,
+	"u":          nil,
+	"ul":         nil,
+	"var":        nil,
+}
+
+// attributes whose values are URLs, checked with isUnsafeURL
+var urlAttributes = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"cite":   true,
+	"poster": true,
+}
+
+// URL schemes that run scripts or embed content of their own
+var unsafeSchemes = []string{"javascript", "vbscript", "data"}
+
+// isUnsafeURL reports whether a link uses one of the unsafe schemes.
+// Browsers ignore whitespace and control characters in the scheme, so they
+// are ignored here as well.
+func isUnsafeURL(link []byte) bool {
+	var scheme []byte
+	for _, c := range link {
+		if c <= ' ' {
+			continue
+		}
+		if c == ':' {
+			break
+		}
+		if c == '/' || c == '?' || c == '#' {
+			return false
+		}
+		scheme = append(scheme, c)
+	}
+	if len(scheme) == len(link) {
+		return false
+	}
+	for _, unsafe := range unsafeSchemes {
+		if bytes.EqualFold(scheme, []byte(unsafe)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeHtml writes out raw HTML, keeping only the tags and attributes in
+// allowed. Other tags are escaped so that they show up as text, and
+// comments are dropped.
+func sanitizeHtml(out *bytes.Buffer, data []byte, allowed map[string][]string) {
+	mark := 0
+	for i := 0; i < len(data); i++ {
+		if data[i] != '<' {
+			continue
+		}
+		out.Write(data[mark:i])
+		mark = i + 1
+
+		if bytes.HasPrefix(data[i:], []byte("<!--")) {
+			end := bytes.Index(data[i+4:], []byte("-->"))
+			if end >= 0 {
+				i += 4 + end + 2
+				mark = i + 1
+				continue
+			}
+		}
+
+		end := skipUntilCharIgnoreQuotes(data, i, '>')
+		if end == i {
+			out.WriteString("&lt;")
+			continue
+		}
+		tag := data[i : end+1]
+		if !writeAllowedTag(out, tag, allowed) {
+			attrEscape(out, tag)
+		}
+		i = end
+		mark = i + 1
+	}
+	out.Write(data[mark:])
+}
+
+// writeAllowedTag writes out tag, which runs from '<' to '>', with the
+// attributes it is allowed to keep. It writes nothing and returns false if
+// the tag itself is not allowed.
+func writeAllowedTag(out *bytes.Buffer, tag []byte, allowed map[string][]string) bool {
+	i := 1
+	closing := i < len(tag) && tag[i] == '/'
+	if closing {
+		i++
+	}
+	start := i
+	for i < len(tag) && (isalnum(tag[i]) || tag[i] == '-') {
+		i++
+	}
+	if i == start || !isletter(tag[start]) {
+		return false
+	}
+	name := strings.ToLower(string(tag[start:i]))
+	attrs, ok := allowed[name]
+	if !ok {
+		return false
+	}
+
+	out.WriteByte('<')
+	if closing {
+		out.WriteByte('/')
+	}
+	out.WriteString(name)
+	if closing {
+		out.WriteByte('>')
+		return true
+	}
+
+	body := tag[i : len(tag)-1]
+	selfClosing := bytes.HasSuffix(bytes.TrimSpace(body), []byte("/"))
+	for len(body) > 0 {
+		var attr, value []byte
+		attr, value, body = nextAttribute(body)
+		if attr == nil {
+			break
+		}
+		key := strings.ToLower(string(attr))
+		if !containsString(attrs, key) {
+			continue
+		}
+		value = []byte(html.UnescapeString(string(value)))
+		if urlAttributes[key] && isUnsafeURL(value) {
+			continue
+		}
+		out.WriteByte(' ')
+		out.WriteString(key)
+		out.WriteString("=\"")
+		attrEscape(out, value)
+		out.WriteByte('"')
+	}
+	if selfClosing {
+		out.WriteString(" /")
+	}
+	out.WriteByte('>')
+	return true
+}
+
+// nextAttribute splits the first attribute off the inside of a tag and
+// returns its name, its value without quotes, and the rest of the tag. The
+// name is nil if there are no more attributes.
+func nextAttribute(data []byte) (name, value, rest []byte) {
+	i := 0
+	for i < len(data) && (isspace(data[i]) || data[i] == '/') {
+		i++
+	}
+	start := i
+	for i < len(data) && !isspace(data[i]) && data[i] != '=' && data[i] != '/' {
+		i++
+	}
+	if i == start {
+		return nil, nil, nil
+	}
+	name = data[start:i]
+	i = skipSpace(data, i)
+	if i >= len(data) || data[i] != '=' {
+		return name, nil, data[i:]
+	}
+	i = skipSpace(data, i+1)
+	if i < len(data) && (data[i] == '"' || data[i] == '\'') {
+		quote := data[i]
+		end := bytes.IndexByte(data[i+1:], quote)
+		if end < 0 {
+			return name, data[i+1:], nil
+		}
+		return name, data[i+1 : i+1+end], data[i+2+end:]
+	}
+	start = i
+	for i < len(data) && !isspace(data[i]) {
+		i++
+	}
+	return name, data[start:i], data[i:]
+}
+
+func containsString(list []string, s string) bool {
+	for _, elt := range list {
+		if elt == s {
+			return true
+		}
+	}
+	return false
+}
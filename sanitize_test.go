@@ -0,0 +1,103 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for HTML_SANITIZE
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html/template"
+	"testing"
+)
+
+func TestSanitizeInline(t *testing.T) {
+	var tests = []string{
+		"a <b>bold</b> and <script>alert(1)</script> word\n",
+		"<p>a <b>bold</b> and &lt;script&gt;alert(1)&lt;/script&gt; word</p>\n",
+
+		"<a href=\"/x\" onclick=\"steal()\" title='t'>link</a>\n",
+		"<p><a href=\"/x\" title=\"t\">link</a></p>\n",
+
+		"<a href=\"javascript:alert(1)\">x</a> <a href=\"java&#x09;script&#58;alert(1)\">y</a>\n",
+		"<p><a>x</a> <a>y</a></p>\n",
+
+		"<img src=\"/a.png\" style=\"position:fixed\" alt=\"a\"/> <IMG SRC=\"data:text/html,x\">\n",
+		"<p><img src=\"/a.png\" alt=\"a\" /> <img></p>\n",
+
+		"text <!-- a comment --> more\n",
+		"<p>text  more</p>\n",
+
+		"[click](javascript:alert(1)) and [ok](http://example.com)\n",
+		"<p><tt>click</tt> and <a href=\"http://example.com\">ok</a></p>\n",
+
+		"[x](  JavaScript:alert(1)) [y](vbscript:msgbox)\n",
+		"<p><tt>x</tt> <tt>y</tt></p>\n",
+
+		"![pixel](data:image/gif;base64,R0lGOD) ![ok](/ok.png)\n",
+		"<p>pixel <img src=\"/ok.png\" alt=\"ok\" /></p>\n",
+
+		"[fine](/data:/x) and [also](#javascript:x)\n",
+		"<p><a href=\"/data:/x\">fine</a> and <a href=\"#javascript:x\">also</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, Options{}, HTML_SANITIZE, HtmlRendererParameters{})
+}
+
+func TestSanitizeBlock(t *testing.T) {
+	var tests = []string{
+		"<div onmouseover=\"x()\">\nhello\n</div>\n",
+		"<div>\nhello\n</div>\n",
+
+		"<iframe src=\"http://evil.example.com\">\n</iframe>\n",
+		"&lt;iframe src=&quot;http://evil.example.com&quot;&gt;\n&lt;/iframe&gt;\n",
+
+		"<table>\n<tr><td colspan=\"2\" bgcolor=\"red\">x</td></tr>\n</table>\n",
+		"<table>\n<tr><td colspan=\"2\">x</td></tr>\n</table>\n",
+	}
+	doTestsBlockWithRunner(t, tests, 0, runnerWithSanitize(nil))
+
+	// a policy of one's own
+	tests = []string{
+		"<div class=\"note\">\n<b>hi</b>\n</div>\n",
+		"<div class=\"note\">\n&lt;b&gt;hi&lt;/b&gt;\n</div>\n",
+	}
+	doTestsBlockWithRunner(t, tests, 0, runnerWithSanitize(map[string][]string{
+		"div": {"class"},
+	}))
+}
+
+func runnerWithSanitize(allowed map[string][]string) func(string, Extensions) string {
+	return func(input string, extensions Extensions) string {
+		renderer := HtmlRendererWithParameters(HTML_USE_XHTML|HTML_SANITIZE, "", "",
+			HtmlRendererParameters{AllowedTags: allowed})
+		return runMarkdownBlockWithRenderer(input, extensions, renderer)
+	}
+}
+
+func TestTemplateHTML(t *testing.T) {
+	input := "*Hi* <script>alert(1)</script> [x](javascript:alert(1))\n"
+	expected := template.HTML("<p><em>Hi</em> &lt;script&gt;alert(1)&lt;/script&gt; <tt>x</tt></p>\n")
+	if output := TemplateHTML([]byte(input), HTML_COMPLETE_PAGE, Options{}); output != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", expected, output)
+	}
+
+	tmpl := template.Must(template.New("page").Funcs(TemplateFuncs(0, Options{})).Parse(
+		`<article title="{{.Title}}">{{markdown .Body}}</article>`))
+	var out bytes.Buffer
+	err := tmpl.Execute(&out, map[string]string{"Title": "a \"b\"", "Body": "A & <b>B</b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := "<article title=\"a &#34;b&#34;\"><p>A &amp; <b>B</b></p>\n</article>"
+	if out.String() != page {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", page, out.String())
+	}
+}
@@ -0,0 +1,124 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Shortcode passthrough with EXTENSION_SHORTCODES
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// The opening and closing delimiters of shortcodes: those of Hugo, and
+// Liquid tags as in Jekyll.
+var shortcodeDelimiters = [][2]string{
+	{"{{<", ">}}"},
+	{"{{%", "%}}"},
+	{"{%", "%}"},
+}
+
+// shortcodeLength returns the length of the shortcode at the start of
+// data, or 0 if there is none. find returns where the closer is, as
+// nextString does.
+func shortcodeLength(data []byte, find func(data []byte, i int, s string) int) int {
+	for _, delims := range shortcodeDelimiters {
+		open, close := delims[0], delims[1]
+		if !bytes.HasPrefix(data, []byte(open)) {
+			continue
+		}
+		end := find(data, len(open), close)
+		if end == len(data) {
+			return 0
+		}
+		return end + len(close)
+	}
+	return 0
+}
+
+// closerSearch is the last search for a closer in the rest of the
+// document, from the start of data to its end.
+type closerSearch struct {
+	data []byte
+	at   int // where the closer is in data, or len(data) if it isn't
+}
+
+// nextCloser is nextString for the closers of blocks, which are looked for
+// in the rest of the document rather than in a span. The openers of later
+// blocks that come before the closer found by the last search, or after a
+// search that found none, are given its result rather than searching
+// again, so that the time taken by thousands of them stays linear.
+func (p *parser) nextCloser(data []byte, i int, s string) int {
+	off, ok := sliceOffset(p.doc, data)
+	if !ok || off+len(data) != len(p.doc) {
+		// a copy of a part of the document
+		if j := bytes.Index(data[i:], []byte(s)); j >= 0 {
+			return i + j
+		}
+		return len(data)
+	}
+	if last, ok := p.closers[s]; ok {
+		if start := off - (len(p.doc) - len(last.data)); start >= 0 && start+i <= last.at {
+			return last.at - start
+		}
+	}
+	at := len(data)
+	if j := bytes.Index(data[i:], []byte(s)); j >= 0 {
+		at = i + j
+	}
+	if p.closers == nil {
+		p.closers = make(map[string]closerSearch)
+	}
+	p.closers[s] = closerSearch{data: data, at: at}
+	return at
+}
+
+// A shortcode on a line of its own is a block, which is not wrapped in a
+// paragraph:
+//
+//	{{< youtube w7Ft2ymGmfc >}}
+func (p *parser) shortcodeBlock(out *bytes.Buffer, data []byte) int {
+	end := shortcodeLength(data, p.nextCloser)
+	if end == 0 {
+		return 0
+	}
+	i := skipChar(data, end, ' ')
+	if data[i] != '\n' {
+		return 0
+	}
+	p.r.BlockShortcode(out, p.resolveShortcode(data[:end]))
+	return i + 1
+}
+
+// '{': a shortcode within text, such as {{< ref "about.md" >}}. It is
+// passed through as it is, out of reach of emphasis, escaping and
+// smartypants.
+func shortcode(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	end := shortcodeLength(data[offset:], p.nextString)
+	if end == 0 {
+		return 0
+	}
+	p.r.Shortcode(out, p.resolveShortcode(data[offset:offset+end]))
+	return end
+}
+
+// resolveShortcode returns the output of a shortcode: what
+// Options.ShortcodeResolver makes of it, or the shortcode itself
+func (p *parser) resolveShortcode(code []byte) []byte {
+	if p.shortcodeResolver == nil || p.indexing {
+		return code
+	}
+	if output, ok := p.shortcodeResolver(string(code)); ok {
+		return []byte(output)
+	}
+	return code
+}
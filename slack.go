@@ -0,0 +1,489 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Slack mrkdwn rendering backend
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Slack is a type that implements the Renderer interface for Slack's
+// mrkdwn message format.
+//
+// Do not create this directly, instead use the SlackRenderer function.
+type Slack struct {
+	flags int
+
+	// item counters of the lists being rendered, innermost last
+	listCounters []int
+
+	footnoteCount int // number of footnote texts emitted so far
+}
+
+// SlackRenderer creates and configures a Slack object, which satisfies the
+// Renderer interface.
+//
+// flags is a set of SLACK_* options ORed together (currently no such
+// options are defined).
+//
+// mrkdwn only knows about emphasis, links, code and block quotes. Headers
+// are written in bold, lists with bullet characters, and tables as
+// preformatted text with aligned columns. Raw HTML and horizontal rules
+// are dropped.
+func SlackRenderer(flags int) Renderer {
+	return &Slack{flags: flags}
+}
+
+func (options *Slack) GetFlags() int {
+	return options.flags
+}
+
+func (options *Slack) newDocument() Renderer {
+	return &Slack{flags: options.flags}
+}
+
+// Every block ends with a blank line.
+
+func (options *Slack) BlockCode(out *bytes.Buffer, text []byte, lang string, attrs Attributes) {
+	out.WriteString("```\n")
+	slackEscape(out, bytes.TrimRight(text, "\n"))
+	out.WriteString("\n```\n\n")
+}
+
+func (options *Slack) TitleBlock(out *bytes.Buffer, text []byte) {
+	text = bytes.TrimPrefix(text, []byte("% "))
+	text = bytes.Replace(text, []byte("\n% "), []byte(" "), -1)
+	out.WriteString("*")
+	slackEscape(out, bytes.TrimSpace(text))
+	out.WriteString("*\n\n")
+}
+
+func (options *Slack) LineBlock(out *bytes.Buffer, lines [][]byte) {
+	out.Write(bytes.Join(lines, []byte("\n")))
+	out.WriteString("\n\n")
+}
+
+func (options *Slack) Bibliography(out *bytes.Buffer, keys []string) {
+}
+
+func (options *Slack) BlockQuote(out *bytes.Buffer, text []byte) {
+	writeIndented(out, bytes.TrimRight(text, "\n"), "> ", "> ")
+	out.WriteString("\n")
+}
+
+func (options *Slack) BlockQuoteCitation(out *bytes.Buffer, text []byte, citation []byte) {
+	var quote bytes.Buffer
+	quote.Write(bytes.TrimRight(text, "\n"))
+	quote.WriteString("\n\u2014 ")
+	quote.Write(citation)
+	writeIndented(out, quote.Bytes(), "> ", "> ")
+	out.WriteString("\n")
+}
+
+func (options *Slack) Admonition(out *bytes.Buffer, kind string, title []byte, text []byte) {
+	out.WriteString("> *")
+	slackEscape(out, admonitionTitle(kind, title))
+	out.WriteString("*\n")
+	if text = bytes.TrimRight(text, "\n"); len(text) > 0 {
+		writeIndented(out, text, "> ", "> ")
+	}
+	out.WriteString("\n")
+}
+
+func (options *Slack) Container(out *bytes.Buffer, info string, text []byte) {
+	out.Write(text)
+}
+
+func (options *Slack) Details(out *bytes.Buffer, summary []byte, text []byte, flags int) {
+	if len(summary) > 0 {
+		out.WriteString("*")
+		out.Write(summary)
+		out.WriteString("*\n")
+	}
+	out.Write(text)
+}
+
+func (options *Slack) BlockSpoiler(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Slack) BlockShortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteString("\n\n")
+}
+
+func (options *Slack) BlockHtml(out *bytes.Buffer, text []byte) {
+}
+
+func (options *Slack) Header(out *bytes.Buffer, text func() bool, level int, id string, attrs Attributes) {
+	marker := out.Len()
+	out.WriteString("*")
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("*\n\n")
+}
+
+func (options *Slack) HRule(out *bytes.Buffer) {
+}
+
+func (options *Slack) List(out *bytes.Buffer, text func() bool, flags int, start int) {
+	marker := out.Len()
+	// a nested list starts on a line of its own
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+		out.Truncate(len(bytes.TrimRight(out.Bytes(), " ")))
+		out.WriteString("\n")
+	}
+	options.listCounters = append(options.listCounters, start-1)
+	ok := text()
+	options.listCounters = options.listCounters[:len(options.listCounters)-1]
+	if !ok {
+		out.Truncate(marker)
+		return
+	}
+	if len(options.listCounters) == 0 {
+		out.WriteString("\n")
+	}
+}
+
+// Items are written with a bullet or their number, and their other lines,
+// including those of nested lists, are indented below it.
+func (options *Slack) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	text = slackBlankLines.ReplaceAll(bytes.Trim(text, " \n"), []byte("\n"))
+	if flags&LIST_TYPE_TERM != 0 {
+		out.WriteString("*")
+		out.Write(text)
+		out.WriteString("*\n")
+		return
+	}
+
+	var bullet string
+	switch {
+	case flags&LIST_TYPE_DEFINITION != 0:
+		bullet = "    "
+	case flags&LIST_TYPE_ORDERED != 0:
+		n := len(options.listCounters) - 1
+		options.listCounters[n]++
+		bullet = listMarker(options.listCounters[n], flags) + ". "
+	default:
+		bullet = "• "
+	}
+	switch {
+	case flags&LIST_ITEM_CHECKED != 0:
+		bullet += "☑ "
+	case flags&LIST_ITEM_TASK != 0:
+		bullet += "☐ "
+	}
+	writeIndented(out, text, bullet, "    ")
+}
+
+var slackBlankLines = regexp.MustCompile(`\n{2,}`)
+
+func (options *Slack) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n\n")
+}
+
+// Cells are written with a separator in front of them, which Table uses to
+// split the rows again once it knows how wide each column is.
+const slackCellSeparator = '\x1f'
+
+func (options *Slack) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int, caption []byte) {
+	var rows [][][]byte
+	widths := make([]int, len(columnData))
+	lines := append(bytes.Split(header, []byte("\n")), bytes.Split(body, []byte("\n"))...)
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		cells := bytes.Split(line[1:], []byte{slackCellSeparator})
+		for i, cell := range cells {
+			if i < len(widths) && utf8.RuneCount(cell) > widths[i] {
+				widths[i] = utf8.RuneCount(cell)
+			}
+		}
+		rows = append(rows, cells)
+	}
+
+	out.WriteString("```\n")
+	for r, cells := range rows {
+		var line bytes.Buffer
+		for i, cell := range cells {
+			if i >= len(widths) {
+				break
+			}
+			if i > 0 {
+				line.WriteString(" | ")
+			}
+			pad := widths[i] - utf8.RuneCount(cell)
+			switch columnData[i] {
+			case TABLE_ALIGNMENT_RIGHT:
+				line.WriteString(strings.Repeat(" ", pad))
+				line.Write(cell)
+			case TABLE_ALIGNMENT_CENTER:
+				line.WriteString(strings.Repeat(" ", pad/2))
+				line.Write(cell)
+				line.WriteString(strings.Repeat(" ", pad-pad/2))
+			default:
+				line.Write(cell)
+				line.WriteString(strings.Repeat(" ", pad))
+			}
+		}
+		out.Write(bytes.TrimRight(line.Bytes(), " "))
+		out.WriteString("\n")
+
+		// rule off the header
+		if r == 0 && len(header) > 0 {
+			for i, width := range widths {
+				if i > 0 {
+					out.WriteString("-+-")
+				}
+				out.WriteString(strings.Repeat("-", width))
+			}
+			out.WriteString("\n")
+		}
+	}
+	out.WriteString("```\n")
+	if len(caption) > 0 {
+		out.Write(caption)
+		out.WriteString("\n")
+	}
+	out.WriteString("\n")
+}
+
+func (options *Slack) TableRow(out *bytes.Buffer, text []byte, flags int) {
+	out.Write(text)
+	out.WriteString("\n")
+}
+
+func (options *Slack) TableHeaderCell(out *bytes.Buffer, text []byte, align int, span int) {
+	options.TableCell(out, text, align, span)
+}
+
+// The cells are laid out in a grid, so spanning cells are followed by
+// empty ones.
+func (options *Slack) TableCell(out *bytes.Buffer, text []byte, align int, span int) {
+	out.WriteByte(slackCellSeparator)
+	out.Write(text)
+	for i := 1; i < span; i++ {
+		out.WriteByte(slackCellSeparator)
+	}
+}
+
+// Footnote texts are listed at the end of the message, numbered like the
+// references written by FootnoteRef.
+func (options *Slack) Footnotes(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n")
+}
+
+func (options *Slack) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	if flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
+		options.footnoteCount = 0
+	}
+	options.footnoteCount++
+	text = slackBlankLines.ReplaceAll(bytes.Trim(text, " \n"), []byte("\n"))
+	writeIndented(out, text, "["+strconv.Itoa(options.footnoteCount)+"] ", "    ")
+}
+
+func (options *Slack) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.WriteString("<")
+	switch kind {
+	case LINK_TYPE_EMAIL:
+		out.WriteString("mailto:")
+	case LINK_TYPE_WWW:
+		out.WriteString("http://")
+	}
+	slackEscape(out, link)
+	if kind != LINK_TYPE_NORMAL {
+		out.WriteString("|")
+		slackEscape(out, link)
+	}
+	out.WriteString(">")
+}
+
+func (options *Slack) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.WriteString("`")
+	slackEscape(out, text)
+	out.WriteString("`")
+}
+
+func (options *Slack) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("*")
+	out.Write(text)
+	out.WriteString("*")
+}
+
+func (options *Slack) Emphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("_")
+	out.Write(text)
+	out.WriteString("_")
+}
+
+// Slack only shows images attached to a message, so they are linked to.
+func (options *Slack) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, attrs Attributes) {
+	out.WriteString("<")
+	slackEscape(out, link)
+	if len(alt) > 0 {
+		out.WriteString("|")
+		slackEscape(out, alt)
+	}
+	out.WriteString(">")
+}
+
+func (options *Slack) LineBreak(out *bytes.Buffer) {
+	out.WriteString("\n")
+}
+
+// The link text can't contain a '|' or a newline, as either would end it.
+func (options *Slack) Link(out *bytes.Buffer, link []byte, title []byte, content []byte, attrs Attributes) {
+	out.WriteString("<")
+	slackEscape(out, link)
+	if len(content) > 0 {
+		out.WriteString("|")
+		content = bytes.Replace(content, []byte("|"), []byte("¦"), -1)
+		out.Write(bytes.Replace(content, []byte("\n"), []byte(" "), -1))
+	}
+	out.WriteString(">")
+}
+
+func (options *Slack) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+}
+
+func (options *Slack) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("*_")
+	out.Write(text)
+	out.WriteString("_*")
+}
+
+func (options *Slack) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString("~")
+	out.Write(text)
+	out.WriteString("~")
+}
+
+// Slack has no markup for highlighted or inserted text.
+func (options *Slack) Highlight(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Slack) Insert(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+// Comments are left out.
+func (options *Slack) CriticMarkup(out *bytes.Buffer, kind int, text []byte, replacement []byte) {
+	criticChanges(options, out, kind, text, replacement)
+}
+
+func (options *Slack) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("[")
+	out.WriteString(strconv.Itoa(id))
+	out.WriteString("]")
+}
+
+func (options *Slack) Abbreviation(out *bytes.Buffer, abbr []byte, title []byte) {
+	slackEscape(out, abbr)
+}
+
+// Slack mentions refer to user IDs rather than names, so mentions are
+// written as plain text.
+func (options *Slack) Mention(out *bytes.Buffer, kind int, token []byte) {
+	if kind == MENTION_TYPE_ISSUE {
+		out.WriteByte('#')
+	} else {
+		out.WriteByte('@')
+	}
+	slackEscape(out, token)
+}
+
+func (options *Slack) Citation(out *bytes.Buffer, items []CitationItem) {
+	slackEscape(out, citationText(items))
+}
+
+func (options *Slack) MediaEmbed(out *bytes.Buffer, kind int, link []byte, title []byte, alt []byte) {
+	options.Image(out, link, title, alt, nil)
+}
+
+func (options *Slack) Kbd(out *bytes.Buffer, key []byte) {
+	options.CodeSpan(out, key)
+}
+
+func (options *Slack) Ruby(out *bytes.Buffer, text []byte, annotation []byte) {
+	out.Write(text)
+	out.WriteString("(")
+	out.Write(annotation)
+	out.WriteString(")")
+}
+
+func (options *Slack) Spoiler(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Slack) Shortcode(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *Slack) Entity(out *bytes.Buffer, entity []byte) {
+	slackEscape(out, []byte(html.UnescapeString(string(entity))))
+}
+
+// Slack breaks lines where the message does, so the newlines within a
+// paragraph are turned into spaces.
+func (options *Slack) NormalText(out *bytes.Buffer, text []byte) {
+	slackEscape(out, bytes.Replace(text, []byte("\n"), []byte(" "), -1))
+}
+
+// slackEscape escapes the three characters that Slack uses for its own
+// markup.
+func slackEscape(out *bytes.Buffer, text []byte) {
+	for _, c := range text {
+		switch c {
+		case '&':
+			out.WriteString("&amp;")
+		case '<':
+			out.WriteString("&lt;")
+		case '>':
+			out.WriteString("&gt;")
+		default:
+			out.WriteByte(c)
+		}
+	}
+}
+
+// header and footer
+func (options *Slack) DocumentHeader(out *bytes.Buffer) {
+	options.listCounters = nil
+	options.footnoteCount = 0
+}
+
+// Messages don't end in a newline, so the trailing ones are trimmed.
+func (options *Slack) DocumentFooter(out *bytes.Buffer) {
+	out.Truncate(len(bytes.TrimRight(out.Bytes(), "\n")))
+}
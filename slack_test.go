@@ -0,0 +1,86 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for Slack rendering
+//
+
+package blackfriday
+
+import (
+	"testing"
+)
+
+func runMarkdownSlack(input string, extensions Extensions) string {
+	return string(Markdown([]byte(input), SlackRenderer(0), extensions))
+}
+
+func doTestsSlack(t *testing.T, tests []string, extensions Extensions) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runMarkdownSlack(input, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestSlackBlocks(t *testing.T) {
+	var tests = []string{
+		"# Title\n\nSome\ntext.\n",
+		"*Title*\n\nSome text.",
+
+		"```go\nif a < b {}\n```\n",
+		"```\nif a &lt; b {}\n```",
+
+		"> one\n>\n> two\n",
+		"> one\n>\n> two",
+
+		"- a\n- b\n    1. c\n    2. d\n- e\n\nafter\n",
+		"• a\n• b\n    1. c\n    2. d\n• e\n\nafter",
+
+		"- [x] done\n- [ ] todo\n",
+		"• ☑ done\n• ☐ todo",
+
+		"| a | bb |\n|---|---:|\n| ccc | 1 |\n",
+		"```\na   | bb\n----+---\nccc |  1\n```",
+
+		"<div>raw</div>\n\n***\n\ntext\n",
+		"text",
+
+		"> [!TIP]\n> Use `go vet`.\n",
+		"> *Tip*\n> Use `go vet`.",
+	}
+	doTestsSlack(t, tests, EXTENSION_FENCED_CODE|EXTENSION_TABLES|EXTENSION_TASK_LISTS|EXTENSION_ADMONITIONS)
+}
+
+func TestSlackInline(t *testing.T) {
+	var tests = []string{
+		"*a* **b** ***c*** ~~d~~ `e`\n",
+		"_a_ *b* *_c_* ~d~ `e`",
+
+		"[text](http://example.com/) and <http://example.com/>\n",
+		"<http://example.com/|text> and <http://example.com/>",
+
+		"mail <me@example.com>\n",
+		"mail <mailto:me@example.com|me@example.com>",
+
+		"![alt](/a.png)\n",
+		"</a.png|alt>",
+
+		"a < b &amp; c\n",
+		"a &lt; b &amp; c",
+
+		"Text[^1].\n\n[^1]: The note.\n",
+		"Text[1].\n\n[1] The note.",
+	}
+	doTestsSlack(t, tests, EXTENSION_STRIKETHROUGH|EXTENSION_AUTOLINK|EXTENSION_FOOTNOTES)
+}
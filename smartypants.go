@@ -17,13 +17,81 @@ package blackfriday
 
 import (
 	"bytes"
+	"html"
+)
+
+// Smartypants options, for the Smartypants function.
+const (
+	SMARTYPANTS_FRACTIONS     = 1 << iota // turn any fraction such as 4/5 into one, not only 1/2, 1/4 and 3/4
+	SMARTYPANTS_DASHES                    // turn -- into an em dash, and - between spaces into an en dash
+	SMARTYPANTS_LATEX_DASHES              // turn --- into an em dash and -- into an en dash (with SMARTYPANTS_DASHES)
+	SMARTYPANTS_ANGLED_QUOTES             // use angled double quotes: « and »
+	SMARTYPANTS_QUOTES_NBSP               // add non-breaking spaces inside double quotes, as in French
+	SMARTYPANTS_LOW_QUOTES                // use German low quotes: „ and “, ‚ and ‘
+	SMARTYPANTS_ELLIPSIS                  // turn ... and . . . into an ellipsis
+	SMARTYPANTS_UNICODE                   // read and write plain text, with Unicode punctuation
+	SMARTYPANTS_HTML                      // read HTML, leaving its tags and their attributes alone
+	SMARTYPANTS_FRENCH_SPACES             // put narrow non-breaking spaces before : ; ? and !, as in French
+	SMARTYPANTS_ORDINALS                  // superscript the suffix of English ordinals such as 1st and 22nd (not with SMARTYPANTS_UNICODE)
 )
 
 type smartypantsData struct {
 	inSingleQuote bool
 	inDoubleQuote bool
+	flags         int // SMARTYPANTS_* options
+	entities      int // ENTITY_* policy of the HTML renderer
+}
+
+// entity writes out the punctuation with the given HTML entity name, as
+// the character itself with SMARTYPANTS_UNICODE, or else as the HTML
+// renderer writes character references.
+func (smrt *smartypantsData) entity(out *bytes.Buffer, name string) {
+	if smrt.flags&SMARTYPANTS_UNICODE != 0 {
+		out.WriteString(html.UnescapeString("&" + name + ";"))
+		return
+	}
+	writeEntity(out, name, smrt.entities)
+}
+
+// quoteEntity returns the entity name for an opening or closing double
+// ('d') or single ('s') quote.
+func (smrt *smartypantsData) quoteEntity(quote byte, open bool) string {
+	switch {
+	case quote == 'd' && smrt.flags&SMARTYPANTS_ANGLED_QUOTES != 0:
+		if open {
+			return "laquo"
+		}
+		return "raquo"
+	case smrt.flags&SMARTYPANTS_LOW_QUOTES != 0:
+		switch {
+		case open && quote == 'd':
+			return "bdquo"
+		case open:
+			return "sbquo"
+		}
+		return "l" + string(quote) + "quo"
+	}
+	if open {
+		return "l" + string(quote) + "quo"
+	}
+	return "r" + string(quote) + "quo"
+}
+
+// smartChar returns the byte of text at i that the punctuation around it
+// is judged by: 0 past the end, and a space for a non-breaking space,
+// which is kept as it is
+func smartChar(text []byte, i int) byte {
+	if i >= len(text) {
+		return 0
+	}
+	if bytes.HasPrefix(text[i:], nbsp) {
+		return ' '
+	}
+	return text[i]
 }
 
+var nbsp = []byte("\u00a0")
+
 func wordBoundary(c byte) bool {
 	return c == 0 || isspace(c) || ispunct(c)
 }
@@ -39,7 +107,7 @@ func isdigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
 
-func smartQuoteHelper(out *bytes.Buffer, previousChar byte, nextChar byte, quote byte, isOpen *bool, addNBSP bool) bool {
+func smartQuoteHelper(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, nextChar byte, quote byte, isOpen *bool, addNBSP bool) bool {
 	// edge of the buffer is likely to be a tag that we don't get to see,
 	// so we treat it like text sometimes
 
@@ -99,20 +167,13 @@ func smartQuoteHelper(out *bytes.Buffer, previousChar byte, nextChar byte, quote
 	// Note that with the limited lookahead, this non-breaking
 	// space will also be appended to single double quotes.
 	if addNBSP && !*isOpen {
-		out.WriteString("&nbsp;")
+		smrt.entity(out, "nbsp")
 	}
 
-	out.WriteByte('&')
-	if *isOpen {
-		out.WriteByte('l')
-	} else {
-		out.WriteByte('r')
-	}
-	out.WriteByte(quote)
-	out.WriteString("quo;")
+	smrt.entity(out, smrt.quoteEntity(quote, *isOpen))
 
 	if addNBSP && *isOpen {
-		out.WriteString("&nbsp;")
+		smrt.entity(out, "nbsp")
 	}
 
 	return true
@@ -123,17 +184,14 @@ func smartSingleQuote(out *bytes.Buffer, smrt *smartypantsData, previousChar byt
 		t1 := tolower(text[1])
 
 		if t1 == '\'' {
-			nextChar := byte(0)
-			if len(text) >= 3 {
-				nextChar = text[2]
-			}
-			if smartQuoteHelper(out, previousChar, nextChar, 'd', &smrt.inDoubleQuote, false) {
+			nextChar := smartChar(text, 2)
+			if smartQuoteHelper(out, smrt, previousChar, nextChar, 'd', &smrt.inDoubleQuote, false) {
 				return 1
 			}
 		}
 
-		if (t1 == 's' || t1 == 't' || t1 == 'm' || t1 == 'd') && (len(text) < 3 || wordBoundary(text[2])) {
-			out.WriteString("&rsquo;")
+		if (t1 == 's' || t1 == 't' || t1 == 'm' || t1 == 'd') && wordBoundary(smartChar(text, 2)) {
+			smrt.entity(out, "rsquo")
 			return 0
 		}
 
@@ -141,18 +199,15 @@ func smartSingleQuote(out *bytes.Buffer, smrt *smartypantsData, previousChar byt
 			t2 := tolower(text[2])
 
 			if ((t1 == 'r' && t2 == 'e') || (t1 == 'l' && t2 == 'l') || (t1 == 'v' && t2 == 'e')) &&
-				(len(text) < 4 || wordBoundary(text[3])) {
-				out.WriteString("&rsquo;")
+				wordBoundary(smartChar(text, 3)) {
+				smrt.entity(out, "rsquo")
 				return 0
 			}
 		}
 	}
 
-	nextChar := byte(0)
-	if len(text) > 1 {
-		nextChar = text[1]
-	}
-	if smartQuoteHelper(out, previousChar, nextChar, 's', &smrt.inSingleQuote, false) {
+	nextChar := smartChar(text, 1)
+	if smartQuoteHelper(out, smrt, previousChar, nextChar, 's', &smrt.inSingleQuote, false) {
 		return 0
 	}
 
@@ -166,17 +221,17 @@ func smartParens(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, te
 		t2 := tolower(text[2])
 
 		if t1 == 'c' && t2 == ')' {
-			out.WriteString("&copy;")
+			smrt.entity(out, "copy")
 			return 2
 		}
 
 		if t1 == 'r' && t2 == ')' {
-			out.WriteString("&reg;")
+			smrt.entity(out, "reg")
 			return 2
 		}
 
 		if len(text) >= 4 && t1 == 't' && t2 == 'm' && text[3] == ')' {
-			out.WriteString("&trade;")
+			smrt.entity(out, "trade")
 			return 3
 		}
 	}
@@ -188,12 +243,12 @@ func smartParens(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, te
 func smartDash(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
 	if len(text) >= 2 {
 		if text[1] == '-' {
-			out.WriteString("&mdash;")
+			smrt.entity(out, "mdash")
 			return 1
 		}
 
-		if wordBoundary(previousChar) && wordBoundary(text[1]) {
-			out.WriteString("&ndash;")
+		if wordBoundary(previousChar) && wordBoundary(smartChar(text, 1)) {
+			smrt.entity(out, "ndash")
 			return 0
 		}
 	}
@@ -204,11 +259,11 @@ func smartDash(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text
 
 func smartDashLatex(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
 	if len(text) >= 3 && text[1] == '-' && text[2] == '-' {
-		out.WriteString("&mdash;")
+		smrt.entity(out, "mdash")
 		return 2
 	}
 	if len(text) >= 2 && text[1] == '-' {
-		out.WriteString("&ndash;")
+		smrt.entity(out, "ndash")
 		return 1
 	}
 
@@ -216,13 +271,11 @@ func smartDashLatex(out *bytes.Buffer, smrt *smartypantsData, previousChar byte,
 	return 0
 }
 
-func smartAmpVariant(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte, quote byte, addNBSP bool) int {
+func smartAmp(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
 	if bytes.HasPrefix(text, []byte("&quot;")) {
-		nextChar := byte(0)
-		if len(text) >= 7 {
-			nextChar = text[6]
-		}
-		if smartQuoteHelper(out, previousChar, nextChar, quote, &smrt.inDoubleQuote, addNBSP) {
+		nextChar := smartChar(text, 6)
+		addNBSP := smrt.flags&SMARTYPANTS_QUOTES_NBSP != 0
+		if smartQuoteHelper(out, smrt, previousChar, nextChar, 'd', &smrt.inDoubleQuote, addNBSP) {
 			return 5
 		}
 	}
@@ -235,25 +288,14 @@ func smartAmpVariant(out *bytes.Buffer, smrt *smartypantsData, previousChar byte
 	return 0
 }
 
-func smartAmp(angledQuotes, addNBSP bool) func(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
-	var quote byte = 'd'
-	if angledQuotes {
-		quote = 'a'
-	}
-
-	return func(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
-		return smartAmpVariant(out, smrt, previousChar, text, quote, addNBSP)
-	}
-}
-
 func smartPeriod(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
 	if len(text) >= 3 && text[1] == '.' && text[2] == '.' {
-		out.WriteString("&hellip;")
+		smrt.entity(out, "hellip")
 		return 2
 	}
 
 	if len(text) >= 5 && text[1] == ' ' && text[2] == '.' && text[3] == ' ' && text[4] == '.' {
-		out.WriteString("&hellip;")
+		smrt.entity(out, "hellip")
 		return 4
 	}
 
@@ -263,11 +305,8 @@ func smartPeriod(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, te
 
 func smartBacktick(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
 	if len(text) >= 2 && text[1] == '`' {
-		nextChar := byte(0)
-		if len(text) >= 3 {
-			nextChar = text[2]
-		}
-		if smartQuoteHelper(out, previousChar, nextChar, 'd', &smrt.inDoubleQuote, false) {
+		nextChar := smartChar(text, 2)
+		if smartQuoteHelper(out, smrt, previousChar, nextChar, 'd', &smrt.inDoubleQuote, false) {
 			return 1
 		}
 	}
@@ -304,10 +343,18 @@ func smartNumberGeneric(out *bytes.Buffer, smrt *smartypantsData, previousChar b
 			out.WriteByte(text[0])
 			return 0
 		}
-		if len(text) == denEnd || wordBoundary(text[denEnd]) && text[denEnd] != '/' {
+		if next := smartChar(text, denEnd); wordBoundary(next) && next != '/' {
+			if smrt.flags&SMARTYPANTS_UNICODE != 0 {
+				out.Write(text[:numEnd])
+				smrt.entity(out, "frasl")
+				out.Write(text[denStart:denEnd])
+				return denEnd - 1
+			}
 			out.WriteString("<sup>")
 			out.Write(text[:numEnd])
-			out.WriteString("</sup>&frasl;<sub>")
+			out.WriteString("</sup>")
+			smrt.entity(out, "frasl")
+			out.WriteString("<sub>")
 			out.Write(text[denStart:denEnd])
 			out.WriteString("</sub>")
 			return denEnd - 1
@@ -321,22 +368,22 @@ func smartNumberGeneric(out *bytes.Buffer, smrt *smartypantsData, previousChar b
 func smartNumber(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
 	if wordBoundary(previousChar) && previousChar != '/' && len(text) >= 3 {
 		if text[0] == '1' && text[1] == '/' && text[2] == '2' {
-			if len(text) < 4 || wordBoundary(text[3]) && text[3] != '/' {
-				out.WriteString("&frac12;")
+			if next := smartChar(text, 3); wordBoundary(next) && next != '/' {
+				smrt.entity(out, "frac12")
 				return 2
 			}
 		}
 
 		if text[0] == '1' && text[1] == '/' && text[2] == '4' {
-			if len(text) < 4 || wordBoundary(text[3]) && text[3] != '/' || (len(text) >= 5 && tolower(text[3]) == 't' && tolower(text[4]) == 'h') {
-				out.WriteString("&frac14;")
+			if next := smartChar(text, 3); wordBoundary(next) && next != '/' || (len(text) >= 5 && tolower(text[3]) == 't' && tolower(text[4]) == 'h') {
+				smrt.entity(out, "frac14")
 				return 2
 			}
 		}
 
 		if text[0] == '3' && text[1] == '/' && text[2] == '4' {
-			if len(text) < 4 || wordBoundary(text[3]) && text[3] != '/' || (len(text) >= 6 && tolower(text[3]) == 't' && tolower(text[4]) == 'h' && tolower(text[5]) == 's') {
-				out.WriteString("&frac34;")
+			if next := smartChar(text, 3); wordBoundary(next) && next != '/' || (len(text) >= 6 && tolower(text[3]) == 't' && tolower(text[4]) == 'h' && tolower(text[5]) == 's') {
+				smrt.entity(out, "frac34")
 				return 2
 			}
 		}
@@ -346,24 +393,76 @@ func smartNumber(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, te
 	return 0
 }
 
-func smartDoubleQuoteVariant(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte, quote byte) int {
-	nextChar := byte(0)
-	if len(text) > 1 {
-		nextChar = text[1]
+// ordinalSuffix returns the English suffix of the ordinal of the number
+// written in digits
+func ordinalSuffix(digits []byte) string {
+	last := digits[len(digits)-1]
+	if len(digits) > 1 && digits[len(digits)-2] == '1' {
+		return "th"
 	}
-	if !smartQuoteHelper(out, previousChar, nextChar, quote, &smrt.inDoubleQuote, false) {
-		out.WriteString("&quot;")
+	switch last {
+	case '1':
+		return "st"
+	case '2':
+		return "nd"
+	case '3':
+		return "rd"
 	}
+	return "th"
+}
 
-	return 0
+// smartOrdinal superscripts the suffix of an ordinal such as 21st, and
+// leaves anything else to next
+func smartOrdinal(next smartCallback) smartCallback {
+	return func(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
+		if wordBoundary(previousChar) && previousChar != '/' && previousChar != '.' {
+			numEnd := 0
+			for numEnd < len(text) && isdigit(text[numEnd]) {
+				numEnd++
+			}
+			suffix := ordinalSuffix(text[:numEnd])
+			end := numEnd + len(suffix)
+			if len(text) >= end && string(text[numEnd:end]) == suffix && wordBoundary(smartChar(text, end)) {
+				out.Write(text[:numEnd])
+				out.WriteString("<sup>")
+				out.WriteString(suffix)
+				out.WriteString("</sup>")
+				return end - 1
+			}
+		}
+		if next == nil {
+			out.WriteByte(text[0])
+			return 0
+		}
+		return next(out, smrt, previousChar, text)
+	}
 }
 
 func smartDoubleQuote(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
-	return smartDoubleQuoteVariant(out, smrt, previousChar, text, 'd')
+	nextChar := smartChar(text, 1)
+	addNBSP := smrt.flags&SMARTYPANTS_QUOTES_NBSP != 0
+	if !smartQuoteHelper(out, smrt, previousChar, nextChar, 'd', &smrt.inDoubleQuote, addNBSP) {
+		out.WriteString("&quot;")
+	}
+
+	return 0
 }
 
-func smartAngledDoubleQuote(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
-	return smartDoubleQuoteVariant(out, smrt, previousChar, text, 'a')
+// smartFrenchSpace puts a narrow non-breaking space before the : ; ? or !
+// at the start of text in place of the space before it, if there is one.
+// The space has been written to out already.
+func smartFrenchSpace(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
+	if previousChar == ' ' {
+		written := out.Bytes()
+		if bytes.HasSuffix(written, nbsp) {
+			out.Truncate(len(written) - len(nbsp))
+		} else if bytes.HasSuffix(written, []byte(" ")) {
+			out.Truncate(len(written) - 1)
+		}
+		smrt.entity(out, "#8239")
+	}
+	out.WriteByte(text[0])
+	return 0
 }
 
 func smartLeftAngle(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
@@ -372,6 +471,10 @@ func smartLeftAngle(out *bytes.Buffer, smrt *smartypantsData, previousChar byte,
 	for i < len(text) && text[i] != '>' {
 		i++
 	}
+	if i == len(text) {
+		out.Write(text)
+		return i - 1
+	}
 
 	out.Write(text[:i+1])
 	return i
@@ -379,52 +482,99 @@ func smartLeftAngle(out *bytes.Buffer, smrt *smartypantsData, previousChar byte,
 
 type smartCallback func(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int
 
-type smartypantsRenderer [256]smartCallback
+type smartypantsRenderer struct {
+	callbacks [256]smartCallback
+	flags     int // SMARTYPANTS_* options
+	entities  int // ENTITY_* policy of the HTML renderer
+}
 
-var (
-	smartAmpAngled      = smartAmp(true, false)
-	smartAmpAngledNBSP  = smartAmp(true, true)
-	smartAmpRegular     = smartAmp(false, false)
-	smartAmpRegularNBSP = smartAmp(false, true)
-)
+// Smartypants writes text to out with smart punctuation: curly quotes,
+// dashes, ellipses, fractions and symbols such as (c). flags is a set of
+// SMARTYPANTS_* options ORed together.
+//
+// By default text is escaped and written out as HTML, with entities for
+// the punctuation, as the HTML renderer does with HTML_USE_SMARTYPANTS.
+// With SMARTYPANTS_HTML, text is taken to be HTML already. With
+// SMARTYPANTS_UNICODE, text is written out as it is, with Unicode
+// characters for the punctuation, for renderers of other formats to
+// escape as they need.
+func Smartypants(out *bytes.Buffer, text []byte, flags int) {
+	smartypants(flags).process(out, text)
+}
 
 func smartypants(flags int) *smartypantsRenderer {
-	r := new(smartypantsRenderer)
-	addNBSP := flags&HTML_SMARTYPANTS_QUOTES_NBSP != 0
-	if flags&HTML_SMARTYPANTS_ANGLED_QUOTES == 0 {
-		r['"'] = smartDoubleQuote
-		if !addNBSP {
-			r['&'] = smartAmpRegular
+	r := &smartypantsRenderer{flags: flags}
+	r.callbacks['"'] = smartDoubleQuote
+	if flags&SMARTYPANTS_UNICODE == 0 {
+		r.callbacks['&'] = smartAmp
+	}
+	r.callbacks['\''] = smartSingleQuote
+	r.callbacks['('] = smartParens
+	if flags&SMARTYPANTS_DASHES != 0 {
+		if flags&SMARTYPANTS_LATEX_DASHES == 0 {
+			r.callbacks['-'] = smartDash
 		} else {
-			r['&'] = smartAmpRegularNBSP
+			r.callbacks['-'] = smartDashLatex
 		}
+	}
+	if flags&SMARTYPANTS_ELLIPSIS != 0 {
+		r.callbacks['.'] = smartPeriod
+	}
+	if flags&SMARTYPANTS_FRACTIONS == 0 {
+		r.callbacks['1'] = smartNumber
+		r.callbacks['3'] = smartNumber
 	} else {
-		r['"'] = smartAngledDoubleQuote
-		if !addNBSP {
-			r['&'] = smartAmpAngled
-		} else {
-			r['&'] = smartAmpAngledNBSP
+		for ch := '1'; ch <= '9'; ch++ {
+			r.callbacks[ch] = smartNumberGeneric
 		}
 	}
-	r['\''] = smartSingleQuote
-	r['('] = smartParens
-	if flags&HTML_SMARTYPANTS_DASHES != 0 {
-		if flags&HTML_SMARTYPANTS_LATEX_DASHES == 0 {
-			r['-'] = smartDash
-		} else {
-			r['-'] = smartDashLatex
+	if flags&SMARTYPANTS_ORDINALS != 0 && flags&SMARTYPANTS_UNICODE == 0 {
+		for ch := '0'; ch <= '9'; ch++ {
+			r.callbacks[ch] = smartOrdinal(r.callbacks[ch])
 		}
 	}
-	r['.'] = smartPeriod
-	if flags&HTML_SMARTYPANTS_FRACTIONS == 0 {
-		r['1'] = smartNumber
-		r['3'] = smartNumber
-	} else {
-		for ch := '1'; ch <= '9'; ch++ {
-			r[ch] = smartNumberGeneric
+	if flags&SMARTYPANTS_HTML != 0 {
+		r.callbacks['<'] = smartLeftAngle
+	}
+	if flags&SMARTYPANTS_FRENCH_SPACES != 0 {
+		for _, ch := range []byte(":;?!") {
+			r.callbacks[ch] = smartFrenchSpace
 		}
 	}
-	r['<'] = smartLeftAngle
-	r['`'] = smartBacktick
+	r.callbacks['`'] = smartBacktick
 	return r
 }
+
+func (r *smartypantsRenderer) process(out *bytes.Buffer, text []byte) {
+	smrt := smartypantsData{flags: r.flags, entities: r.entities}
+
+	// first do normal entity escaping
+	if r.flags&(SMARTYPANTS_UNICODE|SMARTYPANTS_HTML) == 0 {
+		escaped := getBuffer()
+		defer putBuffer(escaped)
+		attrEscape(escaped, text)
+		text = escaped.Bytes()
+	}
+
+	mark := 0
+	for i := 0; i < len(text); i++ {
+		if action := r.callbacks[text[i]]; action != nil {
+			if i > mark {
+				out.Write(text[mark:i])
+			}
+
+			previousChar := byte(0)
+			if i >= len(nbsp) && bytes.Equal(text[i-len(nbsp):i], nbsp) {
+				previousChar = ' '
+			} else if i > 0 {
+				previousChar = text[i-1]
+			}
+			i += action(out, &smrt, previousChar, text[i:])
+			mark = i + 1
+		}
+	}
+
+	if mark < len(text) {
+		out.Write(text[mark:])
+	}
+}
@@ -0,0 +1,139 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for the standalone Smartypants function
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"testing"
+)
+
+func doTestsSmartypants(t *testing.T, tests []string, flags int) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		var out bytes.Buffer
+		Smartypants(&out, []byte(input), flags)
+		if actual := out.String(); actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestSmartypants(t *testing.T) {
+	var tests = []string{
+		`"Don't" & 'so' (c) 1/2`,
+		"&ldquo;Don&rsquo;t&rdquo; &amp; &lsquo;so&rsquo; &copy; &frac12;",
+
+		"wait... what",
+		"wait... what",
+	}
+	doTestsSmartypants(t, tests, 0)
+
+	tests = []string{
+		"wait... for it -- now",
+		"wait&hellip; for it &mdash; now",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_ELLIPSIS|SMARTYPANTS_DASHES)
+}
+
+func TestSmartypantsQuotes(t *testing.T) {
+	var tests = []string{
+		`er sagte "Hallo" und 'Tschüss'`,
+		"er sagte &bdquo;Hallo&ldquo; und &sbquo;Tschüss&lsquo;",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_LOW_QUOTES)
+
+	tests = []string{
+		`il dit "bonjour"`,
+		"il dit &laquo;&nbsp;bonjour&nbsp;&raquo;",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_ANGLED_QUOTES|SMARTYPANTS_QUOTES_NBSP)
+}
+
+func TestSmartypantsUnicode(t *testing.T) {
+	var tests = []string{
+		`"Don't" & <b> (tm) 3/4... 5/8`,
+		"“Don’t” & <b> ™ 3⁄4… 5⁄8",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_UNICODE|SMARTYPANTS_ELLIPSIS|SMARTYPANTS_FRACTIONS)
+
+	tests = []string{
+		`er sagte "Hallo" -- 1/2`,
+		"er sagte „Hallo“ — ½",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_UNICODE|SMARTYPANTS_DASHES|SMARTYPANTS_LOW_QUOTES)
+}
+
+func TestSmartypantsHTML(t *testing.T) {
+	var tests = []string{
+		`<a href="/x" title='it's'>"quoted"</a> &amp; more`,
+		`<a href="/x" title='it's'>&ldquo;quoted&rdquo;</a> &amp; more`,
+
+		"unclosed <tag",
+		"unclosed <tag",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_HTML)
+}
+
+func TestSmartypantsNbsp(t *testing.T) {
+	var tests = []string{
+		"\u00a0\"word\" \u00a0'it'",
+		"\u00a0&ldquo;word&rdquo; \u00a0&lsquo;it&rsquo;",
+
+		"10\u00a0-- see",
+		"10\u00a0&ndash; see",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_DASHES|SMARTYPANTS_LATEX_DASHES)
+}
+
+func TestSmartypantsFrenchSpaces(t *testing.T) {
+	var tests = []string{
+		"Quoi ? Oui ! Non\u00a0: bon ; fin!",
+		"Quoi&#8239;? Oui&#8239;! Non&#8239;: bon&#8239;; fin!",
+
+		"http://example.com/?q",
+		"http://example.com/?q",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_FRENCH_SPACES)
+
+	tests = []string{
+		"Quoi ? Oui\u00a0!",
+		"Quoi\u202f? Oui\u202f!",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_FRENCH_SPACES|SMARTYPANTS_UNICODE)
+}
+
+func TestSmartypantsOrdinals(t *testing.T) {
+	var tests = []string{
+		"1st 2nd 3rd 4th 11th 12th 13th 21st 102nd 111th",
+		"1<sup>st</sup> 2<sup>nd</sup> 3<sup>rd</sup> 4<sup>th</sup> 11<sup>th</sup> 12<sup>th</sup> 13<sup>th</sup> 21<sup>st</sup> 102<sup>nd</sup> 111<sup>th</sup>",
+
+		"1th 11st 2nds a1st 1stly 1/2nd 1/4th",
+		"1th 11st 2nds a1st 1stly 1/2nd &frac14;th",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_ORDINALS)
+
+	tests = []string{
+		"the 3rd of 4/5",
+		"the 3<sup>rd</sup> of <sup>4</sup>&frasl;<sub>5</sub>",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_ORDINALS|SMARTYPANTS_FRACTIONS)
+
+	tests = []string{
+		"the 3rd",
+		"the 3rd",
+	}
+	doTestsSmartypants(t, tests, SMARTYPANTS_ORDINALS|SMARTYPANTS_UNICODE)
+}
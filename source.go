@@ -0,0 +1,348 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Where the nodes of a document tree come from in the input
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"sort"
+	"unicode/utf8"
+)
+
+// While a tree is built, the nodes are placed in the output of the first
+// pass: each block and span parser that renders a node gives it the part
+// of the document it parsed. The contents of block quotes, list items and
+// spoilers are copied out of the document without their markers before
+// they are parsed, so those copies keep track of where each of their
+// lines comes from. Once the tree is built, the text nodes are found
+// between their siblings, and the positions are turned into ones in the
+// input.
+
+// sourceCopy is the contents of a block copied out of the document to be
+// parsed, with where each piece of it comes from. The pieces are only
+// noted when a tree is being built.
+type sourceCopy struct {
+	data []byte // the copy, once it is complete
+	at   []int  // where each piece starts in data
+	from []int  // and where it starts in the document, or -1 if unknown
+}
+
+// copySource appends a piece of the document to the copy in buf, noting
+// where it comes from.
+func (p *parser) copySource(c *sourceCopy, buf *bytes.Buffer, piece []byte) {
+	if p.ast != nil {
+		from := -1
+		if off, ok := p.docOffset(piece); ok {
+			from = off
+		}
+		c.at = append(c.at, buf.Len())
+		c.from = append(c.from, from)
+	}
+	buf.Write(piece)
+}
+
+// enterCopy makes the nodes parsed from data, the completed copy c, known
+// to be from where c says. It returns what leaveCopy needs to forget c
+// again once it is parsed.
+func (p *parser) enterCopy(c *sourceCopy, data []byte) int {
+	n := len(p.copies)
+	if p.ast != nil {
+		c.data = data
+		p.copies = append(p.copies, c)
+	}
+	return n
+}
+
+// leaveCopy forgets the copies entered since enterCopy returned n.
+func (p *parser) leaveCopy(n int) {
+	for i := n; i < len(p.copies); i++ {
+		p.copies[i] = nil
+	}
+	p.copies = p.copies[:n]
+}
+
+// docOffset finds where data starts in the document, if it is a part of
+// it or of a copy of it.
+func (p *parser) docOffset(data []byte) (int, bool) {
+	start, _, ok := p.docRange(data, 1)
+	return start, ok
+}
+
+// docRange finds where data[:size] starts and ends in the document. The
+// end is found from the last byte, which a copy may have taken from
+// another line than the first.
+func (p *parser) docRange(data []byte, size int) (start, end int, ok bool) {
+	if off, ok := sliceOffset(p.doc, data); ok {
+		return off, off + size, true
+	}
+	for i := len(p.copies) - 1; i >= 0; i-- {
+		c := p.copies[i]
+		off, ok := sliceOffset(c.data, data)
+		if !ok {
+			continue
+		}
+		start, ok = c.docOffset(off)
+		if !ok {
+			return 0, 0, false
+		}
+		end, ok = c.docOffset(off + size - 1)
+		return start, end + 1, ok
+	}
+	return 0, 0, false
+}
+
+// docOffset turns a position in the copy into one in the document
+func (c *sourceCopy) docOffset(off int) (int, bool) {
+	i := sort.SearchInts(c.at, off+1) - 1
+	if i < 0 || c.from[i] < 0 {
+		return 0, false
+	}
+	return c.from[i] + off - c.at[i], true
+}
+
+// mark returns where the nodes that a parser is about to record will
+// start, for place.
+func (p *parser) mark() int {
+	if p.ast == nil {
+		return 0
+	}
+	return len(p.ast.nodes)
+}
+
+// place gives the nodes recorded since mark that have not been placed yet
+// the position of data[:size], without its trailing blank lines. Nested
+// parsers have placed the nodes they recorded first, so these are the
+// nodes of the parser that called place.
+func (p *parser) place(mark int, data []byte, size int) {
+	if p.ast == nil || mark >= len(p.ast.nodes) {
+		return
+	}
+	for size > 0 && (data[size-1] == '\n' || data[size-1] == ' ') {
+		size--
+	}
+	start, end, ok := 0, 0, false
+	if size > 0 {
+		start, end, ok = p.docRange(data, size)
+	}
+	for i := mark; i < len(p.ast.nodes); i++ {
+		if p.ast.placed[i] {
+			continue
+		}
+		p.ast.placed[i] = true
+		if ok {
+			p.ast.nodes[i].Pos, p.ast.nodes[i].End = start, end
+		}
+	}
+}
+
+// placeTree fills in the positions of the nodes that the parsers left
+// out, and turns all of them into positions in the input.
+func (p *parser) placeTree(nodes []*Node) {
+	// the columns are only kept for the lines of the nodes at hand, which
+	// for an Iterator are those of a block
+	for i := range p.columns {
+		delete(p.columns, i)
+	}
+	for _, n := range nodes {
+		p.placeNode(n)
+		p.inputNode(n)
+	}
+}
+
+// placeNode places a node that is made of its children, like the head of
+// a table, where they are, and its text nodes between their siblings
+func (p *parser) placeNode(n *Node) {
+	lists := [...][]*Node{n.Children, n.Replacement, n.Annotation}
+	for _, list := range lists {
+		for _, child := range list {
+			if child.Type != NODE_TEXT {
+				p.placeNode(child)
+			}
+		}
+	}
+	if n.End == 0 {
+		for _, list := range lists {
+			for _, child := range list {
+				if child.End == 0 {
+					continue
+				}
+				if n.End == 0 || child.Pos < n.Pos {
+					n.Pos = child.Pos
+				}
+				if child.End > n.End {
+					n.End = child.End
+				}
+			}
+		}
+		return
+	}
+	for _, list := range lists {
+		p.placeText(n, list)
+	}
+}
+
+// placeText places the text nodes of a list of siblings in n. The text
+// comes as it is from the document, unless the parser changed it, as it
+// does with backslash escapes; then it takes up all of the space between
+// its siblings.
+func (p *parser) placeText(n *Node, siblings []*Node) {
+	lo := n.Pos
+	for i, child := range siblings {
+		if child.Type == NODE_TEXT && child.End == 0 {
+			hi := n.End
+			for _, next := range siblings[i+1:] {
+				if next.End > 0 {
+					hi = next.Pos
+					break
+				}
+			}
+			if hi <= lo || hi > len(p.doc) {
+				continue
+			}
+			if j := bytes.Index(p.doc[lo:hi], child.Literal); j >= 0 {
+				child.Pos, child.End = lo+j, lo+j+len(child.Literal)
+			} else {
+				child.Pos, child.End = lo, hi
+			}
+		}
+		if child.End > lo {
+			lo = child.End
+		}
+	}
+}
+
+// inputNode turns the positions of n and its descendants into ones in the
+// input
+func (p *parser) inputNode(n *Node) {
+	if n.End > 0 {
+		n.Pos, n.End = p.inputOffset(n.Pos, false), p.inputOffset(n.End, true)
+	}
+	for _, list := range [...][]*Node{n.Children, n.Replacement, n.Annotation} {
+		for _, child := range list {
+			p.inputNode(child)
+		}
+	}
+}
+
+// inputOffset turns a position in the document into one in the input, or
+// the end of a node, if end is set. The first pass expands tabs and leaves
+// out references, so the position is found on the input line that its
+// line of the document comes from.
+func (p *parser) inputOffset(off int, end bool) int {
+	p.lineTables()
+	at := off
+	if end {
+		// the end is on the line of the last byte
+		at--
+	}
+	i := sort.SearchInts(p.docLines, at+1) - 1
+	if i < 0 || i >= len(p.lines) || p.lines[i] < 1 || p.lines[i] > len(p.sourceLines) {
+		return p.sourceBase
+	}
+	doc := bytes.TrimSuffix(lineAt(p.doc, p.docLines, i), []byte("\n"))
+	beg := p.sourceLines[p.lines[i]-1]
+	src := lineAt(p.source, p.sourceLines, p.lines[i]-1)
+	col := off - p.docLines[i]
+	if col > len(doc) {
+		// past the newline
+		return p.sourceBase + beg + len(src)
+	}
+	src = bytes.TrimSuffix(bytes.TrimSuffix(src, []byte("\n")), []byte("\r"))
+
+	columns, ok := p.columns[i]
+	if !ok {
+		if !bytes.Equal(doc, src) {
+			columns = sourceColumns(doc, src, p.tabSize())
+		}
+		p.columns[i] = columns
+	}
+	if columns == nil {
+		return p.sourceBase + beg + col
+	}
+	return p.sourceBase + beg + columns[col]
+}
+
+// sourceColumns finds the byte of the input line src that each column of
+// the line doc, its copy with the tabs expanded, comes from, and where the
+// end of doc is in src
+func sourceColumns(doc, src []byte, tabSize int) []int {
+	columns := make([]int, 0, len(doc)+1)
+	i, column := 0, 0
+	for i < len(src) && len(columns) < len(doc) {
+		if src[i] == '\t' && doc[len(columns)] != '\t' {
+			width := tabSize - column%tabSize
+			for k := 0; k < width; k++ {
+				columns = append(columns, i)
+			}
+			i, column = i+1, column+width
+			continue
+		}
+		_, size := utf8.DecodeRune(src[i:])
+		for k := 0; k < size; k++ {
+			columns = append(columns, i+k)
+		}
+		i, column = i+size, column+1
+	}
+	for len(columns) <= len(doc) {
+		columns = append(columns, i)
+	}
+	return columns[:len(doc)+1]
+}
+
+// inputLine returns the input line of the start of n, or 0 if it is
+// unknown
+func (p *parser) inputLine(n *Node) int {
+	if n.End == 0 || p.sourceLines == nil {
+		return 0
+	}
+	return sort.SearchInts(p.sourceLines, n.Pos-p.sourceBase+1)
+}
+
+// lineTables finds where the lines of the document and of the input
+// start, once the first pass is done.
+func (p *parser) lineTables() {
+	if p.docLines == nil {
+		p.docLines = lineStarts(p.doc)
+		p.sourceLines = lineStarts(p.source)
+		p.columns = make(map[int][]int)
+	}
+}
+
+// lineAt returns line i of data with its newline, given where each line
+// starts
+func lineAt(data []byte, starts []int, i int) []byte {
+	if i+1 < len(starts) {
+		return data[starts[i]:starts[i+1]]
+	}
+	return data[starts[i]:]
+}
+
+// lineStarts returns where each line of data starts
+func lineStarts(data []byte) []int {
+	starts := []int{0}
+	for i, c := range data {
+		if c == '\n' && i+1 < len(data) {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+func (p *parser) tabSize() int {
+	if p.flags&EXTENSION_TAB_SIZE_EIGHT != 0 {
+		return TAB_SIZE_EIGHT
+	}
+	return TAB_SIZE_DEFAULT
+}
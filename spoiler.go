@@ -0,0 +1,89 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Spoilers with EXTENSION_SPOILERS
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// returns the length of the >! prefix of a spoiler line, or 0 if there is
+// none
+func spoilerPrefix(data []byte) int {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	if data[i] != '>' || data[i+1] != '!' {
+		return 0
+	}
+	i += 2
+	if data[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+// A spoiler block is made of lines starting with >!, like a blockquote,
+// and can hold any blocks:
+//
+//	>! The butler
+//	>! did it.
+//
+// Unlike a blockquote, every line needs the prefix.
+func (p *parser) spoiler(out *bytes.Buffer, data []byte) int {
+	var raw bytes.Buffer
+	var src sourceCopy
+	end := 0
+	for end < len(data) {
+		pre := spoilerPrefix(data[end:])
+		if pre == 0 {
+			break
+		}
+		eol := skipUntilChar(data, end, '\n')
+		if eol < len(data) {
+			eol++
+		}
+		p.copySource(&src, &raw, data[end+pre:eol])
+		end = eol
+	}
+	if end == 0 {
+		return 0
+	}
+
+	var cooked bytes.Buffer
+	defer p.leaveCopy(p.enterCopy(&src, raw.Bytes()))
+	p.block(&cooked, raw.Bytes())
+	p.r.BlockSpoiler(out, cooked.Bytes())
+	return end
+}
+
+// '|': ||inline spoiler||. As with emphasis, there is no whitespace
+// inside the bars, which keeps a || b as it is.
+func spoilerSpan(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	data = data[offset:]
+	if len(data) < 5 || data[1] != '|' || data[2] == '|' || isspace(data[2]) {
+		return 0
+	}
+	end := bytes.Index(data[2:], []byte("||"))
+	if end < 0 || isspace(data[1+end]) {
+		return 0
+	}
+
+	var work bytes.Buffer
+	p.inline(&work, data[2:2+end])
+	p.r.Spoiler(out, work.Bytes())
+	return end + 4
+}
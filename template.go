@@ -0,0 +1,49 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Helpers for html/template
+//
+//
+
+package blackfriday
+
+import (
+	"html/template"
+)
+
+// TemplateHTML renders a block of markdown-encoded text as HTML that
+// html/template inserts as it is, without escaping it again.
+//
+// template.HTML promises that its contents are safe, so the output is
+// always sanitized: HTML_SANITIZE is added to flags, keeping only the raw
+// HTML tags and attributes of DefaultAllowedTags and leaving out links and
+// images with javascript:, vbscript: or data: URLs. This makes it fit for
+// untrusted input. HTML_COMPLETE_PAGE is ignored, as the output is meant
+// for a page of the template's own. To render trusted input without
+// sanitizing it, convert the output of MarkdownOptions to template.HTML
+// yourself.
+func TemplateHTML(input []byte, flags HtmlFlags, opts Options) template.HTML {
+	renderer := HtmlRenderer(flags&^HTML_COMPLETE_PAGE|HTML_SANITIZE, "", "")
+	return template.HTML(MarkdownOptions(input, renderer, opts))
+}
+
+// TemplateFuncs returns functions for a template.FuncMap: "markdown"
+// renders a string with TemplateHTML, with the given flags and options.
+//
+//	t := template.New("page").Funcs(blackfriday.TemplateFuncs(0, opts))
+//	t.Parse(`<article>{{markdown .Body}}</article>`)
+func TemplateFuncs(flags HtmlFlags, opts Options) template.FuncMap {
+	return template.FuncMap{
+		"markdown": func(text string) template.HTML {
+			return TemplateHTML([]byte(text), flags, opts)
+		},
+	}
+}